@@ -4,6 +4,7 @@ import (
 	"client/commands"
 	"log/slog"
 	"os"
+	"strings"
 
 	"github.com/jessevdk/go-flags"
 )
@@ -45,6 +46,272 @@ func run(args []string) error {
 		return err
 	}
 
+	_, err = parser.AddCommand("yank",
+		"Yank package",
+		"Hide package version from latest resolution, exact version stays resolvable",
+		&commands.YankCommand{})
+	if err != nil {
+		return err
+	}
+
+	_, err = parser.AddCommand("delete",
+		"Delete package",
+		"Delete package version from the hub",
+		&commands.DeleteCommand{})
+	if err != nil {
+		return err
+	}
+
+	_, err = parser.AddCommand("resolve",
+		"Resolve package",
+		"Resolve store path of package output",
+		&commands.ResolveCommand{})
+	if err != nil {
+		return err
+	}
+
+	_, err = parser.AddCommand("verify-provenance",
+		"Verify provenance",
+		"Verify provenance signature of package with binary cache public key",
+		&commands.VerifyProvenanceCommand{})
+	if err != nil {
+		return err
+	}
+
+	_, err = parser.AddCommand("sbom",
+		"Generate SBOM",
+		"Generate SBOM of registered package or locally of a store path",
+		&commands.SbomCommand{})
+	if err != nil {
+		return err
+	}
+
+	_, err = parser.AddCommand("machine-token",
+		"Create bootstrap token",
+		"Create one-time bootstrap token used to enroll a machine",
+		&commands.MachineTokenCommand{})
+	if err != nil {
+		return err
+	}
+
+	_, err = parser.AddCommand("enroll",
+		"Enroll machine",
+		"Generate machine identity and register the machine with the hub",
+		&commands.EnrollCommand{})
+	if err != nil {
+		return err
+	}
+
+	_, err = parser.AddCommand("machines",
+		"List machines",
+		"List machines enrolled to the hub",
+		&commands.MachinesCommand{})
+	if err != nil {
+		return err
+	}
+
+	_, err = parser.AddCommand("drift",
+		"Show machine drift",
+		"Show difference between desired state of the machine and state reported by its agent",
+		&commands.DriftCommand{})
+	if err != nil {
+		return err
+	}
+
+	_, err = parser.AddCommand("assign",
+		"Assign package",
+		"Assign package to a machine or group of machines",
+		&commands.AssignCommand{})
+	if err != nil {
+		return err
+	}
+
+	_, err = parser.AddCommand("build-system",
+		"Build NixOS system",
+		"Build nixosConfigurations.<host> and push it as system package",
+		&commands.SystemBuildCommand{})
+	if err != nil {
+		return err
+	}
+
+	rollout, err := parser.AddCommand("rollout",
+		"Manage rollouts",
+		"Roll out package version to machines in waves",
+		&commands.RolloutCommand{})
+	if err != nil {
+		return err
+	}
+	_, err = rollout.AddCommand("create",
+		"Create rollout",
+		"Create rollout of <name>@<version>",
+		&commands.RolloutCreateCommand{})
+	if err != nil {
+		return err
+	}
+	_, err = rollout.AddCommand("list",
+		"List rollouts",
+		"List rollouts",
+		&commands.RolloutListCommand{})
+	if err != nil {
+		return err
+	}
+	_, err = rollout.AddCommand("status",
+		"Show rollout",
+		"Show rollout and state of its machines",
+		&commands.RolloutStatusCommand{})
+	if err != nil {
+		return err
+	}
+	for _, action := range []string{"pause", "resume", "abort", "activate"} {
+		_, err = rollout.AddCommand(action,
+			strings.ToUpper(action[:1])+action[1:]+" rollout",
+			strings.ToUpper(action[:1])+action[1:]+" rollout with <id>",
+			commands.NewRolloutActionCommand(action))
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = parser.AddCommand("failed-versions",
+		"List failed versions",
+		"List package versions which failed health check in machine groups",
+		&commands.FailedVersionsCommand{})
+	if err != nil {
+		return err
+	}
+
+	maintenance, err := parser.AddCommand("maintenance",
+		"Manage maintenance windows",
+		"Limit when machines of a group activate packages",
+		&commands.MaintenanceCommand{})
+	if err != nil {
+		return err
+	}
+	_, err = maintenance.AddCommand("add",
+		"Add maintenance window",
+		"Add maintenance window from <start> to <end> as HH:MM",
+		&commands.MaintenanceAddCommand{})
+	if err != nil {
+		return err
+	}
+	_, err = maintenance.AddCommand("list",
+		"List maintenance windows",
+		"List maintenance windows",
+		&commands.MaintenanceListCommand{})
+	if err != nil {
+		return err
+	}
+	_, err = maintenance.AddCommand("delete",
+		"Delete maintenance window",
+		"Delete maintenance window with <id>",
+		&commands.MaintenanceDeleteCommand{})
+	if err != nil {
+		return err
+	}
+
+	secret, err := parser.AddCommand("secret",
+		"Manage secrets",
+		"Distribute secrets encrypted to machine identity keys",
+		&commands.SecretCommand{})
+	if err != nil {
+		return err
+	}
+	_, err = secret.AddCommand("set",
+		"Set secret",
+		"Encrypt secret <name> to the machine and store it in hub",
+		&commands.SecretSetCommand{})
+	if err != nil {
+		return err
+	}
+	_, err = secret.AddCommand("list",
+		"List secrets",
+		"List secrets without their content",
+		&commands.SecretListCommand{})
+	if err != nil {
+		return err
+	}
+	_, err = secret.AddCommand("delete",
+		"Delete secret",
+		"Delete secret <name> of the machine",
+		&commands.SecretDeleteCommand{})
+	if err != nil {
+		return err
+	}
+
+	replication, err := parser.AddCommand("replication",
+		"Manage replication",
+		"Show replication status of hub and promote replicas",
+		&commands.ReplicationCommand{})
+	if err != nil {
+		return err
+	}
+	_, err = replication.AddCommand("status",
+		"Show replication status",
+		"Show role of the hub and lag of replica",
+		&commands.ReplicationStatusCommand{})
+	if err != nil {
+		return err
+	}
+	_, err = replication.AddCommand("promote",
+		"Promote replica",
+		"Stop following the primary and accept writes on the replica",
+		&commands.ReplicationPromoteCommand{})
+	if err != nil {
+		return err
+	}
+
+	cluster, err := parser.AddCommand("cluster",
+		"Manage cluster",
+		"Show nodes sharding the binary cache and rebalance objects",
+		&commands.ClusterCommand{})
+	if err != nil {
+		return err
+	}
+	_, err = cluster.AddCommand("status",
+		"Show cluster status",
+		"Show nodes of the cluster and progress of rebalancing on the hub",
+		&commands.ClusterStatusCommand{})
+	if err != nil {
+		return err
+	}
+	_, err = cluster.AddCommand("rebalance",
+		"Rebalance objects",
+		"Copy objects of the hub to their owners and drop objects it does not own",
+		&commands.ClusterRebalanceCommand{})
+	if err != nil {
+		return err
+	}
+
+	webhook, err := parser.AddCommand("webhook",
+		"Manage webhooks",
+		"Test webhooks configured on the hub and show their delivery log",
+		&commands.WebhookCommand{})
+	if err != nil {
+		return err
+	}
+	_, err = webhook.AddCommand("test",
+		"Test webhook",
+		"Post test event to the webhook of the given name",
+		&commands.WebhookTestCommand{})
+	if err != nil {
+		return err
+	}
+	_, err = webhook.AddCommand("deliveries",
+		"List webhook deliveries",
+		"List attempts to deliver events to webhooks, newest first",
+		&commands.WebhookDeliveriesCommand{})
+	if err != nil {
+		return err
+	}
+
+	_, err = parser.AddCommand("agent",
+		"Run agent",
+		"Converge this machine to packages assigned to it by the hub",
+		&commands.AgentCommand{})
+	if err != nil {
+		return err
+	}
+
 	_, err = parser.ParseArgs(args)
 	if err != nil {
 		return err
@@ -0,0 +1,245 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Activator installs store paths into the profile managed by the agent.
+type Activator interface {
+	// Realise fetches store paths from the cache.
+	Realise(ctx context.Context, storePaths []string) error
+	// Prefetch realises store paths ahead of activation with download speed limited to KiB/s, unlimited when 0.
+	// Prefetched store paths are kept from garbage collection until the next prefetch.
+	Prefetch(ctx context.Context, storePaths []string, downloadSpeed int) error
+	// Activate replaces contents of the profile with the store paths.
+	Activate(ctx context.Context, storePaths []string) error
+	// Current lists store paths installed by the last successful activation.
+	Current(ctx context.Context) ([]string, error)
+	// Generation of the profile, 0 when nothing was activated yet.
+	Generation(ctx context.Context) (int64, error)
+	// Rollback switches the profile back to the generation which had the store paths installed.
+	Rollback(ctx context.Context, generation int64, storePaths []string) error
+}
+
+// nixProfileActivator installs store paths into dedicated nix profile with nix-env.
+type nixProfileActivator struct {
+	nixBinDir string
+	profile   string
+	// stateFile keeps store paths of the last activation
+	stateFile string
+	// stagedRoots keeps garbage collector roots of prefetched store paths
+	stagedRoots   string
+	substituter   string
+	trustedPubKey string
+	// peers are tried before the substituter, nil when peers are not used
+	peers *hubPeers
+	// deltas reconstruct NARs from deltas against installed store paths, nil when deltas are not used
+	deltas *deltaCache
+}
+
+func newNixProfileActivator(nixBinDir, profile, stateDir, substituter, trustedPubKey string, peers *hubPeers, deltas *deltaCache) *nixProfileActivator {
+	return &nixProfileActivator{
+		nixBinDir:     nixBinDir,
+		profile:       profile,
+		stateFile:     filepath.Join(stateDir, "activated.json"),
+		stagedRoots:   filepath.Join(stateDir, "staged"),
+		substituter:   substituter,
+		trustedPubKey: trustedPubKey,
+		peers:         peers,
+		deltas:        deltas,
+	}
+}
+
+// Realise implements Activator.
+func (a *nixProfileActivator) Realise(ctx context.Context, storePaths []string) error {
+	if len(storePaths) == 0 {
+		return nil
+	}
+
+	err := a.realise(ctx, storePaths)
+	if err != nil {
+		return fmt.Errorf("Failed to realise store paths: %w", err)
+	}
+
+	return nil
+}
+
+// Prefetch implements Activator.
+func (a *nixProfileActivator) Prefetch(ctx context.Context, storePaths []string, downloadSpeed int) error {
+	err := os.RemoveAll(a.stagedRoots)
+	if err != nil {
+		return fmt.Errorf("Failed to remove roots of staged store paths: %w", err)
+	}
+	if len(storePaths) == 0 {
+		return nil
+	}
+	err = os.MkdirAll(a.stagedRoots, 0o755)
+	if err != nil {
+		return fmt.Errorf("Failed to create directory of staged store paths roots: %w", err)
+	}
+
+	args := []string{"--add-root", filepath.Join(a.stagedRoots, "root")}
+	if downloadSpeed > 0 {
+		args = append(args, "--option", "download-speed", strconv.Itoa(downloadSpeed))
+	}
+	err = a.realise(ctx, storePaths, args...)
+	if err != nil {
+		return fmt.Errorf("Failed to prefetch store paths: %w", err)
+	}
+
+	return nil
+}
+
+// realise substitutes store paths from NARs reconstructed from deltas and peers holding the store paths first,
+// narinfos of both carry signatures of the hub and are verified with the same public key as the substituter.
+func (a *nixProfileActivator) realise(ctx context.Context, storePaths []string, extraArgs ...string) error {
+	substituters := []string{}
+	if a.deltas != nil {
+		deltaCache, cleanup, err := a.deltas.Prepare(ctx, storePaths)
+		if err != nil {
+			slog.Warn("Failed to download deltas, downloading full NARs", "err", err)
+		}
+		defer cleanup()
+		if deltaCache != "" {
+			substituters = append(substituters, deltaCache)
+		}
+	}
+	if a.peers != nil {
+		substituters = append(substituters, a.peers.Find(ctx, storePaths)...)
+	}
+	substituters = append(substituters, a.substituter)
+
+	args := a.realiseArgs(storePaths, substituters)
+	args = append(args, extraArgs...)
+	_, err := runNixCmd(ctx, a.bin("nix-store"), args...)
+
+	return err
+}
+
+func (a *nixProfileActivator) realiseArgs(storePaths []string, substituters []string) []string {
+	args := []string{"--realise"}
+	args = append(args, storePaths...)
+	args = append(args, "--option", "extra-substituters", strings.Join(substituters, " "))
+	if a.trustedPubKey != "" {
+		args = append(args, "--option", "extra-trusted-public-keys", a.trustedPubKey)
+	}
+	if len(substituters) > 1 {
+		// Deltas and peers are trusted only by signatures of the hub, unreachable peers must not delay fallback
+		args = append(args, "--option", "require-sigs", "true", "--option", "connect-timeout", "5")
+	}
+
+	return args
+}
+
+// Activate implements Activator.
+func (a *nixProfileActivator) Activate(ctx context.Context, storePaths []string) error {
+	err := os.MkdirAll(filepath.Dir(a.profile), 0o755)
+	if err != nil {
+		return fmt.Errorf("Failed to create profile directory: %w", err)
+	}
+
+	args := []string{"--profile", a.profile}
+	if len(storePaths) == 0 {
+		args = append(args, "--uninstall", ".*")
+	} else {
+		// Installs new generation containing only the store paths
+		args = append(args, "--install", "--remove-all")
+		args = append(args, storePaths...)
+	}
+	_, err = runNixCmd(ctx, a.bin("nix-env"), args...)
+	if err != nil {
+		return fmt.Errorf("Failed to install store paths into profile %s: %w", a.profile, err)
+	}
+
+	return a.writeState(storePaths)
+}
+
+// Current implements Activator.
+func (a *nixProfileActivator) Current(ctx context.Context) ([]string, error) {
+	content, err := os.ReadFile(a.stateFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read activation state: %w", err)
+	}
+
+	storePaths := []string{}
+	err = json.Unmarshal(content, &storePaths)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode activation state: %w", err)
+	}
+
+	return storePaths, nil
+}
+
+// Generation implements Activator.
+func (a *nixProfileActivator) Generation(ctx context.Context) (int64, error) {
+	// Profile links to its generation, e.g. meshix -> meshix-12-link
+	link, err := os.Readlink(a.profile)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("Failed to read profile link: %w", err)
+	}
+
+	generation := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(link), filepath.Base(a.profile)+"-"), "-link")
+	number, err := strconv.ParseInt(generation, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Failed to parse generation of profile link %s: %w", link, err)
+	}
+
+	return number, nil
+}
+
+// Rollback implements Activator.
+func (a *nixProfileActivator) Rollback(ctx context.Context, generation int64, storePaths []string) error {
+	if generation == 0 {
+		// Nothing was activated before, new generation without packages is created
+		return a.Activate(ctx, storePaths)
+	}
+
+	_, err := runNixCmd(ctx, a.bin("nix-env"), "--profile", a.profile, "--switch-generation", strconv.FormatInt(generation, 10))
+	if err != nil {
+		return fmt.Errorf("Failed to switch profile %s to generation %d: %w", a.profile, generation, err)
+	}
+
+	return a.writeState(storePaths)
+}
+
+func (a *nixProfileActivator) writeState(storePaths []string) error {
+	content, err := json.Marshal(storePaths)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(a.stateFile), 0o755)
+	if err != nil {
+		return fmt.Errorf("Failed to create state directory: %w", err)
+	}
+	tmp := a.stateFile + ".tmp"
+	err = os.WriteFile(tmp, content, 0o644)
+	if err != nil {
+		return fmt.Errorf("Failed to write activation state: %w", err)
+	}
+
+	return os.Rename(tmp, a.stateFile)
+}
+
+func (a *nixProfileActivator) bin(name string) string {
+	if a.nixBinDir == "" {
+		return name
+	}
+
+	return filepath.Join(a.nixBinDir, name)
+}
+
+var _ Activator = (*nixProfileActivator)(nil)
@@ -0,0 +1,178 @@
+package commands
+
+import (
+	"context"
+	meshixv1 "gen/proto/meshix/v1"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// nixEnvStub creates generation links of the profile like nix-env, generations point to empty directories.
+const nixEnvStub = `#!/bin/sh
+echo "nix-env $*" >> "$(dirname "$0")/log"
+profile="$2"
+if [ "$3" = "--switch-generation" ]; then
+	ln -sfn "$(basename "$profile")-$4-link" "$profile"
+	exit 0
+fi
+generation=$(( $(ls -d "$profile"-*-link 2>/dev/null | wc -l) + 1 ))
+mkdir "$profile-$generation-link.d"
+ln -s "$(basename "$profile")-$generation-link.d" "$profile-$generation-link"
+ln -sfn "$(basename "$profile")-$generation-link" "$profile"
+`
+
+const nixStoreStub = `#!/bin/sh
+echo "nix-store $*" >> "$(dirname "$0")/log"
+`
+
+// writeNixStubs writes stub nix-env and nix-store into the directory, both log invocations to the log file.
+func writeNixStubs(t *testing.T, dir string) {
+	t.Helper()
+	for name, script := range map[string]string{"nix-env": nixEnvStub, "nix-store": nixStoreStub} {
+		err := os.WriteFile(filepath.Join(dir, name), []byte(script), 0o755)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+type testHub struct {
+	meshixv1.MeshixServiceClient
+	storePaths []string
+	reports    []*meshixv1.ReportActivationRequest
+}
+
+func (h *testHub) GetDesiredState(ctx context.Context, in *meshixv1.GetDesiredStateRequest, opts ...grpc.CallOption) (*meshixv1.GetDesiredStateResponse, error) {
+	return &meshixv1.GetDesiredStateResponse{
+		InMaintenanceWindow: true,
+		Packages:            []*meshixv1.DesiredPackage{{StorePaths: h.storePaths}},
+	}, nil
+}
+
+func (h *testHub) ReportActivation(ctx context.Context, in *meshixv1.ReportActivationRequest, opts ...grpc.CallOption) (*meshixv1.ReportActivationResponse, error) {
+	h.reports = append(h.reports, in)
+	return &meshixv1.ReportActivationResponse{}, nil
+}
+
+func TestAgentConvergesAndRollsBack(t *testing.T) {
+	ctx := context.Background()
+	binDir := t.TempDir()
+	writeNixStubs(t, binDir)
+	stateDir := t.TempDir()
+	profile := filepath.Join(t.TempDir(), "profiles", "meshix")
+	activator := newNixProfileActivator(binDir, profile, stateDir, "http://cache", "cache:key", nil, nil)
+	brokenMarker := filepath.Join(stateDir, "broken")
+	hub := &testHub{storePaths: []string{"/nix/store/aaaa-hello-2.12"}}
+	a := &agent{
+		client:    hub,
+		activator: activator,
+		health: &healthCheck{
+			command:       "test ! -e " + brokenMarker,
+			profile:       profile,
+			timeout:       time.Second,
+			retries:       1,
+			retryInterval: time.Millisecond,
+		},
+	}
+
+	generation, err := activator.Generation(ctx)
+	if err != nil || generation != 0 {
+		t.Fatalf("Generation of missing profile = %d, %v", generation, err)
+	}
+
+	err = a.converge(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertActivated(t, activator, 1, []string{"/nix/store/aaaa-hello-2.12"})
+	if len(hub.reports) != 1 || !hub.reports[0].Success {
+		t.Fatalf("Expected successful activation report, got %v", hub.reports)
+	}
+
+	// Unchanged desired state is not activated again
+	err = a.converge(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hub.reports) != 1 {
+		t.Fatalf("Unchanged desired state was activated again")
+	}
+
+	err = os.WriteFile(brokenMarker, nil, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hub.storePaths = []string{"/nix/store/bbbb-hello-2.13"}
+	err = a.converge(ctx)
+	if err == nil || !strings.Contains(err.Error(), "rolled back") {
+		t.Fatalf("Expected rolled back health check failure, got %v", err)
+	}
+	assertActivated(t, activator, 1, []string{"/nix/store/aaaa-hello-2.12"})
+	if len(hub.reports) != 2 || hub.reports[1].Success || !hub.reports[1].RolledBack {
+		t.Fatalf("Expected rolled back activation report, got %v", hub.reports)
+	}
+
+	// Rejected desired state is not retried until it changes
+	err = a.converge(ctx)
+	if err == nil || len(hub.reports) != 2 {
+		t.Fatalf("Rejected desired state was activated again, err %v", err)
+	}
+
+	log, err := os.ReadFile(filepath.Join(binDir, "log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"nix-store --realise /nix/store/aaaa-hello-2.12 --option extra-substituters http://cache --option extra-trusted-public-keys cache:key",
+		"nix-env --profile " + profile + " --install --remove-all /nix/store/aaaa-hello-2.12",
+		"nix-store --realise /nix/store/bbbb-hello-2.13 --option extra-substituters http://cache --option extra-trusted-public-keys cache:key",
+		"nix-env --profile " + profile + " --install --remove-all /nix/store/bbbb-hello-2.13",
+		"nix-env --profile " + profile + " --switch-generation 1",
+	}
+	if lines := strings.Split(strings.TrimSpace(string(log)), "\n"); !slices.Equal(lines, expected) {
+		t.Errorf("Unexpected nix invocations:\n%s", log)
+	}
+}
+
+func TestRollbackWithoutPreviousGeneration(t *testing.T) {
+	ctx := context.Background()
+	binDir := t.TempDir()
+	writeNixStubs(t, binDir)
+	profile := filepath.Join(t.TempDir(), "meshix")
+	activator := newNixProfileActivator(binDir, profile, t.TempDir(), "http://cache", "", nil, nil)
+
+	err := activator.Activate(ctx, []string{"/nix/store/aaaa-hello-2.12"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = activator.Rollback(ctx, 0, []string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertActivated(t, activator, 2, []string{})
+}
+
+func assertActivated(t *testing.T, activator *nixProfileActivator, generation int64, storePaths []string) {
+	t.Helper()
+	ctx := context.Background()
+	current, err := activator.Current(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(current, storePaths) {
+		t.Errorf("Current store paths %v, expected %v", current, storePaths)
+	}
+	got, err := activator.Generation(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != generation {
+		t.Errorf("Generation %d, expected %d", got, generation)
+	}
+}
@@ -0,0 +1,426 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	meshixv1 "gen/proto/meshix/v1"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+)
+
+type AgentCommand struct {
+	Identity      string        `long:"identity" description:"Path to machine identity written by enroll" default:"/var/lib/meshix/identity.json"`
+	Cache         string        `long:"cache" description:"Binary cache to realise packages from" required:"true"`
+	CachePubKey   string        `long:"cache-public-key" description:"Public key of the binary cache, when it is not trusted in nix config"`
+	Profile       string        `long:"profile" description:"Nix profile packages are installed into" default:"/nix/var/nix/profiles/meshix"`
+	SystemProfile string        `long:"system-profile" description:"NixOS system profile set by system packages" default:"/nix/var/nix/profiles/system"`
+	StateDir      string        `long:"state-dir" description:"Directory to keep agent state in" default:"/var/lib/meshix"`
+	SecretsDir    string        `long:"secrets-dir" description:"Directory on tmpfs secrets of the machine are decrypted into" default:"/run/meshix/secrets"`
+	NixBinDir     string        `long:"nix-bin-dir" description:"Directory with nix binaries, PATH is used when not specified"`
+	Interval      time.Duration `long:"interval" description:"Interval of fetching desired state from hub" default:"30s"`
+	Heartbeat     time.Duration `long:"heartbeat" description:"Interval of reporting status to hub" default:"15s"`
+	Once          bool          `long:"once" description:"Converge once and exit"`
+
+	HealthCommand       string        `long:"health-command" description:"Shell command checking the machine after activation, profile is rolled back when it fails"`
+	HealthUrl           string        `long:"health-url" description:"Url probed after activation, profile is rolled back when it does not return 2xx"`
+	HealthTimeout       time.Duration `long:"health-timeout" description:"Timeout of single health check" default:"10s"`
+	HealthRetries       int           `long:"health-retries" description:"Number of retries of failed health check" default:"3"`
+	HealthRetryInterval time.Duration `long:"health-retry-interval" description:"Interval between retries of health check" default:"5s"`
+
+	StageDownloadSpeed int `long:"stage-download-speed" description:"Download speed limit in KiB/s of packages staged ahead of activation, unlimited when 0"`
+
+	ServePeers string `long:"serve-peers" description:"Address to serve the store to peers on the same site on, e.g. :8090, store is not shared when empty"`
+	PeerUrl    string `long:"peer-url" description:"Url peers reach the served store on, derived from hostname and port when empty"`
+	NoPeers    bool   `long:"no-peers" description:"Substitute packages only from the binary cache, not from peers"`
+
+	Deltas bool `long:"deltas" description:"Download deltas of packages against their installed versions instead of full NARs, for slow links"`
+}
+
+// Execute periodically converges the machine profile to packages assigned to the machine.
+func (x *AgentCommand) Execute(args []string) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	identity, err := readMachineIdentity(x.Identity)
+	if err != nil {
+		return err
+	}
+	client, err := newMachineHubClient(identity)
+	if err != nil {
+		return err
+	}
+	secrets, err := newSecretsDir(x.SecretsDir, identity.PrivateKey)
+	if err != nil {
+		return err
+	}
+	var peers *hubPeers
+	if !x.NoPeers {
+		peers = &hubPeers{client: client}
+	}
+	var deltas *deltaCache
+	if x.Deltas {
+		deltas = newDeltaCache(x.NixBinDir, x.Cache, filepath.Join(x.StateDir, "deltas"), []string{x.Profile, x.SystemProfile})
+	}
+	activator := newNixProfileActivator(x.NixBinDir, x.Profile, x.StateDir, x.Cache, x.CachePubKey, peers, deltas)
+	agent := &agent{
+		client:    client,
+		activator: activator,
+		stager:    newStager(activator, x.StageDownloadSpeed),
+		system:    newNixosActivator(x.NixBinDir, x.SystemProfile),
+		health: &healthCheck{
+			command:       x.HealthCommand,
+			url:           x.HealthUrl,
+			profile:       x.Profile,
+			timeout:       x.HealthTimeout,
+			retries:       x.HealthRetries,
+			retryInterval: x.HealthRetryInterval,
+		},
+		secrets:   secrets,
+		nixBinDir: x.NixBinDir,
+	}
+	if x.ServePeers != "" {
+		server, err := newPeerServer(x.NixBinDir, x.CachePubKey)
+		if err != nil {
+			return err
+		}
+		listener, err := net.Listen("tcp", x.ServePeers)
+		if err != nil {
+			return fmt.Errorf("Failed to listen on %s: %w", x.ServePeers, err)
+		}
+		agent.peerUrl = x.PeerUrl
+		if agent.peerUrl == "" {
+			agent.peerUrl, err = defaultPeerUrl(x.ServePeers)
+			if err != nil {
+				return err
+			}
+		}
+		go func() {
+			err := server.Serve(ctx, listener)
+			if err != nil {
+				slog.Error("Peer server stopped", "err", err)
+			}
+		}()
+	}
+	slog.Info("Agent started", "machine", identity.Name, "hub", identity.HubUrl, "profile", x.Profile)
+
+	ticker := time.NewTicker(x.Interval)
+	defer ticker.Stop()
+	heartbeat := time.NewTicker(x.Heartbeat)
+	defer heartbeat.Stop()
+	for {
+		err := agent.converge(ctx)
+		if err != nil {
+			slog.Error("Failed to converge", "err", err)
+		}
+		agent.reportStatus(ctx)
+		if x.Once {
+			return err
+		}
+
+	wait:
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-heartbeat.C:
+				agent.reportStatus(ctx)
+			case <-ticker.C:
+				break wait
+			}
+		}
+	}
+}
+
+type agent struct {
+	client    meshixv1.MeshixServiceClient
+	activator Activator
+	system    SystemActivator
+	health    *healthCheck
+	secrets   *secretsDir
+	stager    *stager
+	nixBinDir string
+	// peerUrl of the store served to peers, empty when the store is not shared
+	peerUrl string
+	// failed is set after failed activation so the retry is reported even when nothing changed
+	failed bool
+	// rejected store paths failed health check and are not activated again until desired state changes
+	rejected    []string
+	rejectedErr error
+	// lastErr of convergence reported in heartbeats
+	lastErr error
+}
+
+// converge materialises secrets of the machine, then realises and activates desired state when it differs
+// from the current one and reports the result.
+func (a *agent) converge(ctx context.Context) error {
+	err := errors.Join(a.syncSecrets(ctx), a.activateDesired(ctx))
+	a.lastErr = err
+
+	return err
+}
+
+// syncSecrets fetches encrypted secrets of the machine and writes the changed ones.
+func (a *agent) syncSecrets(ctx context.Context) error {
+	if a.secrets == nil {
+		return nil
+	}
+	resp, err := a.client.GetMachineSecrets(ctx, &meshixv1.GetMachineSecretsRequest{})
+	if err != nil {
+		return fmt.Errorf("Failed to get secrets: %w", err)
+	}
+
+	return a.secrets.Sync(resp.Secrets)
+}
+
+func (a *agent) activateDesired(ctx context.Context) error {
+	resp, err := a.client.GetDesiredState(ctx, &meshixv1.GetDesiredStateRequest{})
+	if err != nil {
+		return fmt.Errorf("Failed to get desired state: %w", err)
+	}
+	// desired are all store paths reported to hub, apps are installed into the profile
+	desired := []string{}
+	apps := []string{}
+	var system *meshixv1.DesiredPackage
+	for _, pkg := range resp.Packages {
+		isSystem := pkg.Package.GetKind() == meshixv1.PackageKind_PACKAGE_KIND_SYSTEM
+		if isSystem {
+			system = pkg
+		}
+		for _, storePath := range pkg.StorePaths {
+			if !slices.Contains(desired, storePath) {
+				desired = append(desired, storePath)
+			}
+			if !isSystem && !slices.Contains(apps, storePath) {
+				apps = append(apps, storePath)
+			}
+		}
+	}
+	slices.Sort(desired)
+	slices.Sort(apps)
+	a.stage(ctx, resp.Staged)
+
+	if a.rejected != nil && slices.Equal(a.rejected, desired) {
+		return a.rejectedErr
+	}
+	a.rejected = nil
+	current, err := a.activator.Current(ctx)
+	if err != nil {
+		return err
+	}
+	previous := previousState{
+		storePaths: current,
+	}
+	appsChanged := !slices.Equal(current, apps) || a.failed
+	systemChanged := false
+	if system != nil {
+		running, err := a.system.Running(ctx)
+		if err != nil {
+			return err
+		}
+		if running == "" || len(system.StorePaths) != 1 {
+			return fmt.Errorf("System package %s can't be activated, machine does not run NixOS", system.Package.GetName())
+		}
+		previous.mode = system.Mode
+		previous.system, err = activeSystem(ctx, a.system, system.Mode)
+		if err != nil {
+			return err
+		}
+		systemChanged = previous.system != system.StorePaths[0] || a.failed
+	}
+	if !appsChanged && !systemChanged {
+		return nil
+	}
+	if !resp.InMaintenanceWindow && !emergencyPending(resp.Packages, current, previous.system) {
+		slog.Info("Deferring activation until maintenance window", "next", resp.NextMaintenanceWindow.AsTime())
+		return nil
+	}
+	previous.generation, err = a.activator.Generation(ctx)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Activating desired state", "storePaths", desired)
+	report := &meshixv1.ReportActivationRequest{
+		StorePaths: desired,
+	}
+	err = a.activator.Realise(ctx, desired)
+	if err == nil && appsChanged {
+		err = a.activator.Activate(ctx, apps)
+	}
+	if err == nil && systemChanged {
+		slog.Info("Switching system", "system", system.StorePaths[0], "mode", switchAction(system.Mode))
+		err = a.system.Switch(ctx, system.StorePaths[0], system.Mode)
+	}
+	if err == nil && a.health.configured() {
+		report.Logs, err = a.health.Run(ctx)
+		if err != nil {
+			if !appsChanged {
+				previous.storePaths = nil
+			}
+			if !systemChanged {
+				previous.system = ""
+			}
+			err = a.rollback(ctx, previous, err)
+			report.RolledBack = true
+			a.rejected = desired
+			a.rejectedErr = err
+		}
+	}
+	a.failed = err != nil && !report.RolledBack
+	report.Success = err == nil
+	if err != nil {
+		report.Error = err.Error()
+	}
+	_, reportErr := a.client.ReportActivation(ctx, report)
+	if reportErr != nil {
+		slog.Error("Failed to report activation", "err", reportErr)
+	}
+
+	return err
+}
+
+// emergencyPending checks that some emergency package is not active yet. Outside of maintenance window
+// the whole desired state is activated only to deliver emergency packages.
+func emergencyPending(packages []*meshixv1.DesiredPackage, current []string, system string) bool {
+	for _, pkg := range packages {
+		if !pkg.Emergency {
+			continue
+		}
+		if pkg.Package.GetKind() == meshixv1.PackageKind_PACKAGE_KIND_SYSTEM {
+			if len(pkg.StorePaths) == 1 && pkg.StorePaths[0] != system {
+				return true
+			}
+			continue
+		}
+		for _, storePath := range pkg.StorePaths {
+			if !slices.Contains(current, storePath) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// stage prefetches packages of prestaged rollouts in background.
+func (a *agent) stage(ctx context.Context, staged []*meshixv1.DesiredPackage) {
+	if a.stager == nil {
+		return
+	}
+	storePaths := []string{}
+	for _, pkg := range staged {
+		for _, storePath := range pkg.StorePaths {
+			if !slices.Contains(storePaths, storePath) {
+				storePaths = append(storePaths, storePath)
+			}
+		}
+	}
+	a.stager.Stage(ctx, storePaths)
+}
+
+// previousState of the machine restored on rollback.
+type previousState struct {
+	// generation of the profile and its store paths, nil store paths when the profile was not changed
+	generation int64
+	storePaths []string
+	// system restored in the mode, empty when system was not changed
+	system string
+	mode   meshixv1.ActivationMode
+}
+
+// rollback returns the profile and the system to the state before the failed health check.
+func (a *agent) rollback(ctx context.Context, previous previousState, healthErr error) error {
+	slog.Warn("Health check failed, rolling back", "generation", previous.generation, "system", previous.system, "err", healthErr)
+	if previous.storePaths != nil {
+		err := a.activator.Rollback(ctx, previous.generation, previous.storePaths)
+		if err != nil {
+			return fmt.Errorf("Health check failed: %w, rollback to generation %d failed: %w", healthErr, previous.generation, err)
+		}
+	}
+	if previous.system != "" {
+		err := a.system.Switch(ctx, previous.system, previous.mode)
+		if err != nil {
+			return fmt.Errorf("Health check failed: %w, rollback to system %s failed: %w", healthErr, previous.system, err)
+		}
+	}
+
+	return fmt.Errorf("Health check failed, rolled back: %w", healthErr)
+}
+
+// reportStatus sends heartbeat with state of the machine, failures are only logged.
+func (a *agent) reportStatus(ctx context.Context) {
+	status := &meshixv1.ReportStatusRequest{}
+	if a.lastErr != nil {
+		status.LastError = a.lastErr.Error()
+	}
+
+	generation, err := a.activator.Generation(ctx)
+	if err != nil {
+		slog.Warn("Failed to get profile generation", "err", err)
+	}
+	status.ProfileGeneration = generation
+	storePaths, err := a.activator.Current(ctx)
+	if err != nil {
+		slog.Warn("Failed to get installed store paths", "err", err)
+	}
+	status.StorePaths = storePaths
+	status.NixVersion, err = nixVersion(ctx, a.nixBinDir)
+	if err != nil {
+		slog.Warn("Failed to get nix version", "err", err)
+	}
+	status.FreeDiskBytes, err = freeDiskBytes(storeDir)
+	if err != nil {
+		slog.Warn("Failed to get free disk space", "err", err)
+	}
+	status.RunningSystem, err = a.system.Running(ctx)
+	if err != nil {
+		slog.Warn("Failed to get running system", "err", err)
+	}
+	status.BootedSystem, err = a.system.Booted(ctx)
+	if err != nil {
+		slog.Warn("Failed to get booted system", "err", err)
+	}
+	if a.stager != nil {
+		status.StagedStorePaths = a.stager.Ready()
+	}
+	status.PeerUrl = a.peerUrl
+
+	_, err = a.client.ReportStatus(ctx, status)
+	if err != nil {
+		slog.Error("Failed to report status", "err", err)
+	}
+}
+
+const storeDir = "/nix/store"
+
+// nixVersion parses output of nix --version, e.g. nix (Nix) 2.24.10
+func nixVersion(ctx context.Context, nixBinDir string) (string, error) {
+	output, err := runNixCmd(ctx, filepath.Join(nixBinDir, "nix"), "--version")
+	if err != nil {
+		return "", err
+	}
+	fields := strings.Fields(output.String())
+	if len(fields) == 0 {
+		return "", fmt.Errorf("Unexpected output of nix --version: %s", output.String())
+	}
+
+	return fields[len(fields)-1], nil
+}
+
+func freeDiskBytes(path string) (int64, error) {
+	var stat syscall.Statfs_t
+	err := syscall.Statfs(path, &stat)
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(stat.Bavail) * int64(stat.Bsize), nil
+}
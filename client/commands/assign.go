@@ -0,0 +1,67 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	meshixv1 "gen/proto/meshix/v1"
+	"log/slog"
+	"strings"
+)
+
+type AssignCommand struct {
+	HubUrl    string `long:"hub-url" description:"Url of package hub" required:"true"`
+	Machine   string `long:"machine" description:"Name of the machine to assign package to"`
+	Group     string `long:"group" description:"Group of machines to assign package to"`
+	Remove    bool   `long:"remove" description:"Remove the assignment"`
+	Mode      string `long:"mode" description:"Activation mode of system package" choice:"switch" choice:"boot" choice:"test" default:"switch"`
+	Emergency bool   `long:"emergency" description:"Activate the package outside of maintenance windows"`
+}
+
+var activationModes = map[string]meshixv1.ActivationMode{
+	"switch": meshixv1.ActivationMode_ACTIVATION_MODE_SWITCH,
+	"boot":   meshixv1.ActivationMode_ACTIVATION_MODE_BOOT,
+	"test":   meshixv1.ActivationMode_ACTIVATION_MODE_TEST,
+}
+
+// Execute assigns package to machines, argument is <name> or <name>@<version>.
+// Machines follow latest version of the package when version is not specified.
+func (x *AssignCommand) Execute(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("Expected 1 argument <name>[@<version>], got: %d", len(args))
+	}
+	ctx := context.Background()
+	name, version, _ := strings.Cut(args[0], "@")
+
+	client, err := newHubClient(x.HubUrl)
+	if err != nil {
+		return err
+	}
+
+	if x.Remove {
+		_, err = client.UnassignPackage(ctx, &meshixv1.UnassignPackageRequest{
+			Machine:     x.Machine,
+			Group:       x.Group,
+			PackageName: name,
+		})
+		if err != nil {
+			return fmt.Errorf("Failed to remove assignment: %w", err)
+		}
+		slog.Info("Assignment removed", "name", name, "machine", x.Machine, "group", x.Group)
+		return nil
+	}
+
+	_, err = client.AssignPackage(ctx, &meshixv1.AssignPackageRequest{
+		Machine:        x.Machine,
+		Group:          x.Group,
+		PackageName:    name,
+		PackageVersion: version,
+		Mode:           activationModes[x.Mode],
+		Emergency:      x.Emergency,
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to assign package: %w", err)
+	}
+	slog.Info("Package assigned", "name", name, "version", version, "machine", x.Machine, "group", x.Group)
+
+	return nil
+}
@@ -7,13 +7,11 @@ import (
 	"fmt"
 	meshixv1 "gen/proto/meshix/v1"
 	"log/slog"
+	"maps"
 	"os"
 	"os/exec"
+	"slices"
 	"strings"
-	"time"
-
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
 )
 
 // [{"drvPath":"/nix/store/pznj731mjim1xdd5mir97l20pk3gy5a8-hello-2.12.1.drv","outputs":{"out":"/nix/store/a7hnr9dcmx3qkkn8a20g7md1wya5zc9l-hello-2.12.1"}}]
@@ -24,6 +22,28 @@ type nixBuildOutput struct {
 
 const mainOutput = "out"
 
+// defaultOutput picks store path of the first output to install, falling back to the main output.
+func (o *nixBuildOutput) defaultOutput(outputsToInstall []string) string {
+	for _, output := range outputsToInstall {
+		if path, ok := o.Outputs[output]; ok {
+			return path
+		}
+	}
+	if path, ok := o.Outputs[mainOutput]; ok {
+		return path
+	}
+
+	names := slices.Sorted(maps.Keys(o.Outputs))
+	if len(names) == 0 {
+		return ""
+	}
+	return o.Outputs[names[0]]
+}
+
+func (o *nixBuildOutput) storePaths() []string {
+	return slices.Sorted(maps.Values(o.Outputs))
+}
+
 type buildOverrides struct {
 	Name    string `long:"o-name" description:"Name of the package"`
 	Version string `long:"o-version" description:"Version of the package"`
@@ -34,10 +54,16 @@ type BuildCommand struct {
 	HubUrl    string         `long:"hub-url" description:"Url of package hub"`
 	Cache     string         `long:"cache" description:"Cache to push artifacts to, if not specified nothing is pushed"`
 	Watch     bool           `long:"watch" description:"Watch the store and upload changes when building package"`
-	All       bool           `long:"all" description:"Builds all packages in current flake"`
+	All       bool           `long:"all" description:"Builds all packages in current flake, for every system this machine can build unless --system is set"`
+	System    string         `long:"system" description:"Nix system to build for, current system is used if not specified"`
 	Overrides buildOverrides `group:"overrides"`
 }
 
+type buildTarget struct {
+	expr   string
+	system string
+}
+
 func (x *BuildCommand) Execute(args []string) error {
 	if len(args) != 1 && !x.All {
 		return fmt.Errorf("Expected 1 argument or --all flag, got: %d", len(args))
@@ -57,21 +83,32 @@ func (x *BuildCommand) Execute(args []string) error {
 			}
 		}()
 	}
-	expressions := []string{}
+	nixSystems, err := getNixSystems(ctx)
+	if err != nil {
+		return err
+	}
+	systems := []string{nixSystems.current}
+	if x.System != "" {
+		systems = []string{x.System}
+	} else if x.All {
+		systems = nixSystems.buildable()
+	}
+
+	targets := []buildTarget{}
 	if x.All {
-		pkgs, err := getAllFlakePackages(ctx)
+		pkgs, err := getAllFlakePackages(ctx, nixSystems.current, systems)
 		if err != nil {
 			return err
 		}
-		expressions = append(expressions, pkgs...)
+		targets = append(targets, pkgs...)
 	} else {
-		expressions = append(expressions, args[0])
+		targets = append(targets, buildTarget{expr: args[0], system: systems[0]})
 	}
 
 	// TODO handle controll from user, ^C and cross build errors
 	// TODO split output, it's not readable in console currently
-	for _, expr := range expressions {
-		err := x.buildExpr(ctx, expr)
+	for _, target := range targets {
+		err := x.buildExpr(ctx, target)
 		if err != nil {
 			// TODO better log
 			fmt.Printf("Expr build failed: %v\n", err)
@@ -82,48 +119,62 @@ func (x *BuildCommand) Execute(args []string) error {
 	return nil
 }
 
-func (x *BuildCommand) buildExpr(ctx context.Context, expr string) error {
-	meta, err := getPackageMeta(ctx, expr)
+func (x *BuildCommand) buildExpr(ctx context.Context, target buildTarget) error {
+	expr := target.expr
+	meta, err := getPackageMeta(ctx, expr, target.system)
 	if err != nil {
 		return err
 	}
 
-	buildOutput, err := buildPackage(ctx, expr)
+	buildOutput, err := buildPackage(ctx, expr, target.system)
 	if err != nil {
 		return err
 	}
 
 	if x.Cache != "" {
-		err = pushPackage(ctx, x.Cache, expr)
+		err = pushPackage(ctx, x.Cache, buildOutput.storePaths()...)
 		if err != nil {
 			return err
 		}
 	}
 
-	version, err := getPackageVersion(ctx, expr, x)
+	version, err := getPackageVersion(ctx, expr, target.system, x)
 	if err != nil {
 		return err
 	}
 	mainBin := getPackageMainBin(x, meta)
 
 	if x.HubUrl != "" {
-		cc, err := grpc.NewClient(x.HubUrl, grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithConnectParams(grpc.ConnectParams{
-			MinConnectTimeout: 2 * time.Second,
-		}))
+		provenance, err := collectProvenance(ctx, expr, buildOutput.DrvPaht)
+		if err != nil {
+			return err
+		}
+
+		client, err := newHubClient(x.HubUrl)
 		if err != nil {
 			return err
 		}
 
-		client := meshixv1.NewMeshixServiceClient(cc)
 		_, err = client.PushPackage(ctx, &meshixv1.PushPackageRequest{
 			Package: &meshixv1.Package{
 				Name:    meta.Name,
 				Version: version,
+				System:  target.system,
 				NixMetadata: &meshixv1.NixMetadata{
-					StorePath: buildOutput.Outputs[mainOutput],
-					MainBin:   mainBin,
+					StorePath:        buildOutput.defaultOutput(meta.OutputsToInstall),
+					MainBin:          mainBin,
+					Outputs:          buildOutput.Outputs,
+					OutputsToInstall: meta.OutputsToInstall,
+					Description:      meta.Description,
+					Licenses:         meta.License,
+					Homepage:         string(meta.Homepage),
+					Platforms:        meta.Platforms,
+					Unfree:           meta.Unfree,
+					Insecure:         meta.Insecure,
+					Position:         meta.Position,
 				},
 			},
+			Provenance: provenance,
 		})
 		if err != nil {
 			return err
@@ -133,10 +184,13 @@ func (x *BuildCommand) buildExpr(ctx context.Context, expr string) error {
 	return nil
 }
 
-func getAllFlakePackages(ctx context.Context) ([]string, error) {
+func getAllFlakePackages(ctx context.Context, currentSystem string, systems []string) ([]buildTarget, error) {
 	evalArgs := []string{
 		"flake", "show", "--quiet", "--json",
 	}
+	if slices.ContainsFunc(systems, func(s string) bool { return s != currentSystem }) {
+		evalArgs = append(evalArgs, "--all-systems")
+	}
 	output, err := runNixCmd(ctx, "nix", evalArgs...)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to show flake data 'nix %s' : %w", strings.Join(evalArgs, " "), err)
@@ -149,18 +203,22 @@ func getAllFlakePackages(ctx context.Context) ([]string, error) {
 	packages, ok := data["packages"].(map[string]any)
 	if !ok {
 		// TODO log no package to build
-		return []string{}, nil
+		return []buildTarget{}, nil
 	}
 
-	// TODO determine which system to build for
-	linuxPackages, ok := packages["x86_64-linux"].(map[string]any)
-	if !ok {
-		// TODO log no package to build
-		return []string{}, nil
-	}
-	result := []string{}
-	for pkgs := range linuxPackages {
-		result = append(result, fmt.Sprintf(".#packages.x86_64-linux.%s", pkgs))
+	result := []buildTarget{}
+	for _, system := range systems {
+		systemPackages, ok := packages[system].(map[string]any)
+		if !ok {
+			slog.Info("Flake has no packages for system", "system", system)
+			continue
+		}
+		for pkg := range systemPackages {
+			result = append(result, buildTarget{
+				expr:   fmt.Sprintf(".#packages.%s.%s", system, pkg),
+				system: system,
+			})
+		}
 	}
 
 	return result, nil
@@ -179,7 +237,7 @@ func getPackageMainBin(cmd *BuildCommand, meta *nixMeta) string {
 	return meta.Name
 }
 
-func getPackageVersion(ctx context.Context, expr string, cmd *BuildCommand) (string, error) {
+func getPackageVersion(ctx context.Context, expr string, system string, cmd *BuildCommand) (string, error) {
 	if cmd.Overrides.Version != "" {
 		return cmd.Overrides.Version, nil
 	}
@@ -191,7 +249,7 @@ func getPackageVersion(ctx context.Context, expr string, cmd *BuildCommand) (str
 		evalExpr += ".version"
 	}
 	evalArgs := []string{
-		"eval", "--quiet", "--json", evalExpr,
+		"eval", "--quiet", "--json", "--system", system, evalExpr,
 	}
 	output, err := runNixCmd(ctx, "nix", evalArgs...)
 	if err != nil {
@@ -208,20 +266,21 @@ func getPackageVersion(ctx context.Context, expr string, cmd *BuildCommand) (str
 	return version, nil
 }
 
-func pushPackage(ctx context.Context, cacheUrl string, expr string) error {
-	slog.Info("Pushing to binary cache", "expr", expr)
+func pushPackage(ctx context.Context, cacheUrl string, storePaths ...string) error {
+	slog.Info("Pushing to binary cache", "paths", storePaths)
 
-	_, err := runNixCmd(ctx, "nix", "copy", "--quiet", "--to", cacheUrl, expr)
+	copyArgs := append([]string{"copy", "--quiet", "--to", cacheUrl}, storePaths...)
+	_, err := runNixCmd(ctx, "nix", copyArgs...)
 	if err != nil {
 		return fmt.Errorf("Failed to push to binary cache: %w", err)
 	}
 	return nil
 }
 
-func buildPackage(ctx context.Context, expr string) (*nixBuildOutput, error) {
-	slog.Info(fmt.Sprintf("Building %s", expr))
+func buildPackage(ctx context.Context, expr string, system string) (*nixBuildOutput, error) {
+	slog.Info(fmt.Sprintf("Building %s", expr), "system", system)
 	// TODO add substituters and trusted keys
-	output, err := runNixCmd(ctx, "nix", "build", "--quiet", "--json", expr)
+	output, err := runNixCmd(ctx, "nix", "build", "--quiet", "--json", "--system", system, allOutputs(expr))
 	if err != nil {
 		return nil, err
 	}
@@ -235,15 +294,27 @@ func buildPackage(ctx context.Context, expr string) (*nixBuildOutput, error) {
 		return nil, fmt.Errorf("Expected at least one build output, got: %d", len(buildOutputs))
 	}
 	if len(buildOutputs) > 1 {
-		return nil, fmt.Errorf("Builds with more then one outputs not supported yet, got: %d", len(buildOutputs))
+		return nil, fmt.Errorf("Expected exactly one derivation, got: %d", len(buildOutputs))
 	}
 	buildOutput := buildOutputs[0]
-	slog.Info("Build derivation", "drv", buildOutput.DrvPaht, "out", buildOutput.Outputs[mainOutput])
+	slog.Info("Build derivation", "drv", buildOutput.DrvPaht, "outputs", buildOutput.Outputs)
 
 	return &buildOutput, nil
 }
 
-func getPackageMeta(ctx context.Context, expr string) (*nixMeta, error) {
+// allOutputs makes installable select every output of the derivation.
+func allOutputs(expr string) string {
+	if strings.Contains(expr, "^") {
+		return expr
+	}
+	if strings.HasSuffix(expr, "#") {
+		expr += "default"
+	}
+
+	return expr + "^*"
+}
+
+func getPackageMeta(ctx context.Context, expr string, system string) (*nixMeta, error) {
 	evalExpr := expr
 	if strings.HasSuffix(evalExpr, "#") {
 		evalExpr += "default.meta"
@@ -251,7 +322,7 @@ func getPackageMeta(ctx context.Context, expr string) (*nixMeta, error) {
 		evalExpr += ".meta"
 	}
 	evalArgs := []string{
-		"eval", "--json", "--quiet", evalExpr,
+		"eval", "--json", "--quiet", "--system", system, evalExpr,
 	}
 	output, err := runNixCmd(ctx, "nix", evalArgs...)
 	if err != nil {
@@ -268,17 +339,81 @@ func getPackageMeta(ctx context.Context, expr string) (*nixMeta, error) {
 
 // {"available":true,"broken":false,"insecure":false,"name":"meshix-server","outputsToInstall":["out"],"platforms":["x86_64-darwin","i686-darwin","aarch64-darwin","armv7a-darwin","aarch64-linux","armv5tel-linux","armv6l-linux","armv7a-linux","armv7l-linux","i686-linux","loongarch64-linux","m68k-linux","microblaze-linux","microblazeel-linux","mips-linux","mips64-linux","mips64el-linux","mipsel-linux","powerpc64-linux","powerpc64le-linux","riscv32-linux","riscv64-linux","s390-linux","s390x-linux","x86_64-linux","wasm64-wasi","wasm32-wasi","i686-freebsd","x86_64-freebsd","aarch64-freebsd"],"position":"/nix/store/w6hcacb97bi6fdr4w1l0d159738hbk39-source/nix/server.nix:60","unfree":false,"unsupported":false}
 type nixMeta struct {
-	Available        bool     `json:"available"`
-	Broken           bool     `json:"broken"`
-	Description      string   `json:"description"`
-	Insecure         bool     `json:"insecure"`
-	MainProgram      string   `json:"mainProgram"`
-	Name             string   `json:"name"`
-	OutputsToInstall []string `json:"outputsToInstall"`
-	Platforms        []string `json:"platforms"`
-	Position         string   `json:"position"`
-	Unfree           bool     `json:"unfree"`
-	Unsupported      bool     `json:"unsupported"`
+	Available        bool        `json:"available"`
+	Broken           bool        `json:"broken"`
+	Description      string      `json:"description"`
+	Homepage         nixHomepage `json:"homepage"`
+	Insecure         bool        `json:"insecure"`
+	License          nixLicenses `json:"license"`
+	MainProgram      string      `json:"mainProgram"`
+	Name             string      `json:"name"`
+	OutputsToInstall []string    `json:"outputsToInstall"`
+	Platforms        []string    `json:"platforms"`
+	Position         string      `json:"position"`
+	Unfree           bool        `json:"unfree"`
+	Unsupported      bool        `json:"unsupported"`
+}
+
+// nixLicenses decodes meta.license which is a license attrset, list of them or plain string.
+type nixLicenses []string
+
+type nixLicense struct {
+	SpdxId    string `json:"spdxId"`
+	ShortName string `json:"shortName"`
+	FullName  string `json:"fullName"`
+}
+
+func (l *nixLicenses) UnmarshalJSON(data []byte) error {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		raw = []json.RawMessage{data}
+	}
+
+	licenses := nixLicenses{}
+	for _, r := range raw {
+		var name string
+		if err := json.Unmarshal(r, &name); err == nil {
+			licenses = append(licenses, name)
+			continue
+		}
+
+		var license nixLicense
+		if err := json.Unmarshal(r, &license); err != nil {
+			return fmt.Errorf("Failed to decode license: %w", err)
+		}
+		switch {
+		case license.SpdxId != "":
+			licenses = append(licenses, license.SpdxId)
+		case license.ShortName != "":
+			licenses = append(licenses, license.ShortName)
+		case license.FullName != "":
+			licenses = append(licenses, license.FullName)
+		}
+	}
+	*l = licenses
+
+	return nil
+}
+
+// nixHomepage decodes meta.homepage which is either string or list of them, first one is used.
+type nixHomepage string
+
+func (h *nixHomepage) UnmarshalJSON(data []byte) error {
+	var homepages []string
+	if err := json.Unmarshal(data, &homepages); err == nil {
+		if len(homepages) > 0 {
+			*h = nixHomepage(homepages[0])
+		}
+		return nil
+	}
+
+	var homepage string
+	if err := json.Unmarshal(data, &homepage); err != nil {
+		return fmt.Errorf("Failed to decode homepage: %w", err)
+	}
+	*h = nixHomepage(homepage)
+
+	return nil
 }
 
 func runNixCmd(ctx context.Context, command string, args ...string) (*bytes.Buffer, error) {
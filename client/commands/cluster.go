@@ -0,0 +1,77 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	meshixv1 "gen/proto/meshix/v1"
+	"log/slog"
+	"time"
+)
+
+// ClusterCommand groups cluster subcommands.
+type ClusterCommand struct{}
+
+type ClusterStatusCommand struct {
+	HubUrl string `long:"hub-url" description:"Url of a node of the cluster" required:"true"`
+}
+
+func (x *ClusterStatusCommand) Execute(args []string) error {
+	ctx := context.Background()
+	client, err := newHubClient(x.HubUrl)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.GetClusterStatus(ctx, &meshixv1.GetClusterStatusRequest{})
+	if err != nil {
+		return fmt.Errorf("Failed to get cluster status: %w", err)
+	}
+	fmt.Printf("replication factor %d\n", resp.ReplicationFactor)
+	for _, n := range resp.Nodes {
+		state := "live"
+		if !n.Live {
+			state = "down"
+		}
+		self := ""
+		if n.Self {
+			self = "\tself"
+		}
+		fmt.Printf("%s\t%s%s\n", n.Url, state, self)
+	}
+	r := resp.Rebalance
+	if r == nil {
+		return nil
+	}
+	state := "rebalanced"
+	if r.Running {
+		state = "rebalancing"
+	}
+	fmt.Printf("%s, started %s: checked %d, copied %d, dropped %d, failed %d\n",
+		state, r.StartedAt.AsTime().Local().Format(time.DateTime), r.Checked, r.Copied, r.Dropped, r.Failed)
+	if r.LastError != "" {
+		fmt.Printf("  error: %s\n", r.LastError)
+	}
+
+	return nil
+}
+
+type ClusterRebalanceCommand struct {
+	HubUrl string `long:"hub-url" description:"Url of the node to rebalance" required:"true"`
+}
+
+// Execute starts rebalancing of objects of the node.
+func (x *ClusterRebalanceCommand) Execute(args []string) error {
+	ctx := context.Background()
+	client, err := newHubClient(x.HubUrl)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.RebalanceCluster(ctx, &meshixv1.RebalanceClusterRequest{})
+	if err != nil {
+		return fmt.Errorf("Failed to start rebalancing: %w", err)
+	}
+	slog.Info("Rebalancing started", "hub", x.HubUrl)
+
+	return nil
+}
@@ -0,0 +1,39 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	meshixv1 "gen/proto/meshix/v1"
+	"log/slog"
+)
+
+type DeleteCommand struct {
+	HubUrl     string `long:"hub-url" description:"Url of package hub" required:"true"`
+	DropGcRoot bool   `long:"drop-gc-root" description:"Drop store path of the package from gc roots"`
+	System     string `long:"system" description:"Only delete package built for the nix system"`
+}
+
+func (x *DeleteCommand) Execute(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("Expected 2 arguments <name> <version>, got: %d", len(args))
+	}
+	ctx := context.Background()
+
+	client, err := newHubClient(x.HubUrl)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.DeletePackage(ctx, &meshixv1.DeletePackageRequest{
+		Name:       args[0],
+		Version:    args[1],
+		System:     x.System,
+		DropGcRoot: x.DropGcRoot,
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to delete package: %w", err)
+	}
+	slog.Info("Package deleted", "name", args[0], "version", args[1])
+
+	return nil
+}
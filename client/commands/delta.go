@@ -0,0 +1,324 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sbom"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/nix-community/go-nix/pkg/narinfo"
+	"github.com/nix-community/go-nix/pkg/nixbase32"
+	"github.com/nix-community/go-nix/pkg/nixhash"
+	"github.com/nix-community/go-nix/pkg/storepath"
+)
+
+// deltaDictID identifies the base NAR used as raw zstd dictionary, see handlers.HandleDeltaNar of the hub.
+const deltaDictID = 1
+
+// Reconstructed NARs are preferred over peers and the binary cache.
+const deltaCacheInfo = `StoreDir: /nix/store
+WantMassQuery: 0
+Priority: 10
+`
+
+var errNoDelta = errors.New("Delta is not available")
+
+// deltaCache downloads deltas of missing store paths against older versions installed on the machine
+// and reconstructs their NARs into a local binary cache. Narinfos keep signatures of the hub, so nix
+// verifies reconstructed NARs the same way as NARs downloaded from the binary cache.
+type deltaCache struct {
+	nixBinDir string
+	cacheUrl  string
+	dir       string
+	// roots are profiles whose closures are used as bases of deltas
+	roots  []string
+	client *http.Client
+}
+
+func newDeltaCache(nixBinDir, cacheUrl, dir string, roots []string) *deltaCache {
+	return &deltaCache{
+		nixBinDir: nixBinDir,
+		cacheUrl:  strings.TrimSuffix(cacheUrl, "/"),
+		dir:       dir,
+		roots:     roots,
+		client:    http.DefaultClient,
+	}
+}
+
+// Prepare reconstructs NARs of the missing closure of store paths which have a base installed. It returns url
+// of the local binary cache and function removing it, url is empty when no delta was downloaded.
+func (d *deltaCache) Prepare(ctx context.Context, storePaths []string) (string, func(), error) {
+	missing, err := d.missing(ctx, storePaths)
+	if err != nil || len(missing) == 0 {
+		return "", func() {}, err
+	}
+	bases, err := d.bases(ctx)
+	if err != nil {
+		return "", func() {}, err
+	}
+
+	err = os.MkdirAll(d.dir, 0o755)
+	if err != nil {
+		return "", func() {}, fmt.Errorf("Failed to create delta directory: %w", err)
+	}
+	dir, err := os.MkdirTemp(d.dir, "cache-")
+	if err != nil {
+		return "", func() {}, fmt.Errorf("Failed to create delta cache: %w", err)
+	}
+	cleanup := func() {
+		err := os.RemoveAll(dir)
+		if err != nil {
+			slog.Warn("Failed to remove delta cache", "dir", dir, "err", err)
+		}
+	}
+	err = os.Mkdir(filepath.Join(dir, "nar"), 0o755)
+	if err == nil {
+		err = os.WriteFile(filepath.Join(dir, "nix-cache-info"), []byte(deltaCacheInfo), 0o644)
+	}
+	if err != nil {
+		cleanup()
+		return "", func() {}, fmt.Errorf("Failed to create delta cache: %w", err)
+	}
+
+	reconstructed := 0
+	for _, info := range missing {
+		sp, err := storepath.FromAbsolutePath(info.StorePath)
+		if err != nil {
+			continue
+		}
+		base, ok := bases.find(sp.Name)
+		if !ok {
+			continue
+		}
+		err = d.reconstruct(ctx, dir, info, base)
+		if errors.Is(err, errNoDelta) {
+			continue
+		}
+		if err != nil {
+			slog.Warn("Failed to reconstruct nar from delta", "storePath", info.StorePath, "base", base, "err", err)
+			continue
+		}
+		slog.Info("Nar reconstructed from delta", "storePath", info.StorePath, "base", base)
+		reconstructed++
+	}
+	if reconstructed == 0 {
+		cleanup()
+		return "", func() {}, nil
+	}
+
+	return "file://" + dir, cleanup, nil
+}
+
+// missing walks closure of the store paths with narinfos of the binary cache, store paths which are present
+// locally or not in the binary cache are skipped together with their references.
+func (d *deltaCache) missing(ctx context.Context, storePaths []string) ([]*narinfo.NarInfo, error) {
+	missing := []*narinfo.NarInfo{}
+	queue := append([]string{}, storePaths...)
+	seen := map[string]bool{}
+	for len(queue) > 0 {
+		storePath := queue[0]
+		queue = queue[1:]
+		if seen[storePath] {
+			continue
+		}
+		seen[storePath] = true
+		_, err := os.Lstat(storePath)
+		if err == nil {
+			continue
+		}
+
+		info, err := d.narInfo(ctx, storePath)
+		if err != nil {
+			return nil, err
+		}
+		if info == nil {
+			continue
+		}
+		missing = append(missing, info)
+		for _, reference := range info.References {
+			queue = append(queue, path.Join(storepath.StoreDir, reference))
+		}
+	}
+
+	return missing, nil
+}
+
+// narInfo gets narinfo of the store path from the binary cache, nil when the cache does not have it.
+func (d *deltaCache) narInfo(ctx context.Context, storePath string) (*narinfo.NarInfo, error) {
+	sp, err := storepath.FromAbsolutePath(storePath)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.cacheUrl+"/"+nixbase32.EncodeToString(sp.Digest)+".narinfo", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get narinfo of %s: %w", storePath, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Failed to get narinfo of %s: %s", storePath, resp.Status)
+	}
+
+	info, err := narinfo.Parse(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse narinfo of %s: %w", storePath, err)
+	}
+
+	return info, nil
+}
+
+// reconstruct downloads delta of the narinfo against the base store path and writes the verified NAR
+// with its narinfo into the cache directory.
+func (d *deltaCache) reconstruct(ctx context.Context, dir string, info *narinfo.NarInfo, base string) error {
+	if info.NarHash == nil || info.NarHash.Algo() != nixhash.SHA256 {
+		return errNoDelta
+	}
+	baseNar, err := runNixCmd(ctx, d.bin("nix-store"), "--dump", base)
+	if err != nil {
+		return fmt.Errorf("Failed to dump base store path: %w", err)
+	}
+	if uint64(baseNar.Len())+info.NarSize > zstd.MaxWindowSize {
+		return errNoDelta
+	}
+
+	sp, err := storepath.FromAbsolutePath(info.StorePath)
+	if err != nil {
+		return err
+	}
+	baseSp, err := storepath.FromAbsolutePath(base)
+	if err != nil {
+		return err
+	}
+	hash := nixbase32.EncodeToString(sp.Digest)
+	deltaUrl := d.cacheUrl + "/delta/" + hash + ".nar.zst?" + url.Values{"base": {nixbase32.EncodeToString(baseSp.Digest)}}.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, deltaUrl, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Failed to get delta: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errNoDelta
+	}
+
+	decoder, err := zstd.NewReader(resp.Body, zstd.WithDecoderDictRaw(deltaDictID, baseNar.Bytes()))
+	if err != nil {
+		return fmt.Errorf("Failed to create delta decoder: %w", err)
+	}
+	defer decoder.Close()
+	narPath := filepath.Join(dir, "nar", hash+".nar")
+	nar, err := os.Create(narPath)
+	if err != nil {
+		return fmt.Errorf("Failed to create nar: %w", err)
+	}
+	defer nar.Close()
+	narHash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(nar, narHash), io.LimitReader(decoder, int64(info.NarSize)+1))
+	if err == nil && (uint64(size) != info.NarSize || !bytes.Equal(narHash.Sum(nil), info.NarHash.Digest())) {
+		err = fmt.Errorf("Nar does not match NarHash %s", info.NarHash)
+	}
+	if err == nil {
+		err = nar.Close()
+	}
+	if err != nil {
+		_ = os.Remove(narPath)
+		return fmt.Errorf("Failed to reconstruct nar: %w", err)
+	}
+
+	local := *info
+	local.URL = "nar/" + hash + ".nar"
+	local.Compression = "none"
+	local.FileHash = info.NarHash
+	local.FileSize = info.NarSize
+	err = os.WriteFile(filepath.Join(dir, hash+".narinfo"), []byte(local.String()), 0o644)
+	if err != nil {
+		return fmt.Errorf("Failed to write narinfo: %w", err)
+	}
+
+	return nil
+}
+
+// deltaBases are installed store paths by their name and package name without version.
+type deltaBases struct {
+	byName  map[string]string
+	byPname map[string]string
+}
+
+// bases lists closures of existing roots.
+func (d *deltaCache) bases(ctx context.Context) (deltaBases, error) {
+	bases := deltaBases{
+		byName:  map[string]string{},
+		byPname: map[string]string{},
+	}
+	roots := []string{}
+	for _, root := range d.roots {
+		_, err := os.Stat(root)
+		if err == nil {
+			roots = append(roots, root)
+		}
+	}
+	if len(roots) == 0 {
+		return bases, nil
+	}
+
+	args := append([]string{"--query", "--requisites"}, roots...)
+	output, err := runNixCmd(ctx, d.bin("nix-store"), args...)
+	if err != nil {
+		return bases, fmt.Errorf("Failed to query closure of installed store paths: %w", err)
+	}
+	for _, storePath := range strings.Fields(output.String()) {
+		sp, err := storepath.FromAbsolutePath(storePath)
+		if err != nil || strings.HasSuffix(sp.Name, ".drv") {
+			continue
+		}
+		bases.byName[sp.Name] = storePath
+		bases.byPname[drvPname(sp.Name)] = storePath
+	}
+
+	return bases, nil
+}
+
+// find prefers base with the same name, rebuilt with changed dependencies, over another version of the package.
+func (b deltaBases) find(name string) (string, bool) {
+	if base, ok := b.byName[name]; ok {
+		return base, true
+	}
+	base, ok := b.byPname[drvPname(name)]
+
+	return base, ok
+}
+
+// drvPname strips version from the store path name.
+func drvPname(name string) string {
+	pname, _ := sbom.ParseName(name)
+
+	return pname
+}
+
+func (d *deltaCache) bin(name string) string {
+	if d.nixBinDir == "" {
+		return name
+	}
+
+	return filepath.Join(d.nixBinDir, name)
+}
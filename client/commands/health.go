@@ -0,0 +1,108 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// maxHealthLogBytes limits logs of the failed health check reported to hub, the tail is kept.
+const maxHealthLogBytes = 16 << 10
+
+// healthCheck verifies the machine works after activation, by running command or probing HTTP url.
+type healthCheck struct {
+	command       string
+	url           string
+	profile       string
+	timeout       time.Duration
+	retries       int
+	retryInterval time.Duration
+}
+
+func (h *healthCheck) configured() bool {
+	return h.command != "" || h.url != ""
+}
+
+// Run retries the check until it passes. Output of the last attempt is returned when it fails.
+func (h *healthCheck) Run(ctx context.Context) (string, error) {
+	var logs string
+	var err error
+	for attempt := 0; attempt <= h.retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return logs, ctx.Err()
+			case <-time.After(h.retryInterval):
+			}
+		}
+		logs, err = h.attempt(ctx)
+		if err == nil {
+			return logs, nil
+		}
+	}
+
+	return tail(logs, maxHealthLogBytes), err
+}
+
+func (h *healthCheck) attempt(ctx context.Context) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	logs := ""
+	if h.command != "" {
+		// Command can use binaries of the activated profile from MESHIX_PROFILE
+		cmd := exec.CommandContext(ctx, "sh", "-c", h.command)
+		cmd.Env = append(os.Environ(), "MESHIX_PROFILE="+h.profile)
+		output, err := cmd.CombinedOutput()
+		logs = string(output)
+		if err != nil {
+			return logs, fmt.Errorf("Health command failed: %w", err)
+		}
+	}
+	if h.url != "" {
+		output, err := h.probe(ctx)
+		logs += output
+		if err != nil {
+			return logs, err
+		}
+	}
+
+	return logs, nil
+}
+
+func (h *healthCheck) probe(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.url, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("Health probe failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body := bytes.NewBuffer([]byte{})
+	_, err = io.Copy(body, io.LimitReader(resp.Body, maxHealthLogBytes))
+	if err != nil {
+		return "", fmt.Errorf("Failed to read health probe response: %w", err)
+	}
+	output := fmt.Sprintf("GET %s: %s\n%s", h.url, resp.Status, body.String())
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return output, fmt.Errorf("Health probe returned %s", resp.Status)
+	}
+
+	return output, nil
+}
+
+func tail(s string, size int) string {
+	if len(s) <= size {
+		return s
+	}
+
+	return s[len(s)-size:]
+}
@@ -0,0 +1,85 @@
+package commands
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"gen/machineauth"
+	meshixv1 "gen/proto/meshix/v1"
+	"os"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+// Env variable with API token presented to the hub
+const tokenEnv = "MESHIX_TOKEN"
+
+func newHubClient(hubUrl string, extraOpts ...grpc.DialOption) (meshixv1.MeshixServiceClient, error) {
+	opts := []grpc.DialOption{
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithConnectParams(grpc.ConnectParams{
+			MinConnectTimeout: 2 * time.Second,
+		}),
+	}
+	if token := os.Getenv(tokenEnv); token != "" {
+		opts = append(opts, grpc.WithPerRPCCredentials(tokenCredentials(token)))
+	}
+	opts = append(opts, extraOpts...)
+
+	cc, err := grpc.NewClient(hubUrl, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return meshixv1.NewMeshixServiceClient(cc), nil
+}
+
+type tokenCredentials string
+
+func (t tokenCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{
+		"authorization": "Bearer " + string(t),
+	}, nil
+}
+
+// Hub is served over h2c, token is sent without transport security
+func (t tokenCredentials) RequireTransportSecurity() bool {
+	return false
+}
+
+// newMachineHubClient signs requests with identity key of enrolled machine.
+func newMachineHubClient(identity machineIdentity) (meshixv1.MeshixServiceClient, error) {
+	return newHubClient(identity.HubUrl, grpc.WithUnaryInterceptor(identity.signRequest))
+}
+
+// Fingerprint is documented in gen/machineauth.
+func (i machineIdentity) signRequest(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	message, ok := req.(proto.Message)
+	if !ok {
+		return fmt.Errorf("Request of %s is not a protobuf message", method)
+	}
+	nonce, err := machineauth.NewNonce()
+	if err != nil {
+		return fmt.Errorf("Failed to generate nonce: %w", err)
+	}
+	timestamp := time.Now().Unix()
+	fingerprint, err := machineauth.Fingerprint(method, i.MachineID, timestamp, nonce, message)
+	if err != nil {
+		return err
+	}
+	signature := ed25519.Sign(i.PrivateKey, []byte(fingerprint))
+	ctx = metadata.AppendToOutgoingContext(ctx,
+		machineauth.IdHeader, strconv.FormatInt(i.MachineID, 10),
+		machineauth.TimestampHeader, strconv.FormatInt(timestamp, 10),
+		machineauth.NonceHeader, nonce,
+		machineauth.SignatureHeader, base64.StdEncoding.EncodeToString(signature),
+	)
+
+	return invoker(ctx, method, req, reply, cc, opts...)
+}
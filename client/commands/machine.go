@@ -0,0 +1,264 @@
+package commands
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	meshixv1 "gen/proto/meshix/v1"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+type MachineTokenCommand struct {
+	HubUrl string            `long:"hub-url" description:"Url of package hub" required:"true"`
+	Groups []string          `long:"group" description:"Group of the enrolled machine, can be repeated"`
+	Labels map[string]string `long:"label" description:"Label of the enrolled machine in form key:value, can be repeated"`
+	Ttl    time.Duration     `long:"ttl" description:"Validity of the token" default:"24h"`
+}
+
+// Execute prints bootstrap token used to enroll one machine.
+func (x *MachineTokenCommand) Execute(args []string) error {
+	ctx := context.Background()
+	client, err := newHubClient(x.HubUrl)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.CreateBootstrapToken(ctx, &meshixv1.CreateBootstrapTokenRequest{
+		Groups:     x.Groups,
+		Labels:     x.Labels,
+		TtlSeconds: int64(x.Ttl.Seconds()),
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to create bootstrap token: %w", err)
+	}
+	slog.Info("Bootstrap token created", "expiresAt", resp.ExpiresAt.AsTime())
+	fmt.Println(resp.Token)
+
+	return nil
+}
+
+type EnrollCommand struct {
+	HubUrl   string            `long:"hub-url" description:"Url of package hub" required:"true"`
+	Token    string            `long:"token" description:"Bootstrap token" env:"MESHIX_BOOTSTRAP_TOKEN" required:"true"`
+	Name     string            `long:"name" description:"Name of the machine, hostname when not specified"`
+	System   string            `long:"system" description:"Nix system of the machine, current nix system when not specified"`
+	Labels   map[string]string `long:"label" description:"Label of the machine in form key:value, can be repeated"`
+	Identity string            `long:"identity" description:"Path to write machine identity to" default:"/var/lib/meshix/identity.json"`
+}
+
+// Execute generates machine identity key and registers the machine with the hub.
+func (x *EnrollCommand) Execute(args []string) error {
+	ctx := context.Background()
+	if _, err := os.Stat(x.Identity); err == nil {
+		return fmt.Errorf("Machine identity %s already exists", x.Identity)
+	}
+
+	name := x.Name
+	if name == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return fmt.Errorf("Failed to get hostname: %w", err)
+		}
+		name = hostname
+	}
+	system := x.System
+	if system == "" {
+		systems, err := getNixSystems(ctx)
+		if err != nil {
+			return err
+		}
+		system = systems.current
+	}
+
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return fmt.Errorf("Failed to generate identity key: %w", err)
+	}
+
+	client, err := newHubClient(x.HubUrl)
+	if err != nil {
+		return err
+	}
+	resp, err := client.RegisterMachine(ctx, &meshixv1.RegisterMachineRequest{
+		BootstrapToken: x.Token,
+		Name:           name,
+		System:         system,
+		PublicKey:      publicKey,
+		Labels:         x.Labels,
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to register machine: %w", err)
+	}
+
+	err = writeMachineIdentity(x.Identity, machineIdentity{
+		MachineID:  resp.Machine.Id,
+		Name:       resp.Machine.Name,
+		HubUrl:     x.HubUrl,
+		PrivateKey: privateKey,
+	})
+	if err != nil {
+		return err
+	}
+	slog.Info("Machine enrolled", "name", resp.Machine.Name, "id", resp.Machine.Id, "groups", resp.Machine.Groups)
+
+	return nil
+}
+
+type MachinesCommand struct {
+	HubUrl  string            `long:"hub-url" description:"Url of package hub" required:"true"`
+	Group   string            `long:"group" description:"List only machines in the group"`
+	Labels  map[string]string `long:"label" description:"List only machines with the label in form key:value, can be repeated"`
+	Stale   bool              `long:"stale" description:"List only machines which have not sent heartbeat recently"`
+	Systems bool              `long:"systems" description:"Show running and booted NixOS system of machines"`
+	Drifted bool              `long:"drifted" description:"List only machines which differ from their desired state"`
+}
+
+func (x *MachinesCommand) Execute(args []string) error {
+	ctx := context.Background()
+	client, err := newHubClient(x.HubUrl)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.ListMachines(ctx, &meshixv1.ListMachinesRequest{
+		Group:       x.Group,
+		Labels:      x.Labels,
+		StaleOnly:   x.Stale,
+		DriftedOnly: x.Drifted,
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to list machines: %w", err)
+	}
+	for _, m := range resp.Machines {
+		labels := []string{}
+		for _, k := range slices.Sorted(maps.Keys(m.Labels)) {
+			labels = append(labels, k+"="+m.Labels[k])
+		}
+		state := "alive"
+		if m.Stale {
+			state = "stale"
+		} else if m.Drifted {
+			state = "drifted"
+		}
+		lastSeen := "never"
+		if m.Status != nil {
+			lastSeen = m.Status.ReportedAt.AsTime().Local().Format(time.DateTime)
+		}
+		if x.Systems {
+			printMachineSystem(m)
+			continue
+		}
+		fmt.Printf("%d\t%s\t%s\t%s\t%s\t%s\t%s\n", m.Id, m.Name, m.System, state, lastSeen, strings.Join(m.Groups, ","), strings.Join(labels, ","))
+	}
+
+	return nil
+}
+
+type DriftCommand struct {
+	HubUrl string `long:"hub-url" description:"Url of package hub" required:"true"`
+}
+
+// Execute prints difference between desired state of the machine and state reported by its agent, argument is machine name.
+func (x *DriftCommand) Execute(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("Expected 1 argument <machine>, got: %d", len(args))
+	}
+	ctx := context.Background()
+	client, err := newHubClient(x.HubUrl)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.GetMachineDrift(ctx, &meshixv1.GetMachineDriftRequest{Name: args[0]})
+	if err != nil {
+		return fmt.Errorf("Failed to get machine drift: %w", err)
+	}
+	drift := resp.Drift
+	if drift == nil {
+		fmt.Printf("%s has not reported its state yet\n", args[0])
+		return nil
+	}
+	if !drift.Drifted {
+		fmt.Printf("%s is in sync\n", drift.MachineName)
+		return nil
+	}
+
+	fmt.Printf("%s drifted since %s\n", drift.MachineName, drift.Since.AsTime().Local().Format(time.DateTime))
+	if drift.Error != "" {
+		fmt.Printf("  error: %s\n", drift.Error)
+	}
+	for _, storePath := range drift.MissingStorePaths {
+		fmt.Printf("  missing %s\n", storePath)
+	}
+	for _, storePath := range drift.UnexpectedStorePaths {
+		fmt.Printf("  unexpected %s\n", storePath)
+	}
+	if drift.DesiredSystem != drift.ActualSystem {
+		fmt.Printf("  system %s, desired %s\n", drift.ActualSystem, drift.DesiredSystem)
+	}
+
+	return nil
+}
+
+// printMachineSystem prints running and booted NixOS system of the machine.
+func printMachineSystem(m *meshixv1.Machine) {
+	running, booted := "-", "-"
+	if m.Status != nil && m.Status.RunningSystem != "" {
+		running, booted = m.Status.RunningSystem, m.Status.BootedSystem
+	}
+	pending := ""
+	if running != booted {
+		pending = "reboot pending"
+	}
+	fmt.Printf("%d\t%s\t%s\t%s\t%s\n", m.Id, m.Name, running, booted, pending)
+}
+
+// machineIdentity is written on enrollment and used to sign requests of the machine.
+type machineIdentity struct {
+	MachineID  int64              `json:"machineId"`
+	Name       string             `json:"name"`
+	HubUrl     string             `json:"hubUrl"`
+	PrivateKey ed25519.PrivateKey `json:"privateKey"`
+}
+
+func writeMachineIdentity(path string, identity machineIdentity) error {
+	content, err := json.MarshalIndent(identity, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0o700)
+	if err != nil {
+		return fmt.Errorf("Failed to create identity directory: %w", err)
+	}
+	err = os.WriteFile(path, content, 0o600)
+	if err != nil {
+		return fmt.Errorf("Failed to write machine identity: %w", err)
+	}
+
+	return nil
+}
+
+func readMachineIdentity(path string) (machineIdentity, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return machineIdentity{}, fmt.Errorf("Failed to read machine identity, is the machine enrolled? %w", err)
+	}
+	var identity machineIdentity
+	err = json.Unmarshal(content, &identity)
+	if err != nil {
+		return machineIdentity{}, fmt.Errorf("Failed to decode machine identity: %w", err)
+	}
+	if len(identity.PrivateKey) != ed25519.PrivateKeySize {
+		return machineIdentity{}, fmt.Errorf("Machine identity %s has invalid private key", path)
+	}
+
+	return identity, nil
+}
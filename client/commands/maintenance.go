@@ -0,0 +1,108 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	meshixv1 "gen/proto/meshix/v1"
+	"log/slog"
+	"strconv"
+	"strings"
+)
+
+// MaintenanceCommand groups maintenance window subcommands.
+type MaintenanceCommand struct{}
+
+type MaintenanceAddCommand struct {
+	HubUrl   string   `long:"hub-url" description:"Url of package hub" required:"true"`
+	Group    string   `long:"group" description:"Group of machines the window applies to" required:"true"`
+	Days     []string `long:"day" description:"Day the window starts on, e.g. mon, can be repeated, every day when not specified"`
+	Timezone string   `long:"timezone" description:"IANA time zone of the window" default:"UTC"`
+}
+
+// Execute adds maintenance window, arguments are <start> <end> as HH:MM.
+// Window ending before its start ends on the following day.
+func (x *MaintenanceAddCommand) Execute(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("Expected 2 arguments <start> <end>, got: %d", len(args))
+	}
+	ctx := context.Background()
+	client, err := newHubClient(x.HubUrl)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.CreateMaintenanceWindow(ctx, &meshixv1.CreateMaintenanceWindowRequest{
+		Window: &meshixv1.MaintenanceWindow{
+			Group:    x.Group,
+			Days:     x.Days,
+			Start:    args[0],
+			End:      args[1],
+			Timezone: x.Timezone,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to create maintenance window: %w", err)
+	}
+	slog.Info("Maintenance window created", "id", resp.Window.Id, "group", resp.Window.Group)
+	fmt.Println(resp.Window.Id)
+
+	return nil
+}
+
+type MaintenanceListCommand struct {
+	HubUrl string `long:"hub-url" description:"Url of package hub" required:"true"`
+	Group  string `long:"group" description:"List only windows of the group"`
+}
+
+func (x *MaintenanceListCommand) Execute(args []string) error {
+	ctx := context.Background()
+	client, err := newHubClient(x.HubUrl)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.ListMaintenanceWindows(ctx, &meshixv1.ListMaintenanceWindowsRequest{
+		Group: x.Group,
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to list maintenance windows: %w", err)
+	}
+	for _, w := range resp.Windows {
+		days := "daily"
+		if len(w.Days) > 0 {
+			days = strings.Join(w.Days, ",")
+		}
+		fmt.Printf("%d\t%s\t%s\t%s-%s\t%s\n", w.Id, w.Group, days, w.Start, w.End, w.Timezone)
+	}
+
+	return nil
+}
+
+type MaintenanceDeleteCommand struct {
+	HubUrl string `long:"hub-url" description:"Url of package hub" required:"true"`
+}
+
+func (x *MaintenanceDeleteCommand) Execute(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("Expected 1 argument <id>, got: %d", len(args))
+	}
+	id, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return fmt.Errorf("Invalid maintenance window id %s: %w", args[0], err)
+	}
+	ctx := context.Background()
+	client, err := newHubClient(x.HubUrl)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.DeleteMaintenanceWindow(ctx, &meshixv1.DeleteMaintenanceWindowRequest{
+		Id: id,
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to delete maintenance window: %w", err)
+	}
+	slog.Info("Maintenance window deleted", "id", id)
+
+	return nil
+}
@@ -0,0 +1,189 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	meshixv1 "gen/proto/meshix/v1"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type SystemBuildCommand struct {
+	HubUrl  string `long:"hub-url" description:"Url of package hub" required:"true"`
+	Cache   string `long:"cache" description:"Cache to push the system closure to" required:"true"`
+	Flake   string `long:"flake" description:"Flake with nixosConfigurations" default:"."`
+	Version string `long:"version" description:"Version of the system package, NixOS label with hash of the closure is used if not specified"`
+}
+
+// Execute builds nixosConfigurations.<host>.config.system.build.toplevel and pushes it as system package named by the host.
+func (x *SystemBuildCommand) Execute(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("Expected 1 argument <host>, got: %d", len(args))
+	}
+	ctx := context.Background()
+	host := args[0]
+	configuration := fmt.Sprintf("%s#nixosConfigurations.%s", x.Flake, host)
+
+	system, err := evalRaw(ctx, configuration+".pkgs.stdenv.hostPlatform.system")
+	if err != nil {
+		return err
+	}
+	toplevel := configuration + ".config.system.build.toplevel"
+	buildOutput, err := buildPackage(ctx, toplevel, system)
+	if err != nil {
+		return err
+	}
+	storePath := buildOutput.defaultOutput(nil)
+	err = pushPackage(ctx, x.Cache, storePath)
+	if err != nil {
+		return err
+	}
+
+	version := x.Version
+	if version == "" {
+		label, err := evalRaw(ctx, configuration+".config.system.nixos.label")
+		if err != nil {
+			return err
+		}
+		// Label is the same for every change of the configuration on the same nixpkgs
+		hash, _, _ := strings.Cut(filepath.Base(storePath), "-")
+		version = fmt.Sprintf("%s-%s", label, hash[:min(8, len(hash))])
+	}
+	provenance, err := collectProvenance(ctx, toplevel, buildOutput.DrvPaht)
+	if err != nil {
+		return err
+	}
+
+	client, err := newHubClient(x.HubUrl)
+	if err != nil {
+		return err
+	}
+	_, err = client.PushPackage(ctx, &meshixv1.PushPackageRequest{
+		Package: &meshixv1.Package{
+			Name:    host,
+			Version: version,
+			System:  system,
+			Kind:    meshixv1.PackageKind_PACKAGE_KIND_SYSTEM,
+			NixMetadata: &meshixv1.NixMetadata{
+				StorePath:        storePath,
+				Outputs:          buildOutput.Outputs,
+				OutputsToInstall: []string{mainOutput},
+				Description:      fmt.Sprintf("NixOS system of %s", host),
+				Platforms:        []string{system},
+			},
+		},
+		Provenance: provenance,
+	})
+	if err != nil {
+		return err
+	}
+	slog.Info("System pushed", "host", host, "version", version, "storePath", storePath)
+
+	return nil
+}
+
+func evalRaw(ctx context.Context, expr string) (string, error) {
+	output, err := runNixCmd(ctx, "nix", "eval", "--quiet", "--raw", expr)
+	if err != nil {
+		return "", fmt.Errorf("Failed to eval %s: %w", expr, err)
+	}
+
+	return strings.TrimSpace(output.String()), nil
+}
+
+// SystemActivator switches NixOS machine to system closures.
+type SystemActivator interface {
+	// Switch sets the system profile to the closure, unless mode is test, and runs its switch-to-configuration.
+	Switch(ctx context.Context, toplevel string, mode meshixv1.ActivationMode) error
+	// Running is store path of the current system, empty when machine does not run NixOS.
+	Running(ctx context.Context) (string, error)
+	// Booted is store path of the system the machine booted.
+	Booted(ctx context.Context) (string, error)
+	// Default is store path of the system profile, booted by default.
+	Default(ctx context.Context) (string, error)
+}
+
+// nixosActivator activates system closures with switch-to-configuration.
+type nixosActivator struct {
+	nixBinDir string
+	profile   string
+}
+
+func newNixosActivator(nixBinDir, profile string) *nixosActivator {
+	return &nixosActivator{
+		nixBinDir: nixBinDir,
+		profile:   profile,
+	}
+}
+
+// Switch implements SystemActivator.
+func (a *nixosActivator) Switch(ctx context.Context, toplevel string, mode meshixv1.ActivationMode) error {
+	action := switchAction(mode)
+	if action != "test" {
+		_, err := runNixCmd(ctx, filepath.Join(a.nixBinDir, "nix-env"), "--profile", a.profile, "--set", toplevel)
+		if err != nil {
+			return fmt.Errorf("Failed to set system profile %s: %w", a.profile, err)
+		}
+	}
+
+	_, err := runNixCmd(ctx, filepath.Join(toplevel, "bin", "switch-to-configuration"), action)
+	if err != nil {
+		return fmt.Errorf("Failed to %s system %s: %w", action, toplevel, err)
+	}
+
+	return nil
+}
+
+// Running implements SystemActivator.
+func (a *nixosActivator) Running(ctx context.Context) (string, error) {
+	return resolveSystemLink("/run/current-system")
+}
+
+// Booted implements SystemActivator.
+func (a *nixosActivator) Booted(ctx context.Context) (string, error) {
+	return resolveSystemLink("/run/booted-system")
+}
+
+// Default implements SystemActivator.
+func (a *nixosActivator) Default(ctx context.Context) (string, error) {
+	return resolveSystemLink(a.profile)
+}
+
+func resolveSystemLink(link string) (string, error) {
+	storePath, err := filepath.EvalSymlinks(link)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("Failed to resolve %s: %w", link, err)
+	}
+
+	return storePath, nil
+}
+
+// switchAction is argument of switch-to-configuration for the mode.
+func switchAction(mode meshixv1.ActivationMode) string {
+	switch mode {
+	case meshixv1.ActivationMode_ACTIVATION_MODE_BOOT:
+		return "boot"
+	case meshixv1.ActivationMode_ACTIVATION_MODE_TEST:
+		return "test"
+	default:
+		return "switch"
+	}
+}
+
+// activeSystem is the system which has to match desired system in the mode,
+// the default boot system for boot mode, running system otherwise.
+func activeSystem(ctx context.Context, s SystemActivator, mode meshixv1.ActivationMode) (string, error) {
+	if mode == meshixv1.ActivationMode_ACTIVATION_MODE_BOOT {
+		return s.Default(ctx)
+	}
+
+	return s.Running(ctx)
+}
+
+var _ SystemActivator = (*nixosActivator)(nil)
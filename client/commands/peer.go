@@ -0,0 +1,269 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	meshixv1 "gen/proto/meshix/v1"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/nix-community/go-nix/pkg/narinfo"
+	"github.com/nix-community/go-nix/pkg/narinfo/signature"
+	"github.com/nix-community/go-nix/pkg/nixhash"
+)
+
+// Peers are preferred over the central cache which has priority 39.
+const peerCacheInfo = `StoreDir: /nix/store
+WantMassQuery: 1
+Priority: 20
+`
+
+var (
+	storePathHashRe = regexp.MustCompile(`^[0-9a-df-np-sv-z]{32}$`)
+	errNotServed    = errors.New("Store path is not served")
+)
+
+// peerServer serves store paths signed by the hub as binary cache to agents on the same site.
+// Narinfos are generated from the local store and keep the hub signature, so peers verify them
+// with the same public key as the central cache. Store paths without valid hub signature are not served.
+type peerServer struct {
+	nixBinDir string
+	publicKey signature.PublicKey
+}
+
+func newPeerServer(nixBinDir, cachePubKey string) (*peerServer, error) {
+	if cachePubKey == "" {
+		return nil, errors.New("Public key of the binary cache is required to serve store to peers")
+	}
+	publicKey, err := signature.ParsePublicKey(cachePubKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse public key of the binary cache: %w", err)
+	}
+
+	return &peerServer{
+		nixBinDir: nixBinDir,
+		publicKey: publicKey,
+	}, nil
+}
+
+// Serve accepts peers on the listener until the context is cancelled.
+func (s *peerServer) Serve(ctx context.Context, listener net.Listener) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /nix-cache-info", s.handleCacheInfo)
+	mux.HandleFunc("GET /{file}", s.handleNarInfo)
+	mux.HandleFunc("GET /nar/{file}", s.handleNar)
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		BaseContext: func(net.Listener) context.Context {
+			return ctx
+		},
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+
+	slog.Info("Serving store to peers", "addr", listener.Addr().String())
+	err := server.Serve(listener)
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("Failed to serve store to peers: %w", err)
+	}
+
+	return nil
+}
+
+func (s *peerServer) handleCacheInfo(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("content-type", "text/x-nix-cache-info")
+	_, err := w.Write([]byte(peerCacheInfo))
+	if err != nil {
+		slog.Warn("Failed to write cache info", "err", err)
+	}
+}
+
+func (s *peerServer) handleNarInfo(w http.ResponseWriter, r *http.Request) {
+	hash, ok := strings.CutSuffix(r.PathValue("file"), ".narinfo")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	info, err := s.narInfo(r.Context(), hash)
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("content-type", info.ContentType())
+	_, err = w.Write([]byte(info.String()))
+	if err != nil {
+		slog.Warn("Failed to write narinfo", "hash", hash, "err", err)
+	}
+}
+
+func (s *peerServer) handleNar(w http.ResponseWriter, r *http.Request) {
+	hash, ok := strings.CutSuffix(r.PathValue("file"), ".nar")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	info, err := s.narInfo(r.Context(), hash)
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("content-type", "application/x-nix-nar")
+	cmd := exec.CommandContext(r.Context(), s.bin("nix-store"), "--dump", info.StorePath)
+	cmd.Stdout = w
+	cmd.Stderr = os.Stderr
+	err = cmd.Run()
+	if err != nil {
+		// Headers are already sent, client fails on hash mismatch of the truncated NAR
+		slog.Warn("Failed to dump store path", "storePath", info.StorePath, "err", err)
+	}
+}
+
+func (s *peerServer) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, errNotServed) {
+		http.NotFound(w, r)
+		return
+	}
+	slog.Warn("Failed to serve store path to peer", "path", r.URL.Path, "err", err)
+	w.WriteHeader(http.StatusInternalServerError)
+}
+
+// narInfo finds valid store path with the hash and describes it with signatures of the hub.
+func (s *peerServer) narInfo(ctx context.Context, hash string) (*narinfo.NarInfo, error) {
+	if !storePathHashRe.MatchString(hash) {
+		return nil, errNotServed
+	}
+	candidates, err := filepath.Glob(filepath.Join(storeDir, hash+"-*"))
+	if err != nil {
+		return nil, err
+	}
+	for _, candidate := range candidates {
+		if strings.HasSuffix(candidate, ".drv") || strings.HasSuffix(candidate, ".lock") {
+			continue
+		}
+		output, err := runNixCmd(ctx, s.bin("nix"), "path-info", "--json", candidate)
+		if err != nil {
+			// Store path is being built or garbage collected
+			continue
+		}
+		infos, err := decodePathInfos(output.Bytes())
+		if err != nil {
+			return nil, err
+		}
+		for _, info := range infos {
+			if info.Path != candidate {
+				continue
+			}
+			return s.signedNarInfo(info)
+		}
+	}
+
+	return nil, errNotServed
+}
+
+// signedNarInfo builds uncompressed narinfo of the path info which has valid signature of the hub.
+func (s *peerServer) signedNarInfo(info nixPathInfo) (*narinfo.NarInfo, error) {
+	parsed, err := nixhash.ParseAny(info.NarHash, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse nar hash of %s: %w", info.Path, err)
+	}
+	narHash, err := nixhash.NewHashWithEncoding(parsed.Algo(), parsed.Digest(), nixhash.NixBase32, true)
+	if err != nil {
+		return nil, err
+	}
+	references := []string{}
+	for _, reference := range info.References {
+		references = append(references, path.Base(reference))
+	}
+	deriver := ""
+	if info.Deriver != "" {
+		deriver = path.Base(info.Deriver)
+	}
+
+	n := &narinfo.NarInfo{
+		StorePath:   info.Path,
+		URL:         "nar/" + path.Base(info.Path)[:32] + ".nar",
+		Compression: "none",
+		FileHash:    narHash,
+		FileSize:    info.NarSize,
+		NarHash:     narHash,
+		NarSize:     info.NarSize,
+		References:  references,
+		Deriver:     deriver,
+		CA:          info.CA,
+	}
+	for _, sig := range info.Signatures {
+		decoded, err := signature.ParseSignature(sig)
+		if err != nil || decoded.Name != s.publicKey.Name {
+			continue
+		}
+		if s.publicKey.Verify(n.Fingerprint(), decoded) {
+			n.Signatures = append(n.Signatures, decoded)
+		}
+	}
+	if len(n.Signatures) == 0 {
+		return nil, errNotServed
+	}
+
+	return n, nil
+}
+
+func (s *peerServer) bin(name string) string {
+	if s.nixBinDir == "" {
+		return name
+	}
+
+	return filepath.Join(s.nixBinDir, name)
+}
+
+// hubPeers looks up peers holding store paths, so they are substituted on the site instead of the central cache.
+type hubPeers struct {
+	client meshixv1.MeshixServiceClient
+}
+
+// Find returns urls of peers holding any of the store paths, failures are only logged.
+func (p *hubPeers) Find(ctx context.Context, storePaths []string) []string {
+	resp, err := p.client.FindPeers(ctx, &meshixv1.FindPeersRequest{
+		StorePaths: storePaths,
+	})
+	if err != nil {
+		slog.Warn("Failed to find peers, using binary cache only", "err", err)
+		return nil
+	}
+
+	urls := []string{}
+	for _, peer := range resp.Peers {
+		urls = append(urls, peer.Url)
+	}
+
+	return urls
+}
+
+// defaultPeerUrl is advertised to peers when no url is configured, derived from hostname and port of the address.
+func defaultPeerUrl(addr string) (string, error) {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", fmt.Errorf("Failed to parse address %s: %w", addr, err)
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		return "", fmt.Errorf("Failed to get hostname: %w", err)
+	}
+
+	return "http://" + net.JoinHostPort(host, port), nil
+}
@@ -0,0 +1,155 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	meshixv1 "gen/proto/meshix/v1"
+	"log/slog"
+	"os"
+	"provenance"
+	"strings"
+
+	"github.com/nix-community/go-nix/pkg/narinfo/signature"
+)
+
+// Version of the client, set at build time.
+var Version = "dev"
+
+// Environment variables identifying CI runs, recorded into provenance when set.
+var ciEnvVars = []string{
+	// Github actions
+	"GITHUB_SERVER_URL", "GITHUB_REPOSITORY", "GITHUB_WORKFLOW", "GITHUB_RUN_ID", "GITHUB_RUN_ATTEMPT", "GITHUB_SHA", "GITHUB_REF",
+	// Gitlab CI
+	"CI_SERVER_URL", "CI_PROJECT_PATH", "CI_PIPELINE_ID", "CI_JOB_ID", "CI_COMMIT_SHA", "CI_COMMIT_REF_NAME",
+	// Buildkite
+	"BUILDKITE_PIPELINE_SLUG", "BUILDKITE_BUILD_ID", "BUILDKITE_JOB_ID", "BUILDKITE_COMMIT",
+	// Jenkins
+	"JENKINS_URL", "JOB_NAME", "BUILD_ID",
+}
+
+// {"locked":{"narHash":"sha256-...","rev":"...",...},"revision":"...","url":"git+file:///...?rev=...",...}
+type nixFlakeMetadata struct {
+	Url           string `json:"url"`
+	Revision      string `json:"revision"`
+	DirtyRevision string `json:"dirtyRevision"`
+	Locked        struct {
+		NarHash string `json:"narHash"`
+	} `json:"locked"`
+}
+
+func collectProvenance(ctx context.Context, expr string, drvPath string) (*meshixv1.Provenance, error) {
+	provenance := &meshixv1.Provenance{
+		DrvPath:       drvPath,
+		ClientVersion: Version,
+		Ci:            map[string]string{},
+	}
+
+	host, err := os.Hostname()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get hostname: %w", err)
+	}
+	provenance.BuilderHost = host
+
+	for _, env := range ciEnvVars {
+		if value, ok := os.LookupEnv(env); ok {
+			provenance.Ci[env] = value
+		}
+	}
+
+	flakeRef, _, isFlake := strings.Cut(expr, "#")
+	if !isFlake {
+		return provenance, nil
+	}
+	if flakeRef == "" {
+		flakeRef = "."
+	}
+
+	output, err := runNixCmd(ctx, "nix", "flake", "metadata", "--quiet", "--json", flakeRef)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get flake metadata of %s: %w", flakeRef, err)
+	}
+	var metadata nixFlakeMetadata
+	err = json.NewDecoder(output).Decode(&metadata)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode flake metadata: %w", err)
+	}
+
+	provenance.FlakeUrl = metadata.Url
+	provenance.FlakeRevision = metadata.Revision
+	if provenance.FlakeRevision == "" {
+		slog.Warn("Building from dirty flake", "flake", flakeRef)
+		provenance.FlakeRevision = metadata.DirtyRevision
+	}
+	provenance.FlakeNarHash = metadata.Locked.NarHash
+
+	return provenance, nil
+}
+
+// provenanceFields returns fields of the package covered by its provenance signature.
+func provenanceFields(pkg *meshixv1.Package) provenance.Fields {
+	return provenance.Fields{
+		Name:          pkg.Name,
+		Version:       pkg.Version,
+		System:        pkg.System,
+		StorePath:     pkg.NixMetadata.GetStorePath(),
+		FlakeUrl:      pkg.Provenance.GetFlakeUrl(),
+		FlakeRevision: pkg.Provenance.GetFlakeRevision(),
+		FlakeNarHash:  pkg.Provenance.GetFlakeNarHash(),
+		DrvPath:       pkg.Provenance.GetDrvPath(),
+		BuilderHost:   pkg.Provenance.GetBuilderHost(),
+		ClientVersion: pkg.Provenance.GetClientVersion(),
+		Ci:            pkg.Provenance.GetCi(),
+	}
+}
+
+type VerifyProvenanceCommand struct {
+	HubUrl    string `long:"hub-url" description:"Url of package hub" required:"true"`
+	PublicKey string `long:"public-key" description:"Public key of the binary cache, e.g. meshix:base64key" required:"true"`
+	System    string `long:"system" description:"Nix system of the package"`
+}
+
+// Execute verifies provenance signature of package, argument is <name>@<version>.
+func (x *VerifyProvenanceCommand) Execute(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("Expected 1 argument <name>@<version>, got: %d", len(args))
+	}
+	ctx := context.Background()
+	name, version, _ := strings.Cut(args[0], "@")
+
+	publicKey, err := signature.ParsePublicKey(x.PublicKey)
+	if err != nil {
+		return fmt.Errorf("Failed to parse public key: %w", err)
+	}
+
+	client, err := newHubClient(x.HubUrl)
+	if err != nil {
+		return err
+	}
+	resp, err := client.GetPackage(ctx, &meshixv1.GetPackageRequest{
+		Name:    name,
+		Version: version,
+		System:  x.System,
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to get package: %w", err)
+	}
+
+	pkg := resp.Package
+	if pkg.Provenance == nil {
+		return fmt.Errorf("Package %s-%s has no provenance", pkg.Name, pkg.Version)
+	}
+	err = provenance.Verify(publicKey, provenanceFields(pkg), pkg.Provenance.Signature)
+	if err != nil {
+		return err
+	}
+
+	out, err := json.MarshalIndent(pkg.Provenance, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(out))
+	slog.Info("Provenance verified", "name", pkg.Name, "version", pkg.Version)
+
+	return nil
+}
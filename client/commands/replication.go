@@ -0,0 +1,67 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	meshixv1 "gen/proto/meshix/v1"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// ReplicationCommand groups replication subcommands.
+type ReplicationCommand struct{}
+
+type ReplicationStatusCommand struct {
+	HubUrl string `long:"hub-url" description:"Url of package hub" required:"true"`
+}
+
+func (x *ReplicationStatusCommand) Execute(args []string) error {
+	ctx := context.Background()
+	client, err := newHubClient(x.HubUrl)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.GetReplicationStatus(ctx, &meshixv1.GetReplicationStatusRequest{})
+	if err != nil {
+		return fmt.Errorf("Failed to get replication status: %w", err)
+	}
+	role := strings.ToLower(strings.TrimPrefix(resp.Role.String(), "REPLICATION_ROLE_"))
+	if resp.Role == meshixv1.ReplicationRole_REPLICATION_ROLE_PRIMARY {
+		fmt.Printf("%s\tlast change %d\n", role, resp.LastChangeId)
+		return nil
+	}
+	fmt.Printf("%s of %s\n", role, resp.PrimaryUrl)
+	fmt.Printf("  applied %d of %d changes, lag %d changes %s\n",
+		resp.LastChangeId, resp.PrimaryLatestChangeId, resp.LagChanges, time.Duration(resp.LagSeconds)*time.Second)
+	if resp.SyncedAt != nil {
+		fmt.Printf("  synced at %s\n", resp.SyncedAt.AsTime().Local().Format(time.DateTime))
+	}
+	if resp.LastError != "" {
+		fmt.Printf("  error: %s\n", resp.LastError)
+	}
+
+	return nil
+}
+
+type ReplicationPromoteCommand struct {
+	HubUrl string `long:"hub-url" description:"Url of the replica" required:"true"`
+}
+
+// Execute promotes replica, it stops following its primary and accepts writes.
+func (x *ReplicationPromoteCommand) Execute(args []string) error {
+	ctx := context.Background()
+	client, err := newHubClient(x.HubUrl)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.PromoteReplica(ctx, &meshixv1.PromoteReplicaRequest{})
+	if err != nil {
+		return fmt.Errorf("Failed to promote replica: %w", err)
+	}
+	slog.Info("Replica promoted", "hub", x.HubUrl)
+
+	return nil
+}
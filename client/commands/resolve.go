@@ -0,0 +1,41 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	meshixv1 "gen/proto/meshix/v1"
+	"strings"
+)
+
+type ResolveCommand struct {
+	HubUrl string `long:"hub-url" description:"Url of package hub" required:"true"`
+	Output string `long:"output" description:"Output of the package to resolve, default output when not specified"`
+	System string `long:"system" description:"Nix system to resolve package for"`
+}
+
+// Execute prints store path of package output, argument is <name> or <name>@<version>.
+func (x *ResolveCommand) Execute(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("Expected 1 argument <name>[@<version>], got: %d", len(args))
+	}
+	ctx := context.Background()
+	name, version, _ := strings.Cut(args[0], "@")
+
+	client, err := newHubClient(x.HubUrl)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.GetPackage(ctx, &meshixv1.GetPackageRequest{
+		Name:    name,
+		Version: version,
+		Output:  x.Output,
+		System:  x.System,
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to resolve package: %w", err)
+	}
+	fmt.Println(resp.StorePath)
+
+	return nil
+}
@@ -0,0 +1,222 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	meshixv1 "gen/proto/meshix/v1"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RolloutCommand groups rollout subcommands.
+type RolloutCommand struct{}
+
+type RolloutCreateCommand struct {
+	HubUrl         string            `long:"hub-url" description:"Url of package hub" required:"true"`
+	Group          string            `long:"group" description:"Roll out to machines in the group"`
+	Labels         map[string]string `long:"label" description:"Roll out to machines with the label in form key:value, can be repeated"`
+	Canary         int32             `long:"canary" description:"Number of canary machines updated first"`
+	Waves          []int32           `long:"wave" description:"Cumulative percentage of machines updated by wave, can be repeated"`
+	MaxFailureRate float64           `long:"max-failure-rate" description:"Ratio of failed machines which halts the rollout" default:"0.1"`
+	Soak           time.Duration     `long:"soak" description:"Minimum duration of each wave"`
+	Emergency      bool              `long:"emergency" description:"Ignore maintenance windows of the machines"`
+	Prestage       bool              `long:"prestage" description:"Download the package to machines first, waves start with rollout activate"`
+}
+
+// Execute creates rollout, argument is <name>@<version>.
+func (x *RolloutCreateCommand) Execute(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("Expected 1 argument <name>@<version>, got: %d", len(args))
+	}
+	name, version, ok := strings.Cut(args[0], "@")
+	if !ok {
+		return fmt.Errorf("Version of the package is required, expected <name>@<version>")
+	}
+	ctx := context.Background()
+	client, err := newHubClient(x.HubUrl)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.CreateRollout(ctx, &meshixv1.CreateRolloutRequest{
+		PackageName:     name,
+		PackageVersion:  version,
+		Group:           x.Group,
+		Labels:          x.Labels,
+		CanaryCount:     x.Canary,
+		WavePercentages: x.Waves,
+		MaxFailureRate:  x.MaxFailureRate,
+		SoakSeconds:     int64(x.Soak.Seconds()),
+		Emergency:       x.Emergency,
+		Prestage:        x.Prestage,
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to create rollout: %w", err)
+	}
+	slog.Info("Rollout created", "id", resp.Rollout.Id, "waves", resp.Rollout.WaveCount)
+	fmt.Println(resp.Rollout.Id)
+
+	return nil
+}
+
+type RolloutListCommand struct {
+	HubUrl string `long:"hub-url" description:"Url of package hub" required:"true"`
+}
+
+func (x *RolloutListCommand) Execute(args []string) error {
+	ctx := context.Background()
+	client, err := newHubClient(x.HubUrl)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.ListRollouts(ctx, &meshixv1.ListRolloutsRequest{})
+	if err != nil {
+		return fmt.Errorf("Failed to list rollouts: %w", err)
+	}
+	for _, r := range resp.Rollouts {
+		printRollout(r)
+	}
+
+	return nil
+}
+
+type RolloutStatusCommand struct {
+	HubUrl string `long:"hub-url" description:"Url of package hub" required:"true"`
+}
+
+// Execute prints rollout and state of its machines, argument is <id>.
+func (x *RolloutStatusCommand) Execute(args []string) error {
+	id, err := rolloutId(args)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	client, err := newHubClient(x.HubUrl)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.GetRollout(ctx, &meshixv1.GetRolloutRequest{Id: id})
+	if err != nil {
+		return fmt.Errorf("Failed to get rollout: %w", err)
+	}
+	printRollout(resp.Rollout)
+	if resp.Rollout.State == meshixv1.RolloutState_ROLLOUT_STATE_STAGING {
+		staged := 0
+		for _, m := range resp.Machines {
+			if m.State == meshixv1.RolloutMachineState_ROLLOUT_MACHINE_STATE_STAGED {
+				staged++
+			}
+		}
+		fmt.Printf("  staged on %d of %d machines\n", staged, len(resp.Machines))
+	}
+	for _, m := range resp.Machines {
+		state := strings.ToLower(strings.TrimPrefix(m.State.String(), "ROLLOUT_MACHINE_STATE_"))
+		fmt.Printf("  wave %d\t%s\t%s\n", m.Wave, m.MachineName, state)
+	}
+
+	return nil
+}
+
+// RolloutActionCommand pauses, resumes, aborts or activates rollout.
+type RolloutActionCommand struct {
+	HubUrl string `long:"hub-url" description:"Url of package hub" required:"true"`
+	action string
+}
+
+func NewRolloutActionCommand(action string) *RolloutActionCommand {
+	return &RolloutActionCommand{action: action}
+}
+
+func (x *RolloutActionCommand) Execute(args []string) error {
+	id, err := rolloutId(args)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	client, err := newHubClient(x.HubUrl)
+	if err != nil {
+		return err
+	}
+
+	switch x.action {
+	case "pause":
+		_, err = client.PauseRollout(ctx, &meshixv1.PauseRolloutRequest{Id: id})
+	case "resume":
+		_, err = client.ResumeRollout(ctx, &meshixv1.ResumeRolloutRequest{Id: id})
+	case "abort":
+		_, err = client.AbortRollout(ctx, &meshixv1.AbortRolloutRequest{Id: id})
+	case "activate":
+		_, err = client.ActivateRollout(ctx, &meshixv1.ActivateRolloutRequest{Id: id})
+	default:
+		return fmt.Errorf("Unknown rollout action %s", x.action)
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to %s rollout: %w", x.action, err)
+	}
+	slog.Info("Rollout updated", "id", id, "action", x.action)
+
+	return nil
+}
+
+func rolloutId(args []string) (int64, error) {
+	if len(args) != 1 {
+		return 0, fmt.Errorf("Expected 1 argument <id>, got: %d", len(args))
+	}
+	id, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid rollout id %s: %w", args[0], err)
+	}
+
+	return id, nil
+}
+
+func printRollout(r *meshixv1.Rollout) {
+	state := strings.ToLower(strings.TrimPrefix(r.State.String(), "ROLLOUT_STATE_"))
+	fmt.Printf("%d\t%s@%s\t%s\twave %d/%d\t%s\n", r.Id, r.PackageName, r.PackageVersion, state, r.CurrentWave+1, r.WaveCount, r.StateReason)
+}
+
+type FailedVersionsCommand struct {
+	HubUrl string `long:"hub-url" description:"Url of package hub" required:"true"`
+	Clear  string `long:"clear" description:"Clear failure of <name>@<version> so rollouts schedule it again"`
+	Group  string `long:"group" description:"Clear failure only in the group"`
+}
+
+// Execute lists package versions which failed health check, or clears the failure.
+func (x *FailedVersionsCommand) Execute(args []string) error {
+	ctx := context.Background()
+	client, err := newHubClient(x.HubUrl)
+	if err != nil {
+		return err
+	}
+
+	if x.Clear != "" {
+		name, version, ok := strings.Cut(x.Clear, "@")
+		if !ok {
+			return fmt.Errorf("Version of the package is required, expected <name>@<version>")
+		}
+		_, err = client.ClearFailedVersion(ctx, &meshixv1.ClearFailedVersionRequest{
+			PackageName:    name,
+			PackageVersion: version,
+			Group:          x.Group,
+		})
+		if err != nil {
+			return fmt.Errorf("Failed to clear failed version: %w", err)
+		}
+		slog.Info("Failed version cleared", "package", name, "version", version, "group", x.Group)
+		return nil
+	}
+
+	resp, err := client.ListFailedVersions(ctx, &meshixv1.ListFailedVersionsRequest{})
+	if err != nil {
+		return fmt.Errorf("Failed to list failed versions: %w", err)
+	}
+	for _, f := range resp.FailedVersions {
+		fmt.Printf("%s@%s\t%s\t%s\t%s\t%s\n", f.PackageName, f.PackageVersion, f.Group, f.MachineName, f.FailedAt.AsTime().Local().Format(time.DateTime), f.Reason)
+	}
+
+	return nil
+}
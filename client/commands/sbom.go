@@ -0,0 +1,236 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	meshixv1 "gen/proto/meshix/v1"
+	"log/slog"
+	"os"
+	"path"
+	"sbom"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/nix-community/go-nix/pkg/storepath"
+)
+
+type SbomCommand struct {
+	HubUrl string `long:"hub-url" description:"Url of package hub, required when generating sbom of registered package"`
+	Format string `long:"format" description:"Format of the sbom" choice:"cyclonedx" choice:"spdx" default:"cyclonedx"`
+	System string `long:"system" description:"Nix system of the registered package"`
+	Output string `long:"output" short:"o" description:"File to write sbom to, stdout when not specified"`
+}
+
+// Execute generates sbom of registered package <name>[@<version>] or locally of a store path.
+func (x *SbomCommand) Execute(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("Expected 1 argument <name>[@<version>] or store path, got: %d", len(args))
+	}
+	ctx := context.Background()
+
+	var document []byte
+	var err error
+	if strings.HasPrefix(args[0], storepath.StoreDir) {
+		document, err = localSbom(ctx, args[0], x.Format)
+	} else {
+		document, err = x.hubSbom(ctx, args[0])
+	}
+	if err != nil {
+		return err
+	}
+
+	if x.Output == "" {
+		_, err = os.Stdout.Write(document)
+		return err
+	}
+
+	return os.WriteFile(x.Output, document, 0o644)
+}
+
+func (x *SbomCommand) hubSbom(ctx context.Context, pkg string) ([]byte, error) {
+	if x.HubUrl == "" {
+		return nil, fmt.Errorf("--hub-url is required for registered packages")
+	}
+	name, version, _ := strings.Cut(pkg, "@")
+	format := meshixv1.SbomFormat_SBOM_FORMAT_CYCLONEDX
+	if x.Format == sbom.FormatSPDX {
+		format = meshixv1.SbomFormat_SBOM_FORMAT_SPDX
+	}
+
+	client, err := newHubClient(x.HubUrl)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.GetPackageSbom(ctx, &meshixv1.GetPackageSbomRequest{
+		Name:    name,
+		Version: version,
+		System:  x.System,
+		Format:  format,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get package sbom: %w", err)
+	}
+
+	return resp.Document, nil
+}
+
+// {"path":"/nix/store/...","narHash":"sha256:...","narSize":226560,"references":["/nix/store/..."],"deriver":"/nix/store/...drv",...}
+type nixPathInfo struct {
+	Path       string   `json:"path"`
+	NarHash    string   `json:"narHash"`
+	NarSize    uint64   `json:"narSize"`
+	References []string `json:"references"`
+	Deriver    string   `json:"deriver"`
+	Signatures []string `json:"signatures"`
+	CA         string   `json:"ca"`
+}
+
+func localSbom(ctx context.Context, storePath string, format string) ([]byte, error) {
+	infos, err := getPathInfos(ctx, storePath)
+	if err != nil {
+		return nil, err
+	}
+
+	derivers := []string{}
+	for _, info := range infos {
+		if info.Deriver != "" {
+			derivers = append(derivers, info.Deriver)
+		}
+	}
+	drvNames := getDrvNames(ctx, derivers)
+
+	var rootDrv sbom.DrvName
+	for _, info := range infos {
+		if info.Path == storePath {
+			rootDrv = drvNames[info.Deriver]
+		}
+	}
+	root, err := sbom.NewComponent(storePath, rootDrv)
+	if err != nil {
+		return nil, err
+	}
+	doc := sbom.Document{
+		Name:       root.Name,
+		Version:    root.Version,
+		Roots:      []string{storePath},
+		Components: []sbom.Component{},
+		Created:    time.Now(),
+	}
+	for _, info := range infos {
+		component, err := sbom.NewComponent(info.Path, drvNames[info.Deriver])
+		if err != nil {
+			return nil, err
+		}
+		component.NarHash = info.NarHash
+		component.NarSize = info.NarSize
+		component.References = info.References
+		component.Deriver = info.Deriver
+		doc.Components = append(doc.Components, component)
+	}
+
+	document, _, err := sbom.Render(doc, format)
+	return document, err
+}
+
+// {"/nix/store/...drv":{"env":{"pname":"hello","version":"2.12.1",...},...}}
+type nixDerivation struct {
+	Env map[string]string `json:"env"`
+}
+
+// getDrvNames reads pname and version of derivations present in the local store. Derivations which are missing,
+// e.g. of paths substituted from a binary cache, are skipped and names of their outputs are parsed from store paths.
+func getDrvNames(ctx context.Context, derivers []string) map[string]sbom.DrvName {
+	names := map[string]sbom.DrvName{}
+	present := []string{}
+	for _, deriver := range slices.Compact(slices.Sorted(slices.Values(derivers))) {
+		_, err := os.Stat(deriver)
+		if err == nil {
+			present = append(present, deriver)
+		}
+	}
+	if len(present) == 0 {
+		return names
+	}
+
+	output, err := runNixCmd(ctx, "nix", append([]string{"derivation", "show"}, present...)...)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to read derivations, names of components are parsed from store paths", "err", err)
+		return names
+	}
+	derivations := map[string]nixDerivation{}
+	err = json.Unmarshal(output.Bytes(), &derivations)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to decode derivations, names of components are parsed from store paths", "err", err)
+		return names
+	}
+	for drvPath, drv := range derivations {
+		if !strings.HasPrefix(drvPath, storepath.StoreDir) {
+			drvPath = path.Join(storepath.StoreDir, drvPath)
+		}
+		names[drvPath] = drvName(drv.Env)
+	}
+
+	return names
+}
+
+// drvName returns pname and version of derivation environment, derivations with structured attrs keep them in __json.
+func drvName(env map[string]string) sbom.DrvName {
+	if env["pname"] == "" && env["__json"] != "" {
+		attrs := struct {
+			Pname   string `json:"pname"`
+			Version string `json:"version"`
+		}{}
+		err := json.Unmarshal([]byte(env["__json"]), &attrs)
+		if err == nil {
+			return sbom.DrvName{Pname: attrs.Pname, Version: attrs.Version}
+		}
+	}
+
+	return sbom.DrvName{Pname: env["pname"], Version: env["version"]}
+}
+
+// getPathInfos lists path info of the whole closure of store path.
+func getPathInfos(ctx context.Context, storePath string) ([]nixPathInfo, error) {
+	output, err := runNixCmd(ctx, "nix", "path-info", "--json", "--recursive", storePath)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get path info of %s: %w", storePath, err)
+	}
+
+	return decodePathInfos(output.Bytes())
+}
+
+// decodePathInfos decodes output of nix path-info --json.
+func decodePathInfos(output []byte) ([]nixPathInfo, error) {
+	// Older nix versions print list of path infos, newer ones object keyed by store path.
+	infos := []nixPathInfo{}
+	if bytes.HasPrefix(bytes.TrimSpace(output), []byte("[")) {
+		err := json.Unmarshal(output, &infos)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to decode path info: %w", err)
+		}
+		return infos, nil
+	}
+
+	byPath := map[string]*nixPathInfo{}
+	err := json.Unmarshal(output, &byPath)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode path info: %w", err)
+	}
+	for p, info := range byPath {
+		if info == nil {
+			return nil, fmt.Errorf("Store path %s is not valid", p)
+		}
+		info.Path = p
+		for i, reference := range info.References {
+			if !strings.HasPrefix(reference, storepath.StoreDir) {
+				info.References[i] = path.Join(storepath.StoreDir, reference)
+			}
+		}
+		infos = append(infos, *info)
+	}
+
+	return infos, nil
+}
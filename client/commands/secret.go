@@ -0,0 +1,328 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	meshixv1 "gen/proto/meshix/v1"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"syscall"
+
+	"filippo.io/age"
+	"filippo.io/age/agessh"
+	"golang.org/x/crypto/ssh"
+)
+
+// SecretCommand groups secret subcommands.
+type SecretCommand struct{}
+
+type SecretSetCommand struct {
+	HubUrl  string `long:"hub-url" description:"Url of package hub" required:"true"`
+	Machine string `long:"machine" description:"Machine the secret is encrypted to" required:"true"`
+	File    string `long:"file" description:"File with the secret, stdin when not specified"`
+	Owner   string `long:"owner" description:"Owner of the secret file on the machine, user of the agent when not specified"`
+	Group   string `long:"group" description:"Group of the secret file on the machine, group of the agent when not specified"`
+	Mode    string `long:"mode" description:"Permissions of the secret file on the machine" default:"0400"`
+}
+
+// Execute encrypts the secret to identity key of the machine and stores it in hub, argument is <name>.
+// Plaintext of the secret never leaves this machine.
+func (x *SecretSetCommand) Execute(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("Expected 1 argument <name>, got: %d", len(args))
+	}
+	mode, err := strconv.ParseUint(x.Mode, 8, 32)
+	if err != nil {
+		return fmt.Errorf("Invalid mode %s: %w", x.Mode, err)
+	}
+	var plaintext []byte
+	if x.File == "" {
+		plaintext, err = io.ReadAll(os.Stdin)
+	} else {
+		plaintext, err = os.ReadFile(x.File)
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to read secret: %w", err)
+	}
+	ctx := context.Background()
+	client, err := newHubClient(x.HubUrl)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.GetMachine(ctx, &meshixv1.GetMachineRequest{
+		Name: x.Machine,
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to get machine: %w", err)
+	}
+	ciphertext, err := encryptSecret(plaintext, resp.Machine.PublicKey)
+	if err != nil {
+		return err
+	}
+	_, err = client.PutSecret(ctx, &meshixv1.PutSecretRequest{
+		Machine:    x.Machine,
+		Name:       args[0],
+		Ciphertext: ciphertext,
+		Owner:      x.Owner,
+		Group:      x.Group,
+		Mode:       uint32(mode),
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to store secret: %w", err)
+	}
+	slog.Info("Secret stored", "machine", x.Machine, "name", args[0])
+
+	return nil
+}
+
+// encryptSecret encrypts the secret with age to X25519 key derived from Ed25519 identity key of the machine.
+func encryptSecret(plaintext []byte, publicKey []byte) ([]byte, error) {
+	if len(publicKey) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("Machine has invalid identity key")
+	}
+	sshKey, err := ssh.NewPublicKey(ed25519.PublicKey(publicKey))
+	if err != nil {
+		return nil, err
+	}
+	recipient, err := agessh.NewEd25519Recipient(sshKey)
+	if err != nil {
+		return nil, err
+	}
+
+	ciphertext := &bytes.Buffer{}
+	w, err := age.Encrypt(ciphertext, recipient)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to encrypt secret: %w", err)
+	}
+	_, err = w.Write(plaintext)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to encrypt secret: %w", err)
+	}
+	err = w.Close()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to encrypt secret: %w", err)
+	}
+
+	return ciphertext.Bytes(), nil
+}
+
+type SecretListCommand struct {
+	HubUrl  string `long:"hub-url" description:"Url of package hub" required:"true"`
+	Machine string `long:"machine" description:"List only secrets of the machine"`
+}
+
+func (x *SecretListCommand) Execute(args []string) error {
+	ctx := context.Background()
+	client, err := newHubClient(x.HubUrl)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.ListSecrets(ctx, &meshixv1.ListSecretsRequest{
+		Machine: x.Machine,
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to list secrets: %w", err)
+	}
+	for _, s := range resp.Secrets {
+		owner := s.Owner + ":" + s.Group
+		if owner == ":" {
+			owner = "-"
+		}
+		fmt.Printf("%s\t%s\t%s\t%04o\t%s\t%s\n", s.MachineName, s.Name, owner, s.Mode, s.UpdatedBy, s.UpdatedAt.AsTime().Local())
+	}
+
+	return nil
+}
+
+type SecretDeleteCommand struct {
+	HubUrl  string `long:"hub-url" description:"Url of package hub" required:"true"`
+	Machine string `long:"machine" description:"Machine of the secret" required:"true"`
+}
+
+func (x *SecretDeleteCommand) Execute(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("Expected 1 argument <name>, got: %d", len(args))
+	}
+	ctx := context.Background()
+	client, err := newHubClient(x.HubUrl)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.DeleteSecret(ctx, &meshixv1.DeleteSecretRequest{
+		Machine: x.Machine,
+		Name:    args[0],
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to delete secret: %w", err)
+	}
+	slog.Info("Secret deleted", "machine", x.Machine, "name", args[0])
+
+	return nil
+}
+
+// Magic numbers of memory backed file systems reported by statfs
+const (
+	tmpfsMagic = 0x01021994
+	ramfsMagic = 0x858458f6
+)
+
+// secretsDir materialises secrets of the machine into memory backed directory.
+type secretsDir struct {
+	path     string
+	identity age.Identity
+	// hashes of ciphertext of written secrets by name, secret is rewritten when it changes
+	hashes map[string]string
+}
+
+func newSecretsDir(path string, privateKey ed25519.PrivateKey) (*secretsDir, error) {
+	identity, err := agessh.NewEd25519Identity(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create age identity from machine key: %w", err)
+	}
+
+	return &secretsDir{
+		path:     path,
+		identity: identity,
+		hashes:   map[string]string{},
+	}, nil
+}
+
+// Sync writes changed secrets and removes secrets which are no longer assigned to the machine.
+// Failure of a single secret does not prevent writing the others.
+func (d *secretsDir) Sync(secrets []*meshixv1.Secret) error {
+	if len(secrets) == 0 {
+		if _, err := os.Stat(d.path); errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+	}
+	err := os.MkdirAll(d.path, 0o711)
+	if err != nil {
+		return fmt.Errorf("Failed to create secrets directory: %w", err)
+	}
+	var stat syscall.Statfs_t
+	err = syscall.Statfs(d.path, &stat)
+	if err != nil {
+		return fmt.Errorf("Failed to check file system of secrets directory: %w", err)
+	}
+	if int64(stat.Type) != tmpfsMagic && int64(stat.Type) != ramfsMagic {
+		return fmt.Errorf("Secrets directory %s is not on tmpfs, secrets would be written to disk", d.path)
+	}
+
+	errs := []error{}
+	names := map[string]bool{}
+	for _, s := range secrets {
+		names[s.Name] = true
+		if _, err := os.Stat(filepath.Join(d.path, s.Name)); err == nil && d.hashes[s.Name] == s.Hash {
+			continue
+		}
+		err := d.write(s)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("Failed to write secret %s: %w", s.Name, err))
+			continue
+		}
+		d.hashes[s.Name] = s.Hash
+		slog.Info("Secret written", "name", s.Name)
+	}
+
+	entries, err := os.ReadDir(d.path)
+	if err != nil {
+		return errors.Join(append(errs, fmt.Errorf("Failed to list secrets directory: %w", err))...)
+	}
+	for _, e := range entries {
+		if names[e.Name()] {
+			continue
+		}
+		err := os.Remove(filepath.Join(d.path, e.Name()))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("Failed to remove secret %s: %w", e.Name(), err))
+			continue
+		}
+		delete(d.hashes, e.Name())
+		slog.Info("Secret removed", "name", e.Name())
+	}
+
+	return errors.Join(errs...)
+}
+
+// write decrypts the secret into temporary file with its ownership and permissions and renames it,
+// so readers never see partially written secret.
+func (d *secretsDir) write(s *meshixv1.Secret) error {
+	if filepath.Base(s.Name) != s.Name || s.Name[0] == '.' {
+		return fmt.Errorf("Invalid secret name")
+	}
+	uid, gid, err := lookupOwner(s.Owner, s.Group)
+	if err != nil {
+		return err
+	}
+	r, err := age.Decrypt(bytes.NewReader(s.Ciphertext), d.identity)
+	if err != nil {
+		return fmt.Errorf("Failed to decrypt: %w", err)
+	}
+
+	f, err := os.CreateTemp(d.path, "."+s.Name+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	err = f.Chmod(0o600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if err != nil {
+		return fmt.Errorf("Failed to decrypt: %w", err)
+	}
+	err = f.Chown(uid, gid)
+	if err != nil {
+		return err
+	}
+	err = f.Chmod(fs.FileMode(s.Mode) & fs.ModePerm)
+	if err != nil {
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), filepath.Join(d.path, s.Name))
+}
+
+// lookupOwner resolves owner and group names to ids, -1 keeps user or group of the agent.
+func lookupOwner(owner, group string) (int, int, error) {
+	uid, gid := -1, -1
+	if owner != "" {
+		u, err := user.Lookup(owner)
+		if err != nil {
+			return 0, 0, err
+		}
+		uid, err = strconv.Atoi(u.Uid)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	if group != "" {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			return 0, 0, err
+		}
+		gid, err = strconv.Atoi(g.Gid)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	return uid, gid, nil
+}
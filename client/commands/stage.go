@@ -0,0 +1,80 @@
+package commands
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"sync"
+)
+
+// stager prefetches packages of prestaged rollouts in background, so their activation does not wait
+// for downloads.
+type stager struct {
+	activator Activator
+	// downloadSpeed in KiB/s, unlimited when 0
+	downloadSpeed int
+
+	mu sync.Mutex
+	// target store paths being prefetched, ready when the prefetch succeeded
+	target []string
+	ready  []string
+	cancel context.CancelFunc
+}
+
+func newStager(activator Activator, downloadSpeed int) *stager {
+	return &stager{
+		activator:     activator,
+		downloadSpeed: downloadSpeed,
+	}
+}
+
+// Stage starts prefetch of the store paths unless they are already prefetched or being prefetched.
+// Running prefetch of other store paths is cancelled.
+func (s *stager) Stage(ctx context.Context, storePaths []string) {
+	storePaths = slices.Clone(storePaths)
+	slices.Sort(storePaths)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.target != nil && slices.Equal(s.target, storePaths) {
+		return
+	}
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.target = storePaths
+	s.ready = nil
+	ctx, s.cancel = context.WithCancel(ctx)
+
+	if len(storePaths) > 0 {
+		slog.Info("Staging store paths", "storePaths", storePaths, "downloadSpeed", s.downloadSpeed)
+	}
+	go func() {
+		err := s.activator.Prefetch(ctx, storePaths, s.downloadSpeed)
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if !slices.Equal(s.target, storePaths) {
+			// Superseded by another prefetch
+			return
+		}
+		if err != nil {
+			slog.Error("Failed to stage store paths", "err", err)
+			// Prefetch is retried with the next desired state
+			s.target = nil
+			return
+		}
+		if len(storePaths) > 0 {
+			slog.Info("Store paths staged", "storePaths", storePaths)
+		}
+		s.ready = storePaths
+	}()
+}
+
+// Ready lists prefetched store paths.
+func (s *stager) Ready() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return slices.Clone(s.ready)
+}
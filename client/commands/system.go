@@ -0,0 +1,56 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+)
+
+type nixSystems struct {
+	current string
+	extra   []string
+}
+
+// buildable lists systems this machine can build for, current system first.
+func (s nixSystems) buildable() []string {
+	systems := []string{s.current}
+	for _, system := range s.extra {
+		if !slices.Contains(systems, system) {
+			systems = append(systems, system)
+		}
+	}
+
+	return systems
+}
+
+// {"system":{"value":"x86_64-linux",...},"extra-platforms":{"value":["i686-linux"],...},...}
+type nixConfig struct {
+	System struct {
+		Value string `json:"value"`
+	} `json:"system"`
+	ExtraPlatforms struct {
+		Value []string `json:"value"`
+	} `json:"extra-platforms"`
+}
+
+func getNixSystems(ctx context.Context) (nixSystems, error) {
+	output, err := runNixCmd(ctx, "nix", "config", "show", "--json")
+	if err != nil {
+		return nixSystems{}, fmt.Errorf("Failed to show nix config: %w", err)
+	}
+
+	var cfg nixConfig
+	err = json.NewDecoder(output).Decode(&cfg)
+	if err != nil {
+		return nixSystems{}, fmt.Errorf("Failed to decode nix config: %w", err)
+	}
+	if cfg.System.Value == "" {
+		return nixSystems{}, fmt.Errorf("Nix config has no system set")
+	}
+
+	return nixSystems{
+		current: cfg.System.Value,
+		extra:   cfg.ExtraPlatforms.Value,
+	}, nil
+}
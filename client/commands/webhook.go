@@ -0,0 +1,75 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	meshixv1 "gen/proto/meshix/v1"
+	"log/slog"
+	"time"
+)
+
+// WebhookCommand groups webhook subcommands.
+type WebhookCommand struct{}
+
+type WebhookTestCommand struct {
+	HubUrl string `long:"hub-url" description:"Url of package hub" required:"true"`
+}
+
+// Execute posts test event to the webhook.
+func (x *WebhookTestCommand) Execute(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("Expected 1 argument <name>, got: %d", len(args))
+	}
+	ctx := context.Background()
+	client, err := newHubClient(x.HubUrl)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.TestWebhook(ctx, &meshixv1.TestWebhookRequest{
+		Name: args[0],
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to test webhook: %w", err)
+	}
+	d := resp.Delivery
+	if d.Error != "" {
+		return fmt.Errorf("Webhook %s failed: %s", d.Webhook, d.Error)
+	}
+	slog.Info("Webhook accepted test event", "webhook", d.Webhook, "status", d.StatusCode, "duration", time.Duration(d.DurationMs)*time.Millisecond)
+
+	return nil
+}
+
+type WebhookDeliveriesCommand struct {
+	HubUrl  string `long:"hub-url" description:"Url of package hub" required:"true"`
+	Webhook string `long:"webhook" description:"List only deliveries of the webhook"`
+	Limit   int32  `long:"limit" description:"Maximum number of deliveries" default:"20"`
+}
+
+// Execute lists the delivery log, newest first.
+func (x *WebhookDeliveriesCommand) Execute(args []string) error {
+	ctx := context.Background()
+	client, err := newHubClient(x.HubUrl)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.ListWebhookDeliveries(ctx, &meshixv1.ListWebhookDeliveriesRequest{
+		Webhook: x.Webhook,
+		Limit:   x.Limit,
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to list webhook deliveries: %w", err)
+	}
+	for _, d := range resp.Deliveries {
+		result := "ok"
+		if d.Error != "" {
+			result = d.Error
+		}
+		fmt.Printf("%s\t%s\t%d\t%s\t%s\t#%d\t%d\t%dms\t%s\n", d.CreatedAt.AsTime().Local().Format(time.DateTime),
+			d.Webhook, d.ChangeId, d.Kind, d.Subject, d.Attempt, d.StatusCode, d.DurationMs, result)
+	}
+
+	return nil
+}
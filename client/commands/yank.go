@@ -0,0 +1,39 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	meshixv1 "gen/proto/meshix/v1"
+	"log/slog"
+)
+
+type YankCommand struct {
+	HubUrl string `long:"hub-url" description:"Url of package hub" required:"true"`
+	Reason string `long:"reason" description:"Reason why the package is yanked"`
+	System string `long:"system" description:"Only yank package built for the nix system"`
+}
+
+func (x *YankCommand) Execute(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("Expected 2 arguments <name> <version>, got: %d", len(args))
+	}
+	ctx := context.Background()
+
+	client, err := newHubClient(x.HubUrl)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.YankPackage(ctx, &meshixv1.YankPackageRequest{
+		Name:    args[0],
+		Version: args[1],
+		System:  x.System,
+		Reason:  x.Reason,
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to yank package: %w", err)
+	}
+	slog.Info("Package yanked", "name", args[0], "version", args[1])
+
+	return nil
+}
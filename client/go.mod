@@ -3,16 +3,24 @@ module client
 go 1.23.4
 
 require (
+	filippo.io/age v1.2.1
 	github.com/jessevdk/go-flags v1.6.1
+	github.com/klauspost/compress v1.17.11
+	github.com/nix-community/go-nix v0.0.0-20250101154619-4bdde671e0a1
+	golang.org/x/crypto v0.33.0
 	google.golang.org/grpc v1.70.0
 )
 
+require (
+	filippo.io/edwards25519 v1.1.0 // indirect
+)
+
 require (
 	github.com/fsnotify/fsnotify v1.8.0
 	go.opentelemetry.io/otel v1.34.0 // indirect
-	golang.org/x/net v0.35.0 // indirect
+	golang.org/x/net v0.35.0
 	golang.org/x/sys v0.30.0 // indirect
 	golang.org/x/text v0.22.0 // indirect
 	google.golang.org/genproto/googleapis/rpc v0.0.0-20250207221924-e9438ea467c6 // indirect
-	google.golang.org/protobuf v1.36.5 // indirect
+	google.golang.org/protobuf v1.36.5
 )
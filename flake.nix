@@ -33,6 +33,7 @@
           };
           clientPkg = pkgs.callPackage ./nix/client.nix {
             globset = globset;
+            version = self.shortRev or self.dirtyShortRev or "dev";
           };
           dockerImgs = pkgs.callPackage ./nix/docker.nix {
             meshix-server = serverPkg;
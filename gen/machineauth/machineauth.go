@@ -0,0 +1,48 @@
+// Package machineauth defines signatures of requests sent by enrolled machines, shared by the hub and the agent.
+package machineauth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// Metadata keys of requests signed by enrolled machines.
+const (
+	IdHeader        = "x-meshix-machine-id"
+	TimestampHeader = "x-meshix-timestamp"
+	NonceHeader     = "x-meshix-nonce"
+	SignatureHeader = "x-meshix-signature"
+)
+
+// NonceSize is the number of random bytes of the nonce, encoded as unpadded base64url.
+const NonceSize = 16
+
+// NewNonce generates random nonce of a single request.
+func NewNonce() (string, error) {
+	b := make([]byte, NonceSize)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Fingerprint is signed by machine with its identity key, fields are joined with ';':
+// meshix-machine-2;<full grpc method>;<machine id>;<unix timestamp>;<nonce>;<hex sha256 of request>
+//
+// Request is hashed in deterministic protobuf wire encoding.
+func Fingerprint(method string, machineId int64, timestamp int64, nonce string, req proto.Message) (string, error) {
+	b, err := proto.MarshalOptions{Deterministic: true}.Marshal(req)
+	if err != nil {
+		return "", fmt.Errorf("Failed to marshal request: %w", err)
+	}
+	digest := sha256.Sum256(b)
+
+	return fmt.Sprintf("meshix-machine-2;%s;%d;%d;%s;%s", method, machineId, timestamp, nonce, hex.EncodeToString(digest[:])), nil
+}
@@ -2,6 +2,8 @@
 , lib
 , callPackage
 , globset
+  # Recorded into provenance of pushed packages
+, version ? "dev"
 }:
 let
   protobufGenerated = callPackage ./common.nix {
@@ -17,11 +19,17 @@ buildGoModule {
       "server/go.mod"
       "server/go.sum"
       "gen/**"
+      "provenance/**"
+      "sbom/**"
       "go.*"
     ];
   };
   env.CGO_ENABLED = 0;
-  version = "0.0.1";
+  inherit version;
+
+  ldflags = [
+    "-X client/commands.Version=${version}"
+  ];
 
   proxyVendor = true;
   subPackages = [
@@ -25,7 +25,7 @@ let
 
     installPhase = ''
       mkdir -p $out/migrations
-      cp * $out/migrations
+      cp -r * $out/migrations
     '';
   };
 
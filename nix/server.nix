@@ -18,6 +18,8 @@ buildGoModule {
       "client/go.mod"
       "client/go.sum"
       "gen/**"
+      "provenance/**"
+      "sbom/**"
       "go.*"
     ];
   };
@@ -0,0 +1,5 @@
+module provenance
+
+go 1.23.4
+
+require github.com/nix-community/go-nix v0.0.0-20250101154619-4bdde671e0a1
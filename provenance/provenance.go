@@ -0,0 +1,88 @@
+// Package provenance signs and verifies build provenance of packages, the hub signs it when the package is
+// pushed and clients and replicas verify it with the public key of the binary cache.
+package provenance
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/nix-community/go-nix/pkg/narinfo/signature"
+)
+
+const fingerprintVersion = "meshix-provenance-2"
+
+// Fields of the package and its provenance covered by the signature.
+type Fields struct {
+	Name          string
+	Version       string
+	System        string
+	StorePath     string
+	FlakeUrl      string
+	FlakeRevision string
+	FlakeNarHash  string
+	DrvPath       string
+	BuilderHost   string
+	ClientVersion string
+	Ci            map[string]string
+}
+
+// Fingerprint of the provenance, format is documented on Provenance message in server.proto. Every value is
+// prefixed with its length, so values containing separators can't be shifted into other fields.
+func Fingerprint(f Fields) string {
+	var b strings.Builder
+	b.WriteString(fingerprintVersion)
+	for _, value := range []string{
+		f.Name,
+		f.Version,
+		f.System,
+		f.StorePath,
+		f.FlakeUrl,
+		f.FlakeRevision,
+		f.FlakeNarHash,
+		f.DrvPath,
+		f.BuilderHost,
+		f.ClientVersion,
+		strconv.Itoa(len(f.Ci)),
+	} {
+		writeField(&b, value)
+	}
+	for _, key := range slices.Sorted(maps.Keys(f.Ci)) {
+		writeField(&b, key)
+		writeField(&b, f.Ci[key])
+	}
+
+	return b.String()
+}
+
+func writeField(b *strings.Builder, value string) {
+	b.WriteByte(';')
+	b.WriteString(strconv.Itoa(len(value)))
+	b.WriteByte(':')
+	b.WriteString(value)
+}
+
+// Sign signs the provenance with binary cache key.
+func Sign(key signature.SecretKey, f Fields) (string, error) {
+	sig, err := key.Sign(nil, Fingerprint(f))
+	if err != nil {
+		return "", fmt.Errorf("Failed to sign provenance: %w", err)
+	}
+
+	return sig.String(), nil
+}
+
+// Verify checks that the provenance signature is made by binary cache key.
+func Verify(key signature.PublicKey, f Fields, sig string) error {
+	parsed, err := signature.ParseSignature(sig)
+	if err != nil {
+		return fmt.Errorf("Failed to parse provenance signature: %w", err)
+	}
+	if parsed.Name != key.Name || !key.Verify(Fingerprint(f), parsed) {
+		return fmt.Errorf("Provenance of %s-%s is not signed by %s", f.Name, f.Version, key.Name)
+	}
+
+	return nil
+}
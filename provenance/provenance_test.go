@@ -0,0 +1,50 @@
+package provenance
+
+import (
+	"testing"
+
+	"github.com/nix-community/go-nix/pkg/narinfo/signature"
+)
+
+func TestFingerprintIsUnambiguous(t *testing.T) {
+	// Both were fingerprinted as ...;a=b,c=d when entries were joined with separators
+	first := Fields{Name: "hello", Ci: map[string]string{"a": "b,c=d"}}
+	second := Fields{Name: "hello", Ci: map[string]string{"a": "b", "c": "d"}}
+	if Fingerprint(first) == Fingerprint(second) {
+		t.Errorf("Different ci entries have the same fingerprint %s", Fingerprint(first))
+	}
+
+	// Both were fingerprinted as ...;https://a;b;...
+	first = Fields{FlakeUrl: "https://a;b"}
+	second = Fields{FlakeUrl: "https://a", FlakeRevision: "b"}
+	if Fingerprint(first) == Fingerprint(second) {
+		t.Errorf("Different flake urls have the same fingerprint %s", Fingerprint(first))
+	}
+}
+
+func TestSignAndVerify(t *testing.T) {
+	secretKey, publicKey, err := signature.GenerateKeypair("meshix", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := Fields{
+		Name:      "hello",
+		Version:   "1.0",
+		StorePath: "/nix/store/hello-1.0",
+		Ci:        map[string]string{"GITHUB_RUN_ID": "1"},
+	}
+	sig, err := Sign(secretKey, fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = Verify(publicKey, fields, sig)
+	if err != nil {
+		t.Errorf("Signature is not valid: %s", err)
+	}
+	fields.Ci["GITHUB_RUN_ID"] = "2"
+	err = Verify(publicKey, fields, sig)
+	if err == nil {
+		t.Error("Signature of changed provenance is valid")
+	}
+}
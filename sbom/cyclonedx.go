@@ -0,0 +1,127 @@
+package sbom
+
+import (
+	"encoding/json"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// https://cyclonedx.org/docs/1.5/json/
+type cdxBom struct {
+	BomFormat    string          `json:"bomFormat"`
+	SpecVersion  string          `json:"specVersion"`
+	SerialNumber string          `json:"serialNumber"`
+	Version      int             `json:"version"`
+	Metadata     cdxMetadata     `json:"metadata"`
+	Components   []cdxComponent  `json:"components"`
+	Dependencies []cdxDependency `json:"dependencies"`
+}
+
+type cdxMetadata struct {
+	Timestamp string        `json:"timestamp"`
+	Tools     cdxTools      `json:"tools"`
+	Component *cdxComponent `json:"component,omitempty"`
+}
+
+type cdxTools struct {
+	Components []cdxComponent `json:"components"`
+}
+
+type cdxComponent struct {
+	Type        string        `json:"type"`
+	BomRef      string        `json:"bom-ref,omitempty"`
+	Name        string        `json:"name"`
+	Version     string        `json:"version,omitempty"`
+	Description string        `json:"description,omitempty"`
+	Licenses    []cdxLicense  `json:"licenses,omitempty"`
+	Hashes      []cdxHash     `json:"hashes,omitempty"`
+	Properties  []cdxProperty `json:"properties,omitempty"`
+}
+
+type cdxLicense struct {
+	License cdxLicenseName `json:"license"`
+}
+
+type cdxLicenseName struct {
+	Name string `json:"name"`
+}
+
+type cdxHash struct {
+	Alg     string `json:"alg"`
+	Content string `json:"content"`
+}
+
+type cdxProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type cdxDependency struct {
+	Ref       string   `json:"ref"`
+	DependsOn []string `json:"dependsOn"`
+}
+
+// CycloneDX renders document as CycloneDX 1.5 JSON.
+func CycloneDX(doc Document) ([]byte, error) {
+	uuid, err := newUUID()
+	if err != nil {
+		return nil, err
+	}
+
+	licenses := []cdxLicense{}
+	for _, license := range doc.Licenses {
+		licenses = append(licenses, cdxLicense{License: cdxLicenseName{Name: license}})
+	}
+
+	bom := cdxBom{
+		BomFormat:    "CycloneDX",
+		SpecVersion:  "1.5",
+		SerialNumber: "urn:uuid:" + uuid,
+		Version:      1,
+		Metadata: cdxMetadata{
+			Timestamp: doc.Created.UTC().Format(time.RFC3339),
+			Tools: cdxTools{
+				Components: []cdxComponent{{Type: "application", Name: toolName}},
+			},
+			Component: &cdxComponent{
+				Type:        "application",
+				BomRef:      doc.Name + "@" + doc.Version,
+				Name:        doc.Name,
+				Version:     doc.Version,
+				Description: doc.Description,
+				Licenses:    licenses,
+			},
+		},
+		Components:   []cdxComponent{},
+		Dependencies: []cdxDependency{{Ref: doc.Name + "@" + doc.Version, DependsOn: doc.Roots}},
+	}
+
+	for _, c := range doc.Components {
+		component := cdxComponent{
+			Type:    "library",
+			BomRef:  c.StorePath,
+			Name:    c.Name,
+			Version: c.Version,
+			Properties: []cdxProperty{
+				{Name: "nix:store_path", Value: c.StorePath},
+				{Name: "nix:nar_size", Value: strconv.FormatUint(c.NarSize, 10)},
+			},
+		}
+		if slices.Contains(doc.Roots, c.StorePath) {
+			component.Type = "application"
+		}
+		if c.Deriver != "" {
+			component.Properties = append(component.Properties, cdxProperty{Name: "nix:deriver", Value: c.Deriver})
+		}
+		if sha256, ok := c.sha256Hex(); ok {
+			component.Hashes = []cdxHash{{Alg: "SHA-256", Content: sha256}}
+		}
+		bom.Components = append(bom.Components, component)
+
+		references := slices.DeleteFunc(slices.Clone(c.References), func(r string) bool { return r == c.StorePath })
+		bom.Dependencies = append(bom.Dependencies, cdxDependency{Ref: c.StorePath, DependsOn: references})
+	}
+
+	return json.MarshalIndent(bom, "", "  ")
+}
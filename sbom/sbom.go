@@ -0,0 +1,134 @@
+// Package sbom renders software bill of materials of nix store path closures.
+package sbom
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/nix-community/go-nix/pkg/nixbase32"
+	"github.com/nix-community/go-nix/pkg/nixhash"
+	"github.com/nix-community/go-nix/pkg/storepath"
+)
+
+const (
+	FormatCycloneDX = "cyclonedx"
+	FormatSPDX      = "spdx"
+)
+
+const toolName = "meshix"
+
+// Component is single store path of the closure.
+type Component struct {
+	StorePath string
+	// Name and Version are pname and version of the derivation, parsed from the store path name when unknown
+	Name    string
+	Version string
+	// NarHash in nix format, e.g. sha256:1b8m03r63zqhnjf7l5wnldhh7c134ap5vpj0850ymkq1iyzicy5s
+	NarHash string
+	NarSize uint64
+	// References are absolute store paths of the runtime dependencies
+	References []string
+	Deriver    string
+}
+
+// Document describes closure of the root store paths.
+type Document struct {
+	Name        string
+	Version     string
+	Description string
+	Licenses    []string
+	// Roots are store paths the document is describing, e.g. outputs of a package
+	Roots      []string
+	Components []Component
+	Created    time.Time
+}
+
+// DrvName is pname and version of the derivation which built the store path.
+type DrvName struct {
+	Pname   string
+	Version string
+}
+
+// NewComponent creates component of store path. Name and version are taken from the derivation when its pname is
+// known, otherwise they are parsed from the store path name, which is ambiguous e.g. for foo-unstable-2024-01-01
+// or python3.12-requests-2.31.
+func NewComponent(path string, drv DrvName) (Component, error) {
+	sp, err := storepath.FromAbsolutePath(path)
+	if err != nil {
+		return Component{}, fmt.Errorf("Invalid store path %s: %w", path, err)
+	}
+	name, version := drv.Pname, drv.Version
+	if name == "" {
+		name, version = ParseName(sp.Name)
+	}
+
+	return Component{
+		StorePath:  path,
+		Name:       name,
+		Version:    version,
+		References: []string{},
+	}, nil
+}
+
+// ParseName splits store path name into name and version like builtins.parseDrvName, version starts after the first
+// dash not followed by letter.
+func ParseName(name string) (string, string) {
+	for i := 0; i < len(name)-1; i++ {
+		next := name[i+1]
+		isLetter := (next >= 'a' && next <= 'z') || (next >= 'A' && next <= 'Z')
+		if name[i] == '-' && !isLetter {
+			return name[:i], name[i+1:]
+		}
+	}
+
+	return name, ""
+}
+
+// Render renders document in the format, returns document and its content type.
+func Render(doc Document, format string) ([]byte, string, error) {
+	switch format {
+	case FormatCycloneDX:
+		data, err := CycloneDX(doc)
+		return data, "application/vnd.cyclonedx+json", err
+	case FormatSPDX:
+		data, err := SPDX(doc)
+		return data, "application/spdx+json", err
+	}
+
+	return nil, "", fmt.Errorf("Unsupported sbom format: %s", format)
+}
+
+func (c Component) storePathHash() string {
+	sp, err := storepath.FromAbsolutePath(c.StorePath)
+	if err != nil {
+		return ""
+	}
+
+	return nixbase32.EncodeToString(sp.Digest)
+}
+
+func (c Component) sha256Hex() (string, bool) {
+	if c.NarHash == "" {
+		return "", false
+	}
+	hash, err := nixhash.ParseAny(c.NarHash, nil)
+	if err != nil || hash.Algo() != nixhash.SHA256 {
+		return "", false
+	}
+
+	return hex.EncodeToString(hash.Digest()), true
+}
+
+func newUUID() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
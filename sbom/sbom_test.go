@@ -0,0 +1,44 @@
+package sbom
+
+import "testing"
+
+func TestParseName(t *testing.T) {
+	tests := []struct {
+		name    string
+		pname   string
+		version string
+	}{
+		{"hello-2.12.1", "hello", "2.12.1"},
+		{"glibc-2.40-36", "glibc", "2.40-36"},
+		{"nix-index-db", "nix-index-db", ""},
+		{"source", "source", ""},
+		// Ambiguous names, derivation metadata is needed to split them right
+		{"foo-unstable-2024-01-01", "foo-unstable", "2024-01-01"},
+		{"python3.12-requests-2.31", "python3.12-requests", "2.31"},
+	}
+	for _, test := range tests {
+		pname, version := ParseName(test.name)
+		if pname != test.pname || version != test.version {
+			t.Errorf("ParseName(%q) = %q, %q, expected %q, %q", test.name, pname, version, test.pname, test.version)
+		}
+	}
+}
+
+func TestNewComponentPrefersDerivation(t *testing.T) {
+	path := "/nix/store/a7hnr9dcmx3qkkn8a20g7md1wya5zc9l-python3.12-requests-2.31"
+	component, err := NewComponent(path, DrvName{Pname: "requests", Version: "2.31"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if component.Name != "requests" || component.Version != "2.31" {
+		t.Errorf("Name of the derivation is not used: %s %s", component.Name, component.Version)
+	}
+
+	component, err = NewComponent(path, DrvName{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if component.Name != "python3.12-requests" || component.Version != "2.31" {
+		t.Errorf("Name is not parsed from store path: %s %s", component.Name, component.Version)
+	}
+}
@@ -0,0 +1,146 @@
+package sbom
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// https://spdx.github.io/spdx-spec/v2.3/
+type spdxDocument struct {
+	SpdxVersion       string             `json:"spdxVersion"`
+	DataLicense       string             `json:"dataLicense"`
+	SPDXID            string             `json:"SPDXID"`
+	Name              string             `json:"name"`
+	DocumentNamespace string             `json:"documentNamespace"`
+	CreationInfo      spdxCreationInfo   `json:"creationInfo"`
+	Packages          []spdxPackage      `json:"packages"`
+	Relationships     []spdxRelationship `json:"relationships"`
+}
+
+type spdxCreationInfo struct {
+	Created  string   `json:"created"`
+	Creators []string `json:"creators"`
+}
+
+type spdxPackage struct {
+	SPDXID           string         `json:"SPDXID"`
+	Name             string         `json:"name"`
+	VersionInfo      string         `json:"versionInfo,omitempty"`
+	Description      string         `json:"description,omitempty"`
+	DownloadLocation string         `json:"downloadLocation"`
+	FilesAnalyzed    bool           `json:"filesAnalyzed"`
+	LicenseConcluded string         `json:"licenseConcluded"`
+	LicenseDeclared  string         `json:"licenseDeclared"`
+	CopyrightText    string         `json:"copyrightText"`
+	Checksums        []spdxChecksum `json:"checksums,omitempty"`
+	Comment          string         `json:"comment,omitempty"`
+}
+
+type spdxChecksum struct {
+	Algorithm     string `json:"algorithm"`
+	ChecksumValue string `json:"checksumValue"`
+}
+
+type spdxRelationship struct {
+	SpdxElementId      string `json:"spdxElementId"`
+	RelationshipType   string `json:"relationshipType"`
+	RelatedSpdxElement string `json:"relatedSpdxElement"`
+}
+
+const spdxNoAssertion = "NOASSERTION"
+
+var spdxLicenseIdRe = regexp.MustCompile(`^[A-Za-z0-9.+-]+$`)
+
+// SPDX renders document as SPDX 2.3 JSON.
+func SPDX(doc Document) ([]byte, error) {
+	uuid, err := newUUID()
+	if err != nil {
+		return nil, err
+	}
+
+	rootId := "SPDXRef-package"
+	spdx := spdxDocument{
+		SpdxVersion:       "SPDX-2.3",
+		DataLicense:       "CC0-1.0",
+		SPDXID:            "SPDXRef-DOCUMENT",
+		Name:              doc.Name + "-" + doc.Version,
+		DocumentNamespace: "https://spdx.org/spdxdocs/" + doc.Name + "-" + doc.Version + "-" + uuid,
+		CreationInfo: spdxCreationInfo{
+			Created:  doc.Created.UTC().Format(time.RFC3339),
+			Creators: []string{"Tool: " + toolName},
+		},
+		Packages: []spdxPackage{{
+			SPDXID:           rootId,
+			Name:             doc.Name,
+			VersionInfo:      doc.Version,
+			Description:      doc.Description,
+			DownloadLocation: spdxNoAssertion,
+			LicenseConcluded: spdxNoAssertion,
+			LicenseDeclared:  spdxLicenseExpression(doc.Licenses),
+			CopyrightText:    spdxNoAssertion,
+		}},
+		Relationships: []spdxRelationship{{
+			SpdxElementId:      "SPDXRef-DOCUMENT",
+			RelationshipType:   "DESCRIBES",
+			RelatedSpdxElement: rootId,
+		}},
+	}
+
+	for _, root := range doc.Roots {
+		spdx.Relationships = append(spdx.Relationships, spdxRelationship{
+			SpdxElementId:      rootId,
+			RelationshipType:   "CONTAINS",
+			RelatedSpdxElement: spdxId(root),
+		})
+	}
+
+	for _, c := range doc.Components {
+		pkg := spdxPackage{
+			SPDXID:           spdxId(c.StorePath),
+			Name:             c.Name,
+			VersionInfo:      c.Version,
+			DownloadLocation: spdxNoAssertion,
+			LicenseConcluded: spdxNoAssertion,
+			LicenseDeclared:  spdxNoAssertion,
+			CopyrightText:    spdxNoAssertion,
+			Comment:          "Nix store path " + c.StorePath,
+		}
+		if sha256, ok := c.sha256Hex(); ok {
+			pkg.Checksums = []spdxChecksum{{Algorithm: "SHA256", ChecksumValue: sha256}}
+		}
+		spdx.Packages = append(spdx.Packages, pkg)
+
+		for _, reference := range c.References {
+			if reference == c.StorePath {
+				continue
+			}
+			spdx.Relationships = append(spdx.Relationships, spdxRelationship{
+				SpdxElementId:      spdxId(c.StorePath),
+				RelationshipType:   "DEPENDS_ON",
+				RelatedSpdxElement: spdxId(reference),
+			})
+		}
+	}
+
+	return json.MarshalIndent(spdx, "", "  ")
+}
+
+func spdxId(storePath string) string {
+	c := Component{StorePath: storePath}
+	return "SPDXRef-" + c.storePathHash()
+}
+
+func spdxLicenseExpression(licenses []string) string {
+	if len(licenses) == 0 {
+		return spdxNoAssertion
+	}
+	for _, license := range licenses {
+		if !spdxLicenseIdRe.MatchString(license) {
+			return spdxNoAssertion
+		}
+	}
+
+	return strings.Join(licenses, " AND ")
+}
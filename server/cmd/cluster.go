@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"errors"
+	meshixv1 "gen/proto/meshix/v1"
+	"server/internal/auth"
+	"server/internal/cluster"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// GetClusterStatus implements meshixv1.MeshixServiceServer.
+func (m *Meshix) GetClusterStatus(ctx context.Context, req *meshixv1.GetClusterStatusRequest) (*meshixv1.GetClusterStatusResponse, error) {
+	err := auth.RequireGroup(ctx, m.authCfg.AdminGroup)
+	if err != nil {
+		return nil, err
+	}
+	if m.cluster == nil {
+		return nil, status.Error(codes.FailedPrecondition, "Server is not in cluster mode")
+	}
+
+	nodes := []*meshixv1.ClusterNode{}
+	for _, n := range m.cluster.Nodes() {
+		nodes = append(nodes, &meshixv1.ClusterNode{
+			Url:  n.Url,
+			Self: n.Self,
+			Live: n.Live,
+		})
+	}
+	resp := &meshixv1.GetClusterStatusResponse{
+		Nodes:             nodes,
+		ReplicationFactor: int32(m.cluster.Membership().ReplicationFactor),
+	}
+	r := m.cluster.RebalanceStatus()
+	if !r.StartedAt.IsZero() {
+		resp.Rebalance = &meshixv1.RebalanceStatus{
+			Running:   r.Running,
+			StartedAt: timestamppb.New(r.StartedAt),
+			Checked:   int64(r.Checked),
+			Copied:    int64(r.Copied),
+			Dropped:   int64(r.Dropped),
+			Failed:    int64(r.Failed),
+			LastError: r.LastError,
+		}
+		if !r.FinishedAt.IsZero() {
+			resp.Rebalance.FinishedAt = timestamppb.New(r.FinishedAt)
+		}
+	}
+
+	return resp, nil
+}
+
+// RebalanceCluster implements meshixv1.MeshixServiceServer.
+func (m *Meshix) RebalanceCluster(ctx context.Context, req *meshixv1.RebalanceClusterRequest) (*meshixv1.RebalanceClusterResponse, error) {
+	err := auth.RequireGroup(ctx, m.authCfg.AdminGroup)
+	if err != nil {
+		return nil, err
+	}
+	if m.cluster == nil {
+		return nil, status.Error(codes.FailedPrecondition, "Server is not in cluster mode")
+	}
+	err = m.cluster.StartRebalance(ctx)
+	if errors.Is(err, cluster.ErrRebalanceRunning) {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &meshixv1.RebalanceClusterResponse{}, nil
+}
@@ -4,7 +4,7 @@ package main
 
 import (
 	"context"
-	"database/sql"
+	"crypto/ed25519"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -13,11 +13,24 @@ import (
 	"log/slog"
 	"net/http"
 	"os"
+	"provenance"
 	"runtime/debug"
+	"sbom"
+	"server/internal/auth"
+	"server/internal/closure"
+	"server/internal/cluster"
 	"server/internal/config"
 	"server/internal/db"
 	"server/internal/domain"
+	"server/internal/fleet"
 	"server/internal/handlers"
+	"server/internal/outbox"
+	"server/internal/policy"
+	"server/internal/replication"
+	"server/internal/rollout"
+	"server/internal/storage"
+	"server/internal/vulns"
+	"server/internal/webhooks"
 	"strings"
 	"time"
 
@@ -26,7 +39,6 @@ import (
 	"github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors/recovery"
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
-	"github.com/pressly/goose/v3"
 	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"golang.org/x/net/http2"
 	"golang.org/x/net/http2/h2c"
@@ -34,9 +46,9 @@ import (
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/reflection"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 
 	"github.com/alecthomas/kong"
-	_ "modernc.org/sqlite"
 )
 
 func main() {
@@ -53,6 +65,14 @@ func main() {
 
 var enabledLogging = true
 
+const advisoriesPollInterval = time.Minute
+
+const defaultBootstrapTokenTtl = 24 * time.Hour
+
+const driftCheckInterval = time.Minute
+
+const outboxPruneInterval = time.Hour
+
 var CLI struct {
 	SecretKey []string `name:"secret-key" help:"Binary cache secret key"`
 }
@@ -96,13 +116,66 @@ func run(ctx context.Context, args []string) error {
 		return errors.New("Failed to ping minio")
 	}
 
-	dbPool, err := setupDB()
+	database, err := db.Open(ctx, cfg.DatabaseCfg.Dsn, os.DirFS("./migrations"))
 	if err != nil {
 		return fmt.Errorf("Failed to setup db: %w", err)
 	}
 
+	narInfos := storage.NewNarInfoStore(minioClient, cfg.MinioCfg)
+	var nodes *cluster.Cluster
+	if cfg.ClusterCfg.Self != "" {
+		nodes, err = cluster.New(ctx, cfg.ClusterCfg, cfg.AuthCfg.ClusterGroup, narInfos, database)
+		if err != nil {
+			return fmt.Errorf("Failed to setup cluster: %w", err)
+		}
+		narInfos = narInfos.WithRemote(nodes)
+		go nodes.Run(ctx)
+	}
+	go outbox.RunRetention(ctx, database, cfg.OutboxRetention, outboxPruneInterval)
+	hooks := webhooks.NewDispatcher(database, cfg.Webhooks)
+	go hooks.Run(ctx)
+	go webhooks.RunRetention(ctx, database, cfg.OutboxRetention, outboxPruneInterval)
+	scanner := vulns.NewScanner(database, narInfos)
+	go scanner.Run(ctx)
+	if cfg.AdvisoriesDir != "" {
+		go scanner.WatchDir(ctx, cfg.AdvisoriesDir, advisoriesPollInterval)
+	}
+
+	rollouts := rollout.NewController(database, cfg.MachineStaleAfter)
+	go rollouts.Run(ctx, rolloutControllerInterval)
+
+	drifts := fleet.NewDriftDetector(database)
+	go drifts.Run(ctx, driftCheckInterval)
+
+	policies, err := policy.NewEngine(cfg.Policies, narInfos)
+	if err != nil {
+		return fmt.Errorf("Failed to load policies: %w", err)
+	}
+	authenticator := auth.NewAuthenticator(cfg.AuthCfg, database)
+
+	var replica *replication.Follower
+	if cfg.ReplicationCfg.PrimaryUrl != "" {
+		replica, err = replication.NewFollower(ctx, database, narInfos, scanner, cfg.BinaryCacheCfg.PublicKey, cfg.ReplicationCfg)
+		if err != nil {
+			return fmt.Errorf("Failed to setup replication: %w", err)
+		}
+		go replica.Run(ctx)
+	}
+
 	meshix := Meshix{
-		db: db.NewDatabase(dbPool),
+		db:         database,
+		cacheCfg:   cfg.BinaryCacheCfg,
+		narInfos:   narInfos,
+		scanner:    scanner,
+		policies:   policies,
+		authCfg:    cfg.AuthCfg,
+		staleAfter: cfg.MachineStaleAfter,
+		rollouts:   rollouts,
+		drifts:     drifts,
+		peerSite:   cfg.PeerSiteLabel,
+		replica:    replica,
+		cluster:    nodes,
+		webhooks:   hooks,
 	}
 
 	interceptors := []grpc.UnaryServerInterceptor{}
@@ -112,10 +185,15 @@ func run(ctx context.Context, args []string) error {
 	}
 
 	interceptors = append(interceptors, recovery.UnaryServerInterceptor(recovery.WithRecoveryHandler(grpcPanicRecoveryHandler)))
+	interceptors = append(interceptors, authenticator.UnaryServerInterceptor())
 
 	opts := []grpc.ServerOption{
 		grpc.StatsHandler(otelgrpc.NewServerHandler()),
 		grpc.ChainUnaryInterceptor(interceptors...),
+		grpc.ChainStreamInterceptor(
+			recovery.StreamServerInterceptor(recovery.WithRecoveryHandler(grpcPanicRecoveryHandler)),
+			authenticator.StreamServerInterceptor(),
+		),
 	}
 	grpcServer := grpc.NewServer(opts...)
 	reflection.Register(grpcServer)
@@ -123,18 +201,25 @@ func run(ctx context.Context, args []string) error {
 
 	mux := mux.NewRouter()
 	mux.HandleFunc("/cache/nix-cache-info", handlers.HandleNixCacheInfo)
-	mux.Handle("/cache/nar/{hash}.nar.{compression}", handlers.HandlenNar(minioClient, cfg.MinioCfg))
-	mux.Handle("/cache/{hash}.narinfo", handlers.HandleNarInfo(minioClient, cfg.BinaryCacheCfg, cfg.MinioCfg))
+	mux.Handle("/cache/nar/{hash}.nar.{compression}", replica.Middleware(handlers.HandlenNar(narInfos)))
+	mux.Handle("/cache/delta/{hash}.nar.zst", handlers.HandleDeltaNar(narInfos, cfg.DeltaCfg))
+	mux.Handle("/cache/{hash}.narinfo", replica.Middleware(handlers.HandleNarInfo(narInfos, cfg.BinaryCacheCfg, policies, database)))
+	mux.Handle("/api/sbom", handlers.HandleSbom(meshix.db, narInfos))
+	if nodes != nil {
+		mux.Handle("/cluster/objects/{key}", nodes.HandleObject())
+	}
 	mux.Handle("/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusNotFound)
 	}))
 
+	httpHandler := authenticator.Middleware(mux)
+
 	slog.InfoContext(ctx, "Starting server", "addr", "0.0.0.0:8088")
 	muxer := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.ProtoMajor == 2 && strings.Contains(r.Header.Get("Content-Type"), "application/grpc") {
 			grpcServer.ServeHTTP(w, r)
 		} else {
-			mux.ServeHTTP(w, r)
+			httpHandler.ServeHTTP(w, r)
 		}
 	})
 
@@ -172,25 +257,38 @@ func InterceptorLogger(l *slog.Logger) logging.Logger {
 
 type Meshix struct {
 	meshixv1.UnsafeMeshixServiceServer
-	db db.Database
+	db       db.Database
+	cacheCfg config.BinaryCacheCfg
+	narInfos closure.NarInfoGetter
+	scanner  *vulns.Scanner
+	policies *policy.Engine
+	authCfg  config.AuthCfg
+	// Machines without heartbeat for the duration are stale
+	staleAfter time.Duration
+	rollouts   *rollout.Controller
+	drifts     *fleet.DriftDetector
+	// Label of machines grouping peers which share store paths
+	peerSite string
+	// Follower of the primary, nil on primary
+	replica *replication.Follower
+	// Nodes sharding the binary cache, nil when not in cluster mode
+	cluster *cluster.Cluster
+	// Webhooks notified about changes of the outbox
+	webhooks *webhooks.Dispatcher
 }
 
 // ListPackages implements meshixv1.MeshixServiceServer.
 func (m *Meshix) ListPackages(ctx context.Context, req *meshixv1.ListPackagesRequest) (*meshixv1.ListPackagesResponse, error) {
-	packages, err := m.db.ListPackages(ctx)
+	packages, err := m.db.ListPackages(ctx, domain.PackageFilter{
+		IncludeYanked: req.IncludeYanked,
+		System:        req.System,
+	})
 	if err != nil {
 		return nil, err
 	}
 	mappedPackages := []*meshixv1.Package{}
 	for _, p := range packages {
-		mappedPackages = append(mappedPackages, &meshixv1.Package{
-			Name:    p.Name,
-			Version: p.Version,
-			NixMetadata: &meshixv1.NixMetadata{
-				StorePath: p.NixMetadata.StorePath,
-				MainBin:   p.NixMetadata.MainBin,
-			},
-		})
+		mappedPackages = append(mappedPackages, mapPackage(p))
 	}
 
 	return &meshixv1.ListPackagesResponse{
@@ -198,45 +296,740 @@ func (m *Meshix) ListPackages(ctx context.Context, req *meshixv1.ListPackagesReq
 	}, nil
 }
 
+// GetPackage implements meshixv1.MeshixServiceServer.
+func (m *Meshix) GetPackage(ctx context.Context, req *meshixv1.GetPackageRequest) (*meshixv1.GetPackageResponse, error) {
+	if req.Name == "" {
+		return nil, status.Error(codes.InvalidArgument, "Package name is required")
+	}
+	pkg, err := m.db.GetPackage(ctx, domain.PackageRef{
+		Name:    req.Name,
+		Version: req.Version,
+		System:  req.System,
+	})
+	if err != nil {
+		return nil, mapDbError(err)
+	}
+	storePath, ok := pkg.NixMetadata.OutputPath(req.Output)
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "Package %s-%s has no output %s", pkg.Name, pkg.Version, req.Output)
+	}
+
+	return &meshixv1.GetPackageResponse{
+		Package:         mapPackage(pkg),
+		StorePath:       storePath,
+		Vulnerabilities: mapVulnerabilities(pkg.Vulnerabilities),
+	}, nil
+}
+
+// YankPackage implements meshixv1.MeshixServiceServer.
+func (m *Meshix) YankPackage(ctx context.Context, req *meshixv1.YankPackageRequest) (*meshixv1.YankPackageResponse, error) {
+	err := auth.RequireGroup(ctx, m.authCfg.AdminGroup)
+	if err != nil {
+		return nil, err
+	}
+	if req.Name == "" || req.Version == "" {
+		return nil, status.Error(codes.InvalidArgument, "Package name and version are required")
+	}
+	err = m.requireWritable()
+	if err != nil {
+		return nil, err
+	}
+	ref := domain.PackageRef{
+		Name:    req.Name,
+		Version: req.Version,
+		System:  req.System,
+	}
+	err = m.db.YankPackage(ctx, ref, req.Reason)
+	if err != nil {
+		return nil, mapDbError(err)
+	}
+
+	return &meshixv1.YankPackageResponse{}, nil
+}
+
+// DeletePackage implements meshixv1.MeshixServiceServer.
+func (m *Meshix) DeletePackage(ctx context.Context, req *meshixv1.DeletePackageRequest) (*meshixv1.DeletePackageResponse, error) {
+	err := auth.RequireGroup(ctx, m.authCfg.AdminGroup)
+	if err != nil {
+		return nil, err
+	}
+	if req.Name == "" || req.Version == "" {
+		return nil, status.Error(codes.InvalidArgument, "Package name and version are required")
+	}
+	err = m.requireWritable()
+	if err != nil {
+		return nil, err
+	}
+	ref := domain.PackageRef{
+		Name:    req.Name,
+		Version: req.Version,
+		System:  req.System,
+	}
+	err = m.db.DeletePackage(ctx, ref, req.DropGcRoot)
+	if err != nil {
+		return nil, mapDbError(err)
+	}
+
+	return &meshixv1.DeletePackageResponse{}, nil
+}
+
 // PushPackage implements meshixv1.MeshixServiceServer.
 func (m *Meshix) PushPackage(ctx context.Context, req *meshixv1.PushPackageRequest) (*meshixv1.PushPackageResponse, error) {
-	err := m.db.PutPackage(ctx, domain.NewPackage{
+	err := m.requireWritable()
+	if err != nil {
+		return nil, err
+	}
+	pkg := domain.NewPackage{
 		Name:    req.Package.Name,
 		Version: req.Package.Version,
+		System:  req.Package.System,
+		Kind:    domain.PackageKindApp,
 		NixMetadata: domain.NixMetadata{
-			StorePath: req.Package.NixMetadata.StorePath,
-			MainBin:   req.Package.NixMetadata.MainBin,
+			StorePath:        req.Package.NixMetadata.StorePath,
+			MainBin:          req.Package.NixMetadata.MainBin,
+			Outputs:          req.Package.NixMetadata.Outputs,
+			OutputsToInstall: req.Package.NixMetadata.OutputsToInstall,
+			Description:      req.Package.NixMetadata.Description,
+			Licenses:         req.Package.NixMetadata.Licenses,
+			Homepage:         req.Package.NixMetadata.Homepage,
+			Platforms:        req.Package.NixMetadata.Platforms,
+			Unfree:           req.Package.NixMetadata.Unfree,
+			Insecure:         req.Package.NixMetadata.Insecure,
+			Position:         req.Package.NixMetadata.Position,
 		},
+	}
+	if req.Package.Kind == meshixv1.PackageKind_PACKAGE_KIND_SYSTEM {
+		pkg.Kind = domain.PackageKindSystem
+	}
+	if req.Provenance != nil {
+		pkg.Provenance = &domain.Provenance{
+			FlakeUrl:      req.Provenance.FlakeUrl,
+			FlakeRevision: req.Provenance.FlakeRevision,
+			FlakeNarHash:  req.Provenance.FlakeNarHash,
+			DrvPath:       req.Provenance.DrvPath,
+			BuilderHost:   req.Provenance.BuilderHost,
+			ClientVersion: req.Provenance.ClientVersion,
+			Ci:            req.Provenance.Ci,
+		}
+	}
+
+	err = m.policies.Evaluate(ctx, policy.Input{
+		Action: policy.ActionPushPackage,
+		Caller: auth.FromContext(ctx),
+		Pkg:    &pkg,
 	})
 	if err != nil {
+		var violation *policy.Violation
+		if errors.As(err, &violation) {
+			return nil, status.Error(codes.PermissionDenied, violation.Error())
+		}
 		return nil, err
 	}
 
+	if pkg.Provenance != nil {
+		sig, err := provenance.Sign(m.cacheCfg.PrivateKey, pkg.ProvenanceFields())
+		if err != nil {
+			return nil, err
+		}
+		pkg.Provenance.Signature = sig
+	}
+
+	err = m.db.PutPackage(ctx, pkg)
+	if err != nil {
+		return nil, err
+	}
+	m.scanner.ScanPackage(domain.PackageRef{
+		Name:    pkg.Name,
+		Version: pkg.Version,
+		System:  pkg.System,
+	})
+
 	return &meshixv1.PushPackageResponse{}, nil
 }
 
-var _ (meshixv1.MeshixServiceServer) = (*Meshix)(nil)
+// GetPackageSbom implements meshixv1.MeshixServiceServer.
+func (m *Meshix) GetPackageSbom(ctx context.Context, req *meshixv1.GetPackageSbomRequest) (*meshixv1.GetPackageSbomResponse, error) {
+	if req.Name == "" {
+		return nil, status.Error(codes.InvalidArgument, "Package name is required")
+	}
+	format := sbom.FormatCycloneDX
+	if req.Format == meshixv1.SbomFormat_SBOM_FORMAT_SPDX {
+		format = sbom.FormatSPDX
+	}
 
-func setupDB() (*sql.DB, error) {
-	conn, err := sql.Open("sqlite", "./data")
+	pkg, err := m.db.GetPackage(ctx, domain.PackageRef{
+		Name:    req.Name,
+		Version: req.Version,
+		System:  req.System,
+	})
 	if err != nil {
-		return nil, fmt.Errorf("Failed to open sqlite db: %w", err)
+		return nil, mapDbError(err)
 	}
-	conn.SetMaxOpenConns(1)
 
-	g, err := goose.NewProvider("sqlite3", conn, os.DirFS("./migrations"))
+	doc, err := closure.SbomDocument(ctx, m.narInfos, pkg)
+	if err != nil {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	document, contentType, err := sbom.Render(doc, format)
 	if err != nil {
 		return nil, err
 	}
 
-	if _, err := g.Up(context.Background()); err != nil {
-		return nil, fmt.Errorf("Failed to run goose db migrations: %w", err)
+	return &meshixv1.GetPackageSbomResponse{
+		Document:    document,
+		ContentType: contentType,
+	}, nil
+}
+
+// UploadAdvisories implements meshixv1.MeshixServiceServer.
+func (m *Meshix) UploadAdvisories(ctx context.Context, req *meshixv1.UploadAdvisoriesRequest) (*meshixv1.UploadAdvisoriesResponse, error) {
+	err := auth.RequireGroup(ctx, m.authCfg.AdminGroup)
+	if err != nil {
+		return nil, err
+	}
+	err = m.requireWritable()
+	if err != nil {
+		return nil, err
+	}
+	imported, err := m.scanner.ImportAdvisories(ctx, req.Advisories)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
 
-	err = conn.Ping()
+	return &meshixv1.UploadAdvisoriesResponse{
+		Imported: int32(imported),
+	}, nil
+}
+
+// ListVulnerablePackages implements meshixv1.MeshixServiceServer.
+func (m *Meshix) ListVulnerablePackages(ctx context.Context, req *meshixv1.ListVulnerablePackagesRequest) (*meshixv1.ListVulnerablePackagesResponse, error) {
+	packages, err := m.db.ListVulnerablePackages(ctx, req.System)
 	if err != nil {
 		return nil, err
 	}
 
-	return conn, nil
+	vulnerablePackages := []*meshixv1.VulnerablePackage{}
+	for _, p := range packages {
+		vulnerablePackages = append(vulnerablePackages, &meshixv1.VulnerablePackage{
+			Package:         mapPackage(p),
+			Vulnerabilities: mapVulnerabilities(p.Vulnerabilities),
+		})
+	}
+
+	return &meshixv1.ListVulnerablePackagesResponse{
+		Packages: vulnerablePackages,
+	}, nil
+}
+
+// CreateBootstrapToken implements meshixv1.MeshixServiceServer.
+func (m *Meshix) CreateBootstrapToken(ctx context.Context, req *meshixv1.CreateBootstrapTokenRequest) (*meshixv1.CreateBootstrapTokenResponse, error) {
+	err := auth.RequireGroup(ctx, m.authCfg.AdminGroup)
+	if err != nil {
+		return nil, err
+	}
+	ttl := defaultBootstrapTokenTtl
+	if req.TtlSeconds > 0 {
+		ttl = time.Duration(req.TtlSeconds) * time.Second
+	}
+
+	token, err := auth.NewBootstrapToken()
+	if err != nil {
+		return nil, err
+	}
+	bootstrapToken := domain.BootstrapToken{
+		CreatedBy: auth.FromContext(ctx).Subject,
+		Groups:    req.Groups,
+		Labels:    req.Labels,
+		ExpiresAt: time.Now().Add(ttl),
+	}
+	err = m.db.PutBootstrapToken(ctx, auth.HashToken(token), bootstrapToken)
+	if err != nil {
+		return nil, err
+	}
+
+	return &meshixv1.CreateBootstrapTokenResponse{
+		Token:     token,
+		ExpiresAt: timestamppb.New(bootstrapToken.ExpiresAt),
+	}, nil
+}
+
+// RegisterMachine implements meshixv1.MeshixServiceServer.
+func (m *Meshix) RegisterMachine(ctx context.Context, req *meshixv1.RegisterMachineRequest) (*meshixv1.RegisterMachineResponse, error) {
+	if req.Name == "" || req.System == "" {
+		return nil, status.Error(codes.InvalidArgument, "Machine name and system are required")
+	}
+	if len(req.PublicKey) != ed25519.PublicKeySize {
+		return nil, status.Error(codes.InvalidArgument, "Public key has to be ed25519 public key")
+	}
+
+	machine, err := m.db.RegisterMachine(ctx, auth.HashToken(req.BootstrapToken), domain.NewMachine{
+		Name:      req.Name,
+		System:    req.System,
+		PublicKey: req.PublicKey,
+		Labels:    req.Labels,
+	})
+	if err != nil {
+		return nil, mapDbError(err)
+	}
+	slog.InfoContext(ctx, "Machine enrolled", "machine", machine.Name, "id", machine.ID)
+
+	return &meshixv1.RegisterMachineResponse{
+		Machine: m.mapMachine(machine),
+	}, nil
+}
+
+// ListMachines implements meshixv1.MeshixServiceServer.
+func (m *Meshix) ListMachines(ctx context.Context, req *meshixv1.ListMachinesRequest) (*meshixv1.ListMachinesResponse, error) {
+	machines, err := m.db.ListMachines(ctx, domain.MachineFilter{
+		Group:  req.Group,
+		Labels: req.Labels,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	mappedMachines := []*meshixv1.Machine{}
+	for _, machine := range machines {
+		if req.StaleOnly && !machine.Stale(now, m.staleAfter) {
+			continue
+		}
+		if req.DriftedOnly && (machine.Drift == nil || !machine.Drift.Drifted()) {
+			continue
+		}
+		mappedMachines = append(mappedMachines, m.mapMachine(machine))
+	}
+
+	return &meshixv1.ListMachinesResponse{
+		Machines: mappedMachines,
+	}, nil
+}
+
+// GetMachine implements meshixv1.MeshixServiceServer.
+func (m *Meshix) GetMachine(ctx context.Context, req *meshixv1.GetMachineRequest) (*meshixv1.GetMachineResponse, error) {
+	machine, err := m.findMachine(ctx, req.Id, req.Name)
+	if err != nil {
+		return nil, err
+	}
+	resp := &meshixv1.GetMachineResponse{
+		Machine: m.mapMachine(machine),
+	}
+
+	activation, err := m.db.GetLatestActivation(ctx, machine.ID)
+	if err != nil && !errors.Is(err, db.ErrNotFound) {
+		return nil, err
+	}
+	if err == nil {
+		resp.LastActivation = &meshixv1.Activation{
+			StorePaths: activation.StorePaths,
+			Success:    activation.Success,
+			Error:      activation.Error,
+			ReportedAt: timestamppb.New(activation.ReportedAt),
+			RolledBack: activation.RolledBack,
+			Logs:       activation.Logs,
+		}
+	}
+
+	return resp, nil
+}
+
+// GetMachineDrift implements meshixv1.MeshixServiceServer.
+func (m *Meshix) GetMachineDrift(ctx context.Context, req *meshixv1.GetMachineDriftRequest) (*meshixv1.GetMachineDriftResponse, error) {
+	machine, err := m.findMachine(ctx, req.Id, req.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	drift, err := m.drifts.Check(ctx, machine)
+	if err != nil {
+		return nil, err
+	}
+	resp := &meshixv1.GetMachineDriftResponse{}
+	if drift != nil {
+		resp.Drift = &meshixv1.MachineDrift{
+			MachineName:          machine.Name,
+			Drifted:              drift.Drifted(),
+			MissingStorePaths:    drift.Missing,
+			UnexpectedStorePaths: drift.Unexpected,
+			DesiredSystem:        drift.DesiredSystem,
+			ActualSystem:         drift.ActualSystem,
+			Error:                drift.Error,
+			CheckedAt:            timestamppb.New(drift.CheckedAt),
+		}
+		if drift.Since != nil {
+			resp.Drift.Since = timestamppb.New(*drift.Since)
+		}
+	}
+
+	return resp, nil
+}
+
+func (m *Meshix) findMachine(ctx context.Context, id int64, name string) (domain.Machine, error) {
+	var machine domain.Machine
+	var err error
+	switch {
+	case id != 0:
+		machine, err = m.db.GetMachine(ctx, id)
+	case name != "":
+		machine, err = m.db.GetMachineByName(ctx, name)
+	default:
+		return domain.Machine{}, status.Error(codes.InvalidArgument, "Machine id or name is required")
+	}
+	if err != nil {
+		return domain.Machine{}, mapDbError(err)
+	}
+
+	return machine, nil
+}
+
+// AssignPackage implements meshixv1.MeshixServiceServer.
+func (m *Meshix) AssignPackage(ctx context.Context, req *meshixv1.AssignPackageRequest) (*meshixv1.AssignPackageResponse, error) {
+	err := auth.RequireGroup(ctx, m.authCfg.AdminGroup)
+	if err != nil {
+		return nil, err
+	}
+	target, err := assignmentTarget(req.Machine, req.Group)
+	if err != nil {
+		return nil, err
+	}
+	if req.PackageName == "" {
+		return nil, status.Error(codes.InvalidArgument, "Package name is required")
+	}
+	pkg, err := m.db.GetPackage(ctx, domain.PackageRef{
+		Name:    req.PackageName,
+		Version: req.PackageVersion,
+	})
+	if err != nil && !errors.Is(err, db.ErrNotFound) {
+		return nil, err
+	}
+	if err == nil && pkg.Kind == domain.PackageKindSystem && target.Machine == "" {
+		return nil, status.Error(codes.InvalidArgument, "System packages can be assigned only to machines")
+	}
+	err = m.requireWritable()
+	if err != nil {
+		return nil, err
+	}
+
+	assignment := domain.Assignment{
+		Target:         target,
+		PackageName:    req.PackageName,
+		PackageVersion: req.PackageVersion,
+		Mode:           mapActivationModeReq(req.Mode),
+		Emergency:      req.Emergency,
+	}
+	err = m.db.PutAssignment(ctx, assignment)
+	if err != nil {
+		return nil, err
+	}
+
+	return &meshixv1.AssignPackageResponse{}, nil
+}
+
+// UnassignPackage implements meshixv1.MeshixServiceServer.
+func (m *Meshix) UnassignPackage(ctx context.Context, req *meshixv1.UnassignPackageRequest) (*meshixv1.UnassignPackageResponse, error) {
+	err := auth.RequireGroup(ctx, m.authCfg.AdminGroup)
+	if err != nil {
+		return nil, err
+	}
+	target, err := assignmentTarget(req.Machine, req.Group)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.requireWritable()
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.db.DeleteAssignment(ctx, target, req.PackageName)
+	if err != nil {
+		return nil, mapDbError(err)
+	}
+
+	return &meshixv1.UnassignPackageResponse{}, nil
+}
+
+// ListAssignments implements meshixv1.MeshixServiceServer.
+func (m *Meshix) ListAssignments(ctx context.Context, req *meshixv1.ListAssignmentsRequest) (*meshixv1.ListAssignmentsResponse, error) {
+	assignments, err := m.db.ListAssignments(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	mappedAssignments := []*meshixv1.Assignment{}
+	for _, a := range assignments {
+		mappedAssignments = append(mappedAssignments, &meshixv1.Assignment{
+			Id:             a.ID,
+			Machine:        a.Target.Machine,
+			Group:          a.Target.Group,
+			PackageName:    a.PackageName,
+			PackageVersion: a.PackageVersion,
+			Mode:           activationModes[a.Mode],
+			Emergency:      a.Emergency,
+		})
+	}
+
+	return &meshixv1.ListAssignmentsResponse{
+		Assignments: mappedAssignments,
+	}, nil
+}
+
+// GetDesiredState implements meshixv1.MeshixServiceServer.
+func (m *Meshix) GetDesiredState(ctx context.Context, req *meshixv1.GetDesiredStateRequest) (*meshixv1.GetDesiredStateResponse, error) {
+	machineId, err := auth.RequireMachine(ctx)
+	if err != nil {
+		return nil, err
+	}
+	machine, err := m.db.GetMachine(ctx, machineId)
+	if err != nil {
+		return nil, mapDbError(err)
+	}
+
+	desired, err := fleet.DesiredState(ctx, m.db, machine)
+	if err != nil {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+
+	packages := []*meshixv1.DesiredPackage{}
+	for _, d := range desired {
+		packages = append(packages, &meshixv1.DesiredPackage{
+			Package:    mapPackage(d.Package),
+			StorePaths: d.StorePaths,
+			Mode:       activationModes[d.Mode],
+			Emergency:  d.Emergency,
+		})
+	}
+	staged, err := fleet.StagedState(ctx, m.db, machine)
+	if err != nil {
+		return nil, err
+	}
+	stagedPackages := []*meshixv1.DesiredPackage{}
+	for _, s := range staged {
+		stagedPackages = append(stagedPackages, &meshixv1.DesiredPackage{
+			Package:    mapPackage(s.Package),
+			StorePaths: s.StorePaths,
+			Mode:       activationModes[s.Mode],
+		})
+	}
+	open, next, err := fleet.InMaintenance(ctx, m.db, machine, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	resp := &meshixv1.GetDesiredStateResponse{
+		Packages:            packages,
+		InMaintenanceWindow: open,
+		Staged:              stagedPackages,
+	}
+	if !next.IsZero() {
+		resp.NextMaintenanceWindow = timestamppb.New(next)
+	}
+
+	return resp, nil
+}
+
+// ReportActivation implements meshixv1.MeshixServiceServer.
+func (m *Meshix) ReportActivation(ctx context.Context, req *meshixv1.ReportActivationRequest) (*meshixv1.ReportActivationResponse, error) {
+	machineId, err := auth.RequireMachine(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	activation := domain.Activation{
+		MachineID:  machineId,
+		StorePaths: req.StorePaths,
+		Success:    req.Success,
+		Error:      req.Error,
+		RolledBack: req.RolledBack,
+		Logs:       req.Logs,
+		ReportedAt: time.Now(),
+	}
+	if !req.Success {
+		slog.WarnContext(ctx, "Machine failed to activate", "machineId", machineId, "err", req.Error, "rolledBack", req.RolledBack)
+	}
+	if req.RolledBack {
+		machine, err := m.db.GetMachine(ctx, machineId)
+		if err != nil {
+			return nil, err
+		}
+		_, err = fleet.RecordFailure(ctx, m.db, machine, activation)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = m.db.PutActivation(ctx, activation)
+	if err != nil {
+		return nil, err
+	}
+
+	return &meshixv1.ReportActivationResponse{}, nil
+}
+
+// ReportStatus implements meshixv1.MeshixServiceServer.
+func (m *Meshix) ReportStatus(ctx context.Context, req *meshixv1.ReportStatusRequest) (*meshixv1.ReportStatusResponse, error) {
+	machineId, err := auth.RequireMachine(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.db.PutMachineStatus(ctx, machineId, domain.MachineStatus{
+		ProfileGeneration: req.ProfileGeneration,
+		StorePaths:        req.StorePaths,
+		NixVersion:        req.NixVersion,
+		FreeDiskBytes:     req.FreeDiskBytes,
+		LastError:         req.LastError,
+		RunningSystem:     req.RunningSystem,
+		BootedSystem:      req.BootedSystem,
+		StagedStorePaths:  req.StagedStorePaths,
+		PeerUrl:           req.PeerUrl,
+		ReportedAt:        time.Now(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	machine, err := m.db.GetMachine(ctx, machineId)
+	if err != nil {
+		return nil, err
+	}
+	_, err = m.drifts.Check(ctx, machine)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to check drift of machine", "machine", machine.Name, "err", err)
+	}
+
+	return &meshixv1.ReportStatusResponse{}, nil
+}
+
+var _ (meshixv1.MeshixServiceServer) = (*Meshix)(nil)
+
+func mapPackage(p domain.Package) *meshixv1.Package {
+	pkg := &meshixv1.Package{
+		Name:    p.Name,
+		Version: p.Version,
+		System:  p.System,
+		Kind:    packageKinds[p.Kind],
+		NixMetadata: &meshixv1.NixMetadata{
+			StorePath:        p.NixMetadata.StorePath,
+			MainBin:          p.NixMetadata.MainBin,
+			Outputs:          p.NixMetadata.Outputs,
+			OutputsToInstall: p.NixMetadata.OutputsToInstall,
+			Description:      p.NixMetadata.Description,
+			Licenses:         p.NixMetadata.Licenses,
+			Homepage:         p.NixMetadata.Homepage,
+			Platforms:        p.NixMetadata.Platforms,
+			Unfree:           p.NixMetadata.Unfree,
+			Insecure:         p.NixMetadata.Insecure,
+			Position:         p.NixMetadata.Position,
+		},
+	}
+	if p.Yank != nil {
+		pkg.Yanked = true
+		pkg.YankReason = p.Yank.Reason
+	}
+	if p.Provenance != nil {
+		pkg.Provenance = &meshixv1.Provenance{
+			FlakeUrl:      p.Provenance.FlakeUrl,
+			FlakeRevision: p.Provenance.FlakeRevision,
+			FlakeNarHash:  p.Provenance.FlakeNarHash,
+			DrvPath:       p.Provenance.DrvPath,
+			BuilderHost:   p.Provenance.BuilderHost,
+			ClientVersion: p.Provenance.ClientVersion,
+			Ci:            p.Provenance.Ci,
+			Signature:     p.Provenance.Signature,
+		}
+	}
+
+	return pkg
+}
+
+func mapVulnerabilities(vulnerabilities []domain.Vulnerability) []*meshixv1.Vulnerability {
+	mapped := []*meshixv1.Vulnerability{}
+	for _, v := range vulnerabilities {
+		mapped = append(mapped, &meshixv1.Vulnerability{
+			AdvisoryId:       v.AdvisoryID,
+			Aliases:          v.Aliases,
+			Summary:          v.Summary,
+			Severity:         v.Severity,
+			StorePath:        v.StorePath,
+			ComponentName:    v.ComponentName,
+			ComponentVersion: v.ComponentVersion,
+		})
+	}
+
+	return mapped
+}
+
+var packageKinds = map[domain.PackageKind]meshixv1.PackageKind{
+	domain.PackageKindApp:    meshixv1.PackageKind_PACKAGE_KIND_APP,
+	domain.PackageKindSystem: meshixv1.PackageKind_PACKAGE_KIND_SYSTEM,
+}
+
+var activationModes = map[domain.ActivationMode]meshixv1.ActivationMode{
+	domain.ActivationSwitch: meshixv1.ActivationMode_ACTIVATION_MODE_SWITCH,
+	domain.ActivationBoot:   meshixv1.ActivationMode_ACTIVATION_MODE_BOOT,
+	domain.ActivationTest:   meshixv1.ActivationMode_ACTIVATION_MODE_TEST,
+}
+
+// mapActivationModeReq maps mode from request, unspecified mode is switch.
+func mapActivationModeReq(mode meshixv1.ActivationMode) domain.ActivationMode {
+	for m, mapped := range activationModes {
+		if mapped == mode {
+			return m
+		}
+	}
+
+	return domain.ActivationSwitch
+}
+
+func assignmentTarget(machine, group string) (domain.AssignmentTarget, error) {
+	if (machine == "") == (group == "") {
+		return domain.AssignmentTarget{}, status.Error(codes.InvalidArgument, "Exactly one of machine and group has to be set")
+	}
+
+	return domain.AssignmentTarget{
+		Machine: machine,
+		Group:   group,
+	}, nil
+}
+
+func (m *Meshix) mapMachine(machine domain.Machine) *meshixv1.Machine {
+	mapped := &meshixv1.Machine{
+		Id:         machine.ID,
+		Name:       machine.Name,
+		System:     machine.System,
+		PublicKey:  machine.PublicKey,
+		Labels:     machine.Labels,
+		Groups:     machine.Groups,
+		EnrolledAt: timestamppb.New(machine.EnrolledAt),
+		Stale:      machine.Stale(time.Now(), m.staleAfter),
+		Drifted:    machine.Drift != nil && machine.Drift.Drifted(),
+	}
+	if machine.Status != nil {
+		mapped.Status = &meshixv1.MachineStatus{
+			ProfileGeneration: machine.Status.ProfileGeneration,
+			StorePaths:        machine.Status.StorePaths,
+			NixVersion:        machine.Status.NixVersion,
+			FreeDiskBytes:     machine.Status.FreeDiskBytes,
+			LastError:         machine.Status.LastError,
+			ReportedAt:        timestamppb.New(machine.Status.ReportedAt),
+			RunningSystem:     machine.Status.RunningSystem,
+			BootedSystem:      machine.Status.BootedSystem,
+			StagedStorePaths:  machine.Status.StagedStorePaths,
+			PeerUrl:           machine.Status.PeerUrl,
+		}
+	}
+
+	return mapped
+}
+
+func mapDbError(err error) error {
+	if errors.Is(err, db.ErrNotFound) {
+		return status.Error(codes.NotFound, err.Error())
+	}
+	if errors.Is(err, db.ErrAlreadyExists) {
+		return status.Error(codes.AlreadyExists, err.Error())
+	}
+	if errors.Is(err, db.ErrInvalidToken) {
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+
+	return err
 }
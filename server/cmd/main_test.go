@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	meshixv1 "gen/proto/meshix/v1"
+	"os"
+	"path/filepath"
+	"server/internal/auth"
+	"server/internal/config"
+	"server/internal/db"
+	"server/internal/domain"
+	"server/internal/vulns"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func newTestMeshix(t *testing.T) *Meshix {
+	t.Helper()
+	ctx := context.Background()
+	database, err := db.Open(ctx, filepath.Join(t.TempDir(), "meshix.db"), os.DirFS("../migrations"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = database.PutPackage(ctx, domain.NewPackage{
+		Name:        "app",
+		Version:     "1",
+		System:      "x86_64-linux",
+		NixMetadata: domain.NixMetadata{StorePath: "/nix/store/aaaa-app-1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &Meshix{
+		db:      database,
+		scanner: vulns.NewScanner(database, nil),
+		authCfg: config.AuthCfg{AdminGroup: "admin"},
+	}
+}
+
+// advisory marks every version of package app as vulnerable.
+var advisory = []byte(`{
+	"id": "TEST-1",
+	"affected": [{"package": {"name": "app"}, "ranges": [{"type": "ECOSYSTEM", "events": [{"introduced": "0"}]}]}]
+}`)
+
+func TestMutationsRequireAdmin(t *testing.T) {
+	m := newTestMeshix(t)
+	mutations := []struct {
+		name string
+		call func(ctx context.Context) error
+	}{
+		{"YankPackage", func(ctx context.Context) error {
+			_, err := m.YankPackage(ctx, &meshixv1.YankPackageRequest{Name: "app", Version: "1", Reason: "broken"})
+			return err
+		}},
+		{"UploadAdvisories", func(ctx context.Context) error {
+			_, err := m.UploadAdvisories(ctx, &meshixv1.UploadAdvisoriesRequest{Advisories: [][]byte{advisory}})
+			return err
+		}},
+		{"DeletePackage", func(ctx context.Context) error {
+			_, err := m.DeletePackage(ctx, &meshixv1.DeletePackageRequest{Name: "app", Version: "1", DropGcRoot: true})
+			return err
+		}},
+	}
+	callers := []struct {
+		name     string
+		identity auth.Identity
+	}{
+		{"anonymous", auth.Anonymous},
+		{"non-admin token", auth.Identity{Subject: "ci", Groups: []string{"ci"}}},
+	}
+
+	for _, mutation := range mutations {
+		for _, caller := range callers {
+			err := mutation.call(auth.WithIdentity(context.Background(), caller.identity))
+			if status.Code(err) != codes.PermissionDenied {
+				t.Errorf("%s by %s: expected PermissionDenied, got %v", mutation.name, caller.name, err)
+			}
+		}
+	}
+	_, err := m.db.GetPackage(context.Background(), domain.PackageRef{Name: "app", Version: "1"})
+	if err != nil {
+		t.Fatalf("Package was changed by rejected callers: %v", err)
+	}
+	advisories, err := m.db.ListAdvisories(context.Background(), []string{"app"})
+	if err != nil || len(advisories) != 0 {
+		t.Fatalf("Advisories were imported by rejected callers: %v, %v", advisories, err)
+	}
+
+	admin := auth.WithIdentity(context.Background(), auth.Identity{Subject: "admin", Groups: []string{"admin"}})
+	for _, mutation := range mutations {
+		err := mutation.call(admin)
+		if err != nil {
+			t.Errorf("%s by admin: %v", mutation.name, err)
+		}
+	}
+}
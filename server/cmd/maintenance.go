@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	meshixv1 "gen/proto/meshix/v1"
+	"log/slog"
+	"server/internal/auth"
+	"server/internal/domain"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// CreateMaintenanceWindow implements meshixv1.MeshixServiceServer.
+func (m *Meshix) CreateMaintenanceWindow(ctx context.Context, req *meshixv1.CreateMaintenanceWindowRequest) (*meshixv1.CreateMaintenanceWindowResponse, error) {
+	err := auth.RequireGroup(ctx, m.authCfg.AdminGroup)
+	if err != nil {
+		return nil, err
+	}
+	if req.Window == nil {
+		return nil, status.Error(codes.InvalidArgument, "Maintenance window is required")
+	}
+	window, err := mapMaintenanceWindowReq(req.Window)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	err = window.Validate()
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	window.ID, err = m.db.PutMaintenanceWindow(ctx, window)
+	if err != nil {
+		return nil, err
+	}
+	slog.InfoContext(ctx, "Maintenance window created", "id", window.ID, "group", window.Group, "by", auth.FromContext(ctx).Subject)
+
+	return &meshixv1.CreateMaintenanceWindowResponse{
+		Window: mapMaintenanceWindow(window),
+	}, nil
+}
+
+// ListMaintenanceWindows implements meshixv1.MeshixServiceServer.
+func (m *Meshix) ListMaintenanceWindows(ctx context.Context, req *meshixv1.ListMaintenanceWindowsRequest) (*meshixv1.ListMaintenanceWindowsResponse, error) {
+	windows, err := m.db.ListMaintenanceWindows(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	mapped := []*meshixv1.MaintenanceWindow{}
+	for _, w := range windows {
+		if req.Group != "" && w.Group != req.Group {
+			continue
+		}
+		mapped = append(mapped, mapMaintenanceWindow(w))
+	}
+
+	return &meshixv1.ListMaintenanceWindowsResponse{
+		Windows: mapped,
+	}, nil
+}
+
+// DeleteMaintenanceWindow implements meshixv1.MeshixServiceServer.
+func (m *Meshix) DeleteMaintenanceWindow(ctx context.Context, req *meshixv1.DeleteMaintenanceWindowRequest) (*meshixv1.DeleteMaintenanceWindowResponse, error) {
+	err := auth.RequireGroup(ctx, m.authCfg.AdminGroup)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.db.DeleteMaintenanceWindow(ctx, req.Id)
+	if err != nil {
+		return nil, mapDbError(err)
+	}
+	slog.InfoContext(ctx, "Maintenance window deleted", "id", req.Id, "by", auth.FromContext(ctx).Subject)
+
+	return &meshixv1.DeleteMaintenanceWindowResponse{}, nil
+}
+
+func mapMaintenanceWindowReq(w *meshixv1.MaintenanceWindow) (domain.MaintenanceWindow, error) {
+	days := []time.Weekday{}
+	for _, d := range w.Days {
+		day, err := domain.ParseWeekday(d)
+		if err != nil {
+			return domain.MaintenanceWindow{}, err
+		}
+		days = append(days, day)
+	}
+	start, err := domain.ParseTimeOfDay(w.Start)
+	if err != nil {
+		return domain.MaintenanceWindow{}, err
+	}
+	end, err := domain.ParseTimeOfDay(w.End)
+	if err != nil {
+		return domain.MaintenanceWindow{}, err
+	}
+
+	return domain.MaintenanceWindow{
+		Group:    w.Group,
+		Days:     days,
+		Start:    start,
+		End:      end,
+		Timezone: w.Timezone,
+	}, nil
+}
+
+func mapMaintenanceWindow(w domain.MaintenanceWindow) *meshixv1.MaintenanceWindow {
+	days := []string{}
+	for _, d := range w.Days {
+		days = append(days, domain.FormatWeekday(d))
+	}
+
+	return &meshixv1.MaintenanceWindow{
+		Id:       w.ID,
+		Group:    w.Group,
+		Days:     days,
+		Start:    domain.FormatTimeOfDay(w.Start),
+		End:      domain.FormatTimeOfDay(w.End),
+		Timezone: w.Timezone,
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	meshixv1 "gen/proto/meshix/v1"
+	"server/internal/auth"
+	"server/internal/domain"
+	"server/internal/outbox"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// ReadEvents implements meshixv1.MeshixServiceServer.
+func (m *Meshix) ReadEvents(req *meshixv1.ReadEventsRequest, stream grpc.ServerStreamingServer[meshixv1.ReadEventsResponse]) error {
+	ctx := stream.Context()
+	err := auth.RequireGroup(ctx, m.authCfg.EventsGroup)
+	if err != nil {
+		return err
+	}
+	kinds := []domain.ChangeKind{}
+	for _, k := range req.Kinds {
+		kinds = append(kinds, domain.ChangeKind(k))
+	}
+
+	err = outbox.Read(ctx, m.db, req.Cursor, kinds, func(c domain.Change) error {
+		return stream.Send(&meshixv1.ReadEventsResponse{
+			Change: &meshixv1.Change{
+				Id:        c.ID,
+				Kind:      string(c.Kind),
+				Subject:   c.Subject,
+				Data:      c.Data,
+				CreatedAt: timestamppb.New(c.CreatedAt),
+			},
+		})
+	})
+	if errors.Is(err, outbox.ErrPruned) {
+		return status.Error(codes.OutOfRange, err.Error())
+	}
+	if ctx.Err() != nil {
+		// Consumer disconnected
+		return nil
+	}
+
+	return err
+}
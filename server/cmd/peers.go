@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	meshixv1 "gen/proto/meshix/v1"
+	"server/internal/auth"
+	"server/internal/fleet"
+)
+
+// FindPeers implements meshixv1.MeshixServiceServer.
+func (m *Meshix) FindPeers(ctx context.Context, req *meshixv1.FindPeersRequest) (*meshixv1.FindPeersResponse, error) {
+	machineId, err := auth.RequireMachine(ctx)
+	if err != nil {
+		return nil, err
+	}
+	machine, err := m.db.GetMachine(ctx, machineId)
+	if err != nil {
+		return nil, mapDbError(err)
+	}
+	peers, err := fleet.FindPeers(ctx, m.db, machine, req.StorePaths, m.peerSite, m.staleAfter)
+	if err != nil {
+		return nil, err
+	}
+
+	mapped := []*meshixv1.Peer{}
+	for _, p := range peers {
+		mapped = append(mapped, &meshixv1.Peer{
+			MachineName: p.Machine.Name,
+			Url:         p.Machine.Status.PeerUrl,
+			StorePaths:  p.StorePaths,
+		})
+	}
+
+	return &meshixv1.FindPeersResponse{
+		Peers: mapped,
+	}, nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	meshixv1 "gen/proto/meshix/v1"
+	"server/internal/auth"
+	"server/internal/outbox"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// GetChanges implements meshixv1.MeshixServiceServer.
+func (m *Meshix) GetChanges(ctx context.Context, req *meshixv1.GetChangesRequest) (*meshixv1.GetChangesResponse, error) {
+	err := auth.RequireGroup(ctx, m.authCfg.ReplicationGroup)
+	if err != nil {
+		return nil, err
+	}
+	limit := outbox.DefaultBatchSize
+	if req.Limit > 0 {
+		limit = min(int(req.Limit), outbox.MaxBatchSize)
+	}
+
+	// Latest id is read first, so it never lags behind the returned changes
+	latestId, err := m.db.GetLatestChangeID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	changes, err := outbox.ListComplete(ctx, m.db, req.AfterId, limit)
+	if errors.Is(err, outbox.ErrPruned) {
+		return nil, status.Error(codes.OutOfRange, err.Error())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	mapped := []*meshixv1.Change{}
+	for _, c := range changes {
+		sig, err := outbox.Sign(m.cacheCfg.PrivateKey, c)
+		if err != nil {
+			return nil, err
+		}
+		mapped = append(mapped, &meshixv1.Change{
+			Id:        c.ID,
+			Kind:      string(c.Kind),
+			Subject:   c.Subject,
+			Data:      c.Data,
+			CreatedAt: timestamppb.New(c.CreatedAt),
+			Signature: sig,
+		})
+		latestId = max(latestId, c.ID)
+	}
+
+	return &meshixv1.GetChangesResponse{
+		Changes:  mapped,
+		LatestId: latestId,
+	}, nil
+}
+
+// GetReplicationStatus implements meshixv1.MeshixServiceServer.
+func (m *Meshix) GetReplicationStatus(ctx context.Context, req *meshixv1.GetReplicationStatusRequest) (*meshixv1.GetReplicationStatusResponse, error) {
+	err := auth.RequireGroup(ctx, m.authCfg.AdminGroup)
+	if err != nil {
+		return nil, err
+	}
+	if m.replica == nil {
+		latestId, err := m.db.GetLatestChangeID(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return &meshixv1.GetReplicationStatusResponse{
+			Role:         meshixv1.ReplicationRole_REPLICATION_ROLE_PRIMARY,
+			LastChangeId: latestId,
+		}, nil
+	}
+
+	s := m.replica.Status()
+	resp := &meshixv1.GetReplicationStatusResponse{
+		Role:                  meshixv1.ReplicationRole_REPLICATION_ROLE_REPLICA,
+		PrimaryUrl:            m.replica.PrimaryUrl(),
+		LastChangeId:          s.LastChangeID,
+		PrimaryLatestChangeId: s.PrimaryLatestID,
+		LagChanges:            s.LagChanges(),
+		LagSeconds:            int64(s.Lag(time.Now()).Seconds()),
+		LastError:             s.LastError,
+	}
+	if s.Promoted {
+		resp.Role = meshixv1.ReplicationRole_REPLICATION_ROLE_PROMOTED
+	}
+	if !s.SyncedAt.IsZero() {
+		resp.SyncedAt = timestamppb.New(s.SyncedAt)
+	}
+
+	return resp, nil
+}
+
+// PromoteReplica implements meshixv1.MeshixServiceServer.
+func (m *Meshix) PromoteReplica(ctx context.Context, req *meshixv1.PromoteReplicaRequest) (*meshixv1.PromoteReplicaResponse, error) {
+	err := auth.RequireGroup(ctx, m.authCfg.AdminGroup)
+	if err != nil {
+		return nil, err
+	}
+	if m.replica == nil {
+		return nil, status.Error(codes.FailedPrecondition, "Server is not a replica")
+	}
+	err = m.replica.Promote(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &meshixv1.PromoteReplicaResponse{}, nil
+}
+
+// requireWritable rejects writes of replicated data on read-only replica.
+func (m *Meshix) requireWritable() error {
+	if m.replica.ReadOnly() {
+		return status.Errorf(codes.FailedPrecondition, "Server is a read-only replica of %s", m.replica.PrimaryUrl())
+	}
+
+	return nil
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"errors"
+	meshixv1 "gen/proto/meshix/v1"
+	"log/slog"
+	"server/internal/auth"
+	"server/internal/domain"
+	"server/internal/rollout"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const rolloutControllerInterval = 10 * time.Second
+
+// CreateRollout implements meshixv1.MeshixServiceServer.
+func (m *Meshix) CreateRollout(ctx context.Context, req *meshixv1.CreateRolloutRequest) (*meshixv1.CreateRolloutResponse, error) {
+	err := auth.RequireGroup(ctx, m.authCfg.AdminGroup)
+	if err != nil {
+		return nil, err
+	}
+	// Completed rollout assigns the version to the group, assignments are written on primary only
+	err = m.requireWritable()
+	if err != nil {
+		return nil, err
+	}
+	percentages := []int{}
+	for _, p := range req.WavePercentages {
+		percentages = append(percentages, int(p))
+	}
+
+	id, err := m.rollouts.Create(ctx, domain.NewRollout{
+		PackageName:    req.PackageName,
+		PackageVersion: req.PackageVersion,
+		Selector: domain.MachineFilter{
+			Group:  req.Group,
+			Labels: req.Labels,
+		},
+		CanaryCount:     int(req.CanaryCount),
+		WavePercentages: percentages,
+		MaxFailureRate:  req.MaxFailureRate,
+		Soak:            time.Duration(req.SoakSeconds) * time.Second,
+		CreatedBy:       auth.FromContext(ctx).Subject,
+		Emergency:       req.Emergency,
+		Prestage:        req.Prestage,
+	})
+	if err != nil {
+		return nil, mapRolloutError(err)
+	}
+	r, err := m.db.GetRollout(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &meshixv1.CreateRolloutResponse{
+		Rollout: mapRollout(r),
+	}, nil
+}
+
+// ListRollouts implements meshixv1.MeshixServiceServer.
+func (m *Meshix) ListRollouts(ctx context.Context, req *meshixv1.ListRolloutsRequest) (*meshixv1.ListRolloutsResponse, error) {
+	state := domain.RolloutState("")
+	for s, mapped := range rolloutStates {
+		if mapped == req.State {
+			state = s
+		}
+	}
+	rollouts, err := m.db.ListRollouts(ctx, state)
+	if err != nil {
+		return nil, err
+	}
+
+	mappedRollouts := []*meshixv1.Rollout{}
+	for _, r := range rollouts {
+		mappedRollouts = append(mappedRollouts, mapRollout(r))
+	}
+
+	return &meshixv1.ListRolloutsResponse{
+		Rollouts: mappedRollouts,
+	}, nil
+}
+
+// GetRollout implements meshixv1.MeshixServiceServer.
+func (m *Meshix) GetRollout(ctx context.Context, req *meshixv1.GetRolloutRequest) (*meshixv1.GetRolloutResponse, error) {
+	r, err := m.db.GetRollout(ctx, req.Id)
+	if err != nil {
+		return nil, mapDbError(err)
+	}
+	progress, err := m.rollouts.Progress(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+
+	machines := []*meshixv1.RolloutMachine{}
+	for _, p := range progress {
+		machines = append(machines, &meshixv1.RolloutMachine{
+			MachineName: p.MachineName,
+			Wave:        int32(p.Wave),
+			State:       rolloutMachineStates[p.State],
+			FailedGroup: p.FailedGroup,
+		})
+	}
+
+	return &meshixv1.GetRolloutResponse{
+		Rollout:  mapRollout(r),
+		Machines: machines,
+	}, nil
+}
+
+// PauseRollout implements meshixv1.MeshixServiceServer.
+func (m *Meshix) PauseRollout(ctx context.Context, req *meshixv1.PauseRolloutRequest) (*meshixv1.PauseRolloutResponse, error) {
+	err := auth.RequireGroup(ctx, m.authCfg.AdminGroup)
+	if err != nil {
+		return nil, err
+	}
+	err = m.rollouts.Pause(ctx, req.Id)
+	if err != nil {
+		return nil, mapRolloutError(err)
+	}
+
+	return &meshixv1.PauseRolloutResponse{}, nil
+}
+
+// ResumeRollout implements meshixv1.MeshixServiceServer.
+func (m *Meshix) ResumeRollout(ctx context.Context, req *meshixv1.ResumeRolloutRequest) (*meshixv1.ResumeRolloutResponse, error) {
+	err := auth.RequireGroup(ctx, m.authCfg.AdminGroup)
+	if err != nil {
+		return nil, err
+	}
+	err = m.rollouts.Resume(ctx, req.Id)
+	if err != nil {
+		return nil, mapRolloutError(err)
+	}
+
+	return &meshixv1.ResumeRolloutResponse{}, nil
+}
+
+// AbortRollout implements meshixv1.MeshixServiceServer.
+func (m *Meshix) AbortRollout(ctx context.Context, req *meshixv1.AbortRolloutRequest) (*meshixv1.AbortRolloutResponse, error) {
+	err := auth.RequireGroup(ctx, m.authCfg.AdminGroup)
+	if err != nil {
+		return nil, err
+	}
+	err = m.rollouts.Abort(ctx, req.Id)
+	if err != nil {
+		return nil, mapRolloutError(err)
+	}
+
+	return &meshixv1.AbortRolloutResponse{}, nil
+}
+
+// ActivateRollout implements meshixv1.MeshixServiceServer.
+func (m *Meshix) ActivateRollout(ctx context.Context, req *meshixv1.ActivateRolloutRequest) (*meshixv1.ActivateRolloutResponse, error) {
+	err := auth.RequireGroup(ctx, m.authCfg.AdminGroup)
+	if err != nil {
+		return nil, err
+	}
+	err = m.rollouts.Activate(ctx, req.Id)
+	if err != nil {
+		return nil, mapRolloutError(err)
+	}
+
+	return &meshixv1.ActivateRolloutResponse{}, nil
+}
+
+var rolloutStates = map[domain.RolloutState]meshixv1.RolloutState{
+	domain.RolloutRunning:   meshixv1.RolloutState_ROLLOUT_STATE_RUNNING,
+	domain.RolloutPaused:    meshixv1.RolloutState_ROLLOUT_STATE_PAUSED,
+	domain.RolloutHalted:    meshixv1.RolloutState_ROLLOUT_STATE_HALTED,
+	domain.RolloutAborted:   meshixv1.RolloutState_ROLLOUT_STATE_ABORTED,
+	domain.RolloutCompleted: meshixv1.RolloutState_ROLLOUT_STATE_COMPLETED,
+	domain.RolloutStaging:   meshixv1.RolloutState_ROLLOUT_STATE_STAGING,
+}
+
+var rolloutMachineStates = map[rollout.MachineState]meshixv1.RolloutMachineState{
+	rollout.MachineWaiting:   meshixv1.RolloutMachineState_ROLLOUT_MACHINE_STATE_WAITING,
+	rollout.MachinePending:   meshixv1.RolloutMachineState_ROLLOUT_MACHINE_STATE_PENDING,
+	rollout.MachineSucceeded: meshixv1.RolloutMachineState_ROLLOUT_MACHINE_STATE_SUCCEEDED,
+	rollout.MachineFailed:    meshixv1.RolloutMachineState_ROLLOUT_MACHINE_STATE_FAILED,
+	rollout.MachineStaging:   meshixv1.RolloutMachineState_ROLLOUT_MACHINE_STATE_STAGING,
+	rollout.MachineStaged:    meshixv1.RolloutMachineState_ROLLOUT_MACHINE_STATE_STAGED,
+	rollout.MachineStale:     meshixv1.RolloutMachineState_ROLLOUT_MACHINE_STATE_STALE,
+}
+
+// ListFailedVersions implements meshixv1.MeshixServiceServer.
+func (m *Meshix) ListFailedVersions(ctx context.Context, req *meshixv1.ListFailedVersionsRequest) (*meshixv1.ListFailedVersionsResponse, error) {
+	failed, err := m.db.ListFailedVersions(ctx, domain.PackageRef{
+		Name:    req.PackageName,
+		Version: req.PackageVersion,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	mapped := []*meshixv1.FailedVersion{}
+	for _, f := range failed {
+		mapped = append(mapped, &meshixv1.FailedVersion{
+			PackageName:    f.PackageName,
+			PackageVersion: f.PackageVersion,
+			Group:          f.Group,
+			MachineName:    f.MachineName,
+			Reason:         f.Reason,
+			FailedAt:       timestamppb.New(f.FailedAt),
+		})
+	}
+
+	return &meshixv1.ListFailedVersionsResponse{
+		FailedVersions: mapped,
+	}, nil
+}
+
+// ClearFailedVersion implements meshixv1.MeshixServiceServer.
+func (m *Meshix) ClearFailedVersion(ctx context.Context, req *meshixv1.ClearFailedVersionRequest) (*meshixv1.ClearFailedVersionResponse, error) {
+	err := auth.RequireGroup(ctx, m.authCfg.AdminGroup)
+	if err != nil {
+		return nil, err
+	}
+	if req.PackageName == "" || req.PackageVersion == "" {
+		return nil, status.Error(codes.InvalidArgument, "Package name and version are required")
+	}
+
+	err = m.db.DeleteFailedVersion(ctx, domain.PackageRef{
+		Name:    req.PackageName,
+		Version: req.PackageVersion,
+	}, req.Group)
+	if err != nil {
+		return nil, mapDbError(err)
+	}
+	slog.InfoContext(ctx, "Failed version cleared", "package", req.PackageName, "version", req.PackageVersion, "group", req.Group, "by", auth.FromContext(ctx).Subject)
+
+	return &meshixv1.ClearFailedVersionResponse{}, nil
+}
+
+func mapRollout(r domain.Rollout) *meshixv1.Rollout {
+	percentages := []int32{}
+	for _, p := range r.WavePercentages {
+		percentages = append(percentages, int32(p))
+	}
+
+	return &meshixv1.Rollout{
+		Id:              r.ID,
+		PackageName:     r.PackageName,
+		PackageVersion:  r.PackageVersion,
+		Group:           r.Selector.Group,
+		Labels:          r.Selector.Labels,
+		CanaryCount:     int32(r.CanaryCount),
+		WavePercentages: percentages,
+		MaxFailureRate:  r.MaxFailureRate,
+		SoakSeconds:     int64(r.Soak.Seconds()),
+		State:           rolloutStates[r.State],
+		StateReason:     r.StateReason,
+		CurrentWave:     int32(r.CurrentWave),
+		WaveCount:       int32(rollout.WaveCount(r.CanaryCount, r.WavePercentages)),
+		CreatedBy:       r.CreatedBy,
+		CreatedAt:       timestamppb.New(r.CreatedAt),
+		Emergency:       r.Emergency,
+		Prestage:        r.Prestage,
+	}
+}
+
+func mapRolloutError(err error) error {
+	if errors.Is(err, rollout.ErrInvalidRollout) {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	if errors.Is(err, rollout.ErrInvalidTransition) {
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
+
+	return mapDbError(err)
+}
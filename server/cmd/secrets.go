@@ -0,0 +1,136 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	meshixv1 "gen/proto/meshix/v1"
+	"io/fs"
+	"log/slog"
+	"server/internal/auth"
+	"server/internal/domain"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const defaultSecretMode = 0o400
+
+// PutSecret implements meshixv1.MeshixServiceServer.
+func (m *Meshix) PutSecret(ctx context.Context, req *meshixv1.PutSecretRequest) (*meshixv1.PutSecretResponse, error) {
+	err := auth.RequireGroup(ctx, m.authCfg.AdminGroup)
+	if err != nil {
+		return nil, err
+	}
+	machine, err := m.findMachine(ctx, 0, req.Machine)
+	if err != nil {
+		return nil, err
+	}
+	secret := domain.Secret{
+		MachineID:  machine.ID,
+		Name:       req.Name,
+		Ciphertext: req.Ciphertext,
+		Owner:      req.Owner,
+		Group:      req.Group,
+		Mode:       fs.FileMode(cmp.Or(req.Mode, defaultSecretMode)),
+		UpdatedBy:  auth.FromContext(ctx).Subject,
+	}
+	err = secret.Validate()
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	err = m.db.PutSecret(ctx, secret)
+	if err != nil {
+		return nil, err
+	}
+	slog.InfoContext(ctx, "Secret stored", "machine", machine.Name, "name", secret.Name, "by", secret.UpdatedBy)
+
+	return &meshixv1.PutSecretResponse{}, nil
+}
+
+// ListSecrets implements meshixv1.MeshixServiceServer.
+func (m *Meshix) ListSecrets(ctx context.Context, req *meshixv1.ListSecretsRequest) (*meshixv1.ListSecretsResponse, error) {
+	err := auth.RequireGroup(ctx, m.authCfg.AdminGroup)
+	if err != nil {
+		return nil, err
+	}
+	secrets, err := m.db.ListSecrets(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	mapped := []*meshixv1.Secret{}
+	for _, s := range secrets {
+		if req.Machine != "" && s.MachineName != req.Machine {
+			continue
+		}
+		secret := mapSecret(s)
+		secret.Ciphertext = nil
+		mapped = append(mapped, secret)
+	}
+
+	return &meshixv1.ListSecretsResponse{
+		Secrets: mapped,
+	}, nil
+}
+
+// DeleteSecret implements meshixv1.MeshixServiceServer.
+func (m *Meshix) DeleteSecret(ctx context.Context, req *meshixv1.DeleteSecretRequest) (*meshixv1.DeleteSecretResponse, error) {
+	err := auth.RequireGroup(ctx, m.authCfg.AdminGroup)
+	if err != nil {
+		return nil, err
+	}
+	machine, err := m.findMachine(ctx, 0, req.Machine)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.db.DeleteSecret(ctx, machine.ID, req.Name)
+	if err != nil {
+		return nil, mapDbError(err)
+	}
+	slog.InfoContext(ctx, "Secret deleted", "machine", machine.Name, "name", req.Name, "by", auth.FromContext(ctx).Subject)
+
+	return &meshixv1.DeleteSecretResponse{}, nil
+}
+
+// GetMachineSecrets implements meshixv1.MeshixServiceServer.
+func (m *Meshix) GetMachineSecrets(ctx context.Context, req *meshixv1.GetMachineSecretsRequest) (*meshixv1.GetMachineSecretsResponse, error) {
+	machineId, err := auth.RequireMachine(ctx)
+	if err != nil {
+		return nil, err
+	}
+	machine, err := m.db.GetMachine(ctx, machineId)
+	if err != nil {
+		return nil, mapDbError(err)
+	}
+	secrets, err := m.db.ListMachineSecrets(ctx, machine.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	mapped := []*meshixv1.Secret{}
+	for _, s := range secrets {
+		s.MachineName = machine.Name
+		mapped = append(mapped, mapSecret(s))
+	}
+
+	return &meshixv1.GetMachineSecretsResponse{
+		Secrets: mapped,
+	}, nil
+}
+
+func mapSecret(s domain.Secret) *meshixv1.Secret {
+	return &meshixv1.Secret{
+		MachineName: s.MachineName,
+		Name:        s.Name,
+		Ciphertext:  s.Ciphertext,
+		Hash:        s.Hash,
+		Owner:       s.Owner,
+		Group:       s.Group,
+		Mode:        uint32(s.Mode),
+		UpdatedBy:   s.UpdatedBy,
+		UpdatedAt:   timestamppb.New(s.UpdatedAt),
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	meshixv1 "gen/proto/meshix/v1"
+	"server/internal/auth"
+	"server/internal/domain"
+	"server/internal/webhooks"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const defaultWebhookDeliveriesLimit = 100
+
+const maxWebhookDeliveriesLimit = 1000
+
+// TestWebhook implements meshixv1.MeshixServiceServer.
+func (m *Meshix) TestWebhook(ctx context.Context, req *meshixv1.TestWebhookRequest) (*meshixv1.TestWebhookResponse, error) {
+	err := auth.RequireGroup(ctx, m.authCfg.AdminGroup)
+	if err != nil {
+		return nil, err
+	}
+	delivery, err := m.webhooks.Test(ctx, req.Name)
+	if errors.Is(err, webhooks.ErrUnknownWebhook) {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &meshixv1.TestWebhookResponse{
+		Delivery: mapWebhookDelivery(delivery),
+	}, nil
+}
+
+// ListWebhookDeliveries implements meshixv1.MeshixServiceServer.
+func (m *Meshix) ListWebhookDeliveries(ctx context.Context, req *meshixv1.ListWebhookDeliveriesRequest) (*meshixv1.ListWebhookDeliveriesResponse, error) {
+	err := auth.RequireGroup(ctx, m.authCfg.AdminGroup)
+	if err != nil {
+		return nil, err
+	}
+	limit := defaultWebhookDeliveriesLimit
+	if req.Limit > 0 {
+		limit = min(int(req.Limit), maxWebhookDeliveriesLimit)
+	}
+
+	deliveries, err := m.db.ListWebhookDeliveries(ctx, req.Webhook, limit)
+	if err != nil {
+		return nil, err
+	}
+	resp := &meshixv1.ListWebhookDeliveriesResponse{
+		Deliveries: []*meshixv1.WebhookDelivery{},
+	}
+	for _, d := range deliveries {
+		resp.Deliveries = append(resp.Deliveries, mapWebhookDelivery(d))
+	}
+
+	return resp, nil
+}
+
+func mapWebhookDelivery(d domain.WebhookDelivery) *meshixv1.WebhookDelivery {
+	return &meshixv1.WebhookDelivery{
+		Id:         d.ID,
+		Webhook:    d.Webhook,
+		ChangeId:   d.ChangeID,
+		Kind:       string(d.Kind),
+		Subject:    d.Subject,
+		Attempt:    int32(d.Attempt),
+		StatusCode: int32(d.StatusCode),
+		Error:      d.Error,
+		DurationMs: d.Duration.Milliseconds(),
+		CreatedAt:  timestamppb.New(d.CreatedAt),
+	}
+}
@@ -0,0 +1,174 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"gen/machineauth"
+	"log/slog"
+	"net/http"
+	"server/internal/config"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+var ErrUnknownToken = errors.New("Unknown token")
+
+// Identity of the caller.
+type Identity struct {
+	Subject string
+	Groups  []string
+	// MachineID is set when the caller is an enrolled machine
+	MachineID int64
+}
+
+// Anonymous is the identity of callers which did not present any token.
+var Anonymous = Identity{Subject: "anonymous"}
+
+// RequireGroup returns PermissionDenied status when caller is not in the group.
+func RequireGroup(ctx context.Context, group string) error {
+	identity := FromContext(ctx)
+	if !identity.InGroup(group) {
+		return status.Errorf(codes.PermissionDenied, "%s is not in group %s", identity.Subject, group)
+	}
+
+	return nil
+}
+
+func (i Identity) InGroup(group string) bool {
+	for _, g := range i.Groups {
+		if g == group {
+			return true
+		}
+	}
+
+	return false
+}
+
+type identityKey struct{}
+
+func WithIdentity(ctx context.Context, identity Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+// FromContext returns identity of the caller, Anonymous when the request was not authenticated.
+func FromContext(ctx context.Context) Identity {
+	identity, ok := ctx.Value(identityKey{}).(Identity)
+	if !ok {
+		return Anonymous
+	}
+
+	return identity
+}
+
+// Authenticator resolves static API tokens from config to identities.
+type Authenticator struct {
+	tokens   []config.TokenCfg
+	machines MachineGetter
+	nonces   *nonceCache
+}
+
+func NewAuthenticator(cfg config.AuthCfg, machines MachineGetter) *Authenticator {
+	return &Authenticator{
+		tokens:   cfg.Tokens,
+		machines: machines,
+		nonces:   newNonceCache(),
+	}
+}
+
+func (a *Authenticator) Authenticate(token string) (Identity, error) {
+	if token == "" {
+		return Anonymous, nil
+	}
+	for _, t := range a.tokens {
+		if subtle.ConstantTimeCompare([]byte(t.Token), []byte(token)) == 1 {
+			return Identity{
+				Subject: t.Subject,
+				Groups:  t.Groups,
+			}, nil
+		}
+	}
+
+	return Identity{}, ErrUnknownToken
+}
+
+// UnaryServerInterceptor authenticates requests signed by enrolled machines,
+// or the bearer token from `authorization` metadata.
+func (a *Authenticator) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		identity, err := a.authenticateCall(ctx, info.FullMethod, req)
+		if err != nil {
+			return nil, err
+		}
+
+		return handler(WithIdentity(ctx, identity), req)
+	}
+}
+
+// StreamServerInterceptor authenticates streams the same way as UnaryServerInterceptor.
+func (a *Authenticator) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		// Machines sign the request message, streams have none at the time of authentication
+		identity, err := a.authenticateCall(ss.Context(), info.FullMethod, nil)
+		if err != nil {
+			return err
+		}
+
+		return handler(srv, &identityStream{
+			ServerStream: ss,
+			ctx:          WithIdentity(ss.Context(), identity),
+		})
+	}
+}
+
+func (a *Authenticator) authenticateCall(ctx context.Context, method string, req any) (Identity, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if firstValue(md, machineauth.IdHeader) != "" {
+		identity, err := a.authenticateMachine(ctx, md, method, req)
+		if err != nil {
+			return Identity{}, status.Error(codes.Unauthenticated, err.Error())
+		}
+		return identity, nil
+	}
+
+	token, _ := strings.CutPrefix(firstValue(md, "authorization"), "Bearer ")
+	identity, err := a.Authenticate(token)
+	if err != nil {
+		return Identity{}, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	return identity, nil
+}
+
+// identityStream carries identity of the caller in the context of the stream.
+type identityStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *identityStream) Context() context.Context {
+	return s.ctx
+}
+
+// Middleware authenticates HTTP requests. Token is accepted either as bearer token or as basic auth
+// password, which is what nix sends when credentials for the cache are in netrc.
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			_, token, _ = r.BasicAuth()
+		}
+		identity, err := a.Authenticate(token)
+		if err != nil {
+			slog.WarnContext(r.Context(), "Failed to authenticate request", "err", err, "path", r.URL.Path)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(WithIdentity(r.Context(), identity)))
+	})
+}
@@ -0,0 +1,162 @@
+package auth
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"gen/machineauth"
+	"server/internal/domain"
+	"strconv"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// Allowed difference between machine and hub clocks
+const maxClockSkew = 5 * time.Minute
+
+var (
+	ErrInvalidSignature = errors.New("Invalid machine signature")
+	ErrReplayedRequest  = errors.New("Replayed machine request")
+)
+
+type MachineGetter interface {
+	GetMachine(ctx context.Context, id int64) (domain.Machine, error)
+}
+
+// NewBootstrapToken generates random one-time enrollment token.
+func NewBootstrapToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return "mxb_" + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashToken is used to store bootstrap tokens.
+func HashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// RequireMachine returns id of the calling machine, Unauthenticated status when caller is not an enrolled machine.
+func RequireMachine(ctx context.Context) (int64, error) {
+	identity := FromContext(ctx)
+	if identity.MachineID == 0 {
+		return 0, status.Error(codes.Unauthenticated, "Request has to be signed by enrolled machine")
+	}
+
+	return identity.MachineID, nil
+}
+
+// MachineSubject is subject of identities of enrolled machines.
+func MachineSubject(name string) string {
+	return "machine/" + name
+}
+
+func (a *Authenticator) authenticateMachine(ctx context.Context, md metadata.MD, method string, req any) (Identity, error) {
+	machineId, err := strconv.ParseInt(firstValue(md, machineauth.IdHeader), 10, 64)
+	if err != nil {
+		return Identity{}, fmt.Errorf("Invalid machine id: %w", err)
+	}
+	timestamp, err := strconv.ParseInt(firstValue(md, machineauth.TimestampHeader), 10, 64)
+	if err != nil {
+		return Identity{}, fmt.Errorf("Invalid timestamp: %w", err)
+	}
+	skew := time.Since(time.Unix(timestamp, 0))
+	if skew > maxClockSkew || skew < -maxClockSkew {
+		return Identity{}, ErrInvalidSignature
+	}
+	nonce := firstValue(md, machineauth.NonceHeader)
+	decodedNonce, err := base64.RawURLEncoding.DecodeString(nonce)
+	if err != nil || len(decodedNonce) != machineauth.NonceSize {
+		return Identity{}, ErrInvalidSignature
+	}
+	signature, err := base64.StdEncoding.DecodeString(firstValue(md, machineauth.SignatureHeader))
+	if err != nil {
+		return Identity{}, ErrInvalidSignature
+	}
+	message, ok := req.(proto.Message)
+	if !ok {
+		return Identity{}, ErrInvalidSignature
+	}
+	fingerprint, err := machineauth.Fingerprint(method, machineId, timestamp, nonce, message)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	machine, err := a.machines.GetMachine(ctx, machineId)
+	if err != nil {
+		return Identity{}, fmt.Errorf("Unknown machine %d: %w", machineId, err)
+	}
+	if !ed25519.Verify(machine.PublicKey, []byte(fingerprint), signature) {
+		return Identity{}, ErrInvalidSignature
+	}
+	// Nonce is remembered while the timestamp is accepted, later replays fail the clock skew check
+	if !a.nonces.add(machineId, nonce, time.Unix(timestamp, 0).Add(maxClockSkew)) {
+		return Identity{}, ErrReplayedRequest
+	}
+
+	// Fleet groups of the machine select rollouts and secrets, they do not grant access to the API
+	return Identity{
+		Subject:   MachineSubject(machine.Name),
+		MachineID: machine.ID,
+	}, nil
+}
+
+// nonceCache remembers nonces of machine requests until their timestamps leave the allowed clock skew.
+type nonceCache struct {
+	mu      sync.Mutex
+	expires map[string]time.Time
+	pruned  time.Time
+}
+
+func newNonceCache() *nonceCache {
+	return &nonceCache{
+		expires: map[string]time.Time{},
+	}
+}
+
+// add returns false when the nonce was already seen.
+func (c *nonceCache) add(machineId int64, nonce string, expires time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(c.pruned) > time.Minute {
+		for key, e := range c.expires {
+			if now.After(e) {
+				delete(c.expires, key)
+			}
+		}
+		c.pruned = now
+	}
+
+	key := strconv.FormatInt(machineId, 10) + ";" + nonce
+	if _, ok := c.expires[key]; ok {
+		return false
+	}
+	c.expires[key] = expires
+
+	return true
+}
+
+func firstValue(md metadata.MD, key string) string {
+	values := md.Get(key)
+	if len(values) == 0 {
+		return ""
+	}
+
+	return values[0]
+}
@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"gen/machineauth"
+	meshixv1 "gen/proto/meshix/v1"
+	"server/internal/config"
+	"server/internal/domain"
+	"strconv"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+)
+
+type fakeMachines map[int64]domain.Machine
+
+func (f fakeMachines) GetMachine(ctx context.Context, id int64) (domain.Machine, error) {
+	machine, ok := f[id]
+	if !ok {
+		return domain.Machine{}, errors.New("Not found")
+	}
+
+	return machine, nil
+}
+
+const testMethod = "/meshix.v1.MeshixService/ReportStatus"
+
+func signedMetadata(t *testing.T, key ed25519.PrivateKey, req *meshixv1.ReportStatusRequest) metadata.MD {
+	t.Helper()
+	nonce, err := machineauth.NewNonce()
+	if err != nil {
+		t.Fatal(err)
+	}
+	timestamp := time.Now().Unix()
+	fingerprint, err := machineauth.Fingerprint(testMethod, 7, timestamp, nonce, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return metadata.Pairs(
+		machineauth.IdHeader, "7",
+		machineauth.TimestampHeader, strconv.FormatInt(timestamp, 10),
+		machineauth.NonceHeader, nonce,
+		machineauth.SignatureHeader, base64.StdEncoding.EncodeToString(ed25519.Sign(key, []byte(fingerprint))),
+	)
+}
+
+func TestAuthenticateMachine(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := NewAuthenticator(config.AuthCfg{}, fakeMachines{
+		7: {ID: 7, Name: "web-1", PublicKey: public, Groups: []string{"admin"}},
+	})
+	ctx := context.Background()
+	req := &meshixv1.ReportStatusRequest{FreeDiskBytes: 100}
+	md := signedMetadata(t, private, req)
+
+	identity, err := a.authenticateMachine(ctx, md, testMethod, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.MachineID != 7 || identity.Subject != "machine/web-1" {
+		t.Errorf("Unexpected identity %+v", identity)
+	}
+	if identity.InGroup("admin") {
+		t.Error("Fleet group of machine granted API group")
+	}
+
+	_, err = a.authenticateMachine(ctx, md, testMethod, req)
+	if !errors.Is(err, ErrReplayedRequest) {
+		t.Errorf("Replayed request not rejected, got %v", err)
+	}
+
+	md = signedMetadata(t, private, req)
+	tampered := &meshixv1.ReportStatusRequest{FreeDiskBytes: 200}
+	_, err = a.authenticateMachine(ctx, md, testMethod, tampered)
+	if !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Tampered request not rejected, got %v", err)
+	}
+
+	_, err = a.authenticateMachine(ctx, md, "/meshix.v1.MeshixService/GetMachineSecrets", req)
+	if !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Request signed for other method not rejected, got %v", err)
+	}
+}
@@ -0,0 +1,102 @@
+package closure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"sbom"
+	"server/internal/domain"
+	"server/internal/storage"
+	"slices"
+	"time"
+
+	"github.com/nix-community/go-nix/pkg/narinfo"
+	"github.com/nix-community/go-nix/pkg/nixbase32"
+	"github.com/nix-community/go-nix/pkg/storepath"
+)
+
+type NarInfoGetter interface {
+	GetNarInfo(ctx context.Context, hash string) (*narinfo.NarInfo, error)
+}
+
+// Walk resolves runtime closure of store paths from references of their narinfos.
+func Walk(ctx context.Context, narInfos NarInfoGetter, roots []string) ([]sbom.Component, error) {
+	components := []sbom.Component{}
+	visited := map[string]bool{}
+	queue := slices.Clone(roots)
+
+	for len(queue) > 0 {
+		storePath := queue[0]
+		queue = queue[1:]
+		if visited[storePath] {
+			continue
+		}
+		visited[storePath] = true
+
+		// Derivations are not uploaded to the hub, names are parsed from store paths
+		component, err := sbom.NewComponent(storePath, sbom.DrvName{})
+		if err != nil {
+			return nil, err
+		}
+		sp, err := storepath.FromAbsolutePath(storePath)
+		if err != nil {
+			return nil, err
+		}
+		info, err := narInfos.GetNarInfo(ctx, nixbase32.EncodeToString(sp.Digest))
+		if err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				return nil, fmt.Errorf("Store path %s of the closure is not in the cache", storePath)
+			}
+			return nil, err
+		}
+
+		component.NarHash = info.NarHash.String()
+		component.NarSize = info.NarSize
+		if info.Deriver != "" {
+			component.Deriver = path.Join(storepath.StoreDir, info.Deriver)
+		}
+		for _, reference := range info.References {
+			referencePath := path.Join(storepath.StoreDir, reference)
+			component.References = append(component.References, referencePath)
+			if !visited[referencePath] {
+				queue = append(queue, referencePath)
+			}
+		}
+		components = append(components, component)
+	}
+
+	return components, nil
+}
+
+// Roots are store paths of all package outputs.
+func Roots(pkg domain.Package) []string {
+	roots := []string{pkg.NixMetadata.StorePath}
+	for _, storePath := range pkg.NixMetadata.Outputs {
+		if !slices.Contains(roots, storePath) {
+			roots = append(roots, storePath)
+		}
+	}
+	slices.Sort(roots)
+
+	return roots
+}
+
+// SbomDocument describes runtime closure of all package outputs.
+func SbomDocument(ctx context.Context, narInfos NarInfoGetter, pkg domain.Package) (sbom.Document, error) {
+	roots := Roots(pkg)
+	components, err := Walk(ctx, narInfos, roots)
+	if err != nil {
+		return sbom.Document{}, err
+	}
+
+	return sbom.Document{
+		Name:        pkg.Name,
+		Version:     pkg.Version,
+		Description: pkg.NixMetadata.Description,
+		Licenses:    pkg.NixMetadata.Licenses,
+		Roots:       roots,
+		Components:  components,
+		Created:     time.Now(),
+	}, nil
+}
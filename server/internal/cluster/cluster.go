@@ -0,0 +1,342 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"server/internal/auth"
+	"server/internal/config"
+	"server/internal/db"
+	"server/internal/domain"
+	"server/internal/storage"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// objectKeyRe matches keys of cache objects, narinfos by hash of the store path and NARs by their file hash.
+var objectKeyRe = regexp.MustCompile(`^[0-9a-df-np-sv-z]{32,52}\.(narinfo|nar)$`)
+
+// downFor is how long a node which failed to respond is tried only after other nodes.
+const downFor = 30 * time.Second
+
+// Node is member of the cluster.
+type Node struct {
+	Url  string
+	Self bool
+	// Live is false when the node recently failed to respond
+	Live bool
+}
+
+// Cluster shards objects of the binary cache across nodes.
+//
+// Objects are placed on replication factor nodes by consistent hashing of their hash, so any node answers
+// requests for the cache by reading objects from their owners. Objects of a node are copied to their new owners
+// when membership changes, see Rebalance. Until then objects missing on their owners are looked up on owners
+// in the previous membership. Node which is not in the membership owns no objects, it hands its objects over
+// and leaves the cluster.
+type Cluster struct {
+	self              string
+	nodes             []string
+	replicationFactor int
+	ring              *Ring
+	local             *storage.NarInfoStore
+	db                db.Database
+	token             string
+	group             string
+	client            *http.Client
+
+	mu        sync.Mutex
+	downUntil map[string]time.Time
+	// previous membership, nil when objects of this node are rebalanced
+	previous  *domain.ClusterMembership
+	rebalance RebalanceStatus
+}
+
+// New creates cluster of the nodes, local store has to access only the bucket of this node.
+func New(ctx context.Context, cfg config.ClusterCfg, group string, local *storage.NarInfoStore, database db.Database) (*Cluster, error) {
+	nodes := []string{}
+	for _, node := range cfg.Nodes {
+		nodes = append(nodes, strings.TrimSuffix(node, "/"))
+	}
+	c := &Cluster{
+		self:              strings.TrimSuffix(cfg.Self, "/"),
+		nodes:             nodes,
+		replicationFactor: min(max(cfg.ReplicationFactor, 1), len(nodes)),
+		ring:              NewRing(nodes),
+		local:             local,
+		db:                database,
+		token:             cfg.Token,
+		group:             group,
+		client:            http.DefaultClient,
+		downUntil:         map[string]time.Time{},
+	}
+
+	previous, err := database.GetClusterMembership(ctx, c.self)
+	if errors.Is(err, db.ErrNotFound) {
+		// Objects uploaded before cluster mode was enabled are placed on this node
+		c.previous = &domain.ClusterMembership{
+			Nodes:             []string{c.self},
+			ReplicationFactor: 1,
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("Failed to get cluster membership: %w", err)
+	} else if !previous.Equal(c.Membership()) {
+		c.previous = &previous
+	}
+
+	return c, nil
+}
+
+// Membership of the cluster.
+func (c *Cluster) Membership() domain.ClusterMembership {
+	return domain.ClusterMembership{
+		Nodes:             c.nodes,
+		ReplicationFactor: c.replicationFactor,
+	}
+}
+
+// Nodes lists members of the cluster.
+func (c *Cluster) Nodes() []Node {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	nodes := []Node{}
+	for _, node := range c.nodes {
+		nodes = append(nodes, Node{
+			Url:  node,
+			Self: node == c.self,
+			Live: node == c.self || !now.Before(c.downUntil[node]),
+		})
+	}
+
+	return nodes
+}
+
+// Owners of the object key, nodes which recently failed to respond are last.
+func (c *Cluster) Owners(key string) []string {
+	owners := c.ring.Owners(ringKey(key), c.replicationFactor)
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	slices.SortStableFunc(owners, func(a, b string) int {
+		return boolCompare(now.Before(c.downUntil[a]), now.Before(c.downUntil[b]))
+	})
+
+	return owners
+}
+
+// Owns implements storage.Remote.
+func (c *Cluster) Owns(key string) bool {
+	return slices.Contains(c.ring.Owners(ringKey(key), c.replicationFactor), c.self)
+}
+
+// Get implements storage.Remote.
+func (c *Cluster) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	var errs []error
+	for _, node := range c.candidates(key) {
+		resp, err := c.request(ctx, http.MethodGet, node, key, nil, 0)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if resp.StatusCode == http.StatusOK {
+			return resp.Body, nil
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			errs = append(errs, fmt.Errorf("Failed to get %s from %s: %s", key, node, resp.Status))
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	return nil, storage.ErrNotFound
+}
+
+// Put implements storage.Remote. Object is stored when at least one of the other owners accepted it,
+// missing copies are restored by rebalancing.
+func (c *Cluster) Put(ctx context.Context, key string, body io.ReadSeeker, size int64) error {
+	var errs []error
+	stored := 0
+	owners := c.Owners(key)
+	for _, node := range owners {
+		if node == c.self {
+			continue
+		}
+		_, err := body.Seek(0, io.SeekStart)
+		if err != nil {
+			return err
+		}
+		err = c.put(ctx, node, key, io.NopCloser(body), size)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		stored++
+	}
+	if stored == 0 && len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	if len(errs) > 0 {
+		slog.WarnContext(ctx, "Failed to place object on all owners", "key", key, "err", errors.Join(errs...))
+	}
+
+	return nil
+}
+
+// HandleObject serves objects in the bucket of this node to other nodes.
+func (c *Cluster) HandleObject() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if !auth.FromContext(ctx).InGroup(c.group) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		key := mux.Vars(r)["key"]
+		if !objectKeyRe.MatchString(key) {
+			http.Error(w, "Invalid object key", http.StatusBadRequest)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			obj, err := c.local.GetObject(ctx, key)
+			if errors.Is(err, storage.ErrNotFound) {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			if err != nil {
+				slog.ErrorContext(ctx, "Failed to get object", "key", key, "err", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			defer obj.Close()
+			if r.Method == http.MethodHead {
+				return
+			}
+			_, err = io.Copy(w, obj)
+			if err != nil {
+				slog.ErrorContext(ctx, "Failed to copy object to response", "key", key, "err", err)
+			}
+		case http.MethodPut:
+			// ContentLength is -1 for chunked bodies, minio uploads them in parts
+			err := c.local.PutBucketObject(ctx, key, r.Body, r.ContentLength)
+			if err != nil {
+				slog.ErrorContext(ctx, "Failed to put object", "key", key, "err", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+// candidates are other nodes which may hold the object, owners first and owners in previous membership next.
+func (c *Cluster) candidates(key string) []string {
+	candidates := []string{}
+	for _, node := range c.Owners(key) {
+		if node != c.self {
+			candidates = append(candidates, node)
+		}
+	}
+	c.mu.Lock()
+	previous := c.previous
+	c.mu.Unlock()
+	if previous == nil {
+		return candidates
+	}
+	for _, node := range NewRing(previous.Nodes).Owners(ringKey(key), previous.ReplicationFactor) {
+		// Node removed from the cluster serves its objects until it hands them over
+		if node != c.self && !slices.Contains(candidates, node) {
+			candidates = append(candidates, node)
+		}
+	}
+
+	return candidates
+}
+
+func (c *Cluster) put(ctx context.Context, node, key string, body io.ReadCloser, size int64) error {
+	resp, err := c.request(ctx, http.MethodPut, node, key, body, size)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Failed to put %s to %s: %s", key, node, resp.Status)
+	}
+
+	return nil
+}
+
+// exists checks if the node has the object.
+func (c *Cluster) exists(ctx context.Context, node, key string) (bool, error) {
+	resp, err := c.request(ctx, http.MethodHead, node, key, nil, 0)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("Failed to head %s on %s: %s", key, node, resp.Status)
+	}
+}
+
+func (c *Cluster) request(ctx context.Context, method, node, key string, body io.ReadCloser, size int64) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, node+"/cluster/objects/"+key, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		c.markDown(node)
+		return nil, fmt.Errorf("Failed to request %s from %s: %w", key, node, err)
+	}
+	if resp.StatusCode >= http.StatusInternalServerError {
+		c.markDown(node)
+	}
+
+	return resp, nil
+}
+
+func (c *Cluster) markDown(node string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.downUntil[node] = time.Now().Add(downFor)
+}
+
+// ringKey places narinfo and NAR by their hash.
+func ringKey(key string) string {
+	hash, _, _ := strings.Cut(key, ".")
+
+	return hash
+}
+
+func boolCompare(a, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case a:
+		return 1
+	default:
+		return -1
+	}
+}
@@ -0,0 +1,351 @@
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"server/internal/auth"
+	"server/internal/config"
+	"server/internal/db"
+	"server/internal/storage"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// testS3 keeps objects of all buckets in memory, it implements the part of S3 API used by minio client.
+type testS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	uploads map[string]map[int][]byte
+}
+
+type listBucketResult struct {
+	XMLName  xml.Name `xml:"ListBucketResult"`
+	Name     string
+	KeyCount int
+	Contents []listBucketObject
+}
+
+type listBucketObject struct {
+	Key  string
+	Size int
+}
+
+type initiateMultipartUploadResult struct {
+	Bucket   string
+	Key      string
+	UploadId string
+}
+
+type completeMultipartUploadResult struct {
+	Bucket string
+	Key    string
+	ETag   string
+}
+
+func (s *testS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	query := r.URL.Query()
+	w.Header().Set("ETag", `"etag"`)
+	switch {
+	case query.Has("location"):
+		w.Write([]byte(`<LocationConstraint xmlns="http://s3.amazonaws.com/doc/2006-03-01/">us-east-1</LocationConstraint>`))
+	case key == "":
+		result := listBucketResult{Name: bucket}
+		for _, k := range s.keys(bucket) {
+			result.Contents = append(result.Contents, listBucketObject{Key: k, Size: len(s.objects[bucket+"/"+k])})
+		}
+		result.KeyCount = len(result.Contents)
+		xml.NewEncoder(w).Encode(result)
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		uploadId := strconv.Itoa(len(s.uploads) + 1)
+		s.uploads[uploadId] = map[int][]byte{}
+		xml.NewEncoder(w).Encode(initiateMultipartUploadResult{Bucket: bucket, Key: key, UploadId: uploadId})
+	case r.Method == http.MethodPut && query.Has("uploadId"):
+		part, _ := strconv.Atoi(query.Get("partNumber"))
+		s.uploads[query.Get("uploadId")][part] = readBody(r)
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		parts := s.uploads[query.Get("uploadId")]
+		var object []byte
+		for _, part := range slices.Sorted(maps.Keys(parts)) {
+			object = append(object, parts[part]...)
+		}
+		s.objects[bucket+"/"+key] = object
+		xml.NewEncoder(w).Encode(completeMultipartUploadResult{Bucket: bucket, Key: key, ETag: `"etag"`})
+	case r.Method == http.MethodPut:
+		s.objects[bucket+"/"+key] = readBody(r)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		object, ok := s.objects[bucket+"/"+key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			if r.Method == http.MethodGet {
+				w.Write([]byte(`<Error><Code>NoSuchKey</Code><Message>Not found</Message></Error>`))
+			}
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(object)))
+		w.Header().Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+		if r.Method == http.MethodGet {
+			w.Write(object)
+		}
+	case r.Method == http.MethodDelete:
+		delete(s.objects, bucket+"/"+key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+// readBody reads body of the upload, aws-chunked body of streaming signature is decoded.
+func readBody(r *http.Request) []byte {
+	body, _ := io.ReadAll(r.Body)
+	if !strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING") {
+		return body
+	}
+	var decoded []byte
+	for {
+		header, rest, _ := bytes.Cut(body, []byte("\r\n"))
+		size, _ := strconv.ParseInt(string(bytes.SplitN(header, []byte(";"), 2)[0]), 16, 64)
+		if size == 0 {
+			return decoded
+		}
+		decoded = append(decoded, rest[:size]...)
+		body = rest[size+2:]
+	}
+}
+
+// keys of objects in the bucket, caller holds the lock.
+func (s *testS3) keys(bucket string) []string {
+	keys := []string{}
+	for k := range s.objects {
+		if key, ok := strings.CutPrefix(k, bucket+"/"); ok {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+
+	return keys
+}
+
+func (s *testS3) bucketKeys(bucket string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.keys(bucket)
+}
+
+type testNode struct {
+	bucket  string
+	store   *storage.NarInfoStore
+	cluster *Cluster
+}
+
+// testCluster runs nodes as http servers sharing one S3 server and database, each node uses its own bucket.
+type testCluster struct {
+	t        *testing.T
+	s3       *testS3
+	minio    *minio.Client
+	database db.Database
+	urls     []string
+	handlers []http.Handler
+}
+
+func newTestCluster(t *testing.T, servers int) *testCluster {
+	t.Helper()
+	s3 := &testS3{objects: map[string][]byte{}, uploads: map[string]map[int][]byte{}}
+	s3Server := httptest.NewServer(s3)
+	t.Cleanup(s3Server.Close)
+	u, err := url.Parse(s3Server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := minio.New(u.Host, &minio.Options{Creds: credentials.NewStaticV4("access", "secret", ""), Region: "us-east-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	database, err := db.Open(context.Background(), filepath.Join(t.TempDir(), "meshix.db"), os.DirFS("../../migrations"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tc := &testCluster{t: t, s3: s3, minio: client, database: database, handlers: make([]http.Handler, servers)}
+	for i := range servers {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tc.handlers[i].ServeHTTP(w, r)
+		}))
+		t.Cleanup(server.Close)
+		tc.urls = append(tc.urls, server.URL)
+	}
+
+	return tc
+}
+
+// start (re)starts the server i as node of the cluster with the members.
+func (tc *testCluster) start(i int, members []int) *testNode {
+	tc.t.Helper()
+	nodes := []string{}
+	for _, member := range members {
+		nodes = append(nodes, tc.urls[member])
+	}
+	bucket := fmt.Sprintf("nix-%d", i)
+	local := storage.NewNarInfoStore(tc.minio, config.MinioCfg{Bucket: bucket})
+	c, err := New(context.Background(), config.ClusterCfg{Self: tc.urls[i], Nodes: nodes, ReplicationFactor: 2, Token: "token"}, "cluster", local, tc.database)
+	if err != nil {
+		tc.t.Fatal(err)
+	}
+	router := mux.NewRouter()
+	router.Handle("/cluster/objects/{key}", c.HandleObject())
+	tc.handlers[i] = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		identity := auth.Identity{Subject: "node", Groups: []string{"cluster"}}
+		router.ServeHTTP(w, r.WithContext(auth.WithIdentity(r.Context(), identity)))
+	})
+
+	return &testNode{bucket: bucket, store: local.WithRemote(c), cluster: c}
+}
+
+func (tc *testCluster) startAll(servers []int, members []int) []*testNode {
+	nodes := []*testNode{}
+	for _, i := range servers {
+		nodes = append(nodes, tc.start(i, members))
+	}
+
+	return nodes
+}
+
+func (tc *testCluster) rebalance(nodes []*testNode) {
+	tc.t.Helper()
+	for _, n := range nodes {
+		err := n.cluster.Rebalance(context.Background())
+		if err != nil {
+			tc.t.Fatal(err)
+		}
+	}
+}
+
+// assertPlaced checks that every key has two copies and nodes hold only objects they own.
+func (tc *testCluster) assertPlaced(nodes []*testNode, keys []string) {
+	tc.t.Helper()
+	copies := 0
+	for _, n := range nodes {
+		for _, key := range tc.s3.bucketKeys(n.bucket) {
+			copies++
+			if !n.cluster.Owns(key) {
+				tc.t.Errorf("Node %s holds %s which it does not own", n.bucket, key)
+			}
+		}
+	}
+	if copies != 2*len(keys) {
+		tc.t.Errorf("Expected %d copies of objects, got %d", 2*len(keys), copies)
+	}
+}
+
+func assertReadable(t *testing.T, n *testNode, keys []string) {
+	t.Helper()
+	for _, key := range keys {
+		obj, err := n.store.GetObject(context.Background(), key)
+		if err != nil {
+			t.Fatalf("Failed to read %s from %s: %v", key, n.bucket, err)
+		}
+		body, err := io.ReadAll(obj)
+		obj.Close()
+		if err != nil || string(body) != "object "+key {
+			t.Fatalf("Unexpected %s from %s: %q, %v", key, n.bucket, body, err)
+		}
+	}
+}
+
+func TestClusterPlacesAndRebalancesObjects(t *testing.T) {
+	ctx := context.Background()
+	tc := newTestCluster(t, 4)
+	nodes := tc.startAll([]int{0, 1, 2}, []int{0, 1, 2})
+	keys := []string{}
+	for i := range 30 {
+		key := fmt.Sprintf("%030d%02d.narinfo", 0, i)
+		key = strings.ReplaceAll(key, "0", "a")
+		keys = append(keys, key)
+		body := "object " + key
+		err := nodes[i%3].store.PutObject(ctx, key, strings.NewReader(body), int64(len(body)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	tc.assertPlaced(nodes, keys)
+	for _, n := range nodes {
+		assertReadable(t, n, keys)
+	}
+	_, err := nodes[0].store.GetObject(ctx, strings.Repeat("z", 32)+".narinfo")
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Expected missing object, got %v", err)
+	}
+	tc.rebalance(nodes)
+
+	// Objects are read from owners in previous membership until the added node is rebalanced
+	nodes = tc.startAll([]int{0, 1, 2, 3}, []int{0, 1, 2, 3})
+	for _, n := range nodes {
+		assertReadable(t, n, keys)
+	}
+	tc.rebalance(nodes)
+	tc.assertPlaced(nodes, keys)
+	if restarted := tc.start(0, []int{0, 1, 2, 3}); restarted.cluster.previous != nil {
+		t.Errorf("Restarted node with unchanged membership has to be rebalanced again")
+	}
+
+	// Removed node hands its objects over to remaining nodes
+	members := []int{0, 2, 3}
+	removed := tc.start(1, members)
+	tc.rebalance([]*testNode{removed})
+	if keys := tc.s3.bucketKeys(removed.bucket); len(keys) != 0 {
+		t.Errorf("Removed node kept %d objects", len(keys))
+	}
+	nodes = tc.startAll(members, members)
+	tc.rebalance(nodes)
+	tc.assertPlaced(nodes, keys)
+	assertReadable(t, nodes[0], keys)
+}
+
+func TestHandleObjectStreamsBodyOfUnknownSize(t *testing.T) {
+	tc := newTestCluster(t, 1)
+	node := tc.start(0, []int{0})
+	key := strings.Repeat("a", 32) + ".nar"
+	body := "object " + key
+	// Reader without known length is sent chunked
+	req, err := http.NewRequest(http.MethodPut, tc.urls[0]+"/cluster/objects/"+key, io.MultiReader(strings.NewReader(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer token")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Put of chunked object failed: %s", resp.Status)
+	}
+	if len(tc.s3.uploads) != 1 {
+		t.Errorf("Object of unknown size was not uploaded in parts")
+	}
+	assertReadable(t, node, []string{key})
+}
@@ -0,0 +1,190 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+)
+
+// rebalanceRetryInterval is how long failed rebalancing waits before it is retried.
+const rebalanceRetryInterval = time.Minute
+
+var ErrRebalanceRunning = errors.New("Rebalancing is already running")
+
+// RebalanceStatus reports progress of the last rebalancing of objects of this node.
+type RebalanceStatus struct {
+	Running    bool
+	StartedAt  time.Time
+	FinishedAt time.Time
+	// Checked objects in the bucket of this node
+	Checked int
+	// Copied objects to owners which did not have them
+	Copied int
+	// Dropped objects which this node does not own anymore
+	Dropped int
+	// Failed objects which are retried on the next rebalancing
+	Failed    int
+	LastError string
+}
+
+// RebalanceStatus returns copy of the status of the last rebalancing.
+func (c *Cluster) RebalanceStatus() RebalanceStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.rebalance
+}
+
+// Run rebalances objects of this node when membership changed since the last rebalancing, until it succeeds
+// or the context is cancelled.
+func (c *Cluster) Run(ctx context.Context) {
+	c.mu.Lock()
+	previous := c.previous
+	c.mu.Unlock()
+	if previous == nil {
+		return
+	}
+	slog.InfoContext(ctx, "Cluster membership changed, rebalancing objects", "nodes", c.nodes, "previousNodes", previous.Nodes)
+	for {
+		err := c.Rebalance(ctx)
+		if err == nil {
+			return
+		}
+		slog.ErrorContext(ctx, "Failed to rebalance objects", "err", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(rebalanceRetryInterval):
+		}
+	}
+}
+
+// StartRebalance rebalances objects of this node in background.
+func (c *Cluster) StartRebalance(ctx context.Context) error {
+	err := c.begin()
+	if err != nil {
+		return err
+	}
+	go func() {
+		err := c.rebalanceObjects(context.WithoutCancel(ctx))
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to rebalance objects", "err", err)
+		}
+	}()
+
+	return nil
+}
+
+// Rebalance copies each object of this node to its owners which don't have it and drops the object
+// when this node does not own it anymore. Membership is stored when all objects were rebalanced.
+func (c *Cluster) Rebalance(ctx context.Context) error {
+	err := c.begin()
+	if err != nil {
+		return err
+	}
+
+	return c.rebalanceObjects(ctx)
+}
+
+func (c *Cluster) begin() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.rebalance.Running {
+		return ErrRebalanceRunning
+	}
+	c.rebalance = RebalanceStatus{
+		Running:   true,
+		StartedAt: time.Now(),
+	}
+
+	return nil
+}
+
+func (c *Cluster) rebalanceObjects(ctx context.Context) (err error) {
+	defer func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.rebalance.Running = false
+		c.rebalance.FinishedAt = time.Now()
+		if err != nil {
+			c.rebalance.LastError = err.Error()
+			return
+		}
+		c.previous = nil
+		slog.InfoContext(ctx, "Objects rebalanced", "checked", c.rebalance.Checked, "copied", c.rebalance.Copied, "dropped", c.rebalance.Dropped)
+	}()
+
+	var lastErr error
+	for key, err := range c.local.ListObjects(ctx) {
+		if err != nil {
+			return err
+		}
+		if !objectKeyRe.MatchString(key) {
+			continue
+		}
+		copied, dropped, err := c.rebalanceObject(ctx, key)
+		c.mu.Lock()
+		c.rebalance.Checked++
+		c.rebalance.Copied += copied
+		if dropped {
+			c.rebalance.Dropped++
+		}
+		if err != nil {
+			c.rebalance.Failed++
+			lastErr = err
+		}
+		c.mu.Unlock()
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to rebalance object", "key", key, "err", err)
+		}
+	}
+
+	failed := c.RebalanceStatus().Failed
+	if failed > 0 {
+		return fmt.Errorf("Failed to rebalance %d objects, last error: %w", failed, lastErr)
+	}
+
+	return c.db.PutClusterMembership(ctx, c.self, c.Membership())
+}
+
+// rebalanceObject places the object on all its owners, it returns number of owners it was copied to
+// and if it was dropped from this node.
+func (c *Cluster) rebalanceObject(ctx context.Context, key string) (int, bool, error) {
+	owners := c.ring.Owners(ringKey(key), c.replicationFactor)
+	copied := 0
+	for _, owner := range owners {
+		if owner == c.self {
+			continue
+		}
+		exists, err := c.exists(ctx, owner, key)
+		if err != nil {
+			return copied, false, err
+		}
+		if exists {
+			continue
+		}
+		obj, err := c.local.GetObject(ctx, key)
+		if err != nil {
+			return copied, false, err
+		}
+		// Length is unknown, object is sent chunked
+		err = c.put(ctx, owner, key, obj, -1)
+		if err != nil {
+			return copied, false, err
+		}
+		copied++
+	}
+	if slices.Contains(owners, c.self) {
+		return copied, false, nil
+	}
+
+	err := c.local.DeleteObject(ctx, key)
+	if err != nil {
+		return copied, false, err
+	}
+
+	return copied, true, nil
+}
@@ -0,0 +1,75 @@
+package cluster
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/binary"
+	"slices"
+	"strconv"
+)
+
+// VirtualNodes is number of points of each node on the ring, more points spread objects more evenly.
+const VirtualNodes = 128
+
+// Ring places keys on nodes by consistent hashing, adding or removing a node moves only keys
+// of its neighbours on the ring.
+type Ring struct {
+	points []point
+	nodes  int
+}
+
+type point struct {
+	hash uint64
+	node string
+}
+
+// NewRing places the nodes on the ring, duplicate nodes are placed once.
+func NewRing(nodes []string) *Ring {
+	r := &Ring{}
+	placed := map[string]bool{}
+	for _, node := range nodes {
+		if placed[node] {
+			continue
+		}
+		placed[node] = true
+		r.nodes++
+		for i := range VirtualNodes {
+			r.points = append(r.points, point{
+				hash: ringHash(node + "#" + strconv.Itoa(i)),
+				node: node,
+			})
+		}
+	}
+	slices.SortFunc(r.points, func(a, b point) int {
+		return cmp.Compare(a.hash, b.hash)
+	})
+
+	return r
+}
+
+// Owners returns n distinct nodes owning the key, starting with the first node clockwise from the key.
+func (r *Ring) Owners(key string, n int) []string {
+	n = min(n, r.nodes)
+	owners := make([]string, 0, n)
+	if n == 0 {
+		return owners
+	}
+	hash := ringHash(key)
+	start, _ := slices.BinarySearchFunc(r.points, hash, func(p point, h uint64) int {
+		return cmp.Compare(p.hash, h)
+	})
+	for i := 0; len(owners) < n && i < len(r.points); i++ {
+		node := r.points[(start+i)%len(r.points)].node
+		if !slices.Contains(owners, node) {
+			owners = append(owners, node)
+		}
+	}
+
+	return owners
+}
+
+func ringHash(s string) uint64 {
+	sum := sha256.Sum256([]byte(s))
+
+	return binary.BigEndian.Uint64(sum[:8])
+}
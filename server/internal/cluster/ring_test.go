@@ -0,0 +1,63 @@
+package cluster
+
+import (
+	"slices"
+	"strconv"
+	"testing"
+)
+
+func TestRingOwners(t *testing.T) {
+	ring := NewRing([]string{"a", "b", "c"})
+	for i := range 1000 {
+		key := strconv.Itoa(i)
+		owners := ring.Owners(key, 2)
+		if len(owners) != 2 || owners[0] == owners[1] {
+			t.Fatalf("Owners of %s are not distinct: %v", key, owners)
+		}
+		if !slices.Equal(owners, ring.Owners(key, 2)) {
+			t.Fatalf("Owners of %s are not stable", key)
+		}
+	}
+	if owners := ring.Owners("key", 5); len(owners) != 3 {
+		t.Errorf("Owners over number of nodes %v", owners)
+	}
+	if owners := NewRing(nil).Owners("key", 2); len(owners) != 0 {
+		t.Errorf("Empty ring has owners %v", owners)
+	}
+}
+
+func TestRingMovesKeysOnlyToAddedNode(t *testing.T) {
+	before := NewRing([]string{"a", "b", "c"})
+	after := NewRing([]string{"a", "b", "c", "d"})
+	counts := map[string]int{}
+	moved := 0
+	for i := range 10000 {
+		key := strconv.Itoa(i)
+		previous, owner := before.Owners(key, 1)[0], after.Owners(key, 1)[0]
+		counts[previous]++
+		if previous == owner {
+			continue
+		}
+		moved++
+		if owner != "d" {
+			t.Fatalf("Key %s moved from %s to %s instead of added node", key, previous, owner)
+		}
+	}
+	for node, count := range counts {
+		if count < 2500 || count > 4200 {
+			t.Errorf("Node %s owns %d of 10000 keys", node, count)
+		}
+	}
+	if moved < 1500 || moved > 3500 {
+		t.Errorf("Adding node moved %d of 10000 keys", moved)
+	}
+}
+
+func TestRingWithDuplicateNodes(t *testing.T) {
+	ring := NewRing([]string{"a", "a", "b"})
+	owners := ring.Owners("key", 3)
+	slices.Sort(owners)
+	if !slices.Equal(owners, []string{"a", "b"}) {
+		t.Errorf("Owners of ring with duplicate nodes %v", owners)
+	}
+}
@@ -2,8 +2,13 @@ package config
 
 import (
 	"errors"
+	"fmt"
 	"net/url"
 	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/alecthomas/kong"
 	"github.com/nix-community/go-nix/pkg/narinfo/signature"
@@ -11,14 +16,24 @@ import (
 )
 
 type cli struct {
-	ConfigPath         string `kong:"name='config',help='Path to config file',default='./configuration/config.yaml'"`
-	ListenAddr         string `kong:"name='listen',help='address and port to listen on',default='0.0.0.0:8088'"`
-	SecretKey          string `kong:"name='secret-key',help='Binary cache secret key',env='SECRET_KEY'"`
-	SecretKeyFilePath  string `kong:"name='secret-key-file-path',help='Path to binary cache secret key',env='SECRET_KEY_FILE_PATH'"`
-	MinioUrl           string `kong:"name='s3-url',help='s3 URL',default='http://localhost:9001',env='S3_URL'"`
-	MinioAcccessKey    string `kong:"name='s3-access-key',help='s3 access key',env='S3_ACCESS_KEY'"`
-	MinioAcccessSecret string `kong:"name='s3-access-secret',help='s3 access secret',env='S3_ACCESS_SECRET'"`
-	MinioBucket        string `kong:"name='s3-bucket',help='s3 bucket',env='S3_BUCKET'"`
+	ConfigPath         string        `kong:"name='config',help='Path to config file',default='./configuration/config.yaml'"`
+	ListenAddr         string        `kong:"name='listen',help='address and port to listen on',default='0.0.0.0:8088'"`
+	SecretKey          string        `kong:"name='secret-key',help='Binary cache secret key',env='SECRET_KEY'"`
+	SecretKeyFilePath  string        `kong:"name='secret-key-file-path',help='Path to binary cache secret key',env='SECRET_KEY_FILE_PATH'"`
+	MinioUrl           string        `kong:"name='s3-url',help='s3 URL',default='http://localhost:9001',env='S3_URL'"`
+	MinioAcccessKey    string        `kong:"name='s3-access-key',help='s3 access key',env='S3_ACCESS_KEY'"`
+	MinioAcccessSecret string        `kong:"name='s3-access-secret',help='s3 access secret',env='S3_ACCESS_SECRET'"`
+	MinioBucket        string        `kong:"name='s3-bucket',help='s3 bucket',env='S3_BUCKET'"`
+	AdvisoriesDir      string        `kong:"name='advisories-dir',help='Directory with OSV advisories to import',env='ADVISORIES_DIR'"`
+	MachineStaleAfter  time.Duration `kong:"name='machine-stale-after',help='Machines without heartbeat for the duration are stale',default='5m',env='MACHINE_STALE_AFTER'"`
+	PeerSiteLabel      string        `kong:"name='peer-site-label',help='Machine label grouping agents which share store paths',default='site',env='PEER_SITE_LABEL'"`
+	OutboxRetention    time.Duration `kong:"name='outbox-retention',help='Changes older than the duration are removed from the outbox',default='168h',env='OUTBOX_RETENTION'"`
+	ReplicateFrom      string        `kong:"name='replicate-from',help='URL of primary server to replicate from',env='REPLICATE_FROM'"`
+	ReplicationToken   string        `kong:"name='replication-token',help='API token used to read change feed of the primary',env='REPLICATION_TOKEN'"`
+	ClusterSelf        string        `kong:"name='cluster-self',help='URL of this node in the cluster',env='CLUSTER_SELF'"`
+	ClusterNodes       []string      `kong:"name='cluster-nodes',help='URLs of all nodes in the cluster',sep=',',env='CLUSTER_NODES'"`
+	ClusterToken       string        `kong:"name='cluster-token',help='API token used to access objects on other nodes',env='CLUSTER_TOKEN'"`
+	DatabaseDsn        string        `kong:"name='database-dsn',help='Path to sqlite file or postgres:// connection string',env='DATABASE_DSN'"`
 }
 
 type Config struct {
@@ -27,6 +42,112 @@ type Config struct {
 	SecretKeyPath  string `json:"-"` // Don't use for binary cache. Used just for config loading. Use BinaryCacheCfg
 	MinioCfg       MinioCfg
 	BinaryCacheCfg BinaryCacheCfg
+	// Directory watched for OSV JSON advisories, advisories are not imported from disk when empty
+	AdvisoriesDir string
+	// Machines which have not sent heartbeat for the duration are reported as stale
+	MachineStaleAfter time.Duration
+	// Agents share store paths with peers having the same value of the machine label
+	PeerSiteLabel string
+	// Changes older than the duration are removed from the outbox, consumers have to read them sooner.
+	// Webhook deliveries are kept for the same duration
+	OutboxRetention time.Duration
+	DatabaseCfg     DatabaseCfg    `yaml:"database"`
+	ReplicationCfg  ReplicationCfg `yaml:"replication"`
+	ClusterCfg      ClusterCfg     `yaml:"cluster"`
+	DeltaCfg        DeltaCfg       `yaml:"delta"`
+	AuthCfg         AuthCfg        `yaml:"auth"`
+	Policies        []PolicyCfg    `yaml:"policies"`
+	Webhooks        []WebhookCfg   `yaml:"webhooks"`
+}
+
+// AuthCfg holds the static API tokens accepted by the hub.
+// Requests without a token are evaluated as the anonymous identity.
+type AuthCfg struct {
+	Tokens []TokenCfg `yaml:"tokens"`
+	// Members of the group can manage machines, e.g. create bootstrap tokens
+	AdminGroup string `yaml:"adminGroup"`
+	// Members of the group can read the change feed, tokens of replicas
+	ReplicationGroup string `yaml:"replicationGroup"`
+	// Members of the group can stream changes from the outbox, tokens of downstream consumers
+	EventsGroup string `yaml:"eventsGroup"`
+	// Members of the group can access objects of the node, tokens of other cluster nodes
+	ClusterGroup string `yaml:"clusterGroup"`
+}
+
+// DatabaseCfg selects the database storing state of the hub. Sqlite file can be used by a single server only,
+// servers sharing PostgreSQL database can run side by side.
+type DatabaseCfg struct {
+	// Dsn is path of sqlite file or PostgreSQL connection string starting with postgres://
+	Dsn     string `yaml:"dsn" json:"-"`
+	DsnPath string `yaml:"dsnPath" json:"-"`
+}
+
+// ReplicationCfg makes the server a read-only replica following the change feed of the primary.
+// Replica has to use the same binary cache key as the primary, narinfos are copied with its signatures.
+type ReplicationCfg struct {
+	// PrimaryUrl of the followed server, e.g. http://hub:8088. Server is primary when empty
+	PrimaryUrl string `yaml:"primaryUrl"`
+	// Token of the replica, its subject has to be in replication group of the primary
+	Token     string `yaml:"token" json:"-"`
+	TokenPath string `yaml:"tokenPath" json:"-"`
+	// PollInterval of the change feed when the replica caught up
+	PollInterval time.Duration `yaml:"pollInterval"`
+}
+
+// ClusterCfg shards the binary cache across nodes, each object is placed on ReplicationFactor nodes chosen
+// by consistent hashing of its hash. All nodes share the binary cache key, auth tokens and policies.
+type ClusterCfg struct {
+	// Self is url of this node, cluster mode is disabled when empty. Node which is not in Nodes
+	// hands its objects over to the nodes
+	Self  string   `yaml:"self"`
+	Nodes []string `yaml:"nodes"`
+	// ReplicationFactor is capped by number of nodes
+	ReplicationFactor int `yaml:"replicationFactor"`
+	// Token of this node, its subject has to be in cluster group of other nodes
+	Token     string `yaml:"token" json:"-"`
+	TokenPath string `yaml:"tokenPath" json:"-"`
+}
+
+// DeltaCfg limits encoding of delta NARs for agents, encoded deltas are cached in the bucket.
+type DeltaCfg struct {
+	// MaxNarSize of base and target NARs, agents download larger NARs in full
+	MaxNarSize uint64 `yaml:"maxNarSize"`
+	// Concurrency of delta encodings, requests over the limit fall back to full NARs
+	Concurrency int `yaml:"concurrency"`
+}
+
+type TokenCfg struct {
+	Token     string   `yaml:"token" json:"-"`
+	TokenPath string   `yaml:"tokenPath" json:"-"`
+	Subject   string   `yaml:"subject"`
+	Groups    []string `yaml:"groups"`
+}
+
+// PolicyCfg is an admission rule. Expression is a CEL expression which has to evaluate to true
+// for the request to be admitted.
+type PolicyCfg struct {
+	Name       string `yaml:"name"`
+	Expression string `yaml:"expression"`
+	// Message returned to the caller when the rule is violated
+	Message string `yaml:"message"`
+	// Actions the rule applies to, see policy.Action. Applies to push-package when empty, as
+	// narinfo uploads have no package variables
+	Actions []string `yaml:"actions"`
+}
+
+// DefaultWebhookEvents are posted to webhooks without events, changes of machines are posted only when listed.
+var DefaultWebhookEvents = []string{"package.pushed", "package.yanked", "assignment.put", "rollout.*", "gc.run"}
+
+// WebhookCfg posts changes of the outbox to the url as JSON signed by HMAC-SHA256 of the secret.
+type WebhookCfg struct {
+	// Name identifies the webhook in the delivery log, renaming the webhook starts it from the latest change
+	Name string `yaml:"name"`
+	Url  string `yaml:"url"`
+	// Events are kinds of changes posted to the webhook, e.g. package.pushed or package.*, DefaultWebhookEvents
+	// when empty
+	Events     []string `yaml:"events"`
+	Secret     string   `yaml:"secret" json:"-"`
+	SecretPath string   `yaml:"secretPath" json:"-"`
 }
 
 type BinaryCacheCfg struct {
@@ -79,6 +200,43 @@ func LoadConfiguration(args []string) (Config, error) {
 			AcccessSecret: defaultLeft(cli.MinioAcccessSecret, cfg.MinioCfg.AcccessSecret),
 			Bucket:        defaultLeft(cli.MinioBucket, cfg.MinioCfg.Bucket),
 		},
+		AdvisoriesDir:     defaultLeft(cli.AdvisoriesDir, cfg.AdvisoriesDir),
+		MachineStaleAfter: defaultLeft(cli.MachineStaleAfter, cfg.MachineStaleAfter),
+		PeerSiteLabel:     defaultLeft(cli.PeerSiteLabel, cfg.PeerSiteLabel),
+		OutboxRetention:   defaultLeft(cli.OutboxRetention, cfg.OutboxRetention),
+		DatabaseCfg: DatabaseCfg{
+			Dsn:     defaultLeft(cli.DatabaseDsn, cfg.DatabaseCfg.Dsn),
+			DsnPath: cfg.DatabaseCfg.DsnPath,
+		},
+		ReplicationCfg: ReplicationCfg{
+			PrimaryUrl:   defaultLeft(cli.ReplicateFrom, cfg.ReplicationCfg.PrimaryUrl),
+			Token:        defaultLeft(cli.ReplicationToken, cfg.ReplicationCfg.Token),
+			TokenPath:    cfg.ReplicationCfg.TokenPath,
+			PollInterval: defaultLeft(cfg.ReplicationCfg.PollInterval, 5*time.Second),
+		},
+		ClusterCfg: ClusterCfg{
+			Self:              defaultLeft(cli.ClusterSelf, cfg.ClusterCfg.Self),
+			Nodes:             cfg.ClusterCfg.Nodes,
+			ReplicationFactor: defaultLeft(cfg.ClusterCfg.ReplicationFactor, 2),
+			Token:             defaultLeft(cli.ClusterToken, cfg.ClusterCfg.Token),
+			TokenPath:         cfg.ClusterCfg.TokenPath,
+		},
+		DeltaCfg: DeltaCfg{
+			MaxNarSize:  defaultLeft(cfg.DeltaCfg.MaxNarSize, 256<<20),
+			Concurrency: defaultLeft(cfg.DeltaCfg.Concurrency, 2),
+		},
+		AuthCfg: AuthCfg{
+			Tokens:           cfg.AuthCfg.Tokens,
+			AdminGroup:       defaultLeft(cfg.AuthCfg.AdminGroup, "admin"),
+			ReplicationGroup: defaultLeft(cfg.AuthCfg.ReplicationGroup, "replication"),
+			EventsGroup:      defaultLeft(cfg.AuthCfg.EventsGroup, "events"),
+			ClusterGroup:     defaultLeft(cfg.AuthCfg.ClusterGroup, "cluster"),
+		},
+		Policies: cfg.Policies,
+		Webhooks: cfg.Webhooks,
+	}
+	if len(cli.ClusterNodes) > 0 {
+		defaultedConfig.ClusterCfg.Nodes = cli.ClusterNodes
 	}
 
 	err = resolveSecretKey(&defaultedConfig)
@@ -86,6 +244,31 @@ func LoadConfiguration(args []string) (Config, error) {
 		return Config{}, err
 	}
 
+	err = resolveTokens(&defaultedConfig)
+	if err != nil {
+		return Config{}, err
+	}
+
+	err = resolveDatabase(&defaultedConfig)
+	if err != nil {
+		return Config{}, err
+	}
+
+	err = resolveReplicationToken(&defaultedConfig)
+	if err != nil {
+		return Config{}, err
+	}
+
+	err = resolveCluster(&defaultedConfig)
+	if err != nil {
+		return Config{}, err
+	}
+
+	err = resolveWebhooks(&defaultedConfig)
+	if err != nil {
+		return Config{}, err
+	}
+
 	return defaultedConfig, nil
 }
 
@@ -124,3 +307,118 @@ func resolveSecretKey(cfg *Config) error {
 
 	return nil
 }
+
+func resolveTokens(cfg *Config) error {
+	for i, t := range cfg.AuthCfg.Tokens {
+		if t.Subject == "" {
+			return errors.New("Subject of auth token has to be set")
+		}
+		if t.TokenPath != "" {
+			token, err := os.ReadFile(t.TokenPath)
+			if err != nil {
+				return err
+			}
+			cfg.AuthCfg.Tokens[i].Token = strings.TrimSpace(string(token))
+		}
+		if cfg.AuthCfg.Tokens[i].Token == "" {
+			return fmt.Errorf("One of token or tokenPath has to be set for %s", t.Subject)
+		}
+	}
+
+	return nil
+}
+
+func resolveDatabase(cfg *Config) error {
+	if cfg.DatabaseCfg.DsnPath != "" {
+		dsn, err := os.ReadFile(cfg.DatabaseCfg.DsnPath)
+		if err != nil {
+			return err
+		}
+		cfg.DatabaseCfg.Dsn = strings.TrimSpace(string(dsn))
+	}
+	cfg.DatabaseCfg.Dsn = defaultLeft(cfg.DatabaseCfg.Dsn, "./data")
+
+	return nil
+}
+
+func resolveReplicationToken(cfg *Config) error {
+	if cfg.ReplicationCfg.TokenPath != "" {
+		token, err := os.ReadFile(cfg.ReplicationCfg.TokenPath)
+		if err != nil {
+			return err
+		}
+		cfg.ReplicationCfg.Token = strings.TrimSpace(string(token))
+	}
+	if cfg.ReplicationCfg.PrimaryUrl != "" && cfg.ReplicationCfg.Token == "" {
+		return errors.New("One of replication token or tokenPath has to be set to replicate from primary")
+	}
+
+	return nil
+}
+
+func resolveCluster(cfg *Config) error {
+	if cfg.ClusterCfg.TokenPath != "" {
+		token, err := os.ReadFile(cfg.ClusterCfg.TokenPath)
+		if err != nil {
+			return err
+		}
+		cfg.ClusterCfg.Token = strings.TrimSpace(string(token))
+	}
+	if cfg.ClusterCfg.Self == "" {
+		return nil
+	}
+	if len(cfg.ClusterCfg.Nodes) == 0 {
+		return errors.New("Cluster nodes have to be set in cluster mode")
+	}
+	nodes := map[string]bool{}
+	for _, node := range cfg.ClusterCfg.Nodes {
+		node = strings.TrimSuffix(node, "/")
+		if nodes[node] {
+			return fmt.Errorf("Cluster node %s is configured more than once", node)
+		}
+		nodes[node] = true
+	}
+	if (len(cfg.ClusterCfg.Nodes) > 1 || !slices.Contains(cfg.ClusterCfg.Nodes, cfg.ClusterCfg.Self)) && cfg.ClusterCfg.Token == "" {
+		return errors.New("One of cluster token or tokenPath has to be set to access other nodes")
+	}
+
+	return nil
+}
+
+func resolveWebhooks(cfg *Config) error {
+	names := map[string]bool{}
+	for i, w := range cfg.Webhooks {
+		if w.Name == "" {
+			return errors.New("Name of webhook has to be set")
+		}
+		if names[w.Name] {
+			return fmt.Errorf("Webhook %s is configured more than once", w.Name)
+		}
+		names[w.Name] = true
+		u, err := url.Parse(w.Url)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("Url of webhook %s has to be http or https url", w.Name)
+		}
+		if len(w.Events) == 0 {
+			cfg.Webhooks[i].Events = slices.Clone(DefaultWebhookEvents)
+		}
+		for _, e := range w.Events {
+			_, err := path.Match(e, "")
+			if err != nil {
+				return fmt.Errorf("Invalid event %s of webhook %s: %w", e, w.Name, err)
+			}
+		}
+		if w.SecretPath != "" {
+			secret, err := os.ReadFile(w.SecretPath)
+			if err != nil {
+				return err
+			}
+			cfg.Webhooks[i].Secret = strings.TrimSpace(string(secret))
+		}
+		if cfg.Webhooks[i].Secret == "" {
+			return fmt.Errorf("One of secret or secretPath has to be set for webhook %s", w.Name)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,388 @@
+// Package dbtest is integration test suite of db.Database shared by its backends, so sqlite and PostgreSQL
+// behave the same. Backend runs it from its test with a function opening empty migrated database, e.g.
+//
+//	dbtest.Run(t, func(t *testing.T) db.Database {
+//		database, err := db.Open(ctx, t.TempDir()+"/data", os.DirFS("../../migrations"))
+//		...
+//	})
+package dbtest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"server/internal/db"
+	"server/internal/domain"
+	"slices"
+	"testing"
+	"time"
+)
+
+// Run runs the suite, each test gets its own database from open.
+func Run(t *testing.T, open func(t *testing.T) db.Database) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, database db.Database)
+	}{
+		{"Packages", testPackages},
+		{"Machines", testMachines},
+		{"Assignments", testAssignments},
+		{"FailedVersions", testFailedVersions},
+		{"Rollouts", testRollouts},
+		{"Secrets", testSecrets},
+		{"Outbox", testOutbox},
+		{"Webhooks", testWebhooks},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.fn(t, open(t))
+		})
+	}
+}
+
+func testPackages(t *testing.T, database db.Database) {
+	ctx := context.Background()
+	for _, version := range []string{"1.0", "2.0"} {
+		must(t, database.PutPackage(ctx, domain.NewPackage{
+			Name:    "hello",
+			Version: version,
+			System:  "x86_64-linux",
+			NixMetadata: domain.NixMetadata{
+				StorePath:        "/nix/store/hello-" + version,
+				MainBin:          "hello",
+				Outputs:          map[string]string{"out": "/nix/store/hello-" + version, "man": "/nix/store/hello-" + version + "-man"},
+				OutputsToInstall: []string{"out"},
+				Licenses:         []string{"GPL-3.0-or-later"},
+			},
+		}))
+	}
+	must(t, database.YankPackage(ctx, domain.PackageRef{Name: "hello", Version: "2.0"}, "broken"))
+
+	latest, err := database.GetPackage(ctx, domain.PackageRef{Name: "hello", System: "x86_64-linux"})
+	must(t, err)
+	if latest.Version != "1.0" {
+		t.Errorf("Latest version is %s, yanked version must be skipped", latest.Version)
+	}
+	if latest.NixMetadata.Outputs["man"] != "/nix/store/hello-1.0-man" || !slices.Equal(latest.NixMetadata.OutputsToInstall, []string{"out"}) {
+		t.Errorf("Outputs are not stored: %+v", latest.NixMetadata)
+	}
+	if !slices.Equal(latest.NixMetadata.Licenses, []string{"GPL-3.0-or-later"}) {
+		t.Errorf("Licenses are not stored: %v", latest.NixMetadata.Licenses)
+	}
+
+	yanked, err := database.GetPackage(ctx, domain.PackageRef{Name: "hello", Version: "2.0"})
+	must(t, err)
+	if yanked.Yank == nil || yanked.Yank.Reason != "broken" {
+		t.Errorf("Yank is not stored: %+v", yanked.Yank)
+	}
+
+	packages, err := database.ListPackages(ctx, domain.PackageFilter{System: "x86_64-linux"})
+	must(t, err)
+	if len(packages) != 1 {
+		t.Errorf("Listed %d packages, yanked package must be skipped", len(packages))
+	}
+	packages, err = database.ListPackages(ctx, domain.PackageFilter{IncludeYanked: true, System: "aarch64-linux"})
+	must(t, err)
+	if len(packages) != 0 {
+		t.Errorf("Listed %d packages of other system", len(packages))
+	}
+
+	must(t, database.DeletePackage(ctx, domain.PackageRef{Name: "hello", Version: "2.0"}, true))
+	latestId, err := database.GetLatestChangeID(ctx)
+	must(t, err)
+	changes, err := database.ListChanges(ctx, latestId-1, 1)
+	must(t, err)
+	var gcRun domain.GcRunChange
+	if len(changes) != 1 || changes[0].Kind != domain.ChangeGcRun || json.Unmarshal(changes[0].Data, &gcRun) != nil {
+		t.Fatalf("Dropped GC roots are not recorded: %v", changes)
+	}
+	slices.Sort(gcRun.DroppedRoots)
+	if !slices.Equal(gcRun.DroppedRoots, []string{"/nix/store/hello-2.0", "/nix/store/hello-2.0-man"}) {
+		t.Errorf("Unexpected dropped GC roots %v", gcRun.DroppedRoots)
+	}
+	err = database.DeletePackage(ctx, domain.PackageRef{Name: "hello", Version: "2.0"}, true)
+	expectError(t, err, db.ErrNotFound)
+	_, err = database.GetPackage(ctx, domain.PackageRef{Name: "hello", Version: "2.0"})
+	expectError(t, err, db.ErrNotFound)
+}
+
+func testMachines(t *testing.T, database db.Database) {
+	ctx := context.Background()
+	must(t, database.PutBootstrapToken(ctx, "hash", domain.BootstrapToken{
+		CreatedBy: "admin",
+		Groups:    []string{"web"},
+		Labels:    map[string]string{"env": "prod"},
+		ExpiresAt: time.Now().Add(time.Hour),
+	}))
+
+	machine, err := database.RegisterMachine(ctx, "hash", domain.NewMachine{
+		Name:      "web-1",
+		System:    "x86_64-linux",
+		PublicKey: make([]byte, 32),
+		Labels:    map[string]string{"env": "dev", "dc": "a"},
+	})
+	must(t, err)
+	if machine.Labels["env"] != "prod" || machine.Labels["dc"] != "a" {
+		t.Errorf("Labels of the token must take precedence: %v", machine.Labels)
+	}
+	_, err = database.RegisterMachine(ctx, "hash", domain.NewMachine{Name: "web-2", System: "x86_64-linux", PublicKey: make([]byte, 32)})
+	expectError(t, err, db.ErrInvalidToken)
+
+	machines, err := database.ListMachines(ctx, domain.MachineFilter{Group: "web", Labels: map[string]string{"dc": "a"}})
+	must(t, err)
+	if len(machines) != 1 {
+		t.Errorf("Listed %d machines of the group", len(machines))
+	}
+	machines, err = database.ListMachines(ctx, domain.MachineFilter{Group: "db"})
+	must(t, err)
+	if len(machines) != 0 {
+		t.Errorf("Listed %d machines of other group", len(machines))
+	}
+
+	must(t, database.PutMachineStatus(ctx, machine.ID, domain.MachineStatus{
+		ProfileGeneration: 3,
+		StorePaths:        []string{"/nix/store/hello-1.0"},
+		NixVersion:        "2.24",
+		ReportedAt:        time.Now(),
+	}))
+	byName, err := database.GetMachineByName(ctx, "web-1")
+	must(t, err)
+	if byName.ID != machine.ID || byName.Status == nil || byName.Status.ProfileGeneration != 3 {
+		t.Errorf("Status is not stored: %+v", byName.Status)
+	}
+	_, err = database.GetMachine(ctx, machine.ID+1)
+	expectError(t, err, db.ErrNotFound)
+}
+
+func testAssignments(t *testing.T, database db.Database) {
+	ctx := context.Background()
+	target := domain.AssignmentTarget{Group: "web"}
+	must(t, database.PutAssignment(ctx, domain.Assignment{Target: target, PackageName: "hello", PackageVersion: "1.0"}))
+	must(t, database.PutAssignment(ctx, domain.Assignment{Target: target, PackageName: "hello", PackageVersion: "2.0"}))
+
+	assignments, err := database.ListAssignments(ctx)
+	must(t, err)
+	if len(assignments) != 1 || assignments[0].PackageVersion != "2.0" {
+		t.Errorf("Assignment must be updated in place: %+v", assignments)
+	}
+
+	must(t, database.DeleteAssignment(ctx, target, "hello"))
+	err = database.DeleteAssignment(ctx, target, "hello")
+	expectError(t, err, db.ErrNotFound)
+}
+
+func testFailedVersions(t *testing.T, database db.Database) {
+	ctx := context.Background()
+	first := registerMachine(t, database, "web-1")
+	second := registerMachine(t, database, "web-2")
+	failure := func(machine domain.Machine, group string) domain.FailedVersion {
+		return domain.FailedVersion{
+			PackageName:    "hello",
+			PackageVersion: "2.0",
+			Group:          group,
+			MachineID:      machine.ID,
+			Reason:         "health check",
+			FailedAt:       time.Now().UTC().Truncate(time.Second),
+		}
+	}
+	must(t, database.PutFailedVersion(ctx, failure(first, "web")))
+	must(t, database.PutFailedVersion(ctx, failure(second, "web")))
+	// Machines without groups fail on their own
+	must(t, database.PutFailedVersion(ctx, failure(first, "")))
+	must(t, database.PutFailedVersion(ctx, failure(second, "")))
+	must(t, database.PutFailedVersion(ctx, failure(second, "")))
+
+	failed, err := database.ListFailedVersions(ctx, domain.PackageRef{Name: "hello", Version: "2.0"})
+	must(t, err)
+	machines := map[string][]string{}
+	for _, f := range failed {
+		machines[f.Group] = append(machines[f.Group], f.MachineName)
+	}
+	if len(failed) != 3 || len(machines["web"]) != 1 || machines["web"][0] != "web-1" || len(machines[""]) != 2 {
+		t.Errorf("Unexpected failed versions: %+v", failed)
+	}
+
+	must(t, database.DeleteFailedVersion(ctx, domain.PackageRef{Name: "hello", Version: "2.0"}, ""))
+	failed, err = database.ListFailedVersions(ctx, domain.PackageRef{})
+	must(t, err)
+	if len(failed) != 0 {
+		t.Errorf("Failed versions were not cleared: %+v", failed)
+	}
+}
+
+func testRollouts(t *testing.T, database db.Database) {
+	ctx := context.Background()
+	machine := registerMachine(t, database, "web-1")
+	id, err := database.PutRollout(ctx, domain.NewRollout{
+		PackageName:     "hello",
+		PackageVersion:  "2.0",
+		Selector:        domain.MachineFilter{Group: "web"},
+		CanaryCount:     1,
+		WavePercentages: []int{50, 100},
+		MaxFailureRate:  0.1,
+		Soak:            time.Minute,
+		CreatedBy:       "admin",
+	}, []domain.RolloutMachine{{MachineID: machine.ID, Wave: 0}})
+	must(t, err)
+
+	rollout, err := database.GetRollout(ctx, id)
+	must(t, err)
+	if rollout.State != domain.RolloutRunning || rollout.MaxFailureRate != 0.1 || rollout.Soak != time.Minute {
+		t.Errorf("Rollout is not stored: %+v", rollout)
+	}
+	rollouts, err := database.ListMachineRollouts(ctx, machine.ID)
+	must(t, err)
+	if len(rollouts) != 1 || rollouts[0].ID != id {
+		t.Errorf("Rollout didn't reach machine in canary wave: %+v", rollouts)
+	}
+
+	rollout.State = domain.RolloutAborted
+	must(t, database.UpdateRolloutState(ctx, rollout))
+	rollouts, err = database.ListMachineRollouts(ctx, machine.ID)
+	must(t, err)
+	if len(rollouts) != 0 {
+		t.Errorf("Aborted rollout is listed for machine: %+v", rollouts)
+	}
+}
+
+func testSecrets(t *testing.T, database db.Database) {
+	ctx := context.Background()
+	machine := registerMachine(t, database, "web-1")
+	secret := domain.Secret{
+		MachineID:  machine.ID,
+		Name:       "token",
+		Ciphertext: []byte{0, 1, 2, 255},
+		Hash:       "hash",
+		Owner:      "root",
+		Group:      "root",
+		Mode:       0400,
+		UpdatedBy:  "admin",
+	}
+	must(t, database.PutSecret(ctx, secret))
+	secret.Ciphertext = []byte{3}
+	must(t, database.PutSecret(ctx, secret))
+
+	secrets, err := database.ListSecrets(ctx)
+	must(t, err)
+	if len(secrets) != 1 || secrets[0].MachineName != "web-1" || !bytes.Equal(secrets[0].Ciphertext, []byte{3}) || secrets[0].Mode != 0400 {
+		t.Errorf("Secret must be replaced: %+v", secrets)
+	}
+
+	must(t, database.DeleteSecret(ctx, machine.ID, "token"))
+	err = database.DeleteSecret(ctx, machine.ID, "token")
+	expectError(t, err, db.ErrNotFound)
+}
+
+func testOutbox(t *testing.T, database db.Database) {
+	ctx := context.Background()
+	latest, err := database.GetLatestChangeID(ctx)
+	must(t, err)
+	if latest != 0 {
+		t.Errorf("Latest change of empty outbox is %d", latest)
+	}
+
+	must(t, database.PutAssignment(ctx, domain.Assignment{Target: domain.AssignmentTarget{Machine: "web-1"}, PackageName: "hello"}))
+	change, err := domain.NewChange(domain.ChangeNarInfo, "hash", map[string]string{})
+	must(t, err)
+	id, err := database.PutChange(ctx, change)
+	must(t, err)
+
+	changes, err := database.ListChanges(ctx, 0, 10)
+	must(t, err)
+	if len(changes) != 2 || changes[0].Kind != domain.ChangeAssignmentPut || changes[1].ID != id || changes[0].ID >= id {
+		t.Fatalf("Changes must be listed in order they were appended: %+v", changes)
+	}
+	changes, err = database.ListChanges(ctx, changes[0].ID, 10)
+	must(t, err)
+	if len(changes) != 1 || changes[0].Subject != "hash" {
+		t.Errorf("Changes following the cursor are not listed: %+v", changes)
+	}
+
+	deleted, err := database.DeleteChangesBefore(ctx, time.Now().Add(time.Hour))
+	must(t, err)
+	if deleted != 1 {
+		t.Errorf("Deleted %d changes, the latest change must be kept", deleted)
+	}
+	oldest, err := database.GetOldestChangeID(ctx)
+	must(t, err)
+	if oldest != id {
+		t.Errorf("Oldest change is %d, expected %d", oldest, id)
+	}
+	next, err := database.PutChange(ctx, change)
+	must(t, err)
+	if next <= id {
+		t.Errorf("Id %d of the change is not greater than %d after pruning", next, id)
+	}
+}
+
+func testWebhooks(t *testing.T, database db.Database) {
+	ctx := context.Background()
+	_, err := database.GetWebhookCursor(ctx, "audit")
+	expectError(t, err, db.ErrNotFound)
+	must(t, database.PutWebhookCursor(ctx, "audit", 5))
+	must(t, database.PutWebhookCursor(ctx, "audit", 7))
+	cursor, err := database.GetWebhookCursor(ctx, "audit")
+	must(t, err)
+	if cursor != 7 {
+		t.Errorf("Cursor is %d, expected 7", cursor)
+	}
+
+	createdAt := time.Now().Add(-time.Hour)
+	for attempt, webhook := range []string{"audit", "audit", "chat"} {
+		must(t, database.PutWebhookDelivery(ctx, domain.WebhookDelivery{
+			Webhook:    webhook,
+			ChangeID:   7,
+			Kind:       domain.ChangeNarInfo,
+			Attempt:    attempt + 1,
+			StatusCode: 500,
+			Error:      "Webhook responded with 500",
+			Duration:   20 * time.Millisecond,
+			CreatedAt:  createdAt,
+		}))
+	}
+	deliveries, err := database.ListWebhookDeliveries(ctx, "audit", 1)
+	must(t, err)
+	if len(deliveries) != 1 || deliveries[0].Attempt != 2 || deliveries[0].Duration != 20*time.Millisecond {
+		t.Errorf("The newest delivery of the webhook must be listed: %+v", deliveries)
+	}
+	deliveries, err = database.ListWebhookDeliveries(ctx, "", 10)
+	must(t, err)
+	if len(deliveries) != 3 {
+		t.Errorf("Listed %d deliveries of all webhooks", len(deliveries))
+	}
+
+	deleted, err := database.DeleteWebhookDeliveriesBefore(ctx, time.Now())
+	must(t, err)
+	if deleted != 3 {
+		t.Errorf("Deleted %d deliveries, expected 3", deleted)
+	}
+}
+
+func registerMachine(t *testing.T, database db.Database, name string) domain.Machine {
+	t.Helper()
+	ctx := context.Background()
+	must(t, database.PutBootstrapToken(ctx, name, domain.BootstrapToken{
+		CreatedBy: "admin",
+		Groups:    []string{"web"},
+		ExpiresAt: time.Now().Add(time.Hour),
+	}))
+	machine, err := database.RegisterMachine(ctx, name, domain.NewMachine{Name: name, System: "x86_64-linux", PublicKey: make([]byte, 32)})
+	must(t, err)
+
+	return machine
+}
+
+func must(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func expectError(t *testing.T, err, expected error) {
+	t.Helper()
+	if !errors.Is(err, expected) {
+		t.Errorf("Expected error %v, got %v", expected, err)
+	}
+}
@@ -0,0 +1,87 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/pressly/goose/v3"
+	_ "modernc.org/sqlite"
+)
+
+// Open connects to the database of the DSN and migrates it. DSN starting with postgres:// or postgresql:// is
+// PostgreSQL connection string, anything else is path of sqlite file. Migrations of sqlite are at the root of
+// migrations, migrations of PostgreSQL are in its postgres directory.
+func Open(ctx context.Context, dsn string, migrations fs.FS) (Database, error) {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		return openPostgres(ctx, dsn, migrations)
+	}
+
+	return openSqlite(ctx, dsn, migrations)
+}
+
+func openSqlite(ctx context.Context, path string, migrations fs.FS) (Database, error) {
+	conn, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open sqlite db: %w", err)
+	}
+	conn.SetMaxOpenConns(1)
+
+	err = migrate(ctx, goose.DialectSQLite3, conn, migrations)
+	if err != nil {
+		return nil, err
+	}
+
+	err = conn.PingContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewDatabase(conn), nil
+}
+
+func openPostgres(ctx context.Context, dsn string, migrations fs.FS) (Database, error) {
+	pool, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open postgres db: %w", err)
+	}
+	err = pool.Ping(ctx)
+	if err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("Failed to ping postgres db: %w", err)
+	}
+
+	postgresMigrations, err := fs.Sub(migrations, "postgres")
+	if err != nil {
+		pool.Close()
+		return nil, err
+	}
+	// Closing the database/sql handle leaves the pool open
+	conn := stdlib.OpenDBFromPool(pool)
+	defer conn.Close()
+	err = migrate(ctx, goose.DialectPostgres, conn, postgresMigrations)
+	if err != nil {
+		pool.Close()
+		return nil, err
+	}
+
+	return NewPostgresDatabase(pool), nil
+}
+
+func migrate(ctx context.Context, dialect goose.Dialect, conn *sql.DB, migrations fs.FS) error {
+	g, err := goose.NewProvider(dialect, conn, migrations)
+	if err != nil {
+		return err
+	}
+
+	_, err = g.Up(ctx)
+	if err != nil {
+		return fmt.Errorf("Failed to run goose db migrations: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,332 @@
+package db
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	postgres_queries "server/internal/db/postgres_generated"
+	sqlite_queries "server/internal/db/sqlite_generated"
+	"server/internal/domain"
+	"slices"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// NewPostgresDatabase stores state of the hub in PostgreSQL, so multiple servers can share it.
+//
+// The schema matches the sqlite one, so rows generated for both have the same fields. Postgres rows are
+// converted to sqlite ones to share their mapping to the domain, the conversion fails to compile when
+// the schemas diverge.
+func NewPostgresDatabase(pool *pgxpool.Pool) Database {
+	return &postgresDatabase{
+		pool: pool,
+		q:    postgres_queries.New(pool),
+	}
+}
+
+type postgresDatabase struct {
+	pool *pgxpool.Pool
+	q    *postgres_queries.Queries
+}
+
+// ListPackages implements Database.
+func (s *postgresDatabase) ListPackages(ctx context.Context, filter domain.PackageFilter) ([]domain.Package, error) {
+	packages, err := s.q.ListPackages(ctx, postgres_queries.ListPackagesParams{
+		IncludeYanked: filter.IncludeYanked,
+		System:        filter.System,
+	})
+	if err != nil {
+		return nil, err
+	}
+	rows := []postgres_queries.Package{}
+	for _, p := range packages {
+		rows = append(rows, p.Package)
+	}
+
+	return s.mapPackages(ctx, rows)
+}
+
+// GetPackage implements Database.
+func (s *postgresDatabase) GetPackage(ctx context.Context, ref domain.PackageRef) (domain.Package, error) {
+	var pkg postgres_queries.Package
+	if ref.Version == "" {
+		row, err := s.q.GetLatestPackage(ctx, postgres_queries.GetLatestPackageParams{
+			Name:   ref.Name,
+			System: ref.System,
+		})
+		if err != nil {
+			return domain.Package{}, mapError(err)
+		}
+		pkg = row.Package
+	} else {
+		row, err := s.q.GetPackage(ctx, postgres_queries.GetPackageParams{
+			Name:    ref.Name,
+			Version: ref.Version,
+			System:  ref.System,
+		})
+		if err != nil {
+			return domain.Package{}, mapError(err)
+		}
+		pkg = row.Package
+	}
+
+	packages, err := s.mapPackages(ctx, []postgres_queries.Package{pkg})
+	if err != nil {
+		return domain.Package{}, err
+	}
+
+	return packages[0], nil
+}
+
+// PutPackage implements Database.
+func (s *postgresDatabase) PutPackage(ctx context.Context, pkg domain.NewPackage) error {
+	return s.inTx(ctx, func(q *postgres_queries.Queries) error {
+		licenses, err := json.Marshal(nonNil(pkg.NixMetadata.Licenses))
+		if err != nil {
+			return err
+		}
+		platforms, err := json.Marshal(nonNil(pkg.NixMetadata.Platforms))
+		if err != nil {
+			return err
+		}
+
+		id, err := q.InsertPackage(ctx, postgres_queries.InsertPackageParams{
+			Name:         pkg.Name,
+			Version:      pkg.Version,
+			NixStoreHash: pkg.NixMetadata.StorePath,
+			NixMainBin:   pkg.NixMetadata.MainBin,
+			System:       pkg.System,
+			Description:  pkg.NixMetadata.Description,
+			Licenses:     string(licenses),
+			Homepage:     pkg.NixMetadata.Homepage,
+			Platforms:    string(platforms),
+			Unfree:       pkg.NixMetadata.Unfree,
+			Insecure:     pkg.NixMetadata.Insecure,
+			Position:     pkg.NixMetadata.Position,
+			Kind:         string(cmp.Or(pkg.Kind, domain.PackageKindApp)),
+		})
+		if err != nil {
+			return err
+		}
+
+		err = q.InsertGcRoot(ctx, pkg.NixMetadata.StorePath)
+		if err != nil {
+			return err
+		}
+
+		if pkg.Provenance != nil {
+			ci, err := json.Marshal(pkg.Provenance.Ci)
+			if err != nil {
+				return err
+			}
+			err = q.InsertPackageProvenance(ctx, postgres_queries.InsertPackageProvenanceParams{
+				PackageID:     id,
+				FlakeUrl:      pkg.Provenance.FlakeUrl,
+				FlakeRevision: pkg.Provenance.FlakeRevision,
+				FlakeNarHash:  pkg.Provenance.FlakeNarHash,
+				DrvPath:       pkg.Provenance.DrvPath,
+				BuilderHost:   pkg.Provenance.BuilderHost,
+				ClientVersion: pkg.Provenance.ClientVersion,
+				Ci:            string(ci),
+				Signature:     pkg.Provenance.Signature,
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		for name, storePath := range pkg.NixMetadata.Outputs {
+			err = q.InsertPackageOutput(ctx, postgres_queries.InsertPackageOutputParams{
+				PackageID: id,
+				Name:      name,
+				StorePath: storePath,
+				Install:   slices.Contains(pkg.NixMetadata.OutputsToInstall, name),
+			})
+			if err != nil {
+				return err
+			}
+
+			err = q.InsertGcRoot(ctx, storePath)
+			if err != nil {
+				return err
+			}
+		}
+
+		return putPostgresChange(ctx, q, domain.ChangePackagePushed, pkg.Name, pkg)
+	})
+}
+
+// YankPackage implements Database.
+func (s *postgresDatabase) YankPackage(ctx context.Context, ref domain.PackageRef, reason string) error {
+	return s.inTx(ctx, func(q *postgres_queries.Queries) error {
+		yankedAt := time.Now().UTC()
+		affected, err := q.YankPackage(ctx, postgres_queries.YankPackageParams{
+			YankedAt:   &yankedAt,
+			YankReason: &reason,
+			Name:       ref.Name,
+			Version:    ref.Version,
+			System:     ref.System,
+		})
+		if err != nil {
+			return err
+		}
+		if affected == 0 {
+			return ErrNotFound
+		}
+
+		return putPostgresChange(ctx, q, domain.ChangePackageYanked, ref.Name, domain.PackageYankChange{
+			Ref:    ref,
+			Reason: reason,
+		})
+	})
+}
+
+// DeletePackage implements Database.
+func (s *postgresDatabase) DeletePackage(ctx context.Context, ref domain.PackageRef, dropGcRoot bool) error {
+	return s.inTx(ctx, func(q *postgres_queries.Queries) error {
+		err := q.DeletePackageProvenances(ctx, postgres_queries.DeletePackageProvenancesParams{
+			Name:    ref.Name,
+			Version: ref.Version,
+			System:  ref.System,
+		})
+		if err != nil {
+			return err
+		}
+		err = q.DeletePackageVulnerabilities(ctx, postgres_queries.DeletePackageVulnerabilitiesParams{
+			Name:    ref.Name,
+			Version: ref.Version,
+			System:  ref.System,
+		})
+		if err != nil {
+			return err
+		}
+		outputPaths, err := q.DeletePackageOutputs(ctx, postgres_queries.DeletePackageOutputsParams{
+			Name:    ref.Name,
+			Version: ref.Version,
+			System:  ref.System,
+		})
+		if err != nil {
+			return err
+		}
+		storePaths, err := q.DeletePackage(ctx, postgres_queries.DeletePackageParams{
+			Name:    ref.Name,
+			Version: ref.Version,
+			System:  ref.System,
+		})
+		if err != nil {
+			return err
+		}
+		if len(storePaths) == 0 {
+			return ErrNotFound
+		}
+		err = putPostgresChange(ctx, q, domain.ChangePackageDeleted, ref.Name, domain.PackageDeleteChange{
+			Ref:        ref,
+			DropGcRoot: dropGcRoot,
+		})
+		if err != nil {
+			return err
+		}
+		if !dropGcRoot {
+			return nil
+		}
+
+		storePaths = append(storePaths, outputPaths...)
+		dropped := []string{}
+		for _, storePath := range storePaths {
+			deleted, err := q.DeleteUnusedGcRoot(ctx, storePath)
+			if err != nil {
+				return err
+			}
+			if deleted > 0 {
+				dropped = append(dropped, storePath)
+			}
+		}
+		if len(dropped) == 0 {
+			return nil
+		}
+
+		return putPostgresChange(ctx, q, domain.ChangeGcRun, ref.Name, domain.GcRunChange{
+			Ref:          ref,
+			DroppedRoots: dropped,
+		})
+	})
+}
+
+func (s *postgresDatabase) inTx(ctx context.Context, fn func(q *postgres_queries.Queries) error) error {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	err = fn(s.q.WithTx(tx))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// mapPackages maps package rows together with their outputs, provenance and vulnerabilities.
+func (s *postgresDatabase) mapPackages(ctx context.Context, packages []postgres_queries.Package) ([]domain.Package, error) {
+	mappedPackages := []domain.Package{}
+	if len(packages) == 0 {
+		return mappedPackages, nil
+	}
+
+	ids := []int64{}
+	for _, p := range packages {
+		ids = append(ids, p.ID)
+	}
+
+	outputRows, err := s.q.ListPackageOutputs(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	outputs := map[int64][]sqlite_queries.PackageOutput{}
+	for _, o := range outputRows {
+		outputs[o.PackageID] = append(outputs[o.PackageID], sqlite_queries.PackageOutput(o))
+	}
+
+	provenanceRows, err := s.q.ListPackageProvenances(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	provenances := map[int64]postgres_queries.PackageProvenance{}
+	for _, p := range provenanceRows {
+		provenances[p.PackageID] = p
+	}
+
+	vulnerabilityRows, err := s.q.ListVulnerabilities(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	vulnerabilities := map[int64][]domain.Vulnerability{}
+	for _, v := range vulnerabilityRows {
+		vulnerability, err := mapVulnerability(sqlite_queries.ListVulnerabilitiesRow(v))
+		if err != nil {
+			return nil, err
+		}
+		vulnerabilities[v.PackageID] = append(vulnerabilities[v.PackageID], vulnerability)
+	}
+
+	for _, p := range packages {
+		pkg, err := mapPackage(sqlite_queries.Package(p), outputs[p.ID])
+		if err != nil {
+			return nil, err
+		}
+		pkg.Vulnerabilities = nonNil(vulnerabilities[p.ID])
+		if provenance, ok := provenances[p.ID]; ok {
+			pkg.Provenance, err = mapProvenance(sqlite_queries.PackageProvenance(provenance))
+			if err != nil {
+				return nil, err
+			}
+		}
+		mappedPackages = append(mappedPackages, pkg)
+	}
+
+	return mappedPackages, nil
+}
+
+var _ (Database) = (*postgresDatabase)(nil)
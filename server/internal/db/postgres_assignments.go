@@ -0,0 +1,196 @@
+package db
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	postgres_queries "server/internal/db/postgres_generated"
+	sqlite_queries "server/internal/db/sqlite_generated"
+	"server/internal/domain"
+	"strconv"
+	"time"
+)
+
+// PutAssignment implements Database.
+func (s *postgresDatabase) PutAssignment(ctx context.Context, assignment domain.Assignment) error {
+	return s.inTx(ctx, func(q *postgres_queries.Queries) error {
+		err := q.UpsertAssignment(ctx, postgres_queries.UpsertAssignmentParams{
+			MachineName:    assignment.Target.Machine,
+			MachineGroup:   assignment.Target.Group,
+			PackageName:    assignment.PackageName,
+			PackageVersion: assignment.PackageVersion,
+			ActivationMode: string(cmp.Or(assignment.Mode, domain.ActivationSwitch)),
+			Emergency:      assignment.Emergency,
+			CreatedAt:      time.Now().UTC(),
+		})
+		if err != nil {
+			return err
+		}
+
+		return putPostgresChange(ctx, q, domain.ChangeAssignmentPut, assignment.PackageName, assignment)
+	})
+}
+
+// DeleteAssignment implements Database.
+func (s *postgresDatabase) DeleteAssignment(ctx context.Context, target domain.AssignmentTarget, packageName string) error {
+	return s.inTx(ctx, func(q *postgres_queries.Queries) error {
+		affected, err := q.DeleteAssignment(ctx, postgres_queries.DeleteAssignmentParams{
+			MachineName:  target.Machine,
+			MachineGroup: target.Group,
+			PackageName:  packageName,
+		})
+		if err != nil {
+			return err
+		}
+		if affected == 0 {
+			return ErrNotFound
+		}
+
+		return putPostgresChange(ctx, q, domain.ChangeAssignmentDeleted, packageName, domain.AssignmentDeleteChange{
+			Target:      target,
+			PackageName: packageName,
+		})
+	})
+}
+
+// ListAssignments implements Database.
+func (s *postgresDatabase) ListAssignments(ctx context.Context) ([]domain.Assignment, error) {
+	rows, err := s.q.ListAssignments(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	assignments := []domain.Assignment{}
+	for _, row := range rows {
+		assignments = append(assignments, domain.Assignment{
+			ID: row.Assignment.ID,
+			Target: domain.AssignmentTarget{
+				Machine: row.Assignment.MachineName,
+				Group:   row.Assignment.MachineGroup,
+			},
+			PackageName:    row.Assignment.PackageName,
+			PackageVersion: row.Assignment.PackageVersion,
+			Mode:           domain.ActivationMode(row.Assignment.ActivationMode),
+			Emergency:      row.Assignment.Emergency,
+			CreatedAt:      row.Assignment.CreatedAt,
+		})
+	}
+
+	return assignments, nil
+}
+
+// PutActivation implements Database.
+func (s *postgresDatabase) PutActivation(ctx context.Context, activation domain.Activation) error {
+	storePaths, err := json.Marshal(nonNil(activation.StorePaths))
+	if err != nil {
+		return err
+	}
+
+	return s.inTx(ctx, func(q *postgres_queries.Queries) error {
+		err := q.InsertActivation(ctx, postgres_queries.InsertActivationParams{
+			MachineID:  activation.MachineID,
+			StorePaths: string(storePaths),
+			Success:    activation.Success,
+			Error:      activation.Error,
+			RolledBack: activation.RolledBack,
+			Logs:       activation.Logs,
+			ReportedAt: activation.ReportedAt.UTC(),
+		})
+		if err != nil {
+			return err
+		}
+
+		return putPostgresChange(ctx, q, domain.ChangeActivationReported, strconv.FormatInt(activation.MachineID, 10), activation)
+	})
+}
+
+// GetLatestActivation implements Database.
+func (s *postgresDatabase) GetLatestActivation(ctx context.Context, machineId int64) (domain.Activation, error) {
+	row, err := s.q.GetLatestActivation(ctx, machineId)
+	if err != nil {
+		return domain.Activation{}, mapError(err)
+	}
+
+	return mapActivation(sqlite_queries.Activation(row.Activation))
+}
+
+// GetLatestSuccessfulActivation implements Database.
+func (s *postgresDatabase) GetLatestSuccessfulActivation(ctx context.Context, machineId int64) (domain.Activation, error) {
+	row, err := s.q.GetLatestSuccessfulActivation(ctx, machineId)
+	if err != nil {
+		return domain.Activation{}, mapError(err)
+	}
+
+	return mapActivation(sqlite_queries.Activation(row.Activation))
+}
+
+// PutFailedVersion implements Database.
+func (s *postgresDatabase) PutFailedVersion(ctx context.Context, failed domain.FailedVersion) error {
+	return s.inTx(ctx, func(q *postgres_queries.Queries) error {
+		affected, err := q.InsertFailedVersion(ctx, postgres_queries.InsertFailedVersionParams{
+			PackageName:    failed.PackageName,
+			PackageVersion: failed.PackageVersion,
+			GroupName:      failed.Group,
+			MachineID:      failed.MachineID,
+			Reason:         failed.Reason,
+			FailedAt:       failed.FailedAt.UTC(),
+		})
+		if err != nil {
+			return err
+		}
+		if affected == 0 {
+			// The first failure of the version is kept
+			return nil
+		}
+
+		return putPostgresChange(ctx, q, domain.ChangeFailedVersionPut, failed.PackageName, failed)
+	})
+}
+
+// ListFailedVersions implements Database.
+func (s *postgresDatabase) ListFailedVersions(ctx context.Context, ref domain.PackageRef) ([]domain.FailedVersion, error) {
+	rows, err := s.q.ListFailedVersions(ctx, postgres_queries.ListFailedVersionsParams{
+		PackageName:    ref.Name,
+		PackageVersion: ref.Version,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	failed := []domain.FailedVersion{}
+	for _, row := range rows {
+		failed = append(failed, domain.FailedVersion{
+			PackageName:    row.FailedVersion.PackageName,
+			PackageVersion: row.FailedVersion.PackageVersion,
+			Group:          row.FailedVersion.GroupName,
+			MachineID:      row.FailedVersion.MachineID,
+			MachineName:    row.MachineName,
+			Reason:         row.FailedVersion.Reason,
+			FailedAt:       row.FailedVersion.FailedAt,
+		})
+	}
+
+	return failed, nil
+}
+
+// DeleteFailedVersion implements Database.
+func (s *postgresDatabase) DeleteFailedVersion(ctx context.Context, ref domain.PackageRef, group string) error {
+	return s.inTx(ctx, func(q *postgres_queries.Queries) error {
+		affected, err := q.DeleteFailedVersion(ctx, postgres_queries.DeleteFailedVersionParams{
+			PackageName:    ref.Name,
+			PackageVersion: ref.Version,
+			GroupName:      group,
+		})
+		if err != nil {
+			return err
+		}
+		if affected == 0 {
+			return ErrNotFound
+		}
+
+		return putPostgresChange(ctx, q, domain.ChangeFailedVersionDeleted, ref.Name, domain.FailedVersionDeleteChange{
+			Ref:   ref,
+			Group: group,
+		})
+	})
+}
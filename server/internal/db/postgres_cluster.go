@@ -0,0 +1,44 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	postgres_queries "server/internal/db/postgres_generated"
+	"server/internal/domain"
+	"time"
+)
+
+// GetClusterMembership implements Database.
+func (s *postgresDatabase) GetClusterMembership(ctx context.Context, node string) (domain.ClusterMembership, error) {
+	row, err := s.q.GetClusterMembership(ctx, node)
+	if err != nil {
+		return domain.ClusterMembership{}, mapError(err)
+	}
+	nodes := []string{}
+	err = json.Unmarshal([]byte(row.ClusterMembership.Nodes), &nodes)
+	if err != nil {
+		return domain.ClusterMembership{}, fmt.Errorf("Failed to decode nodes of cluster: %w", err)
+	}
+
+	return domain.ClusterMembership{
+		Nodes:             nodes,
+		ReplicationFactor: int(row.ClusterMembership.ReplicationFactor),
+		UpdatedAt:         row.ClusterMembership.UpdatedAt,
+	}, nil
+}
+
+// PutClusterMembership implements Database.
+func (s *postgresDatabase) PutClusterMembership(ctx context.Context, node string, membership domain.ClusterMembership) error {
+	nodes, err := json.Marshal(nonNil(membership.Nodes))
+	if err != nil {
+		return err
+	}
+
+	return s.q.UpsertClusterMembership(ctx, postgres_queries.UpsertClusterMembershipParams{
+		Node:              node,
+		Nodes:             string(nodes),
+		ReplicationFactor: int64(membership.ReplicationFactor),
+		UpdatedAt:         time.Now().UTC(),
+	})
+}
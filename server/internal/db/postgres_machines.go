@@ -0,0 +1,316 @@
+package db
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	postgres_queries "server/internal/db/postgres_generated"
+	sqlite_queries "server/internal/db/sqlite_generated"
+	"server/internal/domain"
+	"strconv"
+	"time"
+)
+
+// PutBootstrapToken implements Database.
+func (s *postgresDatabase) PutBootstrapToken(ctx context.Context, tokenHash string, token domain.BootstrapToken) error {
+	groups, err := json.Marshal(nonNil(token.Groups))
+	if err != nil {
+		return err
+	}
+	labels, err := json.Marshal(nonNilMap(token.Labels))
+	if err != nil {
+		return err
+	}
+
+	return s.inTx(ctx, func(q *postgres_queries.Queries) error {
+		err := q.InsertBootstrapToken(ctx, postgres_queries.InsertBootstrapTokenParams{
+			TokenHash:  tokenHash,
+			CreatedBy:  token.CreatedBy,
+			GroupNames: string(groups),
+			Labels:     string(labels),
+			ExpiresAt:  token.ExpiresAt.UTC(),
+		})
+		if err != nil {
+			return err
+		}
+
+		// Hash of the token is not recorded
+		return putPostgresChange(ctx, q, domain.ChangeBootstrapTokenCreated, token.CreatedBy, token)
+	})
+}
+
+// RegisterMachine implements Database.
+func (s *postgresDatabase) RegisterMachine(ctx context.Context, tokenHash string, machine domain.NewMachine) (domain.Machine, error) {
+	var registered domain.Machine
+	err := s.inTx(ctx, func(q *postgres_queries.Queries) error {
+		row, err := q.GetBootstrapToken(ctx, tokenHash)
+		if err != nil {
+			if errors.Is(mapError(err), ErrNotFound) {
+				return ErrInvalidToken
+			}
+			return err
+		}
+		token := row.BootstrapToken
+		now := time.Now().UTC()
+		if token.UsedAt != nil || now.After(token.ExpiresAt) {
+			return ErrInvalidToken
+		}
+
+		_, err = q.GetMachineByName(ctx, machine.Name)
+		if err == nil {
+			return fmt.Errorf("Machine %s: %w", machine.Name, ErrAlreadyExists)
+		}
+		if !errors.Is(mapError(err), ErrNotFound) {
+			return err
+		}
+
+		// Labels of the token take precedence over labels requested by the machine
+		labels := map[string]string{}
+		maps.Copy(labels, machine.Labels)
+		tokenLabels := map[string]string{}
+		err = json.Unmarshal([]byte(token.Labels), &tokenLabels)
+		if err != nil {
+			return fmt.Errorf("Failed to decode labels of bootstrap token: %w", err)
+		}
+		maps.Copy(labels, tokenLabels)
+		encodedLabels, err := json.Marshal(labels)
+		if err != nil {
+			return err
+		}
+
+		inserted, err := q.InsertMachine(ctx, postgres_queries.InsertMachineParams{
+			Name:       machine.Name,
+			System:     machine.System,
+			PublicKey:  base64.StdEncoding.EncodeToString(machine.PublicKey),
+			Labels:     string(encodedLabels),
+			GroupNames: token.GroupNames,
+			EnrolledAt: now,
+		})
+		if err != nil {
+			return err
+		}
+
+		affected, err := q.UseBootstrapToken(ctx, postgres_queries.UseBootstrapTokenParams{
+			UsedAt:    &now,
+			MachineID: &inserted.ID,
+			TokenHash: tokenHash,
+		})
+		if err != nil {
+			return err
+		}
+		if affected == 0 {
+			return ErrInvalidToken
+		}
+
+		registered, err = mapMachine(sqlite_queries.Machine(inserted))
+		if err != nil {
+			return err
+		}
+
+		return putPostgresChange(ctx, q, domain.ChangeMachineRegistered, registered.Name, registered)
+	})
+	if err != nil {
+		return domain.Machine{}, err
+	}
+
+	return registered, nil
+}
+
+// ListMachines implements Database.
+func (s *postgresDatabase) ListMachines(ctx context.Context, filter domain.MachineFilter) ([]domain.Machine, error) {
+	rows, err := s.q.ListMachines(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	statusRows, err := s.q.ListMachineStatuses(ctx)
+	if err != nil {
+		return nil, err
+	}
+	statuses := map[int64]postgres_queries.MachineStatus{}
+	for _, row := range statusRows {
+		statuses[row.MachineStatus.MachineID] = row.MachineStatus
+	}
+	driftRows, err := s.q.ListMachineDrifts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	drifts := map[int64]postgres_queries.MachineDrift{}
+	for _, row := range driftRows {
+		drifts[row.MachineDrift.MachineID] = row.MachineDrift
+	}
+
+	machines := []domain.Machine{}
+	for _, row := range rows {
+		machine, err := mapMachine(sqlite_queries.Machine(row.Machine))
+		if err != nil {
+			return nil, err
+		}
+		if status, ok := statuses[machine.ID]; ok {
+			machine.Status, err = mapMachineStatus(sqlite_queries.MachineStatus(status))
+			if err != nil {
+				return nil, err
+			}
+		}
+		if drift, ok := drifts[machine.ID]; ok {
+			machine.Drift, err = mapMachineDrift(sqlite_queries.MachineDrift(drift))
+			if err != nil {
+				return nil, err
+			}
+		}
+		if filter.Matches(machine) {
+			machines = append(machines, machine)
+		}
+	}
+
+	return machines, nil
+}
+
+// GetMachine implements Database.
+func (s *postgresDatabase) GetMachine(ctx context.Context, id int64) (domain.Machine, error) {
+	row, err := s.q.GetMachine(ctx, id)
+	if err != nil {
+		return domain.Machine{}, mapError(err)
+	}
+
+	return s.mapMachineWithStatus(ctx, row.Machine)
+}
+
+// GetMachineByName implements Database.
+func (s *postgresDatabase) GetMachineByName(ctx context.Context, name string) (domain.Machine, error) {
+	row, err := s.q.GetMachineByName(ctx, name)
+	if err != nil {
+		return domain.Machine{}, mapError(err)
+	}
+
+	return s.mapMachineWithStatus(ctx, row.Machine)
+}
+
+// PutMachineStatus implements Database.
+func (s *postgresDatabase) PutMachineStatus(ctx context.Context, machineId int64, status domain.MachineStatus) error {
+	storePaths, err := json.Marshal(nonNil(status.StorePaths))
+	if err != nil {
+		return err
+	}
+	stagedStorePaths, err := json.Marshal(nonNil(status.StagedStorePaths))
+	if err != nil {
+		return err
+	}
+
+	return s.inTx(ctx, func(q *postgres_queries.Queries) error {
+		changed := true
+		row, err := q.GetMachineStatus(ctx, machineId)
+		if err == nil {
+			previous, err := mapMachineStatus(sqlite_queries.MachineStatus(row.MachineStatus))
+			if err != nil {
+				return err
+			}
+			changed = !previous.Equal(status)
+		} else if !errors.Is(mapError(err), ErrNotFound) {
+			return err
+		}
+
+		err = q.UpsertMachineStatus(ctx, postgres_queries.UpsertMachineStatusParams{
+			MachineID:         machineId,
+			ProfileGeneration: status.ProfileGeneration,
+			StorePaths:        string(storePaths),
+			NixVersion:        status.NixVersion,
+			FreeDiskBytes:     status.FreeDiskBytes,
+			LastError:         status.LastError,
+			RunningSystem:     status.RunningSystem,
+			BootedSystem:      status.BootedSystem,
+			StagedStorePaths:  string(stagedStorePaths),
+			PeerUrl:           status.PeerUrl,
+			ReportedAt:        status.ReportedAt.UTC(),
+		})
+		if err != nil || !changed {
+			return err
+		}
+
+		return putPostgresChange(ctx, q, domain.ChangeMachineStatus, strconv.FormatInt(machineId, 10), domain.MachineStatusChange{
+			MachineID: machineId,
+			Status:    status,
+		})
+	})
+}
+
+func (s *postgresDatabase) mapMachineWithStatus(ctx context.Context, m postgres_queries.Machine) (domain.Machine, error) {
+	machine, err := mapMachine(sqlite_queries.Machine(m))
+	if err != nil {
+		return domain.Machine{}, err
+	}
+	row, err := s.q.GetMachineStatus(ctx, m.ID)
+	if err != nil {
+		if errors.Is(mapError(err), ErrNotFound) {
+			return machine, nil
+		}
+		return domain.Machine{}, err
+	}
+	machine.Status, err = mapMachineStatus(sqlite_queries.MachineStatus(row.MachineStatus))
+	if err != nil {
+		return domain.Machine{}, err
+	}
+	driftRow, err := s.q.GetMachineDrift(ctx, m.ID)
+	if err != nil {
+		if errors.Is(mapError(err), ErrNotFound) {
+			return machine, nil
+		}
+		return domain.Machine{}, err
+	}
+	machine.Drift, err = mapMachineDrift(sqlite_queries.MachineDrift(driftRow.MachineDrift))
+	if err != nil {
+		return domain.Machine{}, err
+	}
+
+	return machine, nil
+}
+
+// PutMachineDrift implements Database.
+func (s *postgresDatabase) PutMachineDrift(ctx context.Context, drift domain.MachineDrift) error {
+	missing, err := json.Marshal(nonNil(drift.Missing))
+	if err != nil {
+		return err
+	}
+	unexpected, err := json.Marshal(nonNil(drift.Unexpected))
+	if err != nil {
+		return err
+	}
+	var since *time.Time
+	if drift.Since != nil {
+		utc := drift.Since.UTC()
+		since = &utc
+	}
+
+	return s.inTx(ctx, func(q *postgres_queries.Queries) error {
+		changed := true
+		row, err := q.GetMachineDrift(ctx, drift.MachineID)
+		if err == nil {
+			previous, err := mapMachineDrift(sqlite_queries.MachineDrift(row.MachineDrift))
+			if err != nil {
+				return err
+			}
+			changed = !previous.Equal(drift)
+		} else if !errors.Is(mapError(err), ErrNotFound) {
+			return err
+		}
+
+		err = q.UpsertMachineDrift(ctx, postgres_queries.UpsertMachineDriftParams{
+			MachineID:     drift.MachineID,
+			Missing:       string(missing),
+			Unexpected:    string(unexpected),
+			DesiredSystem: drift.DesiredSystem,
+			ActualSystem:  drift.ActualSystem,
+			Error:         drift.Error,
+			Since:         since,
+			CheckedAt:     drift.CheckedAt.UTC(),
+		})
+		if err != nil || !changed {
+			return err
+		}
+
+		return putPostgresChange(ctx, q, domain.ChangeMachineDrift, strconv.FormatInt(drift.MachineID, 10), drift)
+	})
+}
@@ -0,0 +1,86 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	postgres_queries "server/internal/db/postgres_generated"
+	"server/internal/domain"
+	"strconv"
+	"time"
+)
+
+// PutMaintenanceWindow implements Database.
+func (s *postgresDatabase) PutMaintenanceWindow(ctx context.Context, window domain.MaintenanceWindow) (int64, error) {
+	days, err := json.Marshal(nonNil(window.Days))
+	if err != nil {
+		return 0, err
+	}
+
+	var id int64
+	err = s.inTx(ctx, func(q *postgres_queries.Queries) error {
+		id, err = q.InsertMaintenanceWindow(ctx, postgres_queries.InsertMaintenanceWindowParams{
+			GroupName:   window.Group,
+			Days:        string(days),
+			StartMinute: int64(window.Start),
+			EndMinute:   int64(window.End),
+			Timezone:    window.Timezone,
+			CreatedAt:   time.Now().UTC(),
+		})
+		if err != nil {
+			return err
+		}
+		window.ID = id
+
+		return putPostgresChange(ctx, q, domain.ChangeMaintenanceWindowPut, window.Group, window)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// ListMaintenanceWindows implements Database.
+func (s *postgresDatabase) ListMaintenanceWindows(ctx context.Context) ([]domain.MaintenanceWindow, error) {
+	rows, err := s.q.ListMaintenanceWindows(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	windows := []domain.MaintenanceWindow{}
+	for _, row := range rows {
+		days := []time.Weekday{}
+		err := json.Unmarshal([]byte(row.MaintenanceWindow.Days), &days)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to decode days of maintenance window %d: %w", row.MaintenanceWindow.ID, err)
+		}
+		windows = append(windows, domain.MaintenanceWindow{
+			ID:       row.MaintenanceWindow.ID,
+			Group:    row.MaintenanceWindow.GroupName,
+			Days:     days,
+			Start:    int(row.MaintenanceWindow.StartMinute),
+			End:      int(row.MaintenanceWindow.EndMinute),
+			Timezone: row.MaintenanceWindow.Timezone,
+		})
+	}
+
+	return windows, nil
+}
+
+// DeleteMaintenanceWindow implements Database.
+func (s *postgresDatabase) DeleteMaintenanceWindow(ctx context.Context, id int64) error {
+	return s.inTx(ctx, func(q *postgres_queries.Queries) error {
+		affected, err := q.DeleteMaintenanceWindow(ctx, id)
+		if err != nil {
+			return err
+		}
+		if affected == 0 {
+			return ErrNotFound
+		}
+
+		return putPostgresChange(ctx, q, domain.ChangeMaintenanceWindowDeleted, strconv.FormatInt(id, 10), domain.MaintenanceWindowDeleteChange{
+			ID: id,
+		})
+	})
+}
@@ -0,0 +1,93 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	postgres_queries "server/internal/db/postgres_generated"
+	"server/internal/domain"
+	"time"
+)
+
+// PutChange implements Database.
+func (s *postgresDatabase) PutChange(ctx context.Context, change domain.Change) (int64, error) {
+	var id int64
+	err := s.inTx(ctx, func(q *postgres_queries.Queries) error {
+		err := q.LockOutbox(ctx)
+		if err != nil {
+			return err
+		}
+		id, err = q.InsertOutboxChange(ctx, postgres_queries.InsertOutboxChangeParams{
+			Kind:      string(change.Kind),
+			Subject:   change.Subject,
+			Data:      string(change.Data),
+			CreatedAt: time.Now().UTC(),
+		})
+
+		return err
+	})
+
+	return id, err
+}
+
+// ListChanges implements Database.
+func (s *postgresDatabase) ListChanges(ctx context.Context, afterId int64, limit int) ([]domain.Change, error) {
+	rows, err := s.q.ListOutboxChanges(ctx, postgres_queries.ListOutboxChangesParams{
+		AfterID: afterId,
+		Limit:   int32(limit),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	changes := []domain.Change{}
+	for _, row := range rows {
+		changes = append(changes, domain.Change{
+			ID:        row.Outbox.ID,
+			Kind:      domain.ChangeKind(row.Outbox.Kind),
+			Subject:   row.Outbox.Subject,
+			Data:      []byte(row.Outbox.Data),
+			CreatedAt: row.Outbox.CreatedAt,
+		})
+	}
+
+	return changes, nil
+}
+
+// GetLatestChangeID implements Database.
+func (s *postgresDatabase) GetLatestChangeID(ctx context.Context) (int64, error) {
+	return s.q.GetLatestOutboxChangeID(ctx)
+}
+
+// GetOldestChangeID implements Database.
+func (s *postgresDatabase) GetOldestChangeID(ctx context.Context) (int64, error) {
+	return s.q.GetOldestOutboxChangeID(ctx)
+}
+
+// DeleteChangesBefore implements Database.
+func (s *postgresDatabase) DeleteChangesBefore(ctx context.Context, before time.Time) (int64, error) {
+	return s.q.DeleteOutboxChangesBefore(ctx, before.UTC())
+}
+
+// putPostgresChange appends change to the outbox in the transaction of the mutation it describes. Appends are
+// serialized until the transaction ends, so consumers see changes committed in the order of their ids.
+func putPostgresChange(ctx context.Context, q *postgres_queries.Queries, kind domain.ChangeKind, subject string, payload any) error {
+	change, err := domain.NewChange(kind, subject, payload)
+	if err != nil {
+		return fmt.Errorf("Failed to encode %s change of %s: %w", kind, subject, err)
+	}
+	err = q.LockOutbox(ctx)
+	if err != nil {
+		return fmt.Errorf("Failed to lock outbox: %w", err)
+	}
+	_, err = q.InsertOutboxChange(ctx, postgres_queries.InsertOutboxChangeParams{
+		Kind:      string(change.Kind),
+		Subject:   change.Subject,
+		Data:      string(change.Data),
+		CreatedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to append %s change of %s to outbox: %w", kind, subject, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,170 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	postgres_queries "server/internal/db/postgres_generated"
+	sqlite_queries "server/internal/db/sqlite_generated"
+	"server/internal/domain"
+	"strconv"
+	"time"
+)
+
+// PutRollout implements Database.
+func (s *postgresDatabase) PutRollout(ctx context.Context, rollout domain.NewRollout, machines []domain.RolloutMachine) (int64, error) {
+	labels, err := json.Marshal(nonNilMap(rollout.Selector.Labels))
+	if err != nil {
+		return 0, err
+	}
+	percentages, err := json.Marshal(nonNil(rollout.WavePercentages))
+	if err != nil {
+		return 0, err
+	}
+
+	var id int64
+	err = s.inTx(ctx, func(q *postgres_queries.Queries) error {
+		id, err = q.InsertRollout(ctx, postgres_queries.InsertRolloutParams{
+			PackageName:     rollout.PackageName,
+			PackageVersion:  rollout.PackageVersion,
+			SelectorGroup:   rollout.Selector.Group,
+			SelectorLabels:  string(labels),
+			CanaryCount:     int64(rollout.CanaryCount),
+			WavePercentages: string(percentages),
+			MaxFailureRate:  rollout.MaxFailureRate,
+			SoakSeconds:     int64(rollout.Soak.Seconds()),
+			State:           string(rollout.InitialState()),
+			CreatedBy:       rollout.CreatedBy,
+			CreatedAt:       time.Now().UTC(),
+			Emergency:       rollout.Emergency,
+			Prestage:        rollout.Prestage,
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, m := range machines {
+			err = q.InsertRolloutMachine(ctx, postgres_queries.InsertRolloutMachineParams{
+				RolloutID: id,
+				MachineID: m.MachineID,
+				Wave:      int64(m.Wave),
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		return putPostgresChange(ctx, q, domain.ChangeRolloutCreated, strconv.FormatInt(id, 10), domain.RolloutCreateChange{
+			ID:      id,
+			Rollout: rollout,
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// ListRollouts implements Database.
+func (s *postgresDatabase) ListRollouts(ctx context.Context, state domain.RolloutState) ([]domain.Rollout, error) {
+	rows, err := s.q.ListRollouts(ctx, string(state))
+	if err != nil {
+		return nil, err
+	}
+
+	rollouts := []domain.Rollout{}
+	for _, row := range rows {
+		rollout, err := mapRollout(sqlite_queries.Rollout(row.Rollout))
+		if err != nil {
+			return nil, err
+		}
+		rollouts = append(rollouts, rollout)
+	}
+
+	return rollouts, nil
+}
+
+// GetRollout implements Database.
+func (s *postgresDatabase) GetRollout(ctx context.Context, id int64) (domain.Rollout, error) {
+	row, err := s.q.GetRollout(ctx, id)
+	if err != nil {
+		return domain.Rollout{}, mapError(err)
+	}
+
+	return mapRollout(sqlite_queries.Rollout(row.Rollout))
+}
+
+// UpdateRolloutState implements Database.
+func (s *postgresDatabase) UpdateRolloutState(ctx context.Context, rollout domain.Rollout) error {
+	return s.inTx(ctx, func(q *postgres_queries.Queries) error {
+		err := q.UpdateRolloutState(ctx, postgres_queries.UpdateRolloutStateParams{
+			ID:            rollout.ID,
+			State:         string(rollout.State),
+			StateReason:   rollout.StateReason,
+			CurrentWave:   int64(rollout.CurrentWave),
+			WaveStartedAt: rollout.WaveStartedAt.UTC(),
+		})
+		if err != nil {
+			return err
+		}
+
+		return putPostgresChange(ctx, q, domain.ChangeRolloutUpdated, strconv.FormatInt(rollout.ID, 10), rollout)
+	})
+}
+
+// ListRolloutMachines implements Database.
+func (s *postgresDatabase) ListRolloutMachines(ctx context.Context, rolloutId int64) ([]domain.RolloutMachine, error) {
+	rows, err := s.q.ListRolloutMachines(ctx, rolloutId)
+	if err != nil {
+		return nil, err
+	}
+
+	machines := []domain.RolloutMachine{}
+	for _, row := range rows {
+		machines = append(machines, domain.RolloutMachine{
+			MachineID:   row.MachineID,
+			MachineName: row.Name,
+			Wave:        int(row.Wave),
+		})
+	}
+
+	return machines, nil
+}
+
+// ListMachineRollouts implements Database.
+func (s *postgresDatabase) ListMachineRollouts(ctx context.Context, machineId int64) ([]domain.Rollout, error) {
+	rows, err := s.q.ListMachineRollouts(ctx, machineId)
+	if err != nil {
+		return nil, err
+	}
+
+	rollouts := []domain.Rollout{}
+	for _, row := range rows {
+		rollout, err := mapRollout(sqlite_queries.Rollout(row.Rollout))
+		if err != nil {
+			return nil, err
+		}
+		rollouts = append(rollouts, rollout)
+	}
+
+	return rollouts, nil
+}
+
+// ListMachineStagingRollouts implements Database.
+func (s *postgresDatabase) ListMachineStagingRollouts(ctx context.Context, machineId int64) ([]domain.Rollout, error) {
+	rows, err := s.q.ListMachineStagingRollouts(ctx, machineId)
+	if err != nil {
+		return nil, err
+	}
+
+	rollouts := []domain.Rollout{}
+	for _, row := range rows {
+		rollout, err := mapRollout(sqlite_queries.Rollout(row.Rollout))
+		if err != nil {
+			return nil, err
+		}
+		rollouts = append(rollouts, rollout)
+	}
+
+	return rollouts, nil
+}
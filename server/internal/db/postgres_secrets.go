@@ -0,0 +1,92 @@
+package db
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	postgres_queries "server/internal/db/postgres_generated"
+	sqlite_queries "server/internal/db/sqlite_generated"
+	"server/internal/domain"
+	"time"
+)
+
+// PutSecret implements Database.
+func (s *postgresDatabase) PutSecret(ctx context.Context, secret domain.Secret) error {
+	hash := sha256.Sum256(secret.Ciphertext)
+
+	return s.inTx(ctx, func(q *postgres_queries.Queries) error {
+		err := q.UpsertSecret(ctx, postgres_queries.UpsertSecretParams{
+			MachineID:  secret.MachineID,
+			Name:       secret.Name,
+			Ciphertext: secret.Ciphertext,
+			Hash:       hex.EncodeToString(hash[:]),
+			FileOwner:  secret.Owner,
+			FileGroup:  secret.Group,
+			FileMode:   int64(secret.Mode),
+			UpdatedBy:  secret.UpdatedBy,
+			UpdatedAt:  time.Now().UTC(),
+		})
+		if err != nil {
+			return err
+		}
+
+		// Consumers learn which secret changed, not its content
+		secret.Ciphertext = nil
+		secret.Hash = hex.EncodeToString(hash[:])
+
+		return putPostgresChange(ctx, q, domain.ChangeSecretPut, secret.Name, secret)
+	})
+}
+
+// ListSecrets implements Database.
+func (s *postgresDatabase) ListSecrets(ctx context.Context) ([]domain.Secret, error) {
+	rows, err := s.q.ListSecrets(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	secrets := []domain.Secret{}
+	for _, row := range rows {
+		secret := mapSecret(sqlite_queries.Secret(row.Secret))
+		secret.MachineName = row.MachineName
+		secrets = append(secrets, secret)
+	}
+
+	return secrets, nil
+}
+
+// ListMachineSecrets implements Database.
+func (s *postgresDatabase) ListMachineSecrets(ctx context.Context, machineId int64) ([]domain.Secret, error) {
+	rows, err := s.q.ListMachineSecrets(ctx, machineId)
+	if err != nil {
+		return nil, err
+	}
+
+	secrets := []domain.Secret{}
+	for _, row := range rows {
+		secrets = append(secrets, mapSecret(sqlite_queries.Secret(row.Secret)))
+	}
+
+	return secrets, nil
+}
+
+// DeleteSecret implements Database.
+func (s *postgresDatabase) DeleteSecret(ctx context.Context, machineId int64, name string) error {
+	return s.inTx(ctx, func(q *postgres_queries.Queries) error {
+		affected, err := q.DeleteSecret(ctx, postgres_queries.DeleteSecretParams{
+			MachineID: machineId,
+			Name:      name,
+		})
+		if err != nil {
+			return err
+		}
+		if affected == 0 {
+			return ErrNotFound
+		}
+
+		return putPostgresChange(ctx, q, domain.ChangeSecretDeleted, name, domain.SecretDeleteChange{
+			MachineID: machineId,
+			Name:      name,
+		})
+	})
+}
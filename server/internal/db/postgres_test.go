@@ -0,0 +1,62 @@
+package db_test
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"server/internal/db"
+	"server/internal/db/dbtest"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// postgresDsnEnv names variable with connection string of PostgreSQL database the suite runs against, e.g.
+// postgres://meshix@localhost:5432/meshix_test?sslmode=disable. Tests are skipped when it is unset.
+const postgresDsnEnv = "MESHIX_TEST_POSTGRES_DSN"
+
+func TestPostgres(t *testing.T) {
+	dsn := os.Getenv(postgresDsnEnv)
+	if dsn == "" {
+		t.Skipf("%s is not set", postgresDsnEnv)
+	}
+	ctx := context.Background()
+	conn, err := pgx.Connect(ctx, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close(ctx)
+	})
+
+	// Each test migrates its own schema of the database
+	dbtest.Run(t, func(t *testing.T) db.Database {
+		schema := "meshix_test_" + strings.ToLower(strings.NewReplacer("/", "_", "-", "_").Replace(t.Name()))
+		_, err := conn.Exec(ctx, fmt.Sprintf("DROP SCHEMA IF EXISTS %[1]s CASCADE; CREATE SCHEMA %[1]s", schema))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			_, err := conn.Exec(ctx, "DROP SCHEMA "+schema+" CASCADE")
+			if err != nil {
+				t.Errorf("Failed to drop schema %s: %v", schema, err)
+			}
+		})
+
+		u, err := url.Parse(dsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		query := u.Query()
+		query.Set("search_path", schema)
+		u.RawQuery = query.Encode()
+		database, err := db.Open(ctx, u.String(), os.DirFS("../../migrations"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return database
+	})
+}
@@ -0,0 +1,129 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	postgres_queries "server/internal/db/postgres_generated"
+	"server/internal/domain"
+	"strconv"
+)
+
+// PutAdvisories implements Database.
+func (s *postgresDatabase) PutAdvisories(ctx context.Context, advisories []domain.Advisory) error {
+	return s.inTx(ctx, func(q *postgres_queries.Queries) error {
+		for _, advisory := range advisories {
+			aliases, err := json.Marshal(nonNil(advisory.Aliases))
+			if err != nil {
+				return err
+			}
+			err = q.UpsertAdvisory(ctx, postgres_queries.UpsertAdvisoryParams{
+				ID:       advisory.ID,
+				Modified: advisory.Modified,
+				Summary:  advisory.Summary,
+				Severity: advisory.Severity,
+				Aliases:  string(aliases),
+				Document: string(advisory.Document),
+			})
+			if err != nil {
+				return err
+			}
+
+			err = q.DeleteAdvisoryPackages(ctx, advisory.ID)
+			if err != nil {
+				return err
+			}
+			for _, name := range advisory.Packages {
+				err = q.InsertAdvisoryPackage(ctx, postgres_queries.InsertAdvisoryPackageParams{
+					AdvisoryID:  advisory.ID,
+					PackageName: name,
+				})
+				if err != nil {
+					return err
+				}
+			}
+
+			// Original document is left out to keep the outbox small
+			recorded := advisory
+			recorded.Document = nil
+			err = putPostgresChange(ctx, q, domain.ChangeAdvisoryPut, advisory.ID, recorded)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// ListAdvisories implements Database.
+func (s *postgresDatabase) ListAdvisories(ctx context.Context, packageNames []string) ([]domain.Advisory, error) {
+	advisories := []domain.Advisory{}
+	if len(packageNames) == 0 {
+		return advisories, nil
+	}
+
+	rows, err := s.q.ListAdvisoriesForPackages(ctx, packageNames)
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		aliases := []string{}
+		err = json.Unmarshal([]byte(row.Advisory.Aliases), &aliases)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to decode aliases of advisory %s: %w", row.Advisory.ID, err)
+		}
+		advisories = append(advisories, domain.Advisory{
+			ID:       row.Advisory.ID,
+			Modified: row.Advisory.Modified,
+			Summary:  row.Advisory.Summary,
+			Severity: row.Advisory.Severity,
+			Aliases:  aliases,
+			Document: []byte(row.Advisory.Document),
+		})
+	}
+
+	return advisories, nil
+}
+
+// SetVulnerabilities implements Database.
+func (s *postgresDatabase) SetVulnerabilities(ctx context.Context, packageId int64, vulnerabilities []domain.Vulnerability) error {
+	return s.inTx(ctx, func(q *postgres_queries.Queries) error {
+		err := q.DeleteVulnerabilities(ctx, packageId)
+		if err != nil {
+			return err
+		}
+
+		for _, v := range vulnerabilities {
+			err = q.InsertVulnerability(ctx, postgres_queries.InsertVulnerabilityParams{
+				PackageID:        packageId,
+				AdvisoryID:       v.AdvisoryID,
+				StorePath:        v.StorePath,
+				ComponentName:    v.ComponentName,
+				ComponentVersion: v.ComponentVersion,
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		return putPostgresChange(ctx, q, domain.ChangeVulnerabilitiesSet, strconv.FormatInt(packageId, 10), domain.VulnerabilitiesChange{
+			PackageID:       packageId,
+			Vulnerabilities: nonNil(vulnerabilities),
+		})
+	})
+}
+
+// ListVulnerablePackages implements Database.
+func (s *postgresDatabase) ListVulnerablePackages(ctx context.Context, system string) ([]domain.Package, error) {
+	packages, err := s.q.ListVulnerablePackages(ctx, system)
+	if err != nil {
+		return nil, err
+	}
+	rows := []postgres_queries.Package{}
+	for _, p := range packages {
+		rows = append(rows, p.Package)
+	}
+
+	return s.mapPackages(ctx, rows)
+}
@@ -0,0 +1,100 @@
+-- name: UpsertAssignment :exec
+INSERT INTO assignments (
+    machine_name,
+    machine_group,
+    package_name,
+    package_version,
+    activation_mode,
+    emergency,
+    created_at
+) VALUES (
+ sqlc.arg(machine_name),
+ sqlc.arg(machine_group),
+ sqlc.arg(package_name),
+ sqlc.arg(package_version),
+ sqlc.arg(activation_mode),
+ sqlc.arg(emergency),
+ sqlc.arg(created_at)
+)
+ON CONFLICT (machine_name, machine_group, package_name) DO UPDATE SET
+ package_version = excluded.package_version,
+ activation_mode = excluded.activation_mode,
+ emergency = excluded.emergency;
+
+-- name: DeleteAssignment :execrows
+DELETE FROM assignments
+ WHERE machine_name = sqlc.arg(machine_name)
+ AND machine_group = sqlc.arg(machine_group)
+ AND package_name = sqlc.arg(package_name);
+
+-- name: ListAssignments :many
+SELECT sqlc.embed(assignments)
+ FROM assignments
+ ORDER BY package_name, id;
+
+-- name: InsertActivation :exec
+INSERT INTO activations (
+    machine_id,
+    store_paths,
+    success,
+    error,
+    rolled_back,
+    logs,
+    reported_at
+) VALUES (
+ sqlc.arg(machine_id),
+ sqlc.arg(store_paths),
+ sqlc.arg(success),
+ sqlc.arg(error),
+ sqlc.arg(rolled_back),
+ sqlc.arg(logs),
+ sqlc.arg(reported_at)
+);
+
+-- name: GetLatestActivation :one
+SELECT sqlc.embed(activations)
+ FROM activations
+ WHERE machine_id = sqlc.arg(machine_id)
+ ORDER BY id DESC
+ LIMIT 1;
+
+-- name: GetLatestSuccessfulActivation :one
+SELECT sqlc.embed(activations)
+ FROM activations
+ WHERE machine_id = sqlc.arg(machine_id)
+ AND success
+ ORDER BY id DESC
+ LIMIT 1;
+
+-- name: InsertFailedVersion :execrows
+INSERT INTO failed_versions (
+    package_name,
+    package_version,
+    group_name,
+    machine_id,
+    reason,
+    failed_at
+) VALUES (
+ sqlc.arg(package_name),
+ sqlc.arg(package_version),
+ sqlc.arg(group_name),
+ sqlc.arg(machine_id),
+ sqlc.arg(reason),
+ sqlc.arg(failed_at)
+)
+-- Group failures keep the first machine, failures of machines without groups are kept per machine
+ON CONFLICT DO NOTHING;
+
+-- name: ListFailedVersions :many
+SELECT sqlc.embed(failed_versions), machines.name AS machine_name
+ FROM failed_versions
+ JOIN machines ON machines.id = failed_versions.machine_id
+ WHERE (CAST(sqlc.arg(package_name) AS TEXT) = '' OR failed_versions.package_name = sqlc.arg(package_name))
+ AND (CAST(sqlc.arg(package_version) AS TEXT) = '' OR failed_versions.package_version = sqlc.arg(package_version))
+ ORDER BY failed_versions.failed_at, failed_versions.group_name;
+
+-- name: DeleteFailedVersion :execrows
+DELETE FROM failed_versions
+ WHERE package_name = sqlc.arg(package_name)
+ AND package_version = sqlc.arg(package_version)
+ AND (CAST(sqlc.arg(group_name) AS TEXT) = '' OR group_name = sqlc.arg(group_name));
@@ -0,0 +1,143 @@
+-- name: InsertBootstrapToken :exec
+INSERT INTO bootstrap_tokens (
+    token_hash,
+    created_by,
+    group_names,
+    labels,
+    expires_at
+) VALUES (
+ sqlc.arg(token_hash),
+ sqlc.arg(created_by),
+ sqlc.arg(group_names),
+ sqlc.arg(labels),
+ sqlc.arg(expires_at)
+);
+
+-- name: GetBootstrapToken :one
+SELECT sqlc.embed(bootstrap_tokens)
+ FROM bootstrap_tokens
+ WHERE token_hash = sqlc.arg(token_hash);
+
+-- name: UseBootstrapToken :execrows
+UPDATE bootstrap_tokens
+ SET used_at = sqlc.arg(used_at),
+     machine_id = sqlc.arg(machine_id)
+ WHERE token_hash = sqlc.arg(token_hash) AND used_at IS NULL;
+
+-- name: InsertMachine :one
+INSERT INTO machines (
+    name,
+    system,
+    public_key,
+    labels,
+    group_names,
+    enrolled_at
+) VALUES (
+ sqlc.arg(name),
+ sqlc.arg(system),
+ sqlc.arg(public_key),
+ sqlc.arg(labels),
+ sqlc.arg(group_names),
+ sqlc.arg(enrolled_at)
+)
+RETURNING *;
+
+-- name: ListMachines :many
+SELECT sqlc.embed(machines)
+ FROM machines
+ ORDER BY name;
+
+-- name: GetMachine :one
+SELECT sqlc.embed(machines)
+ FROM machines
+ WHERE id = sqlc.arg(id);
+
+-- name: GetMachineByName :one
+SELECT sqlc.embed(machines)
+ FROM machines
+ WHERE name = sqlc.arg(name);
+
+-- name: UpsertMachineStatus :exec
+INSERT INTO machine_statuses (
+    machine_id,
+    profile_generation,
+    store_paths,
+    nix_version,
+    free_disk_bytes,
+    last_error,
+    running_system,
+    booted_system,
+    staged_store_paths,
+    peer_url,
+    reported_at
+) VALUES (
+ sqlc.arg(machine_id),
+ sqlc.arg(profile_generation),
+ sqlc.arg(store_paths),
+ sqlc.arg(nix_version),
+ sqlc.arg(free_disk_bytes),
+ sqlc.arg(last_error),
+ sqlc.arg(running_system),
+ sqlc.arg(booted_system),
+ sqlc.arg(staged_store_paths),
+ sqlc.arg(peer_url),
+ sqlc.arg(reported_at)
+)
+ON CONFLICT (machine_id) DO UPDATE SET
+ profile_generation = excluded.profile_generation,
+ store_paths = excluded.store_paths,
+ nix_version = excluded.nix_version,
+ free_disk_bytes = excluded.free_disk_bytes,
+ last_error = excluded.last_error,
+ running_system = excluded.running_system,
+ booted_system = excluded.booted_system,
+ staged_store_paths = excluded.staged_store_paths,
+ peer_url = excluded.peer_url,
+ reported_at = excluded.reported_at;
+
+-- name: ListMachineStatuses :many
+SELECT sqlc.embed(machine_statuses)
+ FROM machine_statuses;
+
+-- name: GetMachineStatus :one
+SELECT sqlc.embed(machine_statuses)
+ FROM machine_statuses
+ WHERE machine_id = sqlc.arg(machine_id);
+
+-- name: UpsertMachineDrift :exec
+INSERT INTO machine_drifts (
+    machine_id,
+    missing,
+    unexpected,
+    desired_system,
+    actual_system,
+    error,
+    since,
+    checked_at
+) VALUES (
+ sqlc.arg(machine_id),
+ sqlc.arg(missing),
+ sqlc.arg(unexpected),
+ sqlc.arg(desired_system),
+ sqlc.arg(actual_system),
+ sqlc.arg(error),
+ sqlc.arg(since),
+ sqlc.arg(checked_at)
+)
+ON CONFLICT (machine_id) DO UPDATE SET
+ missing = excluded.missing,
+ unexpected = excluded.unexpected,
+ desired_system = excluded.desired_system,
+ actual_system = excluded.actual_system,
+ error = excluded.error,
+ since = excluded.since,
+ checked_at = excluded.checked_at;
+
+-- name: ListMachineDrifts :many
+SELECT sqlc.embed(machine_drifts)
+ FROM machine_drifts;
+
+-- name: GetMachineDrift :one
+SELECT sqlc.embed(machine_drifts)
+ FROM machine_drifts
+ WHERE machine_id = sqlc.arg(machine_id);
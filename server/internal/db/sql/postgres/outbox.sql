@@ -0,0 +1,41 @@
+-- Serializes appends to the outbox until the end of the transaction. Id of the change is the next one
+-- after the latest, so changes are committed in the order of their ids and consumers following the
+-- latest id never skip a change committed later with a lower id.
+-- name: LockOutbox :exec
+SELECT pg_advisory_xact_lock(hashtext('outbox'));
+
+-- name: InsertOutboxChange :one
+INSERT INTO outbox (
+    id,
+    kind,
+    subject,
+    data,
+    created_at
+) VALUES (
+ (SELECT COALESCE(MAX(latest.id), 0) + 1 FROM outbox AS latest),
+ sqlc.arg(kind),
+ sqlc.arg(subject),
+ sqlc.arg(data),
+ sqlc.arg(created_at)
+)
+RETURNING id;
+
+-- name: ListOutboxChanges :many
+SELECT sqlc.embed(outbox)
+ FROM outbox
+ WHERE id > sqlc.arg(after_id)
+ ORDER BY id
+ LIMIT sqlc.arg('limit');
+
+-- name: GetLatestOutboxChangeID :one
+SELECT CAST(COALESCE(MAX(id), 0) AS BIGINT)
+ FROM outbox;
+
+-- name: GetOldestOutboxChangeID :one
+SELECT CAST(COALESCE(MIN(id), 0) AS BIGINT)
+ FROM outbox;
+
+-- name: DeleteOutboxChangesBefore :execrows
+DELETE FROM outbox
+ WHERE outbox.created_at < sqlc.arg(before)
+   AND outbox.id < (SELECT MAX(latest.id) FROM outbox AS latest);
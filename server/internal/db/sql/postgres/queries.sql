@@ -0,0 +1,141 @@
+-- name: InsertPackage :one
+INSERT INTO packages (
+    name,
+    version,
+    nix_store_hash,
+    nix_main_bin,
+    system,
+    description,
+    licenses,
+    homepage,
+    platforms,
+    unfree,
+    insecure,
+    position,
+    kind
+) VALUES(
+ sqlc.arg(name),
+ sqlc.arg(version),
+ sqlc.arg(nix_store_hash),
+ sqlc.arg(nix_main_bin),
+ sqlc.arg(system),
+ sqlc.arg(description),
+ sqlc.arg(licenses),
+ sqlc.arg(homepage),
+ sqlc.arg(platforms),
+ sqlc.arg(unfree),
+ sqlc.arg(insecure),
+ sqlc.arg(position),
+ sqlc.arg(kind)
+)
+RETURNING id;
+
+-- name: InsertPackageOutput :exec
+INSERT INTO package_outputs (
+    package_id,
+    name,
+    store_path,
+    install
+) VALUES(
+ sqlc.arg(package_id),
+ sqlc.arg(name),
+ sqlc.arg(store_path),
+ sqlc.arg(install)
+);
+
+-- name: ListPackageOutputs :many
+SELECT *
+ FROM package_outputs
+ WHERE package_id = ANY(CAST(sqlc.arg(package_ids) AS BIGINT[]))
+ ORDER BY package_id, name;
+
+-- name: ListPackages :many
+SELECT sqlc.embed(packages)
+ FROM packages
+ WHERE (CAST(sqlc.arg(include_yanked) AS BOOLEAN) OR yanked_at IS NULL)
+ AND (CAST(sqlc.arg(system) AS TEXT) = '' OR system = sqlc.arg(system));
+
+-- name: GetPackage :one
+SELECT sqlc.embed(packages)
+ FROM packages
+ WHERE name = sqlc.arg(name) AND version = sqlc.arg(version)
+ AND (CAST(sqlc.arg(system) AS TEXT) = '' OR system = sqlc.arg(system))
+ ORDER BY id DESC
+ LIMIT 1;
+
+-- name: GetLatestPackage :one
+SELECT sqlc.embed(packages)
+ FROM packages
+ WHERE name = sqlc.arg(name) AND yanked_at IS NULL
+ AND (CAST(sqlc.arg(system) AS TEXT) = '' OR system = sqlc.arg(system))
+ ORDER BY id DESC
+ LIMIT 1;
+
+-- name: YankPackage :execrows
+UPDATE packages
+ SET yanked_at = sqlc.arg(yanked_at),
+     yank_reason = sqlc.arg(yank_reason)
+ WHERE name = sqlc.arg(name) AND version = sqlc.arg(version)
+ AND (CAST(sqlc.arg(system) AS TEXT) = '' OR system = sqlc.arg(system));
+
+-- name: InsertPackageProvenance :exec
+INSERT INTO package_provenances (
+    package_id,
+    flake_url,
+    flake_revision,
+    flake_nar_hash,
+    drv_path,
+    builder_host,
+    client_version,
+    ci,
+    signature
+) VALUES(
+ sqlc.arg(package_id),
+ sqlc.arg(flake_url),
+ sqlc.arg(flake_revision),
+ sqlc.arg(flake_nar_hash),
+ sqlc.arg(drv_path),
+ sqlc.arg(builder_host),
+ sqlc.arg(client_version),
+ sqlc.arg(ci),
+ sqlc.arg(signature)
+);
+
+-- name: ListPackageProvenances :many
+SELECT *
+ FROM package_provenances
+ WHERE package_id = ANY(CAST(sqlc.arg(package_ids) AS BIGINT[]));
+
+-- name: DeletePackageProvenances :exec
+DELETE FROM package_provenances
+ WHERE package_id IN (
+    SELECT packages.id FROM packages
+     WHERE packages.name = sqlc.arg(name) AND packages.version = sqlc.arg(version)
+     AND (CAST(sqlc.arg(system) AS TEXT) = '' OR packages.system = sqlc.arg(system))
+ );
+
+-- name: DeletePackageOutputs :many
+DELETE FROM package_outputs
+ WHERE package_id IN (
+    SELECT packages.id FROM packages
+     WHERE packages.name = sqlc.arg(name) AND packages.version = sqlc.arg(version)
+     AND (CAST(sqlc.arg(system) AS TEXT) = '' OR packages.system = sqlc.arg(system))
+ )
+ RETURNING store_path;
+
+-- name: DeletePackage :many
+DELETE FROM packages
+ WHERE name = sqlc.arg(name) AND version = sqlc.arg(version)
+ AND (CAST(sqlc.arg(system) AS TEXT) = '' OR system = sqlc.arg(system))
+ RETURNING nix_store_hash;
+
+-- name: InsertGcRoot :exec
+INSERT INTO gc_roots (store_path)
+ VALUES (sqlc.arg(store_path))
+ ON CONFLICT DO NOTHING;
+
+-- name: DeleteUnusedGcRoot :execrows
+DELETE FROM gc_roots
+ WHERE gc_roots.store_path = sqlc.arg(store_path)
+ AND NOT EXISTS (SELECT 1 FROM packages WHERE nix_store_hash = sqlc.arg(store_path))
+ AND NOT EXISTS (SELECT 1 FROM package_outputs WHERE package_outputs.store_path = sqlc.arg(store_path));
@@ -0,0 +1,34 @@
+-- name: GetReplicationCursor :one
+SELECT sqlc.embed(replication_cursors)
+ FROM replication_cursors
+ WHERE primary_url = sqlc.arg(primary_url);
+
+-- name: UpsertReplicationCursor :exec
+INSERT INTO replication_cursors (
+    primary_url,
+    last_change_id,
+    updated_at
+) VALUES (
+ sqlc.arg(primary_url),
+ sqlc.arg(last_change_id),
+ sqlc.arg(updated_at)
+)
+ON CONFLICT (primary_url) DO UPDATE SET
+ last_change_id = excluded.last_change_id,
+ updated_at = excluded.updated_at;
+
+-- name: PromoteReplicationCursor :exec
+INSERT INTO replication_cursors (
+    primary_url,
+    last_change_id,
+    promoted_at,
+    updated_at
+) VALUES (
+ sqlc.arg(primary_url),
+ 0,
+ sqlc.arg(promoted_at),
+ sqlc.arg(updated_at)
+)
+ON CONFLICT (primary_url) DO UPDATE SET
+ promoted_at = excluded.promoted_at,
+ updated_at = excluded.updated_at;
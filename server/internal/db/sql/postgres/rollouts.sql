@@ -0,0 +1,91 @@
+-- name: InsertRollout :one
+INSERT INTO rollouts (
+    package_name,
+    package_version,
+    selector_group,
+    selector_labels,
+    canary_count,
+    wave_percentages,
+    max_failure_rate,
+    soak_seconds,
+    state,
+    state_reason,
+    current_wave,
+    wave_started_at,
+    created_by,
+    created_at,
+    emergency,
+    prestage
+) VALUES (
+ sqlc.arg(package_name),
+ sqlc.arg(package_version),
+ sqlc.arg(selector_group),
+ sqlc.arg(selector_labels),
+ sqlc.arg(canary_count),
+ sqlc.arg(wave_percentages),
+ sqlc.arg(max_failure_rate),
+ sqlc.arg(soak_seconds),
+ sqlc.arg(state),
+ '',
+ 0,
+ sqlc.arg(created_at),
+ sqlc.arg(created_by),
+ sqlc.arg(created_at),
+ sqlc.arg(emergency),
+ sqlc.arg(prestage)
+)
+RETURNING id;
+
+-- name: InsertRolloutMachine :exec
+INSERT INTO rollout_machines (
+    rollout_id,
+    machine_id,
+    wave
+) VALUES (
+ sqlc.arg(rollout_id),
+ sqlc.arg(machine_id),
+ sqlc.arg(wave)
+);
+
+-- name: ListRollouts :many
+SELECT sqlc.embed(rollouts)
+ FROM rollouts
+ WHERE (CAST(sqlc.arg(state) AS TEXT) = '' OR state = sqlc.arg(state))
+ ORDER BY id DESC;
+
+-- name: GetRollout :one
+SELECT sqlc.embed(rollouts)
+ FROM rollouts
+ WHERE id = sqlc.arg(id);
+
+-- name: UpdateRolloutState :exec
+UPDATE rollouts
+ SET state = sqlc.arg(state),
+     state_reason = sqlc.arg(state_reason),
+     current_wave = sqlc.arg(current_wave),
+     wave_started_at = sqlc.arg(wave_started_at)
+ WHERE id = sqlc.arg(id);
+
+-- name: ListRolloutMachines :many
+SELECT rollout_machines.machine_id, rollout_machines.wave, machines.name
+ FROM rollout_machines
+ JOIN machines ON machines.id = rollout_machines.machine_id
+ WHERE rollout_machines.rollout_id = sqlc.arg(rollout_id)
+ ORDER BY rollout_machines.wave, machines.name;
+
+-- name: ListMachineRollouts :many
+SELECT sqlc.embed(rollouts)
+ FROM rollout_machines
+ JOIN rollouts ON rollouts.id = rollout_machines.rollout_id
+ WHERE rollout_machines.machine_id = sqlc.arg(machine_id)
+ AND rollout_machines.wave <= rollouts.current_wave
+ AND rollouts.state NOT IN ('aborted', 'staging')
+ ORDER BY rollouts.id;
+
+-- name: ListMachineStagingRollouts :many
+SELECT sqlc.embed(rollouts)
+ FROM rollout_machines
+ JOIN rollouts ON rollouts.id = rollout_machines.rollout_id
+ WHERE rollout_machines.machine_id = sqlc.arg(machine_id)
+ AND rollouts.state = 'staging'
+ ORDER BY rollouts.id;
@@ -0,0 +1,46 @@
+-- name: UpsertSecret :exec
+INSERT INTO secrets (
+    machine_id,
+    name,
+    ciphertext,
+    hash,
+    file_owner,
+    file_group,
+    file_mode,
+    updated_by,
+    updated_at
+) VALUES (
+ sqlc.arg(machine_id),
+ sqlc.arg(name),
+ sqlc.arg(ciphertext),
+ sqlc.arg(hash),
+ sqlc.arg(file_owner),
+ sqlc.arg(file_group),
+ sqlc.arg(file_mode),
+ sqlc.arg(updated_by),
+ sqlc.arg(updated_at)
+)
+ON CONFLICT (machine_id, name) DO UPDATE
+ SET ciphertext = excluded.ciphertext,
+ hash = excluded.hash,
+ file_owner = excluded.file_owner,
+ file_group = excluded.file_group,
+ file_mode = excluded.file_mode,
+ updated_by = excluded.updated_by,
+ updated_at = excluded.updated_at;
+
+-- name: ListSecrets :many
+SELECT sqlc.embed(secrets), machines.name AS machine_name
+ FROM secrets
+ JOIN machines ON machines.id = secrets.machine_id
+ ORDER BY machines.name, secrets.name;
+
+-- name: ListMachineSecrets :many
+SELECT sqlc.embed(secrets)
+ FROM secrets
+ WHERE machine_id = sqlc.arg(machine_id)
+ ORDER BY name;
+
+-- name: DeleteSecret :execrows
+DELETE FROM secrets
+ WHERE machine_id = sqlc.arg(machine_id) AND name = sqlc.arg(name);
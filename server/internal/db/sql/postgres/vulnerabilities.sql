@@ -0,0 +1,85 @@
+-- name: UpsertAdvisory :exec
+INSERT INTO advisories (
+    id,
+    modified,
+    summary,
+    severity,
+    aliases,
+    document
+) VALUES (
+ sqlc.arg(id),
+ sqlc.arg(modified),
+ sqlc.arg(summary),
+ sqlc.arg(severity),
+ sqlc.arg(aliases),
+ sqlc.arg(document)
+)
+ON CONFLICT (id) DO UPDATE SET
+ modified = excluded.modified,
+ summary = excluded.summary,
+ severity = excluded.severity,
+ aliases = excluded.aliases,
+ document = excluded.document;
+
+-- name: DeleteAdvisoryPackages :exec
+DELETE FROM advisory_packages
+ WHERE advisory_id = sqlc.arg(advisory_id);
+
+-- name: InsertAdvisoryPackage :exec
+INSERT INTO advisory_packages (
+    advisory_id,
+    package_name
+) VALUES (
+ sqlc.arg(advisory_id),
+ sqlc.arg(package_name)
+)
+ON CONFLICT DO NOTHING;
+
+-- name: ListAdvisoriesForPackages :many
+SELECT sqlc.embed(advisories)
+ FROM advisories
+ WHERE id IN (
+    SELECT advisory_id FROM advisory_packages WHERE package_name = ANY(CAST(sqlc.arg(package_names) AS TEXT[]))
+ )
+ ORDER BY id;
+
+-- name: DeleteVulnerabilities :exec
+DELETE FROM vulnerabilities
+ WHERE package_id = sqlc.arg(package_id);
+
+-- name: InsertVulnerability :exec
+INSERT INTO vulnerabilities (
+    package_id,
+    advisory_id,
+    store_path,
+    component_name,
+    component_version
+) VALUES (
+ sqlc.arg(package_id),
+ sqlc.arg(advisory_id),
+ sqlc.arg(store_path),
+ sqlc.arg(component_name),
+ sqlc.arg(component_version)
+)
+ON CONFLICT DO NOTHING;
+
+-- name: ListVulnerabilities :many
+SELECT vulnerabilities.*, advisories.summary, advisories.severity, advisories.aliases
+ FROM vulnerabilities
+ JOIN advisories ON advisories.id = vulnerabilities.advisory_id
+ WHERE vulnerabilities.package_id = ANY(CAST(sqlc.arg(package_ids) AS BIGINT[]))
+ ORDER BY vulnerabilities.package_id, vulnerabilities.advisory_id, vulnerabilities.store_path;
+
+-- name: ListVulnerablePackages :many
+SELECT sqlc.embed(packages)
+ FROM packages
+ WHERE (CAST(sqlc.arg(system) AS TEXT) = '' OR system = sqlc.arg(system))
+ AND id IN (SELECT DISTINCT package_id FROM vulnerabilities);
+
+-- name: DeletePackageVulnerabilities :exec
+DELETE FROM vulnerabilities
+ WHERE package_id IN (
+    SELECT packages.id FROM packages
+     WHERE packages.name = sqlc.arg(name) AND packages.version = sqlc.arg(version)
+     AND (CAST(sqlc.arg(system) AS TEXT) = '' OR packages.system = sqlc.arg(system))
+ );
@@ -0,0 +1,52 @@
+-- name: GetWebhookCursor :one
+SELECT sqlc.embed(webhook_cursors)
+ FROM webhook_cursors
+ WHERE webhook = sqlc.arg(webhook);
+
+-- name: UpsertWebhookCursor :exec
+INSERT INTO webhook_cursors (
+    webhook,
+    last_change_id,
+    updated_at
+) VALUES (
+ sqlc.arg(webhook),
+ sqlc.arg(last_change_id),
+ sqlc.arg(updated_at)
+)
+ON CONFLICT (webhook) DO UPDATE SET
+ last_change_id = excluded.last_change_id,
+ updated_at = excluded.updated_at;
+
+-- name: InsertWebhookDelivery :exec
+INSERT INTO webhook_deliveries (
+    webhook,
+    change_id,
+    kind,
+    subject,
+    attempt,
+    status_code,
+    error,
+    duration_ms,
+    created_at
+) VALUES (
+ sqlc.arg(webhook),
+ sqlc.arg(change_id),
+ sqlc.arg(kind),
+ sqlc.arg(subject),
+ sqlc.arg(attempt),
+ sqlc.arg(status_code),
+ sqlc.arg(error),
+ sqlc.arg(duration_ms),
+ sqlc.arg(created_at)
+);
+
+-- name: ListWebhookDeliveries :many
+SELECT sqlc.embed(webhook_deliveries)
+ FROM webhook_deliveries
+ WHERE (CAST(sqlc.arg(webhook) AS TEXT) = '' OR webhook_deliveries.webhook = sqlc.arg(webhook))
+ ORDER BY id DESC
+ LIMIT sqlc.arg('limit');
+
+-- name: DeleteWebhookDeliveriesBefore :execrows
+DELETE FROM webhook_deliveries
+ WHERE created_at < sqlc.arg(before);
@@ -0,0 +1,21 @@
+-- name: GetClusterMembership :one
+SELECT sqlc.embed(cluster_memberships)
+ FROM cluster_memberships
+ WHERE node = sqlc.arg(node);
+
+-- name: UpsertClusterMembership :exec
+INSERT INTO cluster_memberships (
+    node,
+    nodes,
+    replication_factor,
+    updated_at
+) VALUES (
+ sqlc.arg(node),
+ sqlc.arg(nodes),
+ sqlc.arg(replication_factor),
+ sqlc.arg(updated_at)
+)
+ON CONFLICT (node) DO UPDATE SET
+ nodes = excluded.nodes,
+ replication_factor = excluded.replication_factor,
+ updated_at = excluded.updated_at;
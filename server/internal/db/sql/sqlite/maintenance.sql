@@ -0,0 +1,26 @@
+-- name: InsertMaintenanceWindow :one
+INSERT INTO maintenance_windows (
+    group_name,
+    days,
+    start_minute,
+    end_minute,
+    timezone,
+    created_at
+) VALUES (
+ sqlc.arg(group_name),
+ sqlc.arg(days),
+ sqlc.arg(start_minute),
+ sqlc.arg(end_minute),
+ sqlc.arg(timezone),
+ sqlc.arg(created_at)
+)
+RETURNING id;
+
+-- name: ListMaintenanceWindows :many
+SELECT sqlc.embed(maintenance_windows)
+ FROM maintenance_windows
+ ORDER BY group_name, id;
+
+-- name: DeleteMaintenanceWindow :execrows
+DELETE FROM maintenance_windows
+ WHERE id = sqlc.arg(id);
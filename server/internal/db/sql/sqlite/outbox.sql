@@ -0,0 +1,33 @@
+-- name: InsertOutboxChange :one
+INSERT INTO outbox (
+    kind,
+    subject,
+    data,
+    created_at
+) VALUES (
+ sqlc.arg(kind),
+ sqlc.arg(subject),
+ sqlc.arg(data),
+ sqlc.arg(created_at)
+)
+RETURNING id;
+
+-- name: ListOutboxChanges :many
+SELECT sqlc.embed(outbox)
+ FROM outbox
+ WHERE id > sqlc.arg(after_id)
+ ORDER BY id
+ LIMIT sqlc.arg(limit);
+
+-- name: GetLatestOutboxChangeID :one
+SELECT CAST(COALESCE(MAX(id), 0) AS INTEGER)
+ FROM outbox;
+
+-- name: GetOldestOutboxChangeID :one
+SELECT CAST(COALESCE(MIN(id), 0) AS INTEGER)
+ FROM outbox;
+
+-- name: DeleteOutboxChangesBefore :execrows
+DELETE FROM outbox
+ WHERE outbox.created_at < sqlc.arg(before)
+   AND outbox.id < (SELECT MAX(latest.id) FROM outbox AS latest);
@@ -1,61 +1,520 @@
 package db
 
 import (
+	"cmp"
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
 	sqlite_queries "server/internal/db/sqlite_generated"
 	"server/internal/domain"
+	"slices"
+	"time"
 )
 
+var ErrNotFound = errors.New("Not found")
+
+// Database stores state of the hub. Each mutation appends change describing it to the outbox in its
+// transaction, except positions of replicas, cluster nodes and webhooks which are local bookkeeping of consumers.
 type Database interface {
 	PutPackage(ctx context.Context, pkg domain.NewPackage) error
-	ListPackages(ctx context.Context) ([]domain.Package, error)
+	ListPackages(ctx context.Context, filter domain.PackageFilter) ([]domain.Package, error)
+	// GetPackage resolves exact version of package, when version is empty latest non yanked version is returned.
+	GetPackage(ctx context.Context, ref domain.PackageRef) (domain.Package, error)
+	YankPackage(ctx context.Context, ref domain.PackageRef, reason string) error
+	DeletePackage(ctx context.Context, ref domain.PackageRef, dropGcRoot bool) error
+
+	// PutAdvisories inserts or replaces advisories by their id.
+	PutAdvisories(ctx context.Context, advisories []domain.Advisory) error
+	// ListAdvisories lists advisories affecting any of the package names.
+	ListAdvisories(ctx context.Context, packageNames []string) ([]domain.Advisory, error)
+	// SetVulnerabilities replaces vulnerabilities found in the package closure.
+	SetVulnerabilities(ctx context.Context, packageId int64, vulnerabilities []domain.Vulnerability) error
+	ListVulnerablePackages(ctx context.Context, system string) ([]domain.Package, error)
+
+	PutBootstrapToken(ctx context.Context, tokenHash string, token domain.BootstrapToken) error
+	// RegisterMachine enrolls machine using bootstrap token, token can't be used again.
+	RegisterMachine(ctx context.Context, tokenHash string, machine domain.NewMachine) (domain.Machine, error)
+	ListMachines(ctx context.Context, filter domain.MachineFilter) ([]domain.Machine, error)
+	GetMachine(ctx context.Context, id int64) (domain.Machine, error)
+	GetMachineByName(ctx context.Context, name string) (domain.Machine, error)
+	// PutMachineStatus replaces status of the machine with the latest heartbeat.
+	PutMachineStatus(ctx context.Context, machineId int64, status domain.MachineStatus) error
+	// PutMachineDrift replaces drift of the machine with the latest check.
+	PutMachineDrift(ctx context.Context, drift domain.MachineDrift) error
+
+	// PutAssignment inserts assignment or updates version of existing assignment of the package to the target.
+	PutAssignment(ctx context.Context, assignment domain.Assignment) error
+	DeleteAssignment(ctx context.Context, target domain.AssignmentTarget, packageName string) error
+	ListAssignments(ctx context.Context) ([]domain.Assignment, error)
+	PutActivation(ctx context.Context, activation domain.Activation) error
+	GetLatestActivation(ctx context.Context, machineId int64) (domain.Activation, error)
+	GetLatestSuccessfulActivation(ctx context.Context, machineId int64) (domain.Activation, error)
+	// PutFailedVersion marks version as failed for the group, the first failure is kept. Failures with empty
+	// group are kept per machine.
+	PutFailedVersion(ctx context.Context, failed domain.FailedVersion) error
+	// ListFailedVersions lists failures of the package version, empty name or version matches all.
+	ListFailedVersions(ctx context.Context, ref domain.PackageRef) ([]domain.FailedVersion, error)
+	// DeleteFailedVersion clears failure of the version for the group, for all groups and machines when group is empty.
+	DeleteFailedVersion(ctx context.Context, ref domain.PackageRef, group string) error
+
+	// PutRollout creates running rollout with machines already planned into waves.
+	PutRollout(ctx context.Context, rollout domain.NewRollout, machines []domain.RolloutMachine) (int64, error)
+	// ListRollouts lists rollouts in the state, all rollouts when state is empty.
+	ListRollouts(ctx context.Context, state domain.RolloutState) ([]domain.Rollout, error)
+	GetRollout(ctx context.Context, id int64) (domain.Rollout, error)
+	// UpdateRolloutState stores state, reason and current wave of the rollout.
+	UpdateRolloutState(ctx context.Context, rollout domain.Rollout) error
+	ListRolloutMachines(ctx context.Context, rolloutId int64) ([]domain.RolloutMachine, error)
+	// ListMachineRollouts lists not aborted rollouts which reached the machine, oldest first.
+	ListMachineRollouts(ctx context.Context, machineId int64) ([]domain.Rollout, error)
+	// ListMachineStagingRollouts lists rollouts in staging state which selected the machine in any wave.
+	ListMachineStagingRollouts(ctx context.Context, machineId int64) ([]domain.Rollout, error)
+
+	// PutMaintenanceWindow creates maintenance window and returns its id.
+	PutMaintenanceWindow(ctx context.Context, window domain.MaintenanceWindow) (int64, error)
+	ListMaintenanceWindows(ctx context.Context) ([]domain.MaintenanceWindow, error)
+	DeleteMaintenanceWindow(ctx context.Context, id int64) error
+
+	// PutSecret inserts or replaces secret of the machine.
+	PutSecret(ctx context.Context, secret domain.Secret) error
+	// ListSecrets lists secrets of all machines with machine names.
+	ListSecrets(ctx context.Context) ([]domain.Secret, error)
+	ListMachineSecrets(ctx context.Context, machineId int64) ([]domain.Secret, error)
+	DeleteSecret(ctx context.Context, machineId int64, name string) error
+
+	// PutChange appends change which is not stored in the database, e.g. upload to the binary cache,
+	// to the outbox and returns its id. Other mutations append their changes in their transaction.
+	PutChange(ctx context.Context, change domain.Change) (int64, error)
+	// ListChanges lists up to limit changes following the change id, oldest first.
+	ListChanges(ctx context.Context, afterId int64, limit int) ([]domain.Change, error)
+	// GetLatestChangeID returns id of the last change in the outbox, 0 when the outbox is empty.
+	GetLatestChangeID(ctx context.Context) (int64, error)
+	// GetOldestChangeID returns id of the first change kept in the outbox, 0 when the outbox is empty.
+	GetOldestChangeID(ctx context.Context) (int64, error)
+	// DeleteChangesBefore removes changes older than the time except the latest change, so ids keep growing.
+	DeleteChangesBefore(ctx context.Context, before time.Time) (int64, error)
+	GetReplicationCursor(ctx context.Context, primaryUrl string) (domain.ReplicationCursor, error)
+	// PutReplicationCursor stores id of the last change of the primary applied by the replica.
+	PutReplicationCursor(ctx context.Context, primaryUrl string, lastChangeId int64) error
+	// PromoteReplica marks the replica of the primary as promoted, it stops following the primary.
+	PromoteReplica(ctx context.Context, primaryUrl string) error
+
+	// GetClusterMembership returns membership the objects of the node were last rebalanced to.
+	GetClusterMembership(ctx context.Context, node string) (domain.ClusterMembership, error)
+	PutClusterMembership(ctx context.Context, node string, membership domain.ClusterMembership) error
+
+	// GetWebhookCursor returns id of the last change of the outbox handled by the webhook.
+	GetWebhookCursor(ctx context.Context, webhook string) (int64, error)
+	PutWebhookCursor(ctx context.Context, webhook string, lastChangeId int64) error
+	PutWebhookDelivery(ctx context.Context, delivery domain.WebhookDelivery) error
+	// ListWebhookDeliveries lists up to limit deliveries of the webhook, of all webhooks when empty, newest first.
+	ListWebhookDeliveries(ctx context.Context, webhook string, limit int) ([]domain.WebhookDelivery, error)
+	DeleteWebhookDeliveriesBefore(ctx context.Context, before time.Time) (int64, error)
 }
 
 func NewDatabase(pool *sql.DB) Database {
 	return &sqliteDatabase{
-		q: sqlite_queries.New(pool),
+		pool: pool,
+		q:    sqlite_queries.New(pool),
 	}
 }
 
 type sqliteDatabase struct {
-	q *sqlite_queries.Queries
+	pool *sql.DB
+	q    *sqlite_queries.Queries
 }
 
 // ListPackages implements Database.
-func (s *sqliteDatabase) ListPackages(ctx context.Context) ([]domain.Package, error) {
-	packages, err := s.q.ListPackages(ctx)
+func (s *sqliteDatabase) ListPackages(ctx context.Context, filter domain.PackageFilter) ([]domain.Package, error) {
+	packages, err := s.q.ListPackages(ctx, sqlite_queries.ListPackagesParams{
+		IncludeYanked: filter.IncludeYanked,
+		System:        filter.System,
+	})
 	if err != nil {
 		return nil, err
 	}
-	mappedPackages := []domain.Package{}
+	rows := []sqlite_queries.Package{}
 	for _, p := range packages {
-		mappedPackages = append(mappedPackages, domain.Package{
-			Name:    p.Package.Name,
-			Version: p.Package.Version,
-			NixMetadata: domain.NixMetadata{
-				StorePath: p.Package.NixStoreHash,
-				MainBin:   p.Package.NixMainBin,
-			},
+		rows = append(rows, p.Package)
+	}
+
+	return s.mapPackages(ctx, rows)
+}
+
+// GetPackage implements Database.
+func (s *sqliteDatabase) GetPackage(ctx context.Context, ref domain.PackageRef) (domain.Package, error) {
+	var pkg sqlite_queries.Package
+	if ref.Version == "" {
+		row, err := s.q.GetLatestPackage(ctx, sqlite_queries.GetLatestPackageParams{
+			Name:   ref.Name,
+			System: ref.System,
 		})
+		if err != nil {
+			return domain.Package{}, mapError(err)
+		}
+		pkg = row.Package
+	} else {
+		row, err := s.q.GetPackage(ctx, sqlite_queries.GetPackageParams{
+			Name:    ref.Name,
+			Version: ref.Version,
+			System:  ref.System,
+		})
+		if err != nil {
+			return domain.Package{}, mapError(err)
+		}
+		pkg = row.Package
 	}
 
-	return mappedPackages, nil
+	packages, err := s.mapPackages(ctx, []sqlite_queries.Package{pkg})
+	if err != nil {
+		return domain.Package{}, err
+	}
+
+	return packages[0], nil
 }
 
 // PutPackage implements Database.
 func (s *sqliteDatabase) PutPackage(ctx context.Context, pkg domain.NewPackage) error {
-	err := s.q.InsertPackage(ctx, sqlite_queries.InsertPackageParams{
-		Name:         pkg.Name,
-		Version:      pkg.Version,
-		NixStoreHash: pkg.NixMetadata.StorePath,
-		NixMainBin:   pkg.NixMetadata.MainBin,
+	return s.inTx(ctx, func(q *sqlite_queries.Queries) error {
+		licenses, err := json.Marshal(nonNil(pkg.NixMetadata.Licenses))
+		if err != nil {
+			return err
+		}
+		platforms, err := json.Marshal(nonNil(pkg.NixMetadata.Platforms))
+		if err != nil {
+			return err
+		}
+
+		id, err := q.InsertPackage(ctx, sqlite_queries.InsertPackageParams{
+			Name:         pkg.Name,
+			Version:      pkg.Version,
+			NixStoreHash: pkg.NixMetadata.StorePath,
+			NixMainBin:   pkg.NixMetadata.MainBin,
+			System:       pkg.System,
+			Description:  pkg.NixMetadata.Description,
+			Licenses:     string(licenses),
+			Homepage:     pkg.NixMetadata.Homepage,
+			Platforms:    string(platforms),
+			Unfree:       pkg.NixMetadata.Unfree,
+			Insecure:     pkg.NixMetadata.Insecure,
+			Position:     pkg.NixMetadata.Position,
+			Kind:         string(cmp.Or(pkg.Kind, domain.PackageKindApp)),
+		})
+		if err != nil {
+			return err
+		}
+
+		err = q.InsertGcRoot(ctx, pkg.NixMetadata.StorePath)
+		if err != nil {
+			return err
+		}
+
+		if pkg.Provenance != nil {
+			ci, err := json.Marshal(pkg.Provenance.Ci)
+			if err != nil {
+				return err
+			}
+			err = q.InsertPackageProvenance(ctx, sqlite_queries.InsertPackageProvenanceParams{
+				PackageID:     id,
+				FlakeUrl:      pkg.Provenance.FlakeUrl,
+				FlakeRevision: pkg.Provenance.FlakeRevision,
+				FlakeNarHash:  pkg.Provenance.FlakeNarHash,
+				DrvPath:       pkg.Provenance.DrvPath,
+				BuilderHost:   pkg.Provenance.BuilderHost,
+				ClientVersion: pkg.Provenance.ClientVersion,
+				Ci:            string(ci),
+				Signature:     pkg.Provenance.Signature,
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		for name, storePath := range pkg.NixMetadata.Outputs {
+			err = q.InsertPackageOutput(ctx, sqlite_queries.InsertPackageOutputParams{
+				PackageID: id,
+				Name:      name,
+				StorePath: storePath,
+				Install:   slices.Contains(pkg.NixMetadata.OutputsToInstall, name),
+			})
+			if err != nil {
+				return err
+			}
+
+			err = q.InsertGcRoot(ctx, storePath)
+			if err != nil {
+				return err
+			}
+		}
+
+		return putChange(ctx, q, domain.ChangePackagePushed, pkg.Name, pkg)
+	})
+}
+
+// YankPackage implements Database.
+func (s *sqliteDatabase) YankPackage(ctx context.Context, ref domain.PackageRef, reason string) error {
+	return s.inTx(ctx, func(q *sqlite_queries.Queries) error {
+		yankedAt := time.Now().UTC()
+		affected, err := q.YankPackage(ctx, sqlite_queries.YankPackageParams{
+			YankedAt:   &yankedAt,
+			YankReason: &reason,
+			Name:       ref.Name,
+			Version:    ref.Version,
+			System:     ref.System,
+		})
+		if err != nil {
+			return err
+		}
+		if affected == 0 {
+			return ErrNotFound
+		}
+
+		return putChange(ctx, q, domain.ChangePackageYanked, ref.Name, domain.PackageYankChange{
+			Ref:    ref,
+			Reason: reason,
+		})
+	})
+}
+
+// DeletePackage implements Database.
+func (s *sqliteDatabase) DeletePackage(ctx context.Context, ref domain.PackageRef, dropGcRoot bool) error {
+	return s.inTx(ctx, func(q *sqlite_queries.Queries) error {
+		err := q.DeletePackageProvenances(ctx, sqlite_queries.DeletePackageProvenancesParams{
+			Name:    ref.Name,
+			Version: ref.Version,
+			System:  ref.System,
+		})
+		if err != nil {
+			return err
+		}
+		err = q.DeletePackageVulnerabilities(ctx, sqlite_queries.DeletePackageVulnerabilitiesParams{
+			Name:    ref.Name,
+			Version: ref.Version,
+			System:  ref.System,
+		})
+		if err != nil {
+			return err
+		}
+		outputPaths, err := q.DeletePackageOutputs(ctx, sqlite_queries.DeletePackageOutputsParams{
+			Name:    ref.Name,
+			Version: ref.Version,
+			System:  ref.System,
+		})
+		if err != nil {
+			return err
+		}
+		storePaths, err := q.DeletePackage(ctx, sqlite_queries.DeletePackageParams{
+			Name:    ref.Name,
+			Version: ref.Version,
+			System:  ref.System,
+		})
+		if err != nil {
+			return err
+		}
+		if len(storePaths) == 0 {
+			return ErrNotFound
+		}
+		err = putChange(ctx, q, domain.ChangePackageDeleted, ref.Name, domain.PackageDeleteChange{
+			Ref:        ref,
+			DropGcRoot: dropGcRoot,
+		})
+		if err != nil {
+			return err
+		}
+		if !dropGcRoot {
+			return nil
+		}
+
+		storePaths = append(storePaths, outputPaths...)
+		dropped := []string{}
+		for _, storePath := range storePaths {
+			deleted, err := q.DeleteUnusedGcRoot(ctx, storePath)
+			if err != nil {
+				return err
+			}
+			if deleted > 0 {
+				dropped = append(dropped, storePath)
+			}
+		}
+		if len(dropped) == 0 {
+			return nil
+		}
+
+		return putChange(ctx, q, domain.ChangeGcRun, ref.Name, domain.GcRunChange{
+			Ref:          ref,
+			DroppedRoots: dropped,
+		})
 	})
+}
+
+func (s *sqliteDatabase) inTx(ctx context.Context, fn func(q *sqlite_queries.Queries) error) error {
+	tx, err := s.pool.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
+
+	err = fn(s.q.WithTx(tx))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// mapPackages maps package rows together with their outputs, provenance and vulnerabilities.
+func (s *sqliteDatabase) mapPackages(ctx context.Context, packages []sqlite_queries.Package) ([]domain.Package, error) {
+	mappedPackages := []domain.Package{}
+	if len(packages) == 0 {
+		return mappedPackages, nil
+	}
+
+	ids := []int64{}
+	for _, p := range packages {
+		ids = append(ids, p.ID)
+	}
+
+	outputRows, err := s.q.ListPackageOutputs(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	outputs := map[int64][]sqlite_queries.PackageOutput{}
+	for _, o := range outputRows {
+		outputs[o.PackageID] = append(outputs[o.PackageID], o)
+	}
+
+	provenanceRows, err := s.q.ListPackageProvenances(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	provenances := map[int64]sqlite_queries.PackageProvenance{}
+	for _, p := range provenanceRows {
+		provenances[p.PackageID] = p
+	}
+
+	vulnerabilityRows, err := s.q.ListVulnerabilities(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	vulnerabilities := map[int64][]domain.Vulnerability{}
+	for _, v := range vulnerabilityRows {
+		vulnerability, err := mapVulnerability(v)
+		if err != nil {
+			return nil, err
+		}
+		vulnerabilities[v.PackageID] = append(vulnerabilities[v.PackageID], vulnerability)
+	}
+
+	for _, p := range packages {
+		pkg, err := mapPackage(p, outputs[p.ID])
+		if err != nil {
+			return nil, err
+		}
+		pkg.Vulnerabilities = nonNil(vulnerabilities[p.ID])
+		if provenance, ok := provenances[p.ID]; ok {
+			pkg.Provenance, err = mapProvenance(provenance)
+			if err != nil {
+				return nil, err
+			}
+		}
+		mappedPackages = append(mappedPackages, pkg)
+	}
+
+	return mappedPackages, nil
+}
+
+func mapProvenance(p sqlite_queries.PackageProvenance) (*domain.Provenance, error) {
+	ci := map[string]string{}
+	err := json.Unmarshal([]byte(p.Ci), &ci)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode ci of package provenance %d: %w", p.PackageID, err)
+	}
+
+	return &domain.Provenance{
+		FlakeUrl:      p.FlakeUrl,
+		FlakeRevision: p.FlakeRevision,
+		FlakeNarHash:  p.FlakeNarHash,
+		DrvPath:       p.DrvPath,
+		BuilderHost:   p.BuilderHost,
+		ClientVersion: p.ClientVersion,
+		Ci:            ci,
+		Signature:     p.Signature,
+	}, nil
+}
+
+func mapPackage(p sqlite_queries.Package, outputs []sqlite_queries.PackageOutput) (domain.Package, error) {
+	licenses := []string{}
+	err := json.Unmarshal([]byte(p.Licenses), &licenses)
+	if err != nil {
+		return domain.Package{}, fmt.Errorf("Failed to decode licenses of package %d: %w", p.ID, err)
+	}
+	platforms := []string{}
+	err = json.Unmarshal([]byte(p.Platforms), &platforms)
+	if err != nil {
+		return domain.Package{}, fmt.Errorf("Failed to decode platforms of package %d: %w", p.ID, err)
+	}
+
+	pkg := domain.Package{
+		ID:      p.ID,
+		Name:    p.Name,
+		Version: p.Version,
+		System:  p.System,
+		Kind:    domain.PackageKind(p.Kind),
+		NixMetadata: domain.NixMetadata{
+			StorePath:        p.NixStoreHash,
+			MainBin:          p.NixMainBin,
+			Outputs:          map[string]string{},
+			OutputsToInstall: []string{},
+			Description:      p.Description,
+			Licenses:         licenses,
+			Homepage:         p.Homepage,
+			Platforms:        platforms,
+			Unfree:           p.Unfree,
+			Insecure:         p.Insecure,
+			Position:         p.Position,
+		},
+	}
+	for _, o := range outputs {
+		pkg.NixMetadata.Outputs[o.Name] = o.StorePath
+		if o.Install {
+			pkg.NixMetadata.OutputsToInstall = append(pkg.NixMetadata.OutputsToInstall, o.Name)
+		}
+	}
+	if p.YankedAt != nil {
+		pkg.Yank = &domain.Yank{
+			YankedAt: *p.YankedAt,
+			Reason:   deref(p.YankReason),
+		}
+	}
+
+	return pkg, nil
+}
+
+func mapError(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+
+	return err
+}
+
+func nonNil[T any](s []T) []T {
+	if s == nil {
+		return []T{}
+	}
+
+	return s
+}
+
+func deref[T any](v *T) T {
+	var zero T
+	if v == nil {
+		return zero
+	}
 
-	return nil
+	return *v
 }
 
 var _ (Database) = (*sqliteDatabase)(nil)
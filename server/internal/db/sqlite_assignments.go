@@ -0,0 +1,214 @@
+package db
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	sqlite_queries "server/internal/db/sqlite_generated"
+	"server/internal/domain"
+	"strconv"
+	"time"
+)
+
+// PutAssignment implements Database.
+func (s *sqliteDatabase) PutAssignment(ctx context.Context, assignment domain.Assignment) error {
+	return s.inTx(ctx, func(q *sqlite_queries.Queries) error {
+		err := q.UpsertAssignment(ctx, sqlite_queries.UpsertAssignmentParams{
+			MachineName:    assignment.Target.Machine,
+			MachineGroup:   assignment.Target.Group,
+			PackageName:    assignment.PackageName,
+			PackageVersion: assignment.PackageVersion,
+			ActivationMode: string(cmp.Or(assignment.Mode, domain.ActivationSwitch)),
+			Emergency:      assignment.Emergency,
+			CreatedAt:      time.Now().UTC(),
+		})
+		if err != nil {
+			return err
+		}
+
+		return putChange(ctx, q, domain.ChangeAssignmentPut, assignment.PackageName, assignment)
+	})
+}
+
+// DeleteAssignment implements Database.
+func (s *sqliteDatabase) DeleteAssignment(ctx context.Context, target domain.AssignmentTarget, packageName string) error {
+	return s.inTx(ctx, func(q *sqlite_queries.Queries) error {
+		affected, err := q.DeleteAssignment(ctx, sqlite_queries.DeleteAssignmentParams{
+			MachineName:  target.Machine,
+			MachineGroup: target.Group,
+			PackageName:  packageName,
+		})
+		if err != nil {
+			return err
+		}
+		if affected == 0 {
+			return ErrNotFound
+		}
+
+		return putChange(ctx, q, domain.ChangeAssignmentDeleted, packageName, domain.AssignmentDeleteChange{
+			Target:      target,
+			PackageName: packageName,
+		})
+	})
+}
+
+// ListAssignments implements Database.
+func (s *sqliteDatabase) ListAssignments(ctx context.Context) ([]domain.Assignment, error) {
+	rows, err := s.q.ListAssignments(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	assignments := []domain.Assignment{}
+	for _, row := range rows {
+		assignments = append(assignments, domain.Assignment{
+			ID: row.Assignment.ID,
+			Target: domain.AssignmentTarget{
+				Machine: row.Assignment.MachineName,
+				Group:   row.Assignment.MachineGroup,
+			},
+			PackageName:    row.Assignment.PackageName,
+			PackageVersion: row.Assignment.PackageVersion,
+			Mode:           domain.ActivationMode(row.Assignment.ActivationMode),
+			Emergency:      row.Assignment.Emergency,
+			CreatedAt:      row.Assignment.CreatedAt,
+		})
+	}
+
+	return assignments, nil
+}
+
+// PutActivation implements Database.
+func (s *sqliteDatabase) PutActivation(ctx context.Context, activation domain.Activation) error {
+	storePaths, err := json.Marshal(nonNil(activation.StorePaths))
+	if err != nil {
+		return err
+	}
+
+	return s.inTx(ctx, func(q *sqlite_queries.Queries) error {
+		err := q.InsertActivation(ctx, sqlite_queries.InsertActivationParams{
+			MachineID:  activation.MachineID,
+			StorePaths: string(storePaths),
+			Success:    activation.Success,
+			Error:      activation.Error,
+			RolledBack: activation.RolledBack,
+			Logs:       activation.Logs,
+			ReportedAt: activation.ReportedAt.UTC(),
+		})
+		if err != nil {
+			return err
+		}
+
+		return putChange(ctx, q, domain.ChangeActivationReported, strconv.FormatInt(activation.MachineID, 10), activation)
+	})
+}
+
+// GetLatestActivation implements Database.
+func (s *sqliteDatabase) GetLatestActivation(ctx context.Context, machineId int64) (domain.Activation, error) {
+	row, err := s.q.GetLatestActivation(ctx, machineId)
+	if err != nil {
+		return domain.Activation{}, mapError(err)
+	}
+
+	return mapActivation(row.Activation)
+}
+
+// GetLatestSuccessfulActivation implements Database.
+func (s *sqliteDatabase) GetLatestSuccessfulActivation(ctx context.Context, machineId int64) (domain.Activation, error) {
+	row, err := s.q.GetLatestSuccessfulActivation(ctx, machineId)
+	if err != nil {
+		return domain.Activation{}, mapError(err)
+	}
+
+	return mapActivation(row.Activation)
+}
+
+// PutFailedVersion implements Database.
+func (s *sqliteDatabase) PutFailedVersion(ctx context.Context, failed domain.FailedVersion) error {
+	return s.inTx(ctx, func(q *sqlite_queries.Queries) error {
+		affected, err := q.InsertFailedVersion(ctx, sqlite_queries.InsertFailedVersionParams{
+			PackageName:    failed.PackageName,
+			PackageVersion: failed.PackageVersion,
+			GroupName:      failed.Group,
+			MachineID:      failed.MachineID,
+			Reason:         failed.Reason,
+			FailedAt:       failed.FailedAt.UTC(),
+		})
+		if err != nil {
+			return err
+		}
+		if affected == 0 {
+			// The first failure of the version is kept
+			return nil
+		}
+
+		return putChange(ctx, q, domain.ChangeFailedVersionPut, failed.PackageName, failed)
+	})
+}
+
+// ListFailedVersions implements Database.
+func (s *sqliteDatabase) ListFailedVersions(ctx context.Context, ref domain.PackageRef) ([]domain.FailedVersion, error) {
+	rows, err := s.q.ListFailedVersions(ctx, sqlite_queries.ListFailedVersionsParams{
+		PackageName:    ref.Name,
+		PackageVersion: ref.Version,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	failed := []domain.FailedVersion{}
+	for _, row := range rows {
+		failed = append(failed, domain.FailedVersion{
+			PackageName:    row.FailedVersion.PackageName,
+			PackageVersion: row.FailedVersion.PackageVersion,
+			Group:          row.FailedVersion.GroupName,
+			MachineID:      row.FailedVersion.MachineID,
+			MachineName:    row.MachineName,
+			Reason:         row.FailedVersion.Reason,
+			FailedAt:       row.FailedVersion.FailedAt,
+		})
+	}
+
+	return failed, nil
+}
+
+// DeleteFailedVersion implements Database.
+func (s *sqliteDatabase) DeleteFailedVersion(ctx context.Context, ref domain.PackageRef, group string) error {
+	return s.inTx(ctx, func(q *sqlite_queries.Queries) error {
+		affected, err := q.DeleteFailedVersion(ctx, sqlite_queries.DeleteFailedVersionParams{
+			PackageName:    ref.Name,
+			PackageVersion: ref.Version,
+			GroupName:      group,
+		})
+		if err != nil {
+			return err
+		}
+		if affected == 0 {
+			return ErrNotFound
+		}
+
+		return putChange(ctx, q, domain.ChangeFailedVersionDeleted, ref.Name, domain.FailedVersionDeleteChange{
+			Ref:   ref,
+			Group: group,
+		})
+	})
+}
+
+func mapActivation(row sqlite_queries.Activation) (domain.Activation, error) {
+	storePaths := []string{}
+	err := json.Unmarshal([]byte(row.StorePaths), &storePaths)
+	if err != nil {
+		return domain.Activation{}, fmt.Errorf("Failed to decode store paths of activation %d: %w", row.ID, err)
+	}
+
+	return domain.Activation{
+		MachineID:  row.MachineID,
+		StorePaths: storePaths,
+		Success:    row.Success,
+		Error:      row.Error,
+		RolledBack: row.RolledBack,
+		Logs:       row.Logs,
+		ReportedAt: row.ReportedAt,
+	}, nil
+}
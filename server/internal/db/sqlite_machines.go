@@ -0,0 +1,406 @@
+package db
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	sqlite_queries "server/internal/db/sqlite_generated"
+	"server/internal/domain"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrAlreadyExists = errors.New("Already exists")
+	// ErrInvalidToken is returned for unknown, expired or already used bootstrap tokens
+	ErrInvalidToken = errors.New("Invalid bootstrap token")
+)
+
+// PutBootstrapToken implements Database.
+func (s *sqliteDatabase) PutBootstrapToken(ctx context.Context, tokenHash string, token domain.BootstrapToken) error {
+	groups, err := json.Marshal(nonNil(token.Groups))
+	if err != nil {
+		return err
+	}
+	labels, err := json.Marshal(nonNilMap(token.Labels))
+	if err != nil {
+		return err
+	}
+
+	return s.inTx(ctx, func(q *sqlite_queries.Queries) error {
+		err := q.InsertBootstrapToken(ctx, sqlite_queries.InsertBootstrapTokenParams{
+			TokenHash:  tokenHash,
+			CreatedBy:  token.CreatedBy,
+			GroupNames: string(groups),
+			Labels:     string(labels),
+			ExpiresAt:  token.ExpiresAt.UTC(),
+		})
+		if err != nil {
+			return err
+		}
+
+		// Hash of the token is not recorded
+		return putChange(ctx, q, domain.ChangeBootstrapTokenCreated, token.CreatedBy, token)
+	})
+}
+
+// RegisterMachine implements Database.
+func (s *sqliteDatabase) RegisterMachine(ctx context.Context, tokenHash string, machine domain.NewMachine) (domain.Machine, error) {
+	var registered domain.Machine
+	err := s.inTx(ctx, func(q *sqlite_queries.Queries) error {
+		row, err := q.GetBootstrapToken(ctx, tokenHash)
+		if err != nil {
+			if errors.Is(mapError(err), ErrNotFound) {
+				return ErrInvalidToken
+			}
+			return err
+		}
+		token := row.BootstrapToken
+		now := time.Now().UTC()
+		if token.UsedAt != nil || now.After(token.ExpiresAt) {
+			return ErrInvalidToken
+		}
+
+		_, err = q.GetMachineByName(ctx, machine.Name)
+		if err == nil {
+			return fmt.Errorf("Machine %s: %w", machine.Name, ErrAlreadyExists)
+		}
+		if !errors.Is(mapError(err), ErrNotFound) {
+			return err
+		}
+
+		// Labels of the token take precedence over labels requested by the machine
+		labels := map[string]string{}
+		maps.Copy(labels, machine.Labels)
+		tokenLabels := map[string]string{}
+		err = json.Unmarshal([]byte(token.Labels), &tokenLabels)
+		if err != nil {
+			return fmt.Errorf("Failed to decode labels of bootstrap token: %w", err)
+		}
+		maps.Copy(labels, tokenLabels)
+		encodedLabels, err := json.Marshal(labels)
+		if err != nil {
+			return err
+		}
+
+		inserted, err := q.InsertMachine(ctx, sqlite_queries.InsertMachineParams{
+			Name:       machine.Name,
+			System:     machine.System,
+			PublicKey:  base64.StdEncoding.EncodeToString(machine.PublicKey),
+			Labels:     string(encodedLabels),
+			GroupNames: token.GroupNames,
+			EnrolledAt: now,
+		})
+		if err != nil {
+			return err
+		}
+
+		affected, err := q.UseBootstrapToken(ctx, sqlite_queries.UseBootstrapTokenParams{
+			UsedAt:    &now,
+			MachineID: &inserted.ID,
+			TokenHash: tokenHash,
+		})
+		if err != nil {
+			return err
+		}
+		if affected == 0 {
+			return ErrInvalidToken
+		}
+
+		registered, err = mapMachine(inserted)
+		if err != nil {
+			return err
+		}
+
+		return putChange(ctx, q, domain.ChangeMachineRegistered, registered.Name, registered)
+	})
+	if err != nil {
+		return domain.Machine{}, err
+	}
+
+	return registered, nil
+}
+
+// ListMachines implements Database.
+func (s *sqliteDatabase) ListMachines(ctx context.Context, filter domain.MachineFilter) ([]domain.Machine, error) {
+	rows, err := s.q.ListMachines(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	statusRows, err := s.q.ListMachineStatuses(ctx)
+	if err != nil {
+		return nil, err
+	}
+	statuses := map[int64]sqlite_queries.MachineStatus{}
+	for _, row := range statusRows {
+		statuses[row.MachineStatus.MachineID] = row.MachineStatus
+	}
+	driftRows, err := s.q.ListMachineDrifts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	drifts := map[int64]sqlite_queries.MachineDrift{}
+	for _, row := range driftRows {
+		drifts[row.MachineDrift.MachineID] = row.MachineDrift
+	}
+
+	machines := []domain.Machine{}
+	for _, row := range rows {
+		machine, err := mapMachine(row.Machine)
+		if err != nil {
+			return nil, err
+		}
+		if status, ok := statuses[machine.ID]; ok {
+			machine.Status, err = mapMachineStatus(status)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if drift, ok := drifts[machine.ID]; ok {
+			machine.Drift, err = mapMachineDrift(drift)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if filter.Matches(machine) {
+			machines = append(machines, machine)
+		}
+	}
+
+	return machines, nil
+}
+
+// GetMachine implements Database.
+func (s *sqliteDatabase) GetMachine(ctx context.Context, id int64) (domain.Machine, error) {
+	row, err := s.q.GetMachine(ctx, id)
+	if err != nil {
+		return domain.Machine{}, mapError(err)
+	}
+
+	return s.mapMachineWithStatus(ctx, row.Machine)
+}
+
+// GetMachineByName implements Database.
+func (s *sqliteDatabase) GetMachineByName(ctx context.Context, name string) (domain.Machine, error) {
+	row, err := s.q.GetMachineByName(ctx, name)
+	if err != nil {
+		return domain.Machine{}, mapError(err)
+	}
+
+	return s.mapMachineWithStatus(ctx, row.Machine)
+}
+
+// PutMachineStatus implements Database.
+func (s *sqliteDatabase) PutMachineStatus(ctx context.Context, machineId int64, status domain.MachineStatus) error {
+	storePaths, err := json.Marshal(nonNil(status.StorePaths))
+	if err != nil {
+		return err
+	}
+	stagedStorePaths, err := json.Marshal(nonNil(status.StagedStorePaths))
+	if err != nil {
+		return err
+	}
+
+	return s.inTx(ctx, func(q *sqlite_queries.Queries) error {
+		changed := true
+		row, err := q.GetMachineStatus(ctx, machineId)
+		if err == nil {
+			previous, err := mapMachineStatus(row.MachineStatus)
+			if err != nil {
+				return err
+			}
+			changed = !previous.Equal(status)
+		} else if !errors.Is(mapError(err), ErrNotFound) {
+			return err
+		}
+
+		err = q.UpsertMachineStatus(ctx, sqlite_queries.UpsertMachineStatusParams{
+			MachineID:         machineId,
+			ProfileGeneration: status.ProfileGeneration,
+			StorePaths:        string(storePaths),
+			NixVersion:        status.NixVersion,
+			FreeDiskBytes:     status.FreeDiskBytes,
+			LastError:         status.LastError,
+			RunningSystem:     status.RunningSystem,
+			BootedSystem:      status.BootedSystem,
+			StagedStorePaths:  string(stagedStorePaths),
+			PeerUrl:           status.PeerUrl,
+			ReportedAt:        status.ReportedAt.UTC(),
+		})
+		if err != nil || !changed {
+			return err
+		}
+
+		return putChange(ctx, q, domain.ChangeMachineStatus, strconv.FormatInt(machineId, 10), domain.MachineStatusChange{
+			MachineID: machineId,
+			Status:    status,
+		})
+	})
+}
+
+func (s *sqliteDatabase) mapMachineWithStatus(ctx context.Context, m sqlite_queries.Machine) (domain.Machine, error) {
+	machine, err := mapMachine(m)
+	if err != nil {
+		return domain.Machine{}, err
+	}
+	row, err := s.q.GetMachineStatus(ctx, m.ID)
+	if err != nil {
+		if errors.Is(mapError(err), ErrNotFound) {
+			return machine, nil
+		}
+		return domain.Machine{}, err
+	}
+	machine.Status, err = mapMachineStatus(row.MachineStatus)
+	if err != nil {
+		return domain.Machine{}, err
+	}
+	driftRow, err := s.q.GetMachineDrift(ctx, m.ID)
+	if err != nil {
+		if errors.Is(mapError(err), ErrNotFound) {
+			return machine, nil
+		}
+		return domain.Machine{}, err
+	}
+	machine.Drift, err = mapMachineDrift(driftRow.MachineDrift)
+	if err != nil {
+		return domain.Machine{}, err
+	}
+
+	return machine, nil
+}
+
+// PutMachineDrift implements Database.
+func (s *sqliteDatabase) PutMachineDrift(ctx context.Context, drift domain.MachineDrift) error {
+	missing, err := json.Marshal(nonNil(drift.Missing))
+	if err != nil {
+		return err
+	}
+	unexpected, err := json.Marshal(nonNil(drift.Unexpected))
+	if err != nil {
+		return err
+	}
+	var since *time.Time
+	if drift.Since != nil {
+		utc := drift.Since.UTC()
+		since = &utc
+	}
+
+	return s.inTx(ctx, func(q *sqlite_queries.Queries) error {
+		changed := true
+		row, err := q.GetMachineDrift(ctx, drift.MachineID)
+		if err == nil {
+			previous, err := mapMachineDrift(row.MachineDrift)
+			if err != nil {
+				return err
+			}
+			changed = !previous.Equal(drift)
+		} else if !errors.Is(mapError(err), ErrNotFound) {
+			return err
+		}
+
+		err = q.UpsertMachineDrift(ctx, sqlite_queries.UpsertMachineDriftParams{
+			MachineID:     drift.MachineID,
+			Missing:       string(missing),
+			Unexpected:    string(unexpected),
+			DesiredSystem: drift.DesiredSystem,
+			ActualSystem:  drift.ActualSystem,
+			Error:         drift.Error,
+			Since:         since,
+			CheckedAt:     drift.CheckedAt.UTC(),
+		})
+		if err != nil || !changed {
+			return err
+		}
+
+		return putChange(ctx, q, domain.ChangeMachineDrift, strconv.FormatInt(drift.MachineID, 10), drift)
+	})
+}
+
+func mapMachineDrift(d sqlite_queries.MachineDrift) (*domain.MachineDrift, error) {
+	missing := []string{}
+	err := json.Unmarshal([]byte(d.Missing), &missing)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode missing store paths of machine %d: %w", d.MachineID, err)
+	}
+	unexpected := []string{}
+	err = json.Unmarshal([]byte(d.Unexpected), &unexpected)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode unexpected store paths of machine %d: %w", d.MachineID, err)
+	}
+
+	return &domain.MachineDrift{
+		MachineID:     d.MachineID,
+		Missing:       missing,
+		Unexpected:    unexpected,
+		DesiredSystem: d.DesiredSystem,
+		ActualSystem:  d.ActualSystem,
+		Error:         d.Error,
+		Since:         d.Since,
+		CheckedAt:     d.CheckedAt,
+	}, nil
+}
+
+func mapMachineStatus(s sqlite_queries.MachineStatus) (*domain.MachineStatus, error) {
+	storePaths := []string{}
+	err := json.Unmarshal([]byte(s.StorePaths), &storePaths)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode store paths of machine %d status: %w", s.MachineID, err)
+	}
+	stagedStorePaths := []string{}
+	err = json.Unmarshal([]byte(s.StagedStorePaths), &stagedStorePaths)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode staged store paths of machine %d status: %w", s.MachineID, err)
+	}
+
+	return &domain.MachineStatus{
+		ProfileGeneration: s.ProfileGeneration,
+		StorePaths:        storePaths,
+		NixVersion:        s.NixVersion,
+		FreeDiskBytes:     s.FreeDiskBytes,
+		LastError:         s.LastError,
+		RunningSystem:     s.RunningSystem,
+		BootedSystem:      s.BootedSystem,
+		StagedStorePaths:  stagedStorePaths,
+		PeerUrl:           s.PeerUrl,
+		ReportedAt:        s.ReportedAt,
+	}, nil
+}
+
+func mapMachine(m sqlite_queries.Machine) (domain.Machine, error) {
+	publicKey, err := base64.StdEncoding.DecodeString(m.PublicKey)
+	if err != nil {
+		return domain.Machine{}, fmt.Errorf("Failed to decode public key of machine %s: %w", m.Name, err)
+	}
+	labels := map[string]string{}
+	err = json.Unmarshal([]byte(m.Labels), &labels)
+	if err != nil {
+		return domain.Machine{}, fmt.Errorf("Failed to decode labels of machine %s: %w", m.Name, err)
+	}
+	groups := []string{}
+	err = json.Unmarshal([]byte(m.GroupNames), &groups)
+	if err != nil {
+		return domain.Machine{}, fmt.Errorf("Failed to decode groups of machine %s: %w", m.Name, err)
+	}
+
+	return domain.Machine{
+		ID:         m.ID,
+		Name:       m.Name,
+		System:     m.System,
+		PublicKey:  publicKey,
+		Labels:     labels,
+		Groups:     groups,
+		EnrolledAt: m.EnrolledAt,
+	}, nil
+}
+
+func nonNilMap[K comparable, V any](m map[K]V) map[K]V {
+	if m == nil {
+		return map[K]V{}
+	}
+
+	return m
+}
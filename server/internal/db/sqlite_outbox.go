@@ -0,0 +1,77 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	sqlite_queries "server/internal/db/sqlite_generated"
+	"server/internal/domain"
+	"time"
+)
+
+// PutChange implements Database.
+func (s *sqliteDatabase) PutChange(ctx context.Context, change domain.Change) (int64, error) {
+	return s.q.InsertOutboxChange(ctx, sqlite_queries.InsertOutboxChangeParams{
+		Kind:      string(change.Kind),
+		Subject:   change.Subject,
+		Data:      string(change.Data),
+		CreatedAt: time.Now().UTC(),
+	})
+}
+
+// ListChanges implements Database.
+func (s *sqliteDatabase) ListChanges(ctx context.Context, afterId int64, limit int) ([]domain.Change, error) {
+	rows, err := s.q.ListOutboxChanges(ctx, sqlite_queries.ListOutboxChangesParams{
+		AfterID: afterId,
+		Limit:   int64(limit),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	changes := []domain.Change{}
+	for _, row := range rows {
+		changes = append(changes, domain.Change{
+			ID:        row.Outbox.ID,
+			Kind:      domain.ChangeKind(row.Outbox.Kind),
+			Subject:   row.Outbox.Subject,
+			Data:      []byte(row.Outbox.Data),
+			CreatedAt: row.Outbox.CreatedAt,
+		})
+	}
+
+	return changes, nil
+}
+
+// GetLatestChangeID implements Database.
+func (s *sqliteDatabase) GetLatestChangeID(ctx context.Context) (int64, error) {
+	return s.q.GetLatestOutboxChangeID(ctx)
+}
+
+// GetOldestChangeID implements Database.
+func (s *sqliteDatabase) GetOldestChangeID(ctx context.Context) (int64, error) {
+	return s.q.GetOldestOutboxChangeID(ctx)
+}
+
+// DeleteChangesBefore implements Database.
+func (s *sqliteDatabase) DeleteChangesBefore(ctx context.Context, before time.Time) (int64, error) {
+	return s.q.DeleteOutboxChangesBefore(ctx, before.UTC())
+}
+
+// putChange appends change to the outbox in the transaction of the mutation it describes.
+func putChange(ctx context.Context, q *sqlite_queries.Queries, kind domain.ChangeKind, subject string, payload any) error {
+	change, err := domain.NewChange(kind, subject, payload)
+	if err != nil {
+		return fmt.Errorf("Failed to encode %s change of %s: %w", kind, subject, err)
+	}
+	_, err = q.InsertOutboxChange(ctx, sqlite_queries.InsertOutboxChangeParams{
+		Kind:      string(change.Kind),
+		Subject:   change.Subject,
+		Data:      string(change.Data),
+		CreatedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to append %s change of %s to outbox: %w", kind, subject, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,43 @@
+package db
+
+import (
+	"context"
+	sqlite_queries "server/internal/db/sqlite_generated"
+	"server/internal/domain"
+	"time"
+)
+
+// GetReplicationCursor implements Database.
+func (s *sqliteDatabase) GetReplicationCursor(ctx context.Context, primaryUrl string) (domain.ReplicationCursor, error) {
+	row, err := s.q.GetReplicationCursor(ctx, primaryUrl)
+	if err != nil {
+		return domain.ReplicationCursor{}, mapError(err)
+	}
+
+	return domain.ReplicationCursor{
+		PrimaryUrl:   row.ReplicationCursor.PrimaryUrl,
+		LastChangeID: row.ReplicationCursor.LastChangeID,
+		PromotedAt:   row.ReplicationCursor.PromotedAt,
+		UpdatedAt:    row.ReplicationCursor.UpdatedAt,
+	}, nil
+}
+
+// PutReplicationCursor implements Database.
+func (s *sqliteDatabase) PutReplicationCursor(ctx context.Context, primaryUrl string, lastChangeId int64) error {
+	return s.q.UpsertReplicationCursor(ctx, sqlite_queries.UpsertReplicationCursorParams{
+		PrimaryUrl:   primaryUrl,
+		LastChangeID: lastChangeId,
+		UpdatedAt:    time.Now().UTC(),
+	})
+}
+
+// PromoteReplica implements Database.
+func (s *sqliteDatabase) PromoteReplica(ctx context.Context, primaryUrl string) error {
+	now := time.Now().UTC()
+
+	return s.q.PromoteReplicationCursor(ctx, sqlite_queries.PromoteReplicationCursorParams{
+		PrimaryUrl: primaryUrl,
+		PromotedAt: &now,
+		UpdatedAt:  now,
+	})
+}
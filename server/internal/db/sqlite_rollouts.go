@@ -0,0 +1,205 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	sqlite_queries "server/internal/db/sqlite_generated"
+	"server/internal/domain"
+	"strconv"
+	"time"
+)
+
+// PutRollout implements Database.
+func (s *sqliteDatabase) PutRollout(ctx context.Context, rollout domain.NewRollout, machines []domain.RolloutMachine) (int64, error) {
+	labels, err := json.Marshal(nonNilMap(rollout.Selector.Labels))
+	if err != nil {
+		return 0, err
+	}
+	percentages, err := json.Marshal(nonNil(rollout.WavePercentages))
+	if err != nil {
+		return 0, err
+	}
+
+	var id int64
+	err = s.inTx(ctx, func(q *sqlite_queries.Queries) error {
+		id, err = q.InsertRollout(ctx, sqlite_queries.InsertRolloutParams{
+			PackageName:     rollout.PackageName,
+			PackageVersion:  rollout.PackageVersion,
+			SelectorGroup:   rollout.Selector.Group,
+			SelectorLabels:  string(labels),
+			CanaryCount:     int64(rollout.CanaryCount),
+			WavePercentages: string(percentages),
+			MaxFailureRate:  rollout.MaxFailureRate,
+			SoakSeconds:     int64(rollout.Soak.Seconds()),
+			State:           string(rollout.InitialState()),
+			CreatedBy:       rollout.CreatedBy,
+			CreatedAt:       time.Now().UTC(),
+			Emergency:       rollout.Emergency,
+			Prestage:        rollout.Prestage,
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, m := range machines {
+			err = q.InsertRolloutMachine(ctx, sqlite_queries.InsertRolloutMachineParams{
+				RolloutID: id,
+				MachineID: m.MachineID,
+				Wave:      int64(m.Wave),
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		return putChange(ctx, q, domain.ChangeRolloutCreated, strconv.FormatInt(id, 10), domain.RolloutCreateChange{
+			ID:      id,
+			Rollout: rollout,
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// ListRollouts implements Database.
+func (s *sqliteDatabase) ListRollouts(ctx context.Context, state domain.RolloutState) ([]domain.Rollout, error) {
+	rows, err := s.q.ListRollouts(ctx, string(state))
+	if err != nil {
+		return nil, err
+	}
+
+	rollouts := []domain.Rollout{}
+	for _, row := range rows {
+		rollout, err := mapRollout(row.Rollout)
+		if err != nil {
+			return nil, err
+		}
+		rollouts = append(rollouts, rollout)
+	}
+
+	return rollouts, nil
+}
+
+// GetRollout implements Database.
+func (s *sqliteDatabase) GetRollout(ctx context.Context, id int64) (domain.Rollout, error) {
+	row, err := s.q.GetRollout(ctx, id)
+	if err != nil {
+		return domain.Rollout{}, mapError(err)
+	}
+
+	return mapRollout(row.Rollout)
+}
+
+// UpdateRolloutState implements Database.
+func (s *sqliteDatabase) UpdateRolloutState(ctx context.Context, rollout domain.Rollout) error {
+	return s.inTx(ctx, func(q *sqlite_queries.Queries) error {
+		err := q.UpdateRolloutState(ctx, sqlite_queries.UpdateRolloutStateParams{
+			ID:            rollout.ID,
+			State:         string(rollout.State),
+			StateReason:   rollout.StateReason,
+			CurrentWave:   int64(rollout.CurrentWave),
+			WaveStartedAt: rollout.WaveStartedAt.UTC(),
+		})
+		if err != nil {
+			return err
+		}
+
+		return putChange(ctx, q, domain.ChangeRolloutUpdated, strconv.FormatInt(rollout.ID, 10), rollout)
+	})
+}
+
+// ListRolloutMachines implements Database.
+func (s *sqliteDatabase) ListRolloutMachines(ctx context.Context, rolloutId int64) ([]domain.RolloutMachine, error) {
+	rows, err := s.q.ListRolloutMachines(ctx, rolloutId)
+	if err != nil {
+		return nil, err
+	}
+
+	machines := []domain.RolloutMachine{}
+	for _, row := range rows {
+		machines = append(machines, domain.RolloutMachine{
+			MachineID:   row.MachineID,
+			MachineName: row.Name,
+			Wave:        int(row.Wave),
+		})
+	}
+
+	return machines, nil
+}
+
+// ListMachineRollouts implements Database.
+func (s *sqliteDatabase) ListMachineRollouts(ctx context.Context, machineId int64) ([]domain.Rollout, error) {
+	rows, err := s.q.ListMachineRollouts(ctx, machineId)
+	if err != nil {
+		return nil, err
+	}
+
+	rollouts := []domain.Rollout{}
+	for _, row := range rows {
+		rollout, err := mapRollout(row.Rollout)
+		if err != nil {
+			return nil, err
+		}
+		rollouts = append(rollouts, rollout)
+	}
+
+	return rollouts, nil
+}
+
+// ListMachineStagingRollouts implements Database.
+func (s *sqliteDatabase) ListMachineStagingRollouts(ctx context.Context, machineId int64) ([]domain.Rollout, error) {
+	rows, err := s.q.ListMachineStagingRollouts(ctx, machineId)
+	if err != nil {
+		return nil, err
+	}
+
+	rollouts := []domain.Rollout{}
+	for _, row := range rows {
+		rollout, err := mapRollout(row.Rollout)
+		if err != nil {
+			return nil, err
+		}
+		rollouts = append(rollouts, rollout)
+	}
+
+	return rollouts, nil
+}
+
+func mapRollout(r sqlite_queries.Rollout) (domain.Rollout, error) {
+	labels := map[string]string{}
+	err := json.Unmarshal([]byte(r.SelectorLabels), &labels)
+	if err != nil {
+		return domain.Rollout{}, fmt.Errorf("Failed to decode selector labels of rollout %d: %w", r.ID, err)
+	}
+	percentages := []int{}
+	err = json.Unmarshal([]byte(r.WavePercentages), &percentages)
+	if err != nil {
+		return domain.Rollout{}, fmt.Errorf("Failed to decode wave percentages of rollout %d: %w", r.ID, err)
+	}
+
+	return domain.Rollout{
+		ID:             r.ID,
+		PackageName:    r.PackageName,
+		PackageVersion: r.PackageVersion,
+		Selector: domain.MachineFilter{
+			Group:  r.SelectorGroup,
+			Labels: labels,
+		},
+		CanaryCount:     int(r.CanaryCount),
+		WavePercentages: percentages,
+		MaxFailureRate:  r.MaxFailureRate,
+		Soak:            time.Duration(r.SoakSeconds) * time.Second,
+		State:           domain.RolloutState(r.State),
+		StateReason:     r.StateReason,
+		CurrentWave:     int(r.CurrentWave),
+		WaveStartedAt:   r.WaveStartedAt,
+		CreatedBy:       r.CreatedBy,
+		CreatedAt:       r.CreatedAt,
+		Emergency:       r.Emergency,
+		Prestage:        r.Prestage,
+	}, nil
+}
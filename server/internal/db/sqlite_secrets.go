@@ -0,0 +1,106 @@
+package db
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	sqlite_queries "server/internal/db/sqlite_generated"
+	"server/internal/domain"
+	"time"
+)
+
+// PutSecret implements Database.
+func (s *sqliteDatabase) PutSecret(ctx context.Context, secret domain.Secret) error {
+	hash := sha256.Sum256(secret.Ciphertext)
+
+	return s.inTx(ctx, func(q *sqlite_queries.Queries) error {
+		err := q.UpsertSecret(ctx, sqlite_queries.UpsertSecretParams{
+			MachineID:  secret.MachineID,
+			Name:       secret.Name,
+			Ciphertext: secret.Ciphertext,
+			Hash:       hex.EncodeToString(hash[:]),
+			FileOwner:  secret.Owner,
+			FileGroup:  secret.Group,
+			FileMode:   int64(secret.Mode),
+			UpdatedBy:  secret.UpdatedBy,
+			UpdatedAt:  time.Now().UTC(),
+		})
+		if err != nil {
+			return err
+		}
+
+		// Consumers learn which secret changed, not its content
+		secret.Ciphertext = nil
+		secret.Hash = hex.EncodeToString(hash[:])
+
+		return putChange(ctx, q, domain.ChangeSecretPut, secret.Name, secret)
+	})
+}
+
+// ListSecrets implements Database.
+func (s *sqliteDatabase) ListSecrets(ctx context.Context) ([]domain.Secret, error) {
+	rows, err := s.q.ListSecrets(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	secrets := []domain.Secret{}
+	for _, row := range rows {
+		secret := mapSecret(row.Secret)
+		secret.MachineName = row.MachineName
+		secrets = append(secrets, secret)
+	}
+
+	return secrets, nil
+}
+
+// ListMachineSecrets implements Database.
+func (s *sqliteDatabase) ListMachineSecrets(ctx context.Context, machineId int64) ([]domain.Secret, error) {
+	rows, err := s.q.ListMachineSecrets(ctx, machineId)
+	if err != nil {
+		return nil, err
+	}
+
+	secrets := []domain.Secret{}
+	for _, row := range rows {
+		secrets = append(secrets, mapSecret(row.Secret))
+	}
+
+	return secrets, nil
+}
+
+// DeleteSecret implements Database.
+func (s *sqliteDatabase) DeleteSecret(ctx context.Context, machineId int64, name string) error {
+	return s.inTx(ctx, func(q *sqlite_queries.Queries) error {
+		affected, err := q.DeleteSecret(ctx, sqlite_queries.DeleteSecretParams{
+			MachineID: machineId,
+			Name:      name,
+		})
+		if err != nil {
+			return err
+		}
+		if affected == 0 {
+			return ErrNotFound
+		}
+
+		return putChange(ctx, q, domain.ChangeSecretDeleted, name, domain.SecretDeleteChange{
+			MachineID: machineId,
+			Name:      name,
+		})
+	})
+}
+
+func mapSecret(row sqlite_queries.Secret) domain.Secret {
+	return domain.Secret{
+		MachineID:  row.MachineID,
+		Name:       row.Name,
+		Ciphertext: row.Ciphertext,
+		Hash:       row.Hash,
+		Owner:      row.FileOwner,
+		Group:      row.FileGroup,
+		Mode:       fs.FileMode(row.FileMode),
+		UpdatedBy:  row.UpdatedBy,
+		UpdatedAt:  row.UpdatedAt,
+	}
+}
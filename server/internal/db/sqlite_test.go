@@ -0,0 +1,21 @@
+package db_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"server/internal/db"
+	"server/internal/db/dbtest"
+	"testing"
+)
+
+func TestSqlite(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T) db.Database {
+		database, err := db.Open(context.Background(), filepath.Join(t.TempDir(), "meshix.db"), os.DirFS("../../migrations"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return database
+	})
+}
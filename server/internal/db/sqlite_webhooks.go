@@ -0,0 +1,77 @@
+package db
+
+import (
+	"context"
+	sqlite_queries "server/internal/db/sqlite_generated"
+	"server/internal/domain"
+	"time"
+)
+
+// GetWebhookCursor implements Database.
+func (s *sqliteDatabase) GetWebhookCursor(ctx context.Context, webhook string) (int64, error) {
+	row, err := s.q.GetWebhookCursor(ctx, webhook)
+	if err != nil {
+		return 0, mapError(err)
+	}
+
+	return row.WebhookCursor.LastChangeID, nil
+}
+
+// PutWebhookCursor implements Database.
+func (s *sqliteDatabase) PutWebhookCursor(ctx context.Context, webhook string, lastChangeId int64) error {
+	return s.q.UpsertWebhookCursor(ctx, sqlite_queries.UpsertWebhookCursorParams{
+		Webhook:      webhook,
+		LastChangeID: lastChangeId,
+		UpdatedAt:    time.Now().UTC(),
+	})
+}
+
+// PutWebhookDelivery implements Database.
+func (s *sqliteDatabase) PutWebhookDelivery(ctx context.Context, delivery domain.WebhookDelivery) error {
+	return s.q.InsertWebhookDelivery(ctx, sqlite_queries.InsertWebhookDeliveryParams{
+		Webhook:    delivery.Webhook,
+		ChangeID:   delivery.ChangeID,
+		Kind:       string(delivery.Kind),
+		Subject:    delivery.Subject,
+		Attempt:    int64(delivery.Attempt),
+		StatusCode: int64(delivery.StatusCode),
+		Error:      delivery.Error,
+		DurationMs: delivery.Duration.Milliseconds(),
+		CreatedAt:  delivery.CreatedAt.UTC(),
+	})
+}
+
+// ListWebhookDeliveries implements Database.
+func (s *sqliteDatabase) ListWebhookDeliveries(ctx context.Context, webhook string, limit int) ([]domain.WebhookDelivery, error) {
+	rows, err := s.q.ListWebhookDeliveries(ctx, sqlite_queries.ListWebhookDeliveriesParams{
+		Webhook: webhook,
+		Limit:   int64(limit),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries := []domain.WebhookDelivery{}
+	for _, row := range rows {
+		d := row.WebhookDelivery
+		deliveries = append(deliveries, domain.WebhookDelivery{
+			ID:         d.ID,
+			Webhook:    d.Webhook,
+			ChangeID:   d.ChangeID,
+			Kind:       domain.ChangeKind(d.Kind),
+			Subject:    d.Subject,
+			Attempt:    int(d.Attempt),
+			StatusCode: int(d.StatusCode),
+			Error:      d.Error,
+			Duration:   time.Duration(d.DurationMs) * time.Millisecond,
+			CreatedAt:  d.CreatedAt,
+		})
+	}
+
+	return deliveries, nil
+}
+
+// DeleteWebhookDeliveriesBefore implements Database.
+func (s *sqliteDatabase) DeleteWebhookDeliveriesBefore(ctx context.Context, before time.Time) (int64, error) {
+	return s.q.DeleteWebhookDeliveriesBefore(ctx, before.UTC())
+}
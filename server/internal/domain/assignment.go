@@ -0,0 +1,71 @@
+package domain
+
+import (
+	"slices"
+	"time"
+)
+
+// AssignmentTarget is either a single machine or all machines in a group.
+type AssignmentTarget struct {
+	Machine string
+	Group   string
+}
+
+func (t AssignmentTarget) Matches(m Machine) bool {
+	if t.Machine != "" {
+		return t.Machine == m.Name
+	}
+
+	return slices.Contains(m.Groups, t.Group)
+}
+
+// ActivationMode of system package, mode of switch-to-configuration.
+type ActivationMode string
+
+const (
+	// ActivationSwitch activates the system now and makes it the boot default
+	ActivationSwitch ActivationMode = "switch"
+	// ActivationBoot makes the system the boot default without activating it
+	ActivationBoot ActivationMode = "boot"
+	// ActivationTest activates the system without making it the boot default
+	ActivationTest ActivationMode = "test"
+)
+
+// Assignment of package to machines. Empty version follows latest non yanked version.
+type Assignment struct {
+	ID             int64
+	Target         AssignmentTarget
+	PackageName    string
+	PackageVersion string
+	// Mode is used only by system packages
+	Mode ActivationMode
+	// Emergency assignments are activated outside of maintenance windows
+	Emergency bool
+	CreatedAt time.Time
+}
+
+// Activation is result of machine converging to its desired state.
+type Activation struct {
+	MachineID  int64
+	StorePaths []string
+	Success    bool
+	Error      string
+	// RolledBack is set when health check failed after activation and the profile was rolled back
+	RolledBack bool
+	// Logs of the failed health check
+	Logs       string
+	ReportedAt time.Time
+}
+
+// FailedVersion marks package version which failed health check on a machine of the group.
+// Rollouts don't schedule failed version to machines of the group. Group is empty for failure on machine
+// without groups, which blocks the version only on that machine.
+type FailedVersion struct {
+	PackageName    string
+	PackageVersion string
+	Group          string
+	MachineID      int64
+	MachineName    string
+	Reason         string
+	FailedAt       time.Time
+}
@@ -0,0 +1,21 @@
+package domain
+
+import (
+	"slices"
+	"time"
+)
+
+// ClusterMembership is set of nodes the binary cache is sharded across.
+type ClusterMembership struct {
+	// Nodes are urls of the nodes
+	Nodes []string
+	// ReplicationFactor is number of nodes each object is placed on
+	ReplicationFactor int
+	UpdatedAt         time.Time
+}
+
+// Equal reports if objects are placed the same way in both memberships, order of nodes does not matter.
+func (m ClusterMembership) Equal(other ClusterMembership) bool {
+	return m.ReplicationFactor == other.ReplicationFactor &&
+		slices.Equal(slices.Sorted(slices.Values(m.Nodes)), slices.Sorted(slices.Values(other.Nodes)))
+}
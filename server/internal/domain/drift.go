@@ -0,0 +1,42 @@
+package domain
+
+import (
+	"slices"
+	"time"
+)
+
+// MachineDrift is difference between desired state of the machine and state reported by its agent.
+type MachineDrift struct {
+	MachineID int64
+	// Missing store paths are desired but not installed in the agent profile
+	Missing []string
+	// Unexpected store paths are installed but not desired
+	Unexpected []string
+	// DesiredSystem differs from ActualSystem until the system package is activated,
+	// both are empty when the machine has no system package
+	DesiredSystem string
+	ActualSystem  string
+	// Error resolving desired state of the machine
+	Error string
+	// Since the machine is drifted, nil when it is in sync
+	Since     *time.Time
+	CheckedAt time.Time
+}
+
+func (d MachineDrift) Drifted() bool {
+	return len(d.Missing) > 0 || len(d.Unexpected) > 0 || d.DesiredSystem != d.ActualSystem || d.Error != ""
+}
+
+// Equal reports if both drifts are the same apart from the time they were checked.
+func (d MachineDrift) Equal(other MachineDrift) bool {
+	sameSince := d.Since == nil && other.Since == nil ||
+		d.Since != nil && other.Since != nil && d.Since.Equal(*other.Since)
+
+	return d.MachineID == other.MachineID &&
+		slices.Equal(d.Missing, other.Missing) &&
+		slices.Equal(d.Unexpected, other.Unexpected) &&
+		d.DesiredSystem == other.DesiredSystem &&
+		d.ActualSystem == other.ActualSystem &&
+		d.Error == other.Error &&
+		sameSince
+}
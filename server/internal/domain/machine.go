@@ -0,0 +1,92 @@
+package domain
+
+import (
+	"crypto/ed25519"
+	"slices"
+	"time"
+)
+
+// Machine is a host enrolled to the hub with a bootstrap token.
+type Machine struct {
+	ID     int64
+	Name   string
+	System string
+	// PublicKey verifies signatures of requests made by the machine
+	PublicKey  ed25519.PublicKey
+	Labels     map[string]string
+	Groups     []string
+	EnrolledAt time.Time
+	// Status from the last heartbeat, nil when machine has not reported yet
+	Status *MachineStatus
+	// Drift from the last check, nil when it was not checked yet
+	Drift *MachineDrift
+}
+
+// Stale machines have not sent heartbeat within the timeout.
+func (m Machine) Stale(now time.Time, timeout time.Duration) bool {
+	return m.Status == nil || now.Sub(m.Status.ReportedAt) > timeout
+}
+
+// MachineStatus is reported by agent in periodic heartbeats.
+type MachineStatus struct {
+	ProfileGeneration int64
+	StorePaths        []string
+	NixVersion        string
+	FreeDiskBytes     int64
+	LastError         string
+	// RunningSystem and BootedSystem are store paths of the current and booted NixOS system
+	RunningSystem string
+	BootedSystem  string
+	// StagedStorePaths are realised ahead of activation of prestaged rollouts
+	StagedStorePaths []string
+	// PeerUrl serves the store of the machine to peers on the same site, empty when disabled
+	PeerUrl    string
+	ReportedAt time.Time
+}
+
+// Equal reports if both statuses are the same apart from the time they were reported and free disk space,
+// which changes with nearly every heartbeat.
+func (s MachineStatus) Equal(other MachineStatus) bool {
+	return s.ProfileGeneration == other.ProfileGeneration &&
+		slices.Equal(s.StorePaths, other.StorePaths) &&
+		s.NixVersion == other.NixVersion &&
+		s.LastError == other.LastError &&
+		s.RunningSystem == other.RunningSystem &&
+		s.BootedSystem == other.BootedSystem &&
+		slices.Equal(s.StagedStorePaths, other.StagedStorePaths) &&
+		s.PeerUrl == other.PeerUrl
+}
+
+type NewMachine struct {
+	Name      string
+	System    string
+	PublicKey ed25519.PublicKey
+	Labels    map[string]string
+}
+
+// BootstrapToken allows enrollment of a single machine. Groups and labels of the token are given to the machine.
+type BootstrapToken struct {
+	CreatedBy string
+	Groups    []string
+	Labels    map[string]string
+	ExpiresAt time.Time
+}
+
+// MachineFilter matches machines in the group having all of the labels, empty filter matches all machines.
+type MachineFilter struct {
+	Group  string
+	Labels map[string]string
+}
+
+func (f MachineFilter) Matches(m Machine) bool {
+	if f.Group != "" && !slices.Contains(m.Groups, f.Group) {
+		return false
+	}
+	for k, v := range f.Labels {
+		if m.Labels[k] != v {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,21 @@
+package domain
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMachineStatusEqual(t *testing.T) {
+	status := MachineStatus{ProfileGeneration: 3, StorePaths: []string{"/nix/store/aaaa-app-1"}, FreeDiskBytes: 100, ReportedAt: time.Now()}
+	heartbeat := status
+	heartbeat.FreeDiskBytes = 90
+	heartbeat.ReportedAt = status.ReportedAt.Add(time.Minute)
+	if !status.Equal(heartbeat) {
+		t.Errorf("Heartbeat changing only free disk space and time is not equal")
+	}
+	activated := heartbeat
+	activated.StorePaths = []string{"/nix/store/bbbb-app-2"}
+	if status.Equal(activated) {
+		t.Errorf("Status with other store paths is equal")
+	}
+}
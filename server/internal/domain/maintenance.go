@@ -0,0 +1,149 @@
+package domain
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// MaintenanceWindow limits when machines of the group activate new packages.
+//
+// Window starts at Start on each of Days and lasts until End, window ending before it starts ends
+// on the following day, e.g. 22:00-05:00. Times are in Timezone.
+type MaintenanceWindow struct {
+	ID    int64
+	Group string
+	// Days the window starts on, every day when empty
+	Days []time.Weekday
+	// Start and End are minutes from midnight
+	Start    int
+	End      int
+	Timezone string
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// ParseWeekday parses three letter abbreviation of the day, e.g. mon.
+func ParseWeekday(day string) (time.Weekday, error) {
+	weekday, ok := weekdays[strings.ToLower(day)]
+	if !ok {
+		return 0, fmt.Errorf("Unknown day %s, expected one of sun, mon, tue, wed, thu, fri, sat", day)
+	}
+
+	return weekday, nil
+}
+
+// FormatWeekday is inverse of ParseWeekday.
+func FormatWeekday(day time.Weekday) string {
+	return strings.ToLower(day.String()[:3])
+}
+
+// ParseTimeOfDay parses HH:MM into minutes from midnight.
+func ParseTimeOfDay(value string) (int, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid time of day %s, expected HH:MM: %w", value, err)
+	}
+
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// FormatTimeOfDay formats minutes from midnight as HH:MM.
+func FormatTimeOfDay(minutes int) string {
+	return fmt.Sprintf("%02d:%02d", minutes/60, minutes%60)
+}
+
+func (w MaintenanceWindow) location() (*time.Location, error) {
+	loc, err := time.LoadLocation(w.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid time zone %s of maintenance window: %w", w.Timezone, err)
+	}
+
+	return loc, nil
+}
+
+func (w MaintenanceWindow) Validate() error {
+	if w.Group == "" {
+		return fmt.Errorf("Group of maintenance window is required")
+	}
+	if w.Start < 0 || w.Start >= 24*60 || w.End < 0 || w.End >= 24*60 {
+		return fmt.Errorf("Maintenance window has to start and end within a day")
+	}
+	if w.Start == w.End {
+		return fmt.Errorf("Maintenance window can't be empty")
+	}
+	_, err := w.location()
+
+	return err
+}
+
+func (w MaintenanceWindow) startsOn(day time.Weekday) bool {
+	return len(w.Days) == 0 || slices.Contains(w.Days, day)
+}
+
+// Contains checks that the time is within the window.
+func (w MaintenanceWindow) Contains(t time.Time) bool {
+	loc, err := w.location()
+	if err != nil {
+		return false
+	}
+	local := t.In(loc)
+	minutes := local.Hour()*60 + local.Minute()
+	if w.Start < w.End {
+		return w.startsOn(local.Weekday()) && minutes >= w.Start && minutes < w.End
+	}
+
+	// Window spans midnight, it is open since start today or until end from yesterday
+	yesterday := local.AddDate(0, 0, -1).Weekday()
+	return (w.startsOn(local.Weekday()) && minutes >= w.Start) || (w.startsOn(yesterday) && minutes < w.End)
+}
+
+// NextStart returns the first start of the window after the time.
+func (w MaintenanceWindow) NextStart(t time.Time) time.Time {
+	loc, err := w.location()
+	if err != nil {
+		return time.Time{}
+	}
+	local := t.In(loc)
+	for i := 0; i <= 7; i++ {
+		day := local.AddDate(0, 0, i)
+		start := time.Date(day.Year(), day.Month(), day.Day(), w.Start/60, w.Start%60, 0, 0, loc)
+		if start.After(t) && w.startsOn(start.Weekday()) {
+			return start
+		}
+	}
+
+	return time.Time{}
+}
+
+// InMaintenance checks that machine is in one of the maintenance windows of its groups.
+// Machine in groups without windows can be updated at any time. Next is the start of the nearest window
+// when machine is not in maintenance.
+func InMaintenance(windows []MaintenanceWindow, machine Machine, now time.Time) (bool, time.Time) {
+	applicable := false
+	var next time.Time
+	for _, w := range windows {
+		if !slices.Contains(machine.Groups, w.Group) {
+			continue
+		}
+		applicable = true
+		if w.Contains(now) {
+			return true, time.Time{}
+		}
+		start := w.NextStart(now)
+		if !start.IsZero() && (next.IsZero() || start.Before(next)) {
+			next = start
+		}
+	}
+
+	return !applicable, next
+}
@@ -0,0 +1,128 @@
+package domain
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// ChangeKind is kind of change in the outbox of the hub.
+type ChangeKind string
+
+// Changes applied by replicas.
+const (
+	// ChangeNarInfo adds narinfo signed by the hub together with its NAR
+	ChangeNarInfo ChangeKind = "narinfo"
+	// ChangePackagePushed adds or replaces package
+	ChangePackagePushed  ChangeKind = "package.pushed"
+	ChangePackageYanked  ChangeKind = "package.yanked"
+	ChangePackageDeleted ChangeKind = "package.deleted"
+	// ChangeAssignmentPut promotes package version to a machine or group
+	ChangeAssignmentPut     ChangeKind = "assignment.put"
+	ChangeAssignmentDeleted ChangeKind = "assignment.deleted"
+)
+
+// Changes of state local to the hub, e.g. of its machines, replicas skip them, see ChangeKind.Local.
+const (
+	// ChangeAdvisoryPut imports advisory, payload is Advisory without its document
+	ChangeAdvisoryPut           ChangeKind = "advisory.put"
+	ChangeVulnerabilitiesSet    ChangeKind = "vulnerabilities.set"
+	ChangeBootstrapTokenCreated ChangeKind = "bootstrap_token.created"
+	ChangeMachineRegistered     ChangeKind = "machine.registered"
+	// ChangeMachineStatus reports changed status, heartbeats which only refresh the status are not recorded
+	ChangeMachineStatus ChangeKind = "machine.status"
+	// ChangeMachineDrift reports changed drift, checks which did not change it are not recorded
+	ChangeMachineDrift             ChangeKind = "machine.drift"
+	ChangeActivationReported       ChangeKind = "activation.reported"
+	ChangeFailedVersionPut         ChangeKind = "failed_version.put"
+	ChangeFailedVersionDeleted     ChangeKind = "failed_version.deleted"
+	ChangeRolloutCreated           ChangeKind = "rollout.created"
+	ChangeRolloutUpdated           ChangeKind = "rollout.updated"
+	ChangeMaintenanceWindowPut     ChangeKind = "maintenance_window.put"
+	ChangeMaintenanceWindowDeleted ChangeKind = "maintenance_window.deleted"
+	// ChangeSecretPut stores secret of the machine, payload is Secret without its ciphertext
+	ChangeSecretPut     ChangeKind = "secret.put"
+	ChangeSecretDeleted ChangeKind = "secret.deleted"
+	// ChangeGcRun drops GC roots of a deleted package, store paths can be collected by garbage collection
+	// afterwards. Replicas drop their roots when applying ChangePackageDeleted and record their own runs.
+	ChangeGcRun ChangeKind = "gc.run"
+)
+
+// Local reports if changes of the kind describe state local to the hub which replicas don't apply.
+func (k ChangeKind) Local() bool {
+	switch k {
+	case ChangeAdvisoryPut, ChangeVulnerabilitiesSet, ChangeBootstrapTokenCreated, ChangeMachineRegistered,
+		ChangeMachineStatus, ChangeMachineDrift, ChangeActivationReported, ChangeFailedVersionPut,
+		ChangeFailedVersionDeleted, ChangeRolloutCreated, ChangeRolloutUpdated, ChangeMaintenanceWindowPut,
+		ChangeMaintenanceWindowDeleted, ChangeSecretPut, ChangeSecretDeleted, ChangeGcRun:
+		return true
+	default:
+		return false
+	}
+}
+
+// Change is an entry of the outbox which consumers, e.g. replicas, read in order of ID.
+type Change struct {
+	ID   int64
+	Kind ChangeKind
+	// Subject the change is about, e.g. hash of the narinfo, name of the package or id of the machine
+	Subject string
+	// Data is JSON of the change payload, e.g. NarInfoChange for ChangeNarInfo
+	Data      json.RawMessage
+	CreatedAt time.Time
+}
+
+// NewChange creates change of the kind with payload encoded as JSON.
+func NewChange(kind ChangeKind, subject string, payload any) (Change, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return Change{}, err
+	}
+
+	return Change{
+		Kind:    kind,
+		Subject: subject,
+		Data:    data,
+	}, nil
+}
+
+// VulnerabilitiesChange is payload of ChangeVulnerabilitiesSet.
+type VulnerabilitiesChange struct {
+	PackageID       int64
+	Vulnerabilities []Vulnerability
+}
+
+// MachineStatusChange is payload of ChangeMachineStatus.
+type MachineStatusChange struct {
+	MachineID int64
+	Status    MachineStatus
+}
+
+// FailedVersionDeleteChange is payload of ChangeFailedVersionDeleted.
+type FailedVersionDeleteChange struct {
+	Ref   PackageRef
+	Group string
+}
+
+// RolloutCreateChange is payload of ChangeRolloutCreated.
+type RolloutCreateChange struct {
+	ID      int64
+	Rollout NewRollout
+}
+
+// MaintenanceWindowDeleteChange is payload of ChangeMaintenanceWindowDeleted.
+type MaintenanceWindowDeleteChange struct {
+	ID int64
+}
+
+// GcRunChange is payload of ChangeGcRun.
+type GcRunChange struct {
+	Ref PackageRef
+	// DroppedRoots are store paths no longer used by any package
+	DroppedRoots []string
+}
+
+// SecretDeleteChange is payload of ChangeSecretDeleted.
+type SecretDeleteChange struct {
+	MachineID int64
+	Name      string
+}
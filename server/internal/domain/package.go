@@ -1,18 +1,124 @@
 package domain
 
+import (
+	"provenance"
+	"time"
+)
+
+// PackageKind distinguishes application packages installed into the agent profile
+// from NixOS system closures activated with switch-to-configuration.
+type PackageKind string
+
+const (
+	PackageKindApp    PackageKind = "app"
+	PackageKindSystem PackageKind = "system"
+)
+
 type NewPackage struct {
 	Name        string
 	Version     string
+	System      string
+	Kind        PackageKind
 	NixMetadata NixMetadata
+	Provenance  *Provenance
 }
 
 type Package struct {
-	Name        string
-	Version     string
-	NixMetadata NixMetadata
+	ID              int64
+	Name            string
+	Version         string
+	System          string
+	Kind            PackageKind
+	NixMetadata     NixMetadata
+	Yank            *Yank
+	Provenance      *Provenance
+	Vulnerabilities []Vulnerability
+}
+
+// PackageRef references packages by name and version, empty system matches packages of every system.
+type PackageRef struct {
+	Name    string
+	Version string
+	System  string
+}
+
+type PackageFilter struct {
+	IncludeYanked bool
+	// System filters packages by nix system, empty matches every system
+	System string
 }
 
 type NixMetadata struct {
+	// StorePath of the default output
 	StorePath string
 	MainBin   string
+	// Outputs maps output name to its store path
+	Outputs          map[string]string
+	OutputsToInstall []string
+	Description      string
+	Licenses         []string
+	Homepage         string
+	Platforms        []string
+	Unfree           bool
+	Insecure         bool
+	// Position of the package definition in nix source
+	Position string
+}
+
+const DefaultOutput = "out"
+
+// OutputPath resolves store path of the output, empty output resolves the default one.
+func (m NixMetadata) OutputPath(output string) (string, bool) {
+	if output == "" {
+		return m.StorePath, m.StorePath != ""
+	}
+	if path, ok := m.Outputs[output]; ok {
+		return path, true
+	}
+	if output == DefaultOutput && len(m.Outputs) == 0 {
+		return m.StorePath, m.StorePath != ""
+	}
+
+	return "", false
+}
+
+// Yank marks a package that should not be resolved as latest anymore.
+type Yank struct {
+	YankedAt time.Time
+	Reason   string
+}
+
+// Provenance describes where the store path of package came from.
+type Provenance struct {
+	FlakeUrl      string
+	FlakeRevision string
+	FlakeNarHash  string
+	DrvPath       string
+	BuilderHost   string
+	ClientVersion string
+	// Ci holds identifiers of the CI run
+	Ci map[string]string
+	// Signature of the provenance made by binary cache key
+	Signature string
+}
+
+// ProvenanceFields returns fields of the package and its provenance covered by the provenance signature.
+func (p NewPackage) ProvenanceFields() provenance.Fields {
+	f := provenance.Fields{
+		Name:      p.Name,
+		Version:   p.Version,
+		System:    p.System,
+		StorePath: p.NixMetadata.StorePath,
+	}
+	if p.Provenance != nil {
+		f.FlakeUrl = p.Provenance.FlakeUrl
+		f.FlakeRevision = p.Provenance.FlakeRevision
+		f.FlakeNarHash = p.Provenance.FlakeNarHash
+		f.DrvPath = p.Provenance.DrvPath
+		f.BuilderHost = p.Provenance.BuilderHost
+		f.ClientVersion = p.Provenance.ClientVersion
+		f.Ci = p.Provenance.Ci
+	}
+
+	return f
 }
@@ -0,0 +1,36 @@
+package domain
+
+import "time"
+
+// NarInfoChange is payload of ChangeNarInfo. NAR is downloaded from the cache of the primary.
+type NarInfoChange struct {
+	Hash    string
+	NarInfo string
+}
+
+// PackageYankChange is payload of ChangePackageYanked.
+type PackageYankChange struct {
+	Ref    PackageRef
+	Reason string
+}
+
+// PackageDeleteChange is payload of ChangePackageDeleted.
+type PackageDeleteChange struct {
+	Ref        PackageRef
+	DropGcRoot bool
+}
+
+// AssignmentDeleteChange is payload of ChangeAssignmentDeleted.
+type AssignmentDeleteChange struct {
+	Target      AssignmentTarget
+	PackageName string
+}
+
+// ReplicationCursor is position of the replica in the change feed of its primary.
+type ReplicationCursor struct {
+	PrimaryUrl   string
+	LastChangeID int64
+	// PromotedAt is set when the replica stopped following the primary and accepts writes
+	PromotedAt *time.Time
+	UpdatedAt  time.Time
+}
@@ -0,0 +1,81 @@
+package domain
+
+import "time"
+
+type RolloutState string
+
+const (
+	// RolloutStaging rollouts wait for activation while machines download the package
+	RolloutStaging   RolloutState = "staging"
+	RolloutRunning   RolloutState = "running"
+	RolloutPaused    RolloutState = "paused"
+	RolloutHalted    RolloutState = "halted"
+	RolloutAborted   RolloutState = "aborted"
+	RolloutCompleted RolloutState = "completed"
+)
+
+// Rollout deploys package version to selected machines in waves.
+//
+// Wave 0 is canary when CanaryCount is set, following waves update machines up to the cumulative
+// percentage of WavePercentages. Machines of waves up to CurrentWave install the rollout version.
+type Rollout struct {
+	ID              int64
+	PackageName     string
+	PackageVersion  string
+	Selector        MachineFilter
+	CanaryCount     int
+	WavePercentages []int
+	// MaxFailureRate is ratio of failed machines from machines of reached waves which halts the rollout
+	MaxFailureRate float64
+	// Soak is minimum duration of wave before advancing to the next one
+	Soak          time.Duration
+	State         RolloutState
+	StateReason   string
+	CurrentWave   int
+	WaveStartedAt time.Time
+	CreatedBy     string
+	CreatedAt     time.Time
+	// Emergency rollouts ignore maintenance windows
+	Emergency bool
+	// Prestage rollouts start in staging state
+	Prestage bool
+}
+
+type NewRollout struct {
+	PackageName     string
+	PackageVersion  string
+	Selector        MachineFilter
+	CanaryCount     int
+	WavePercentages []int
+	MaxFailureRate  float64
+	Soak            time.Duration
+	CreatedBy       string
+	Emergency       bool
+	Prestage        bool
+}
+
+// InitialState of the rollout, prestaged rollouts wait in staging state until they are activated.
+func (r NewRollout) InitialState() RolloutState {
+	if r.Prestage {
+		return RolloutStaging
+	}
+
+	return RolloutRunning
+}
+
+// RolloutMachine is a machine selected by rollout and the wave it is updated in.
+type RolloutMachine struct {
+	MachineID   int64
+	MachineName string
+	Wave        int
+}
+
+// Active rollouts pin version of the package on machines of reached waves.
+func (r Rollout) Active() bool {
+	return r.State != RolloutAborted
+}
+
+// Finished rollouts can't change state anymore.
+func (r Rollout) Finished() bool {
+	return r.State == RolloutAborted || r.State == RolloutCompleted
+}
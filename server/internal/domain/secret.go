@@ -0,0 +1,45 @@
+package domain
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"time"
+)
+
+// ageHeader starts every age encrypted file, secrets without it are rejected so plaintext is never stored.
+const ageHeader = "age-encryption.org/v1\n"
+
+var secretNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]{0,254}$`)
+
+// Secret is a file encrypted to the identity key of the machine. Agent of the machine decrypts it
+// into its secrets directory, the hub never sees the plaintext.
+type Secret struct {
+	MachineID   int64
+	MachineName string
+	// Name of the file in secrets directory of the agent
+	Name       string
+	Ciphertext []byte
+	// Hash is SHA-256 of the ciphertext
+	Hash      string
+	Owner     string
+	Group     string
+	Mode      fs.FileMode
+	UpdatedBy string
+	UpdatedAt time.Time
+}
+
+func (s Secret) Validate() error {
+	if !secretNamePattern.MatchString(s.Name) {
+		return fmt.Errorf("Invalid secret name %q, only letters, digits, '.', '_' and '-' are allowed", s.Name)
+	}
+	if !bytes.HasPrefix(s.Ciphertext, []byte(ageHeader)) {
+		return fmt.Errorf("Secret %s is not encrypted with age", s.Name)
+	}
+	if s.Mode&^fs.ModePerm != 0 {
+		return fmt.Errorf("Invalid mode %o of secret %s", s.Mode, s.Name)
+	}
+
+	return nil
+}
@@ -0,0 +1,27 @@
+package domain
+
+import "time"
+
+// Advisory is an OSV advisory imported from the advisory feed.
+type Advisory struct {
+	ID       string
+	Modified time.Time
+	Summary  string
+	Severity string
+	Aliases  []string
+	// Packages are names of affected packages, used to look up advisories for closure members
+	Packages []string
+	// Document is the original OSV JSON
+	Document []byte
+}
+
+// Vulnerability is an advisory matching member of package closure.
+type Vulnerability struct {
+	AdvisoryID       string
+	Aliases          []string
+	Summary          string
+	Severity         string
+	StorePath        string
+	ComponentName    string
+	ComponentVersion string
+}
@@ -0,0 +1,27 @@
+package domain
+
+import "time"
+
+// WebhookDelivery is an attempt to post change of the outbox to a webhook.
+type WebhookDelivery struct {
+	ID      int64
+	Webhook string
+	// ChangeID is id of the change in the outbox, 0 for test deliveries
+	ChangeID int64
+	Kind     ChangeKind
+	Subject  string
+	// Attempt is 1 for the first attempt to deliver the change
+	Attempt int
+	// StatusCode of the response, 0 when no response was received
+	StatusCode int
+	// Error is empty when the delivery succeeded
+	Error    string
+	Duration time.Duration
+	// CreatedAt is when the attempt started
+	CreatedAt time.Time
+}
+
+// Succeeded reports if the webhook accepted the change.
+func (d WebhookDelivery) Succeeded() bool {
+	return d.Error == ""
+}
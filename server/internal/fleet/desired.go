@@ -0,0 +1,184 @@
+package fleet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"server/internal/db"
+	"server/internal/domain"
+	"slices"
+)
+
+// DesiredPackage is a package the machine should have installed.
+type DesiredPackage struct {
+	Package domain.Package
+	// StorePaths of outputs to install
+	StorePaths []string
+	// Mode of system package activation
+	Mode domain.ActivationMode
+	// Emergency packages are activated outside of maintenance windows
+	Emergency bool
+}
+
+// DesiredState resolves packages assigned to the machine for its system.
+// Assignment to the machine takes precedence over assignment of the same package to its groups,
+// version of the package is pinned by the latest rollout which reached the machine, unless the version failed
+// in a group of the machine.
+func DesiredState(ctx context.Context, database db.Database, machine domain.Machine) ([]DesiredPackage, error) {
+	assignments, err := database.ListAssignments(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	selected := map[string]domain.Assignment{}
+	names := []string{}
+	for _, a := range assignments {
+		if !a.Target.Matches(machine) {
+			continue
+		}
+		current, ok := selected[a.PackageName]
+		if !ok {
+			names = append(names, a.PackageName)
+		}
+		if !ok || (current.Target.Machine == "" && a.Target.Machine != "") {
+			selected[a.PackageName] = a
+		}
+	}
+
+	rollouts, err := database.ListMachineRollouts(ctx, machine.ID)
+	if err != nil {
+		return nil, err
+	}
+	failed, err := database.ListFailedVersions(ctx, domain.PackageRef{})
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range rollouts {
+		if f, ok := FailedOnMachine(failed, r.PackageName, r.PackageVersion, machine); ok {
+			slog.DebugContext(ctx, "Skipping rollout of failed version", "rollout", r.ID, "machine", machine.Name, "group", f.Group)
+			continue
+		}
+		if _, ok := selected[r.PackageName]; !ok {
+			names = append(names, r.PackageName)
+		}
+		selected[r.PackageName] = domain.Assignment{
+			Target:         domain.AssignmentTarget{Machine: machine.Name},
+			PackageName:    r.PackageName,
+			PackageVersion: r.PackageVersion,
+			Mode:           domain.ActivationSwitch,
+			Emergency:      r.Emergency,
+		}
+	}
+
+	desired := []DesiredPackage{}
+	systemPackage := ""
+	for _, name := range names {
+		a := selected[name]
+		pkg, err := database.GetPackage(ctx, domain.PackageRef{
+			Name:    a.PackageName,
+			Version: a.PackageVersion,
+			System:  machine.System,
+		})
+		if err != nil {
+			if errors.Is(err, db.ErrNotFound) {
+				return nil, fmt.Errorf("Assigned package %s %s is not available for %s: %w", a.PackageName, a.PackageVersion, machine.System, err)
+			}
+			return nil, err
+		}
+		if pkg.Kind == domain.PackageKindSystem {
+			if systemPackage != "" {
+				return nil, fmt.Errorf("Machine %s has more system packages assigned, %s and %s", machine.Name, systemPackage, pkg.Name)
+			}
+			systemPackage = pkg.Name
+		}
+		storePaths, err := InstallPaths(pkg)
+		if err != nil {
+			return nil, err
+		}
+		desired = append(desired, DesiredPackage{
+			Package:    pkg,
+			StorePaths: storePaths,
+			Mode:       a.Mode,
+			Emergency:  a.Emergency,
+		})
+	}
+
+	return desired, nil
+}
+
+// StagedState resolves packages of staging rollouts which selected the machine, so its agent downloads them
+// before the rollouts are activated. Versions failed on the machine or in its group and packages not built
+// for its system are skipped.
+func StagedState(ctx context.Context, database db.Database, machine domain.Machine) ([]DesiredPackage, error) {
+	rollouts, err := database.ListMachineStagingRollouts(ctx, machine.ID)
+	if err != nil {
+		return nil, err
+	}
+	if len(rollouts) == 0 {
+		return []DesiredPackage{}, nil
+	}
+	failed, err := database.ListFailedVersions(ctx, domain.PackageRef{})
+	if err != nil {
+		return nil, err
+	}
+
+	staged := []DesiredPackage{}
+	for _, r := range rollouts {
+		if _, ok := FailedOnMachine(failed, r.PackageName, r.PackageVersion, machine); ok {
+			continue
+		}
+		pkg, err := database.GetPackage(ctx, domain.PackageRef{
+			Name:    r.PackageName,
+			Version: r.PackageVersion,
+			System:  machine.System,
+		})
+		if errors.Is(err, db.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		storePaths, err := InstallPaths(pkg)
+		if err != nil {
+			return nil, err
+		}
+		staged = append(staged, DesiredPackage{
+			Package:    pkg,
+			StorePaths: storePaths,
+			Mode:       domain.ActivationSwitch,
+		})
+	}
+
+	return staged, nil
+}
+
+// InstallPaths are store paths of package outputs installed into machine profile.
+func InstallPaths(pkg domain.Package) ([]string, error) {
+	outputs := pkg.NixMetadata.OutputsToInstall
+	if len(outputs) == 0 {
+		outputs = []string{""}
+	}
+
+	storePaths := []string{}
+	for _, output := range outputs {
+		storePath, ok := pkg.NixMetadata.OutputPath(output)
+		if !ok {
+			return nil, fmt.Errorf("Package %s-%s has no output %s", pkg.Name, pkg.Version, output)
+		}
+		storePaths = append(storePaths, storePath)
+	}
+
+	return storePaths, nil
+}
+
+// ContainsAll checks that all expected store paths are in store paths.
+func ContainsAll(storePaths []string, expected []string) bool {
+	for _, e := range expected {
+		if !slices.Contains(storePaths, e) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,132 @@
+package fleet
+
+import (
+	"context"
+	"log/slog"
+	"server/internal/db"
+	"server/internal/domain"
+	"slices"
+	"time"
+)
+
+// ComputeDrift compares desired state of the machine with the state reported in its last heartbeat.
+// Machine which has not reported yet has no drift.
+func ComputeDrift(ctx context.Context, database db.Database, machine domain.Machine) (*domain.MachineDrift, error) {
+	if machine.Status == nil {
+		return nil, nil
+	}
+	drift := &domain.MachineDrift{
+		MachineID:  machine.ID,
+		Missing:    []string{},
+		Unexpected: []string{},
+		CheckedAt:  time.Now(),
+	}
+
+	desired, err := DesiredState(ctx, database, machine)
+	if err != nil {
+		drift.Error = err.Error()
+		return drift, nil
+	}
+
+	apps := []string{}
+	for _, d := range desired {
+		if d.Package.Kind == domain.PackageKindSystem {
+			drift.DesiredSystem = d.StorePaths[0]
+			// System activated for next boot only is running after reboot
+			drift.ActualSystem = machine.Status.RunningSystem
+			if d.Mode == domain.ActivationBoot {
+				drift.ActualSystem = machine.Status.BootedSystem
+			}
+			continue
+		}
+		apps = append(apps, d.StorePaths...)
+	}
+	for _, storePath := range apps {
+		if !slices.Contains(machine.Status.StorePaths, storePath) && !slices.Contains(drift.Missing, storePath) {
+			drift.Missing = append(drift.Missing, storePath)
+		}
+	}
+	for _, storePath := range machine.Status.StorePaths {
+		if !slices.Contains(apps, storePath) {
+			drift.Unexpected = append(drift.Unexpected, storePath)
+		}
+	}
+	slices.Sort(drift.Missing)
+	slices.Sort(drift.Unexpected)
+
+	return drift, nil
+}
+
+// DriftDetector periodically checks drift of machines and logs when machines drift or converge.
+type DriftDetector struct {
+	db db.Database
+}
+
+func NewDriftDetector(database db.Database) *DriftDetector {
+	return &DriftDetector{
+		db: database,
+	}
+}
+
+// Run checks all machines every interval until the context is done.
+func (d *DriftDetector) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := d.CheckAll(ctx)
+			if err != nil {
+				slog.ErrorContext(ctx, "Failed to check drift of machines", "err", err)
+			}
+		}
+	}
+}
+
+func (d *DriftDetector) CheckAll(ctx context.Context) error {
+	machines, err := d.db.ListMachines(ctx, domain.MachineFilter{})
+	if err != nil {
+		return err
+	}
+	for _, m := range machines {
+		_, err = d.Check(ctx, m)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to check drift of machine", "machine", m.Name, "err", err)
+		}
+	}
+
+	return nil
+}
+
+// Check computes and stores drift of the machine. Machine.Drift has to hold the result of the previous check.
+func (d *DriftDetector) Check(ctx context.Context, machine domain.Machine) (*domain.MachineDrift, error) {
+	drift, err := ComputeDrift(ctx, d.db, machine)
+	if err != nil || drift == nil {
+		return drift, err
+	}
+
+	previous := machine.Drift
+	wasDrifted := previous != nil && previous.Drifted()
+	if drift.Drifted() {
+		drift.Since = &drift.CheckedAt
+		if wasDrifted && previous.Since != nil {
+			drift.Since = previous.Since
+		}
+	}
+	err = d.db.PutMachineDrift(ctx, *drift)
+	if err != nil {
+		return nil, err
+	}
+
+	// Changed drift is recorded in the outbox as machine.drift, consumers read it from the event stream
+	switch {
+	case drift.Drifted() && !wasDrifted:
+		slog.WarnContext(ctx, "Machine drifted", "machine", machine.Name, "missing", drift.Missing, "unexpected", drift.Unexpected, "desiredSystem", drift.DesiredSystem, "actualSystem", drift.ActualSystem, "err", drift.Error)
+	case !drift.Drifted() && wasDrifted:
+		slog.InfoContext(ctx, "Machine converged", "machine", machine.Name, "driftedSince", previous.Since)
+	}
+
+	return drift, nil
+}
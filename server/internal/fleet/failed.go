@@ -0,0 +1,82 @@
+package fleet
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"server/internal/db"
+	"server/internal/domain"
+	"slices"
+	"time"
+)
+
+// RecordFailure marks versions of packages newly installed by the rolled back activation as failed
+// for groups of the machine, for the machine itself when it has no groups. Packages which were already
+// installed by the last successful activation are not blamed for the failure.
+func RecordFailure(ctx context.Context, database db.Database, machine domain.Machine, activation domain.Activation) ([]domain.FailedVersion, error) {
+	desired, err := DesiredState(ctx, database, machine)
+	if err != nil {
+		return nil, err
+	}
+	previous, err := database.GetLatestSuccessfulActivation(ctx, machine.ID)
+	if err != nil && !errors.Is(err, db.ErrNotFound) {
+		return nil, err
+	}
+
+	failed := []domain.FailedVersion{}
+	for _, d := range desired {
+		if !ContainsAll(activation.StorePaths, d.StorePaths) || ContainsAll(previous.StorePaths, d.StorePaths) {
+			continue
+		}
+		groups := machine.Groups
+		if len(groups) == 0 {
+			groups = []string{""}
+		}
+		for _, group := range groups {
+			f := domain.FailedVersion{
+				PackageName:    d.Package.Name,
+				PackageVersion: d.Package.Version,
+				Group:          group,
+				MachineID:      machine.ID,
+				MachineName:    machine.Name,
+				Reason:         activation.Error,
+				FailedAt:       time.Now(),
+			}
+			err = database.PutFailedVersion(ctx, f)
+			if err != nil {
+				return nil, err
+			}
+			failed = append(failed, f)
+		}
+		slog.WarnContext(ctx, "Package version failed on machine", "machine", machine.Name, "package", d.Package.Name, "version", d.Package.Version, "groups", machine.Groups)
+	}
+
+	return failed, nil
+}
+
+// FailedInGroups returns the group in which the package version failed. Failures of machines without groups
+// are not considered.
+func FailedInGroups(failed []domain.FailedVersion, packageName, packageVersion string, groups []string) (string, bool) {
+	for _, f := range failed {
+		if f.Group != "" && f.PackageName == packageName && f.PackageVersion == packageVersion && slices.Contains(groups, f.Group) {
+			return f.Group, true
+		}
+	}
+
+	return "", false
+}
+
+// FailedOnMachine returns failure of the package version in a group of the machine, or on the machine itself
+// when it has no groups.
+func FailedOnMachine(failed []domain.FailedVersion, packageName, packageVersion string, machine domain.Machine) (domain.FailedVersion, bool) {
+	for _, f := range failed {
+		if f.PackageName != packageName || f.PackageVersion != packageVersion {
+			continue
+		}
+		if f.Group == "" && f.MachineID == machine.ID || f.Group != "" && slices.Contains(machine.Groups, f.Group) {
+			return f, true
+		}
+	}
+
+	return domain.FailedVersion{}, false
+}
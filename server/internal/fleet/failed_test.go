@@ -0,0 +1,36 @@
+package fleet
+
+import (
+	"server/internal/domain"
+	"testing"
+)
+
+func TestFailedOnMachine(t *testing.T) {
+	failed := []domain.FailedVersion{
+		{PackageName: "hello", PackageVersion: "2.0", Group: "web", MachineID: 1},
+		{PackageName: "hello", PackageVersion: "3.0", MachineID: 2},
+	}
+	tests := []struct {
+		name    string
+		version string
+		machine domain.Machine
+		want    bool
+	}{
+		{"machine in failed group", "2.0", domain.Machine{ID: 3, Groups: []string{"db", "web"}}, true},
+		{"machine in other group", "2.0", domain.Machine{ID: 3, Groups: []string{"db"}}, false},
+		{"failed machine without groups", "3.0", domain.Machine{ID: 2}, true},
+		{"other machine without groups", "3.0", domain.Machine{ID: 4}, false},
+		{"other version", "1.0", domain.Machine{ID: 2, Groups: []string{"web"}}, false},
+	}
+	for _, test := range tests {
+		_, got := FailedOnMachine(failed, "hello", test.version, test.machine)
+		if got != test.want {
+			t.Errorf("%s: FailedOnMachine = %v, want %v", test.name, got, test.want)
+		}
+	}
+
+	// Failure of machine without groups does not block rollouts selecting machines by empty group
+	if _, ok := FailedInGroups(failed, "hello", "3.0", []string{""}); ok {
+		t.Error("Failure of machine without groups matched as group failure")
+	}
+}
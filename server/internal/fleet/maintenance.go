@@ -0,0 +1,20 @@
+package fleet
+
+import (
+	"context"
+	"server/internal/db"
+	"server/internal/domain"
+	"time"
+)
+
+// InMaintenance checks that the machine is in maintenance window of its groups, next is the start of the nearest
+// window when it is not.
+func InMaintenance(ctx context.Context, database db.Database, machine domain.Machine, now time.Time) (bool, time.Time, error) {
+	windows, err := database.ListMaintenanceWindows(ctx)
+	if err != nil {
+		return false, time.Time{}, err
+	}
+	open, next := domain.InMaintenance(windows, machine, now)
+
+	return open, next, nil
+}
@@ -0,0 +1,69 @@
+package fleet
+
+import (
+	"context"
+	"math/rand/v2"
+	"server/internal/db"
+	"server/internal/domain"
+	"slices"
+	"time"
+)
+
+// MaxPeers limits number of peers handed to a machine, agents try them in order before the central cache.
+const MaxPeers = 5
+
+// Peer is a machine on the same site which serves requested store paths.
+type Peer struct {
+	Machine    domain.Machine
+	StorePaths []string
+}
+
+// FindPeers lists machines sharing the site label value with the machine which serve any of the store paths.
+// Machines without the site label have no peers.
+func FindPeers(ctx context.Context, database db.Database, machine domain.Machine, storePaths []string, siteLabel string, staleAfter time.Duration) ([]Peer, error) {
+	site, ok := machine.Labels[siteLabel]
+	if !ok || siteLabel == "" {
+		return []Peer{}, nil
+	}
+	machines, err := database.ListMachines(ctx, domain.MachineFilter{
+		Labels: map[string]string{siteLabel: site},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return SelectPeers(machines, machine, storePaths, time.Now(), staleAfter), nil
+}
+
+// SelectPeers picks up to MaxPeers live machines serving their store which hold any of the store paths,
+// either installed or staged. Peers holding more of the paths come first, ties are shuffled to spread the load.
+func SelectPeers(machines []domain.Machine, machine domain.Machine, storePaths []string, now time.Time, staleAfter time.Duration) []Peer {
+	peers := []Peer{}
+	for _, m := range machines {
+		if m.ID == machine.ID || m.Stale(now, staleAfter) || m.Status.PeerUrl == "" {
+			continue
+		}
+		held := []string{}
+		for _, p := range storePaths {
+			if slices.Contains(m.Status.StorePaths, p) || slices.Contains(m.Status.StagedStorePaths, p) {
+				held = append(held, p)
+			}
+		}
+		if len(held) == 0 {
+			continue
+		}
+		peers = append(peers, Peer{
+			Machine:    m,
+			StorePaths: held,
+		})
+	}
+
+	rand.Shuffle(len(peers), func(i, j int) {
+		peers[i], peers[j] = peers[j], peers[i]
+	})
+	slices.SortStableFunc(peers, func(a, b Peer) int {
+		return len(b.StorePaths) - len(a.StorePaths)
+	})
+
+	return peers[:min(len(peers), MaxPeers)]
+}
@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/bits"
+	"net/http"
+	"os"
+	"server/internal/config"
+	"server/internal/storage"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/klauspost/compress/zstd"
+	"github.com/nix-community/go-nix/pkg/narinfo"
+)
+
+// DeltaDictID identifies the base NAR used as raw zstd dictionary in delta frames.
+const DeltaDictID = 1
+
+// HandleDeltaNar serves NAR of store path with the hash as delta against NAR of store path the client already has.
+//
+// Delta is a zstd frame of the uncompressed NAR encoded with the base NAR, selected by hash in query parameter base,
+// as raw dictionary. Window of the frame covers both NARs, so the client decodes it with the base NAR as dictionary
+// and verifies the result against NarHash of the narinfo. Delta is not served when the NARs are larger than
+// MaxNarSize or don't fit into the largest zstd window, or when Concurrency encodings are running, clients
+// download the full NAR instead. Encoded deltas are stored in the bucket and served from it to later clients.
+func HandleDeltaNar(narInfos *storage.NarInfoStore, cfg config.DeltaCfg) http.Handler {
+	encodings := make(chan struct{}, cfg.Concurrency)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		hash := mux.Vars(r)["hash"]
+		baseHash := r.URL.Query().Get("base")
+		if baseHash == "" || baseHash == hash {
+			http.Error(w, "Hash of base store path is required", http.StatusBadRequest)
+			return
+		}
+
+		info, err := narInfos.GetNarInfo(ctx, hash)
+		if err != nil {
+			writeStorageError(w, r, "Failed to get narinfo", err)
+			return
+		}
+		baseInfo, err := narInfos.GetNarInfo(ctx, baseHash)
+		if err != nil {
+			writeStorageError(w, r, "Failed to get base narinfo", err)
+			return
+		}
+		windowSize := deltaWindowSize(baseInfo.NarSize + info.NarSize)
+		if baseInfo.NarSize > cfg.MaxNarSize || info.NarSize > cfg.MaxNarSize || windowSize > zstd.MaxWindowSize {
+			http.Error(w, "NARs are too large for delta", http.StatusUnprocessableEntity)
+			return
+		}
+
+		cached, err := narInfos.GetDelta(ctx, baseInfo, info)
+		if err == nil {
+			defer cached.Close()
+			w.Header().Add("content-type", "application/zstd")
+			_, err = io.Copy(w, cached)
+			if err != nil {
+				slog.ErrorContext(ctx, "Failed to copy cached delta nar to response", "err", err)
+			}
+			return
+		}
+		if !errors.Is(err, storage.ErrNotFound) {
+			slog.WarnContext(ctx, "Failed to get cached delta nar, encoding it again", "hash", hash, "base", baseHash, "err", err)
+		}
+
+		select {
+		case encodings <- struct{}{}:
+			defer func() { <-encodings }()
+		default:
+			w.Header().Add("retry-after", "60")
+			http.Error(w, "Too many delta encodings", http.StatusServiceUnavailable)
+			return
+		}
+
+		delta, err := encodeDelta(ctx, narInfos, baseInfo, info, windowSize)
+		if err != nil {
+			writeStorageError(w, r, "Failed to encode delta nar", err)
+			return
+		}
+		defer os.Remove(delta.Name())
+		defer delta.Close()
+		size, err := delta.Seek(0, io.SeekEnd)
+		if err == nil {
+			_, err = delta.Seek(0, io.SeekStart)
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to read encoded delta nar", "err", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		err = narInfos.PutDelta(ctx, baseInfo, info, delta, size)
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to cache delta nar", "hash", hash, "base", baseHash, "err", err)
+		}
+		_, err = delta.Seek(0, io.SeekStart)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to read encoded delta nar", "err", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		slog.InfoContext(ctx, "Getting delta nar", "hash", hash, "base", baseHash, "size", size)
+		w.Header().Add("content-type", "application/zstd")
+		w.Header().Add("content-length", strconv.FormatInt(size, 10))
+		_, err = io.Copy(w, delta)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to copy delta nar to response", "err", err)
+		}
+	})
+}
+
+// encodeDelta encodes NAR of the narinfo with the base NAR as dictionary into temporary file.
+func encodeDelta(ctx context.Context, narInfos *storage.NarInfoStore, baseInfo, info *narinfo.NarInfo, windowSize int) (*os.File, error) {
+	baseNar, err := narInfos.GetNar(ctx, baseInfo)
+	if err != nil {
+		return nil, err
+	}
+	dict, err := io.ReadAll(io.LimitReader(baseNar, int64(baseInfo.NarSize)+1))
+	baseNar.Close()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read base nar: %w", err)
+	}
+	if uint64(len(dict)) != baseInfo.NarSize {
+		return nil, fmt.Errorf("Base nar has %d bytes, expected %d", len(dict), baseInfo.NarSize)
+	}
+	nar, err := narInfos.GetNar(ctx, info)
+	if err != nil {
+		return nil, err
+	}
+	defer nar.Close()
+
+	delta, err := os.CreateTemp("", "meshix-delta-*.nar.zst")
+	if err != nil {
+		return nil, err
+	}
+	encoder, err := zstd.NewWriter(delta,
+		zstd.WithEncoderDictRaw(DeltaDictID, dict),
+		zstd.WithWindowSize(windowSize),
+		zstd.WithEncoderLevel(zstd.SpeedBestCompression),
+		// Every concurrent block encoder holds its own window
+		zstd.WithEncoderConcurrency(1))
+	if err == nil {
+		_, err = io.Copy(encoder, nar)
+		err = errors.Join(err, encoder.Close())
+	}
+	if err != nil {
+		delta.Close()
+		os.Remove(delta.Name())
+		return nil, fmt.Errorf("Failed to encode delta: %w", err)
+	}
+
+	return delta, nil
+}
+
+// deltaWindowSize is the smallest valid zstd window covering size bytes.
+func deltaWindowSize(size uint64) int {
+	if size <= zstd.MinWindowSize {
+		return zstd.MinWindowSize
+	}
+
+	return 1 << bits.Len64(size-1)
+}
+
+func writeStorageError(w http.ResponseWriter, r *http.Request, msg string, err error) {
+	if errors.Is(err, storage.ErrNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	slog.ErrorContext(r.Context(), msg, "err", err)
+	w.WriteHeader(http.StatusInternalServerError)
+}
@@ -2,15 +2,19 @@ package handlers
 
 import (
 	"bytes"
-	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
+	"server/internal/auth"
 	"server/internal/config"
+	"server/internal/db"
+	"server/internal/domain"
+	"server/internal/policy"
+	"server/internal/storage"
 
 	"github.com/gorilla/mux"
-	"github.com/minio/minio-go/v7"
 	"github.com/nix-community/go-nix/pkg/narinfo"
 )
 
@@ -39,7 +43,7 @@ func HandleNixCacheInfo(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func HandleNarInfo(client *minio.Client, cacheCfg config.BinaryCacheCfg, s3Cfg config.MinioCfg) http.Handler {
+func HandleNarInfo(narInfos *storage.NarInfoStore, cacheCfg config.BinaryCacheCfg, policies *policy.Engine, database db.Database) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 		fmt.Printf("NAR Info Method: %+v Path: %+v\n", r.Method, r.URL.Path)
@@ -47,51 +51,30 @@ func HandleNarInfo(client *minio.Client, cacheCfg config.BinaryCacheCfg, s3Cfg c
 		hash := vars["hash"]
 		if r.Method == http.MethodHead {
 			slog.InfoContext(ctx, "Heading narinfo", "hash", hash)
-			obj, err := client.GetObject(ctx, s3Cfg.Bucket, hash+".narinfo", minio.GetObjectOptions{})
+			obj, err := narInfos.GetObject(ctx, hash+".narinfo")
 			if err != nil {
-				slog.ErrorContext(ctx, "Failed to head narinfo", "err", err)
-				w.WriteHeader(http.StatusInternalServerError)
-				return
-			}
-			defer obj.Close()
-			_, err = obj.Stat()
-			if err != nil {
-				w.WriteHeader(http.StatusNotFound)
+				writeStorageError(w, r, "Failed to head narinfo", err)
 				return
 			}
+			obj.Close()
+			return
 		}
 
 		if r.Method == http.MethodGet {
 			slog.InfoContext(ctx, "Getting narinfo", "hash", hash)
-			obj, err := client.GetObject(context.Background(), s3Cfg.Bucket, hash+".narinfo", minio.GetObjectOptions{})
-			if err != nil {
-				slog.ErrorContext(ctx, "Failed to get nar info", "err", err)
-				w.WriteHeader(http.StatusInternalServerError)
-				return
-			}
-			_, err = obj.Stat()
+			obj, err := narInfos.GetObject(ctx, hash+".narinfo")
 			if err != nil {
-				slog.ErrorContext(ctx, "Failed to stat narinfo", "err", err)
-				w.WriteHeader(http.StatusNotFound)
+				writeStorageError(w, r, "Failed to get narinfo", err)
 				return
 			}
-
 			defer obj.Close()
+
 			_, err = io.Copy(w, obj)
 			if err != nil {
-				merr := minio.ToErrorResponse(err)
 				slog.ErrorContext(ctx, "Failed to copy narinfo to response", "err", err)
-				if merr.StatusCode != 0 {
-					w.WriteHeader(merr.StatusCode)
-				} else {
-					w.WriteHeader(http.StatusInternalServerError)
-				}
+				w.WriteHeader(http.StatusInternalServerError)
 				return
 			}
-			err = obj.Close()
-			if err != nil {
-				slog.ErrorContext(ctx, "Failed to close narinfo s3", "err", err)
-			}
 			return
 		}
 		if r.Method == http.MethodPut {
@@ -104,6 +87,23 @@ func HandleNarInfo(client *minio.Client, cacheCfg config.BinaryCacheCfg, s3Cfg c
 			}
 			r.Body.Close()
 
+			err = policies.Evaluate(ctx, policy.Input{
+				Action:  policy.ActionPutNarInfo,
+				Caller:  auth.FromContext(ctx),
+				NarInfo: info,
+			})
+			if err != nil {
+				var violation *policy.Violation
+				if errors.As(err, &violation) {
+					slog.WarnContext(ctx, "Narinfo denied by policy", "hash", hash, "rule", violation.Rule)
+					http.Error(w, violation.Error(), http.StatusForbidden)
+					return
+				}
+				slog.ErrorContext(ctx, "Failed to evaluate policies", "err", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
 			sig, err := cacheCfg.PrivateKey.Sign(nil, info.Fingerprint())
 			if err != nil {
 				slog.ErrorContext(ctx, "Failed to sign narinfo", "err", err)
@@ -112,21 +112,34 @@ func HandleNarInfo(client *minio.Client, cacheCfg config.BinaryCacheCfg, s3Cfg c
 			}
 			info.Signatures = append(info.Signatures, sig)
 
-			narinfoFile := bytes.NewBuffer([]byte(info.String()))
-			uploadInfo, err := client.PutObject(ctx, s3Cfg.Bucket, hash+".narinfo", narinfoFile, int64(narinfoFile.Len()), minio.PutObjectOptions{})
+			err = narInfos.PutNarInfo(ctx, hash, info)
 			if err != nil {
 				slog.ErrorContext(ctx, "Failed to upload nar info", "err", err)
 				w.WriteHeader(http.StatusInternalServerError)
 				return
 			}
-			slog.InfoContext(ctx, "Successful upload", "info", uploadInfo.Key)
+			// Object store has no transaction shared with the outbox, upload is accepted only once it is
+			// recorded, so consumers don't miss it. Failed upload is retried and stores the same narinfo.
+			change, err := domain.NewChange(domain.ChangeNarInfo, hash, domain.NarInfoChange{
+				Hash:    hash,
+				NarInfo: info.String(),
+			})
+			if err == nil {
+				_, err = database.PutChange(ctx, change)
+			}
+			if err != nil {
+				slog.ErrorContext(ctx, "Failed to record nar info upload", "err", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			slog.InfoContext(ctx, "Successful upload", "hash", hash)
 			return
 		}
 		w.WriteHeader(http.StatusNotFound)
 	})
 }
 
-func HandlenNar(client *minio.Client, minioCfg config.MinioCfg) http.Handler {
+func HandlenNar(narInfos *storage.NarInfoStore) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 		fmt.Printf("NAR Method: %+v Path: %+v\n", r.Method, r.URL.Path)
@@ -138,35 +151,22 @@ func HandlenNar(client *minio.Client, minioCfg config.MinioCfg) http.Handler {
 		}
 		if r.Method == http.MethodHead {
 			slog.InfoContext(ctx, "Heading nar", "hash", hash)
-			// TODO move bucket to cfg
-			obj, err := client.GetObject(ctx, minioCfg.Bucket, hash+".nar", minio.GetObjectOptions{})
-			if err != nil {
-				slog.ErrorContext(r.Context(), "Failed to get nar", "err", err)
-				w.WriteHeader(http.StatusInternalServerError)
-				return
-			}
-			defer obj.Close()
-			_, err = obj.Stat()
+			obj, err := narInfos.GetObject(ctx, hash+".nar")
 			if err != nil {
-				w.WriteHeader(http.StatusNotFound)
+				writeStorageError(w, r, "Failed to head nar", err)
 				return
 			}
+			obj.Close()
 			return
 		}
 		if r.Method == http.MethodGet {
 			slog.InfoContext(ctx, "Getting nar", "hash", hash)
-			obj, err := client.GetObject(ctx, minioCfg.Bucket, hash+".nar", minio.GetObjectOptions{})
-			if err != nil {
-				slog.ErrorContext(r.Context(), "Failed to get nar", "err", err)
-				w.WriteHeader(http.StatusInternalServerError)
-				return
-			}
-
-			_, err = obj.Stat()
+			obj, err := narInfos.GetObject(ctx, hash+".nar")
 			if err != nil {
-				w.WriteHeader(http.StatusNotFound)
+				writeStorageError(w, r, "Failed to get nar", err)
 				return
 			}
+			defer obj.Close()
 
 			w.Header().Add("content-type", "application/x-nix-nar")
 			compressedW, err := NewCompressionWriter(compression, w)
@@ -178,9 +178,8 @@ func HandlenNar(client *minio.Client, minioCfg config.MinioCfg) http.Handler {
 
 			_, err = io.Copy(compressedW, obj)
 			if err != nil {
-				merr := minio.ToErrorResponse(err)
 				slog.ErrorContext(r.Context(), "Failed to copy nar to response", "err", err)
-				w.WriteHeader(merr.StatusCode)
+				w.WriteHeader(http.StatusInternalServerError)
 				return
 			}
 
@@ -188,10 +187,6 @@ func HandlenNar(client *minio.Client, minioCfg config.MinioCfg) http.Handler {
 			if err != nil {
 				slog.ErrorContext(r.Context(), "Failed to close compressed writter", "err", err)
 			}
-			err = obj.Close()
-			if err != nil {
-				slog.ErrorContext(r.Context(), "Failed to close nar s3", "err", err)
-			}
 			return
 		}
 		if r.Method == http.MethodPut {
@@ -216,13 +211,13 @@ func HandlenNar(client *minio.Client, minioCfg config.MinioCfg) http.Handler {
 				return
 			}
 
-			uploadInfo, err := client.PutObject(context.Background(), minioCfg.Bucket, hash+".nar", body, int64(body.Len()), minio.PutObjectOptions{})
+			err = narInfos.PutObject(ctx, hash+".nar", bytes.NewReader(body.Bytes()), int64(body.Len()))
 			if err != nil {
 				slog.ErrorContext(r.Context(), "Failed to upload nar", "err", err)
 				w.WriteHeader(http.StatusInternalServerError)
 				return
 			}
-			slog.InfoContext(ctx, "Successful upload", "info", uploadInfo.Key)
+			slog.InfoContext(ctx, "Successful upload", "hash", hash)
 			return
 		}
 		w.WriteHeader(http.StatusNotFound)
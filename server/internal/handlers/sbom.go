@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"sbom"
+	"server/internal/closure"
+	"server/internal/db"
+	"server/internal/domain"
+)
+
+// HandleSbom serves SBOM of package closure.
+//
+// Package is selected by query parameters name, version and system, format is either cyclonedx (default) or spdx.
+func HandleSbom(database db.Database, narInfos closure.NarInfoGetter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		query := r.URL.Query()
+		name := query.Get("name")
+		if name == "" {
+			http.Error(w, "Package name is required", http.StatusBadRequest)
+			return
+		}
+		format := query.Get("format")
+		if format == "" {
+			format = sbom.FormatCycloneDX
+		}
+
+		pkg, err := database.GetPackage(ctx, domain.PackageRef{
+			Name:    name,
+			Version: query.Get("version"),
+			System:  query.Get("system"),
+		})
+		if err != nil {
+			if errors.Is(err, db.ErrNotFound) {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			slog.ErrorContext(ctx, "Failed to get package", "err", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		doc, err := closure.SbomDocument(ctx, narInfos, pkg)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to resolve package closure", "err", err)
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		document, contentType, err := sbom.Render(doc, format)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Add("content-type", contentType)
+		w.WriteHeader(http.StatusOK)
+		_, err = w.Write(document)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to write sbom", "err", err)
+		}
+	})
+}
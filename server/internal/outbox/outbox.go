@@ -0,0 +1,104 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"server/internal/db"
+	"server/internal/domain"
+	"slices"
+	"time"
+)
+
+// DefaultBatchSize is number of changes read from the outbox when the request has no limit.
+const DefaultBatchSize = 100
+
+// MaxBatchSize limits number of changes read from the outbox at once.
+const MaxBatchSize = 1000
+
+// PollInterval is how often readers which caught up look for new changes.
+const PollInterval = time.Second
+
+// ErrPruned is returned for cursor followed by changes which were already removed by retention,
+// the consumer missed them and has to resynchronize.
+var ErrPruned = errors.New("Changes following the cursor were pruned")
+
+// List lists up to limit changes following the cursor, oldest first. Cursor 0 starts with the oldest kept change,
+// so new consumers can start after retention removed the first changes.
+func List(ctx context.Context, database db.Database, cursor int64, limit int) ([]domain.Change, error) {
+	return list(ctx, database, cursor, limit, cursor > 0)
+}
+
+// ListComplete lists changes like List, but cursor 0 fails with ErrPruned when the first changes were removed.
+// Replicas rebuild the whole state from the changes, so they can't skip any.
+func ListComplete(ctx context.Context, database db.Database, cursor int64, limit int) ([]domain.Change, error) {
+	return list(ctx, database, cursor, limit, true)
+}
+
+func list(ctx context.Context, database db.Database, cursor int64, limit int, checkPruned bool) ([]domain.Change, error) {
+	changes, err := database.ListChanges(ctx, cursor, limit)
+	if err != nil || !checkPruned {
+		return changes, err
+	}
+	// Checked after listing, changes pruned in between are detected
+	oldest, err := database.GetOldestChangeID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if oldest > cursor+1 {
+		return nil, fmt.Errorf("%w, the oldest kept change is %d", ErrPruned, oldest)
+	}
+
+	return changes, nil
+}
+
+// Read sends changes following the cursor in order and keeps sending new changes until the context is
+// cancelled or send fails. Only changes of the kinds are sent, all changes when kinds are empty.
+func Read(ctx context.Context, database db.Database, cursor int64, kinds []domain.ChangeKind, send func(domain.Change) error) error {
+	for {
+		changes, err := List(ctx, database, cursor, MaxBatchSize)
+		if err != nil {
+			return err
+		}
+		for _, c := range changes {
+			cursor = c.ID
+			if len(kinds) > 0 && !slices.Contains(kinds, c.Kind) {
+				continue
+			}
+			err = send(c)
+			if err != nil {
+				return err
+			}
+		}
+		if len(changes) == MaxBatchSize {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(PollInterval):
+		}
+	}
+}
+
+// RunRetention removes changes older than retention every interval.
+func RunRetention(ctx context.Context, database db.Database, retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		deleted, err := database.DeleteChangesBefore(ctx, time.Now().Add(-retention))
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to prune outbox", "err", err)
+		} else if deleted > 0 {
+			slog.InfoContext(ctx, "Outbox pruned", "deleted", deleted, "retention", retention)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
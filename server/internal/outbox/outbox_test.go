@@ -0,0 +1,56 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"server/internal/db"
+	"server/internal/domain"
+	"testing"
+	"time"
+)
+
+func TestListAfterRetention(t *testing.T) {
+	ctx := context.Background()
+	database, err := db.Open(ctx, filepath.Join(t.TempDir(), "meshix.db"), os.DirFS("../../migrations"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Retention keeps the latest change, changes 3 to 5 are kept
+	for i := range 5 {
+		if i == 3 {
+			_, err = database.DeleteChangesBefore(ctx, time.Now().Add(time.Hour))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		err = database.PutAssignment(ctx, domain.Assignment{Target: domain.AssignmentTarget{Group: "web"}, PackageName: "app", PackageVersion: "1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		list     func(context.Context, db.Database, int64, int) ([]domain.Change, error)
+		cursor   int64
+		expected int
+		pruned   bool
+	}{
+		{"new consumer", List, 0, 3, false},
+		{"consumer which fell behind", List, 1, 0, true},
+		{"consumer after pruned changes", List, 2, 3, false},
+		{"new replica", ListComplete, 0, 0, true},
+		{"replica after pruned changes", ListComplete, 3, 2, false},
+	}
+	for _, tt := range tests {
+		changes, err := tt.list(ctx, database, tt.cursor, DefaultBatchSize)
+		if errors.Is(err, ErrPruned) != tt.pruned {
+			t.Errorf("%s: expected pruned %v, got %v", tt.name, tt.pruned, err)
+		}
+		if len(changes) != tt.expected {
+			t.Errorf("%s: expected %d changes, got %d", tt.name, tt.expected, len(changes))
+		}
+	}
+}
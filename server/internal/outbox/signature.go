@@ -0,0 +1,61 @@
+package outbox
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"server/internal/domain"
+	"strconv"
+	"strings"
+
+	"github.com/nix-community/go-nix/pkg/narinfo/signature"
+)
+
+const fingerprintVersion = "meshix-change-1"
+
+// Fingerprint of the change signed for replicas, format is documented on Change message in server.proto.
+// Every value is prefixed with its length, so values containing separators can't be shifted into other fields.
+func Fingerprint(change domain.Change) string {
+	digest := sha256.Sum256(change.Data)
+	var b strings.Builder
+	b.WriteString(fingerprintVersion)
+	for _, value := range []string{
+		strconv.FormatInt(change.ID, 10),
+		string(change.Kind),
+		change.Subject,
+		hex.EncodeToString(digest[:]),
+	} {
+		b.WriteByte(';')
+		b.WriteString(strconv.Itoa(len(value)))
+		b.WriteByte(':')
+		b.WriteString(value)
+	}
+
+	return b.String()
+}
+
+// Sign signs the change with binary cache key.
+func Sign(key signature.SecretKey, change domain.Change) (string, error) {
+	sig, err := key.Sign(nil, Fingerprint(change))
+	if err != nil {
+		return "", fmt.Errorf("Failed to sign change %d: %w", change.ID, err)
+	}
+
+	return sig.String(), nil
+}
+
+// Verify checks that the change signature is made by binary cache key.
+func Verify(key signature.PublicKey, change domain.Change, sig string) error {
+	if sig == "" {
+		return fmt.Errorf("Change %d is not signed", change.ID)
+	}
+	parsed, err := signature.ParseSignature(sig)
+	if err != nil {
+		return fmt.Errorf("Failed to parse signature of change %d: %w", change.ID, err)
+	}
+	if parsed.Name != key.Name || !key.Verify(Fingerprint(change), parsed) {
+		return fmt.Errorf("Change %d is not signed by %s", change.ID, key.Name)
+	}
+
+	return nil
+}
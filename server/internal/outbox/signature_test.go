@@ -0,0 +1,48 @@
+package outbox
+
+import (
+	"server/internal/domain"
+	"testing"
+
+	"github.com/nix-community/go-nix/pkg/narinfo/signature"
+)
+
+func TestVerifyChangeSignature(t *testing.T) {
+	secretKey, publicKey, err := signature.GenerateKeypair("cache", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherKey, err := signature.GenerateKeypair("cache", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	change := domain.Change{ID: 7, Kind: domain.ChangeAssignmentPut, Subject: "app", Data: []byte(`{"version":"2"}`)}
+	sig, err := Sign(secretKey, change)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tampered := change
+	tampered.Data = []byte(`{"version":"3"}`)
+	shifted := change
+	shifted.Kind, shifted.Subject = domain.ChangeKind("assignment.pu"), "tapp"
+	tests := []struct {
+		name   string
+		key    signature.PublicKey
+		change domain.Change
+		sig    string
+		valid  bool
+	}{
+		{"signed", publicKey, change, sig, true},
+		{"unsigned", publicKey, change, "", false},
+		{"tampered data", publicKey, tampered, sig, false},
+		{"shifted fields", publicKey, shifted, sig, false},
+		{"other key", otherKey, change, sig, false},
+	}
+	for _, tt := range tests {
+		err := Verify(tt.key, tt.change, tt.sig)
+		if (err == nil) != tt.valid {
+			t.Errorf("%s: Verify = %v, expected valid %v", tt.name, err, tt.valid)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package policy
+
+import (
+	"context"
+	"fmt"
+	"server/internal/auth"
+	"server/internal/config"
+	"server/internal/domain"
+	"slices"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/interpreter/functions"
+	"github.com/nix-community/go-nix/pkg/narinfo"
+)
+
+type Action string
+
+const (
+	ActionPushPackage Action = "push-package"
+	ActionPutNarInfo  Action = "put-narinfo"
+)
+
+const pathExistsOverload = "pathExists_string"
+
+// PathChecker checks existence of absolute paths inside nix store paths in the cache.
+type PathChecker interface {
+	PathExists(ctx context.Context, absolutePath string) (bool, error)
+}
+
+// Violation of an admission rule.
+type Violation struct {
+	Rule    string
+	Message string
+}
+
+func (v *Violation) Error() string {
+	if v.Message == "" {
+		return fmt.Sprintf("Violated policy %s", v.Rule)
+	}
+
+	return fmt.Sprintf("Violated policy %s: %s", v.Rule, v.Message)
+}
+
+// Input of the rule evaluation. Pkg is set for ActionPushPackage, NarInfo for ActionPutNarInfo.
+type Input struct {
+	Action  Action
+	Caller  auth.Identity
+	Pkg     *domain.NewPackage
+	NarInfo *narinfo.NarInfo
+}
+
+type rule struct {
+	name    string
+	message string
+	actions []Action
+	ast     *cel.Ast
+}
+
+// Engine evaluates admission rules written as CEL expressions.
+//
+// Expressions have access to following variables:
+//   - action: "push-package" or "put-narinfo"
+//   - caller: map with subject and groups
+//   - pkg: package metadata on push-package, empty map otherwise
+//   - narinfo: narinfo fields on put-narinfo, empty map otherwise
+//
+// and function pathExists(string) which checks that absolute path exists in the cache.
+type Engine struct {
+	env   *cel.Env
+	rules []rule
+	paths PathChecker
+}
+
+func NewEngine(policies []config.PolicyCfg, paths PathChecker) (*Engine, error) {
+	env, err := cel.NewEnv(
+		cel.Variable("action", cel.StringType),
+		cel.Variable("caller", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("pkg", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("narinfo", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Function("pathExists", cel.Overload(pathExistsOverload, []*cel.Type{cel.StringType}, cel.BoolType)),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create policy environment: %w", err)
+	}
+
+	rules := []rule{}
+	for _, p := range policies {
+		ast, issues := env.Compile(p.Expression)
+		if issues.Err() != nil {
+			return nil, fmt.Errorf("Failed to compile policy %s: %w", p.Name, issues.Err())
+		}
+		if ast.OutputType() != cel.BoolType {
+			return nil, fmt.Errorf("Policy %s has to evaluate to bool, got %s", p.Name, ast.OutputType())
+		}
+		actions := []Action{}
+		for _, a := range p.Actions {
+			action := Action(a)
+			if action != ActionPushPackage && action != ActionPutNarInfo {
+				return nil, fmt.Errorf("Policy %s has unknown action %s", p.Name, a)
+			}
+			actions = append(actions, action)
+		}
+		if len(actions) == 0 {
+			// pkg is unbound on put-narinfo, package rules would deny every cache upload
+			actions = []Action{ActionPushPackage}
+		}
+		rules = append(rules, rule{
+			name:    p.Name,
+			message: p.Message,
+			actions: actions,
+			ast:     ast,
+		})
+	}
+
+	return &Engine{
+		env:   env,
+		rules: rules,
+		paths: paths,
+	}, nil
+}
+
+// Evaluate returns *Violation of the first rule which denies the input.
+func (e *Engine) Evaluate(ctx context.Context, input Input) error {
+	vars := map[string]any{
+		"action":  string(input.Action),
+		"caller":  callerVars(input.Caller),
+		"pkg":     map[string]any{},
+		"narinfo": map[string]any{},
+	}
+	if input.Pkg != nil {
+		vars["pkg"] = packageVars(*input.Pkg)
+	}
+	if input.NarInfo != nil {
+		vars["narinfo"] = narInfoVars(input.NarInfo)
+	}
+
+	var evalErr error
+	pathExists := &functions.Overload{
+		Operator: pathExistsOverload,
+		Unary: func(value ref.Val) ref.Val {
+			p, ok := value.(types.String)
+			if !ok {
+				return types.MaybeNoSuchOverloadErr(value)
+			}
+			exists, err := e.paths.PathExists(ctx, string(p))
+			if err != nil {
+				evalErr = err
+				return types.WrapErr(err)
+			}
+			return types.Bool(exists)
+		},
+	}
+
+	for _, r := range e.rules {
+		if !slices.Contains(r.actions, input.Action) {
+			continue
+		}
+		prg, err := e.env.Program(r.ast, cel.Functions(pathExists))
+		if err != nil {
+			return fmt.Errorf("Failed to create program of policy %s: %w", r.name, err)
+		}
+		out, _, err := prg.ContextEval(ctx, vars)
+		if evalErr != nil {
+			return fmt.Errorf("Failed to evaluate policy %s: %w", r.name, evalErr)
+		}
+		if err != nil {
+			// Missing fields, e.g. pkg.provenance of package pushed without provenance, deny
+			message := err.Error()
+			if r.message != "" {
+				message = fmt.Sprintf("%s (%s)", r.message, err.Error())
+			}
+			return &Violation{
+				Rule:    r.name,
+				Message: message,
+			}
+		}
+		if allowed, ok := out.Value().(bool); !ok || !allowed {
+			return &Violation{
+				Rule:    r.name,
+				Message: r.message,
+			}
+		}
+	}
+
+	return nil
+}
+
+func callerVars(identity auth.Identity) map[string]any {
+	groups := identity.Groups
+	if groups == nil {
+		groups = []string{}
+	}
+
+	return map[string]any{
+		"subject": identity.Subject,
+		"groups":  groups,
+	}
+}
+
+func packageVars(pkg domain.NewPackage) map[string]any {
+	vars := map[string]any{
+		"name":             pkg.Name,
+		"version":          pkg.Version,
+		"system":           pkg.System,
+		"kind":             string(pkg.Kind),
+		"storePath":        pkg.NixMetadata.StorePath,
+		"mainBin":          pkg.NixMetadata.MainBin,
+		"outputs":          nonNilMap(pkg.NixMetadata.Outputs),
+		"outputsToInstall": nonNilSlice(pkg.NixMetadata.OutputsToInstall),
+		"description":      pkg.NixMetadata.Description,
+		"licenses":         nonNilSlice(pkg.NixMetadata.Licenses),
+		"homepage":         pkg.NixMetadata.Homepage,
+		"platforms":        nonNilSlice(pkg.NixMetadata.Platforms),
+		"unfree":           pkg.NixMetadata.Unfree,
+		"insecure":         pkg.NixMetadata.Insecure,
+		"position":         pkg.NixMetadata.Position,
+	}
+	if pkg.Provenance != nil {
+		vars["provenance"] = map[string]any{
+			"flakeUrl":      pkg.Provenance.FlakeUrl,
+			"flakeRevision": pkg.Provenance.FlakeRevision,
+			"flakeNarHash":  pkg.Provenance.FlakeNarHash,
+			"drvPath":       pkg.Provenance.DrvPath,
+			"builderHost":   pkg.Provenance.BuilderHost,
+			"clientVersion": pkg.Provenance.ClientVersion,
+			"ci":            nonNilMap(pkg.Provenance.Ci),
+		}
+	}
+
+	return vars
+}
+
+func narInfoVars(info *narinfo.NarInfo) map[string]any {
+	narHash := ""
+	if info.NarHash != nil {
+		narHash = info.NarHash.String()
+	}
+
+	return map[string]any{
+		"storePath":   info.StorePath,
+		"url":         info.URL,
+		"compression": info.Compression,
+		"narHash":     narHash,
+		"narSize":     info.NarSize,
+		"references":  nonNilSlice(info.References),
+		"deriver":     info.Deriver,
+		"system":      info.System,
+		"ca":          info.CA,
+	}
+}
+
+func nonNilSlice(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+
+	return s
+}
+
+func nonNilMap(m map[string]string) map[string]string {
+	if m == nil {
+		return map[string]string{}
+	}
+
+	return m
+}
@@ -0,0 +1,41 @@
+package policy
+
+import (
+	"context"
+	"errors"
+	"server/internal/config"
+	"server/internal/domain"
+	"testing"
+
+	"github.com/nix-community/go-nix/pkg/narinfo"
+)
+
+type fakePathChecker map[string]bool
+
+func (f fakePathChecker) PathExists(ctx context.Context, absolutePath string) (bool, error) {
+	return f[absolutePath], nil
+}
+
+func TestRuleWithoutActionsSkipsNarInfo(t *testing.T) {
+	engine, err := NewEngine([]config.PolicyCfg{
+		{Name: "free", Expression: "!pkg.unfree"},
+	}, fakePathChecker{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = engine.Evaluate(context.Background(), Input{
+		Action:  ActionPutNarInfo,
+		NarInfo: &narinfo.NarInfo{StorePath: "/nix/store/aaaa-hello-2.12"},
+	})
+	if err != nil {
+		t.Errorf("Narinfo upload denied by package rule: %v", err)
+	}
+
+	pkg := domain.NewPackage{Name: "hello", NixMetadata: domain.NixMetadata{Unfree: true}}
+	err = engine.Evaluate(context.Background(), Input{Action: ActionPushPackage, Pkg: &pkg})
+	var violation *Violation
+	if !errors.As(err, &violation) || violation.Rule != "free" {
+		t.Errorf("Unfree package not denied, got %v", err)
+	}
+}
@@ -0,0 +1,472 @@
+package replication
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	meshixv1 "gen/proto/meshix/v1"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"provenance"
+	"server/internal/config"
+	"server/internal/db"
+	"server/internal/domain"
+	"server/internal/outbox"
+	"server/internal/storage"
+	"server/internal/vulns"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/nix-community/go-nix/pkg/narinfo"
+	"github.com/nix-community/go-nix/pkg/narinfo/signature"
+	"github.com/nix-community/go-nix/pkg/nixbase32"
+	"github.com/nix-community/go-nix/pkg/nixhash"
+	"github.com/nix-community/go-nix/pkg/storepath"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+var errPromoted = errors.New("Replica is promoted")
+
+// Status of the replica, lag is derived from the last change seen on the primary.
+type Status struct {
+	// LastChangeID of the primary applied by the replica
+	LastChangeID int64
+	// PrimaryLatestID is the last change of the primary seen by the replica
+	PrimaryLatestID int64
+	// PendingSince is creation time of the oldest change not applied yet, zero when caught up
+	PendingSince time.Time
+	// LastError of the last failed sync, empty when the last sync succeeded
+	LastError string
+	SyncedAt  time.Time
+	Promoted  bool
+}
+
+// LagChanges is number of changes of the primary not applied yet.
+func (s Status) LagChanges() int64 {
+	return max(0, s.PrimaryLatestID-s.LastChangeID)
+}
+
+// Lag is age of the oldest change not applied yet.
+func (s Status) Lag(now time.Time) time.Duration {
+	if s.PendingSince.IsZero() {
+		return 0
+	}
+
+	return max(0, now.Sub(s.PendingSince))
+}
+
+// Follower applies change feed of the primary to the replica.
+//
+// Changes are applied in order and the cursor is stored after each change, so the replica resumes after
+// restart with the change which was not applied. Applying a change is idempotent, the change being applied
+// when the replica stopped is applied again. Changes, narinfos and package provenances have to be signed by
+// the binary cache key shared with the primary. Applied changes are appended to the feed of the replica, so it can
+// continue as primary when promoted.
+type Follower struct {
+	db         db.Database
+	narInfos   *storage.NarInfoStore
+	scanner    *vulns.Scanner
+	publicKey  signature.PublicKey
+	primaryUrl string
+	token      string
+	interval   time.Duration
+	client     meshixv1.MeshixServiceClient
+	httpClient *http.Client
+
+	mu     sync.Mutex
+	status Status
+}
+
+func NewFollower(ctx context.Context, database db.Database, narInfos *storage.NarInfoStore, scanner *vulns.Scanner, publicKey signature.PublicKey, cfg config.ReplicationCfg) (*Follower, error) {
+	primaryUrl := strings.TrimSuffix(cfg.PrimaryUrl, "/")
+	u, err := url.Parse(primaryUrl)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("Invalid url of primary %s", cfg.PrimaryUrl)
+	}
+	transportCredentials := insecure.NewCredentials()
+	if u.Scheme == "https" {
+		transportCredentials = credentials.NewTLS(&tls.Config{})
+	}
+	cc, err := grpc.NewClient(u.Host,
+		grpc.WithTransportCredentials(transportCredentials),
+		grpc.WithPerRPCCredentials(tokenCredentials(cfg.Token)))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create client of primary: %w", err)
+	}
+
+	cursor, err := database.GetReplicationCursor(ctx, primaryUrl)
+	if err != nil && !errors.Is(err, db.ErrNotFound) {
+		return nil, fmt.Errorf("Failed to get replication cursor: %w", err)
+	}
+
+	return &Follower{
+		db:         database,
+		narInfos:   narInfos,
+		scanner:    scanner,
+		publicKey:  publicKey,
+		primaryUrl: primaryUrl,
+		token:      cfg.Token,
+		interval:   cfg.PollInterval,
+		client:     meshixv1.NewMeshixServiceClient(cc),
+		httpClient: http.DefaultClient,
+		status: Status{
+			LastChangeID: cursor.LastChangeID,
+			Promoted:     cursor.PromotedAt != nil,
+		},
+	}, nil
+}
+
+// PrimaryUrl of the followed server.
+func (f *Follower) PrimaryUrl() string {
+	return f.primaryUrl
+}
+
+// Status returns copy of the replica status.
+func (f *Follower) Status() Status {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.status
+}
+
+// ReadOnly reports if writes have to go to the primary, server without follower is writable.
+func (f *Follower) ReadOnly() bool {
+	if f == nil {
+		return false
+	}
+
+	return !f.Status().Promoted
+}
+
+// Promote stops following the primary, the replica accepts writes afterwards. Change being applied is finished.
+func (f *Follower) Promote(ctx context.Context) error {
+	err := f.db.PromoteReplica(ctx, f.primaryUrl)
+	if err != nil {
+		return fmt.Errorf("Failed to promote replica: %w", err)
+	}
+	f.update(func(s *Status) {
+		s.Promoted = true
+	})
+	slog.InfoContext(ctx, "Replica promoted", "primary", f.primaryUrl, "lastChangeId", f.Status().LastChangeID)
+
+	return nil
+}
+
+// Middleware rejects uploads to the binary cache of read-only replica.
+func (f *Follower) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead && f.ReadOnly() {
+			http.Error(w, "Server is a read-only replica of "+f.primaryUrl, http.StatusMethodNotAllowed)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Run follows the primary until the context is cancelled or the replica is promoted.
+func (f *Follower) Run(ctx context.Context) {
+	slog.InfoContext(ctx, "Replicating from primary", "primary", f.primaryUrl, "lastChangeId", f.Status().LastChangeID)
+	for {
+		caughtUp, err := f.sync(ctx)
+		if errors.Is(err, errPromoted) {
+			slog.InfoContext(ctx, "Stopped replicating from primary", "primary", f.primaryUrl)
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to replicate from primary", "primary", f.primaryUrl, "err", err)
+			f.update(func(s *Status) {
+				s.LastError = err.Error()
+			})
+		}
+		if !caughtUp && err == nil {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(f.interval):
+		}
+	}
+}
+
+// sync applies one batch of changes, it reports if the replica caught up with the primary.
+func (f *Follower) sync(ctx context.Context) (bool, error) {
+	status := f.Status()
+	if status.Promoted {
+		return true, errPromoted
+	}
+	resp, err := f.client.GetChanges(ctx, &meshixv1.GetChangesRequest{
+		AfterId: status.LastChangeID,
+		Limit:   outbox.DefaultBatchSize,
+	})
+	if err != nil {
+		return false, fmt.Errorf("Failed to get changes: %w", err)
+	}
+	f.update(func(s *Status) {
+		s.PrimaryLatestID = resp.LatestId
+		s.PendingSince = time.Time{}
+		if len(resp.Changes) > 0 {
+			s.PendingSince = resp.Changes[0].CreatedAt.AsTime()
+		}
+	})
+
+	for i, c := range resp.Changes {
+		if f.Status().Promoted {
+			return true, errPromoted
+		}
+		change := domain.Change{
+			ID:        c.Id,
+			Kind:      domain.ChangeKind(c.Kind),
+			Subject:   c.Subject,
+			Data:      c.Data,
+			CreatedAt: c.CreatedAt.AsTime(),
+		}
+		// Primary may be reached without TLS, payloads are trusted only with signature of the cache key
+		err := outbox.Verify(f.publicKey, change, c.Signature)
+		if err != nil {
+			return false, err
+		}
+		err = f.apply(ctx, change)
+		if err != nil {
+			return false, fmt.Errorf("Failed to apply change %d %s of %s: %w", change.ID, change.Kind, change.Subject, err)
+		}
+		err = f.db.PutReplicationCursor(ctx, f.primaryUrl, change.ID)
+		if err != nil {
+			return false, fmt.Errorf("Failed to store replication cursor: %w", err)
+		}
+
+		f.update(func(s *Status) {
+			s.LastChangeID = change.ID
+			switch {
+			case i+1 < len(resp.Changes):
+				s.PendingSince = resp.Changes[i+1].CreatedAt.AsTime()
+			case change.ID >= s.PrimaryLatestID:
+				s.PendingSince = time.Time{}
+			}
+		})
+	}
+	f.update(func(s *Status) {
+		s.LastError = ""
+		s.SyncedAt = time.Now()
+	})
+
+	return f.Status().LagChanges() == 0, nil
+}
+
+func (f *Follower) apply(ctx context.Context, change domain.Change) error {
+	switch change.Kind {
+	case domain.ChangeNarInfo:
+		var payload domain.NarInfoChange
+		err := json.Unmarshal(change.Data, &payload)
+		if err != nil {
+			return err
+		}
+		err = f.applyNarInfo(ctx, payload)
+		if err != nil {
+			return err
+		}
+		// Other changes are appended to the outbox of the replica by the database
+		_, err = f.db.PutChange(ctx, change)
+		return err
+	case domain.ChangePackagePushed:
+		var pkg domain.NewPackage
+		err := json.Unmarshal(change.Data, &pkg)
+		if err != nil {
+			return err
+		}
+		return f.applyPackage(ctx, pkg)
+	case domain.ChangePackageYanked:
+		var payload domain.PackageYankChange
+		err := json.Unmarshal(change.Data, &payload)
+		if err != nil {
+			return err
+		}
+		return ignoreNotFound(f.db.YankPackage(ctx, payload.Ref, payload.Reason))
+	case domain.ChangePackageDeleted:
+		var payload domain.PackageDeleteChange
+		err := json.Unmarshal(change.Data, &payload)
+		if err != nil {
+			return err
+		}
+		return ignoreNotFound(f.db.DeletePackage(ctx, payload.Ref, payload.DropGcRoot))
+	case domain.ChangeAssignmentPut:
+		var assignment domain.Assignment
+		err := json.Unmarshal(change.Data, &assignment)
+		if err != nil {
+			return err
+		}
+		return f.db.PutAssignment(ctx, assignment)
+	case domain.ChangeAssignmentDeleted:
+		var payload domain.AssignmentDeleteChange
+		err := json.Unmarshal(change.Data, &payload)
+		if err != nil {
+			return err
+		}
+		return ignoreNotFound(f.db.DeleteAssignment(ctx, payload.Target, payload.PackageName))
+	default:
+		if change.Kind.Local() {
+			return nil
+		}
+		// Replica older than the primary, it has to be upgraded
+		return fmt.Errorf("Unknown change kind %s", change.Kind)
+	}
+}
+
+func (f *Follower) applyPackage(ctx context.Context, pkg domain.NewPackage) error {
+	if pkg.Provenance != nil {
+		err := provenance.Verify(f.publicKey, pkg.ProvenanceFields(), pkg.Provenance.Signature)
+		if err != nil {
+			return err
+		}
+	}
+	ref := domain.PackageRef{
+		Name:    pkg.Name,
+		Version: pkg.Version,
+		System:  pkg.System,
+	}
+	_, err := f.db.GetPackage(ctx, ref)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, db.ErrNotFound) {
+		return err
+	}
+
+	err = f.db.PutPackage(ctx, pkg)
+	if err != nil {
+		return err
+	}
+	f.scanner.ScanPackage(ref)
+
+	return nil
+}
+
+// applyNarInfo copies NAR and then its narinfo, so the replica never serves narinfo without NAR.
+func (f *Follower) applyNarInfo(ctx context.Context, payload domain.NarInfoChange) error {
+	info, err := narinfo.Parse(strings.NewReader(payload.NarInfo))
+	if err != nil {
+		return fmt.Errorf("Failed to parse narinfo: %w", err)
+	}
+	sp, err := storepath.FromAbsolutePath(info.StorePath)
+	if err != nil {
+		return err
+	}
+	if nixbase32.EncodeToString(sp.Digest) != payload.Hash {
+		return fmt.Errorf("Narinfo of %s does not match hash %s", info.StorePath, payload.Hash)
+	}
+	err = f.verifyNarInfo(info)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.narInfos.GetNarInfo(ctx, payload.Hash)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, storage.ErrNotFound) {
+		return err
+	}
+
+	err = f.copyNar(ctx, info)
+	if err != nil {
+		return err
+	}
+
+	return f.narInfos.PutNarInfo(ctx, payload.Hash, info)
+}
+
+func (f *Follower) verifyNarInfo(info *narinfo.NarInfo) error {
+	for _, sig := range info.Signatures {
+		if sig.Name == f.publicKey.Name && f.publicKey.Verify(info.Fingerprint(), sig) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("Narinfo of %s is not signed by %s", info.StorePath, f.publicKey.Name)
+}
+
+// copyNar downloads zstd compressed NAR from the primary and stores it when it matches NarHash of the narinfo.
+func (f *Follower) copyNar(ctx context.Context, info *narinfo.NarInfo) error {
+	if info.NarHash == nil || info.NarHash.Algo() != nixhash.SHA256 {
+		return fmt.Errorf("Narinfo of %s has no sha256 NarHash", info.StorePath)
+	}
+	fileHash, _, _ := strings.Cut(path.Base(info.URL), ".")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, f.primaryUrl+"/cache/nar/"+fileHash+".nar.zst", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+f.token)
+	resp, err := f.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("Failed to get nar %s: %w", fileHash, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Failed to get nar %s: %s", fileHash, resp.Status)
+	}
+	decoder, err := zstd.NewReader(resp.Body)
+	if err != nil {
+		return err
+	}
+	defer decoder.Close()
+
+	nar, err := os.CreateTemp("", "meshix-nar-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(nar.Name())
+	defer nar.Close()
+	narHash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(nar, narHash), io.LimitReader(decoder, int64(info.NarSize)+1))
+	if err != nil {
+		return fmt.Errorf("Failed to download nar %s: %w", fileHash, err)
+	}
+	if uint64(size) != info.NarSize || !bytes.Equal(narHash.Sum(nil), info.NarHash.Digest()) {
+		return fmt.Errorf("Nar %s does not match NarHash %s", fileHash, info.NarHash)
+	}
+	_, err = nar.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	return f.narInfos.PutNar(ctx, info, nar)
+}
+
+func (f *Follower) update(fn func(s *Status)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fn(&f.status)
+}
+
+func ignoreNotFound(err error) error {
+	if errors.Is(err, db.ErrNotFound) {
+		return nil
+	}
+
+	return err
+}
+
+type tokenCredentials string
+
+func (t tokenCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{
+		"authorization": "Bearer " + string(t),
+	}, nil
+}
+
+// Primary is served over h2c, token is sent without transport security
+func (t tokenCredentials) RequireTransportSecurity() bool {
+	return false
+}
@@ -0,0 +1,158 @@
+package replication
+
+import (
+	"context"
+	meshixv1 "gen/proto/meshix/v1"
+	"os"
+	"path/filepath"
+	"server/internal/config"
+	"server/internal/db"
+	"server/internal/domain"
+	"server/internal/outbox"
+	"server/internal/vulns"
+	"strings"
+	"testing"
+
+	"github.com/nix-community/go-nix/pkg/narinfo/signature"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// testPrimary serves change feed of the database, changes are signed with the key when set.
+type testPrimary struct {
+	meshixv1.MeshixServiceClient
+	db  db.Database
+	key *signature.SecretKey
+}
+
+func (p testPrimary) GetChanges(ctx context.Context, in *meshixv1.GetChangesRequest, opts ...grpc.CallOption) (*meshixv1.GetChangesResponse, error) {
+	changes, err := p.db.ListChanges(ctx, in.AfterId, int(in.Limit))
+	if err != nil {
+		return nil, err
+	}
+	latest, err := p.db.GetLatestChangeID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resp := &meshixv1.GetChangesResponse{LatestId: latest}
+	for _, c := range changes {
+		var sig string
+		if p.key != nil {
+			sig, err = outbox.Sign(*p.key, c)
+			if err != nil {
+				return nil, err
+			}
+		}
+		resp.Changes = append(resp.Changes, &meshixv1.Change{
+			Id:        c.ID,
+			Kind:      string(c.Kind),
+			Subject:   c.Subject,
+			Data:      c.Data,
+			CreatedAt: timestamppb.New(c.CreatedAt),
+			Signature: sig,
+		})
+	}
+
+	return resp, nil
+}
+
+func openDatabase(t *testing.T) db.Database {
+	t.Helper()
+	database, err := db.Open(context.Background(), filepath.Join(t.TempDir(), "meshix.db"), os.DirFS("../../migrations"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return database
+}
+
+func newTestFollower(t *testing.T, replica db.Database, publicKey signature.PublicKey, primary testPrimary) *Follower {
+	t.Helper()
+	f, err := NewFollower(context.Background(), replica, nil, vulns.NewScanner(replica, nil), publicKey, config.ReplicationCfg{PrimaryUrl: "http://primary:8088", Token: "token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.client = primary
+
+	return f
+}
+
+func TestFollowerAppliesSignedChanges(t *testing.T) {
+	ctx := context.Background()
+	secretKey, publicKey, err := signature.GenerateKeypair("cache", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	primary := openDatabase(t)
+	replica := openDatabase(t)
+	err = primary.PutPackage(ctx, domain.NewPackage{
+		Name:        "app",
+		Version:     "1",
+		System:      "x86_64-linux",
+		NixMetadata: domain.NixMetadata{StorePath: "/nix/store/aaaa-app-1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = primary.PutAssignment(ctx, domain.Assignment{Target: domain.AssignmentTarget{Group: "web"}, PackageName: "app", PackageVersion: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := newTestFollower(t, replica, publicKey, testPrimary{db: primary, key: &secretKey})
+	caughtUp, err := f.sync(ctx)
+	if err != nil || !caughtUp {
+		t.Fatalf("Sync caught up %v, %v", caughtUp, err)
+	}
+	if status := f.Status(); status.LastChangeID != 2 || status.LagChanges() != 0 {
+		t.Errorf("Unexpected status after sync %+v", status)
+	}
+	_, err = replica.GetPackage(ctx, domain.PackageRef{Name: "app", Version: "1"})
+	if err != nil {
+		t.Errorf("Package was not replicated: %v", err)
+	}
+	assignments, err := replica.ListAssignments(ctx)
+	if err != nil || len(assignments) != 1 {
+		t.Errorf("Assignment was not replicated: %v, %v", assignments, err)
+	}
+}
+
+func TestFollowerRejectsUnverifiedChanges(t *testing.T) {
+	ctx := context.Background()
+	_, publicKey, err := signature.GenerateKeypair("cache", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, _, err := signature.GenerateKeypair("cache", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	primary := openDatabase(t)
+	err = primary.PutAssignment(ctx, domain.Assignment{Target: domain.AssignmentTarget{Group: "web"}, PackageName: "app", PackageVersion: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		key  *signature.SecretKey
+	}{
+		{"unsigned", nil},
+		{"signed by other key", &otherKey},
+	}
+	for _, tt := range tests {
+		replica := openDatabase(t)
+		f := newTestFollower(t, replica, publicKey, testPrimary{db: primary, key: tt.key})
+		_, err = f.sync(ctx)
+		if err == nil || !strings.Contains(err.Error(), "signed") {
+			t.Errorf("%s: expected rejected change, got %v", tt.name, err)
+		}
+		if f.Status().LastChangeID != 0 {
+			t.Errorf("%s: cursor moved to %d", tt.name, f.Status().LastChangeID)
+		}
+		assignments, err := replica.ListAssignments(ctx)
+		if err != nil || len(assignments) != 0 {
+			t.Errorf("%s: assignment was applied %v, %v", tt.name, assignments, err)
+		}
+	}
+}
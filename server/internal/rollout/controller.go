@@ -0,0 +1,400 @@
+package rollout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"server/internal/db"
+	"server/internal/domain"
+	"server/internal/fleet"
+	"slices"
+	"sync"
+	"time"
+)
+
+var ErrInvalidTransition = errors.New("Invalid rollout state transition")
+
+type MachineState string
+
+const (
+	// Machine is in a wave which was not reached yet
+	MachineWaiting   MachineState = "waiting"
+	MachinePending   MachineState = "pending"
+	MachineSucceeded MachineState = "succeeded"
+	MachineFailed    MachineState = "failed"
+	// Machine of staging rollout is downloading the package
+	MachineStaging MachineState = "staging"
+	// Machine of staging rollout has the package ready for activation
+	MachineStaged MachineState = "staged"
+	// Pending machine stopped sending heartbeats, waves do not wait for it and it is not counted as failed
+	MachineStale MachineState = "stale"
+)
+
+// MachineProgress is state of machine in the rollout evaluated from its last activation.
+type MachineProgress struct {
+	domain.RolloutMachine
+	State MachineState
+	// FailedGroup is set when the rollout version failed in a group of the machine, so it is not scheduled to it
+	FailedGroup string
+}
+
+// Controller advances running rollouts wave by wave, based on activations reported by agents.
+type Controller struct {
+	db db.Database
+	// staleAfter is the heartbeat timeout after which pending machines are skipped
+	staleAfter time.Duration
+	// mu serializes state changes of rollouts made by controller and by users
+	mu sync.Mutex
+}
+
+func NewController(database db.Database, staleAfter time.Duration) *Controller {
+	return &Controller{
+		db:         database,
+		staleAfter: staleAfter,
+	}
+}
+
+// Run advances rollouts every interval until the context is done.
+func (c *Controller) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := c.Tick(ctx)
+			if err != nil {
+				slog.ErrorContext(ctx, "Failed to advance rollouts", "err", err)
+			}
+		}
+	}
+}
+
+// Create plans machines matching the selector into waves and starts the rollout.
+func (c *Controller) Create(ctx context.Context, r domain.NewRollout) (int64, error) {
+	err := Validate(&r)
+	if err != nil {
+		return 0, err
+	}
+	pkg, err := c.db.GetPackage(ctx, domain.PackageRef{
+		Name:    r.PackageName,
+		Version: r.PackageVersion,
+	})
+	if err != nil {
+		return 0, err
+	}
+	if pkg.Kind == domain.PackageKindSystem {
+		return 0, fmt.Errorf("%w: system packages are assigned to machines", ErrInvalidRollout)
+	}
+	failed, err := c.db.ListFailedVersions(ctx, domain.PackageRef{
+		Name:    r.PackageName,
+		Version: r.PackageVersion,
+	})
+	if err != nil {
+		return 0, err
+	}
+	if group, ok := fleet.FailedInGroups(failed, r.PackageName, r.PackageVersion, []string{r.Selector.Group}); ok {
+		return 0, fmt.Errorf("%w: version failed in group %s", ErrInvalidRollout, group)
+	}
+	matched, err := c.db.ListMachines(ctx, r.Selector)
+	if err != nil {
+		return 0, err
+	}
+	machines := []domain.Machine{}
+	for _, m := range matched {
+		if _, ok := fleet.FailedOnMachine(failed, r.PackageName, r.PackageVersion, m); !ok {
+			machines = append(machines, m)
+		}
+	}
+	if len(machines) == 0 {
+		return 0, fmt.Errorf("%w: no machines match the selector", ErrInvalidRollout)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	id, err := c.db.PutRollout(ctx, r, Plan(r, machines))
+	if err != nil {
+		return 0, err
+	}
+	slog.InfoContext(ctx, "Rollout created", "rollout", id, "package", r.PackageName, "version", r.PackageVersion, "machines", len(machines))
+
+	return id, nil
+}
+
+// Activate starts waves of staging rollout.
+func (c *Controller) Activate(ctx context.Context, id int64) error {
+	return c.transition(ctx, id, domain.RolloutRunning, []domain.RolloutState{domain.RolloutStaging}, "")
+}
+
+// Pause stops advancing of running rollout, reached machines keep the rollout version.
+func (c *Controller) Pause(ctx context.Context, id int64) error {
+	return c.transition(ctx, id, domain.RolloutPaused, []domain.RolloutState{domain.RolloutRunning}, "Paused by user")
+}
+
+// Resume continues paused or halted rollout. Rollout halted by failed version is halted again
+// until the failure is cleared.
+func (c *Controller) Resume(ctx context.Context, id int64) error {
+	return c.transition(ctx, id, domain.RolloutRunning, []domain.RolloutState{domain.RolloutPaused, domain.RolloutHalted}, "")
+}
+
+// Abort stops the rollout, machines return to the version of their assignments.
+func (c *Controller) Abort(ctx context.Context, id int64) error {
+	return c.transition(ctx, id, domain.RolloutAborted, []domain.RolloutState{domain.RolloutStaging, domain.RolloutRunning, domain.RolloutPaused, domain.RolloutHalted}, "Aborted by user")
+}
+
+func (c *Controller) transition(ctx context.Context, id int64, to domain.RolloutState, from []domain.RolloutState, reason string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	r, err := c.db.GetRollout(ctx, id)
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(from, r.State) {
+		return fmt.Errorf("%w: rollout %d is %s", ErrInvalidTransition, id, r.State)
+	}
+	r.State = to
+	r.StateReason = reason
+	if to == domain.RolloutRunning {
+		// Soak of the current wave starts again
+		r.WaveStartedAt = time.Now()
+	}
+
+	return c.db.UpdateRolloutState(ctx, r)
+}
+
+// Tick evaluates running rollouts, halting them on failures and advancing finished waves.
+func (c *Controller) Tick(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	rollouts, err := c.db.ListRollouts(ctx, domain.RolloutRunning)
+	if err != nil {
+		return err
+	}
+	for _, r := range rollouts {
+		err = c.advance(ctx, r)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to advance rollout", "rollout", r.ID, "err", err)
+		}
+	}
+
+	return nil
+}
+
+func (c *Controller) advance(ctx context.Context, r domain.Rollout) error {
+	progress, err := c.Progress(ctx, r)
+	if err != nil {
+		return err
+	}
+
+	reached, failed, currentPending, currentCount := 0, 0, 0, 0
+	for _, p := range progress {
+		if p.Wave == r.CurrentWave {
+			currentCount++
+		}
+		if p.State == MachineWaiting || p.State == MachineStale {
+			continue
+		}
+		if p.FailedGroup != "" {
+			r.State = domain.RolloutHalted
+			r.StateReason = fmt.Sprintf("Version failed health check in group %s", p.FailedGroup)
+			slog.WarnContext(ctx, "Rollout halted", "rollout", r.ID, "reason", r.StateReason)
+			return c.db.UpdateRolloutState(ctx, r)
+		}
+		reached++
+		if p.State == MachineFailed {
+			failed++
+		}
+		if p.Wave == r.CurrentWave && p.State == MachinePending {
+			currentPending++
+		}
+	}
+
+	if reached > 0 && float64(failed)/float64(reached) > r.MaxFailureRate {
+		r.State = domain.RolloutHalted
+		r.StateReason = fmt.Sprintf("%d of %d machines failed to activate", failed, reached)
+		slog.WarnContext(ctx, "Rollout halted", "rollout", r.ID, "reason", r.StateReason)
+		return c.db.UpdateRolloutState(ctx, r)
+	}
+	if currentPending > 0 {
+		return nil
+	}
+	now := time.Now()
+	if currentCount > 0 && now.Sub(r.WaveStartedAt) < r.Soak {
+		return nil
+	}
+
+	if r.CurrentWave >= WaveCount(r.CanaryCount, r.WavePercentages)-1 {
+		return c.complete(ctx, r)
+	}
+	if !r.Emergency {
+		open, err := c.waveInMaintenance(ctx, progress, r.CurrentWave+1, now)
+		if err != nil {
+			return err
+		}
+		if !open {
+			slog.DebugContext(ctx, "Rollout waits for maintenance window", "rollout", r.ID, "wave", r.CurrentWave+1)
+			return nil
+		}
+	}
+	r.CurrentWave++
+	r.WaveStartedAt = now
+	slog.InfoContext(ctx, "Rollout advanced", "rollout", r.ID, "wave", r.CurrentWave)
+
+	return c.db.UpdateRolloutState(ctx, r)
+}
+
+// waveInMaintenance checks that some machine of the wave is in its maintenance window, so the wave does not
+// start soaking while none of its machines can activate.
+func (c *Controller) waveInMaintenance(ctx context.Context, progress []MachineProgress, wave int, now time.Time) (bool, error) {
+	windows, err := c.db.ListMaintenanceWindows(ctx)
+	if err != nil {
+		return false, err
+	}
+	if len(windows) == 0 {
+		return true, nil
+	}
+
+	for _, p := range progress {
+		if p.Wave != wave {
+			continue
+		}
+		machine, err := c.db.GetMachine(ctx, p.MachineID)
+		if err != nil {
+			return false, err
+		}
+		if open, _ := domain.InMaintenance(windows, machine, now); open {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// complete finishes the rollout. Group assignment of the package is updated to the rollout version,
+// so machines enrolled later get it as well.
+func (c *Controller) complete(ctx context.Context, r domain.Rollout) error {
+	if r.Selector.Group != "" && len(r.Selector.Labels) == 0 {
+		assignment := domain.Assignment{
+			Target:         domain.AssignmentTarget{Group: r.Selector.Group},
+			PackageName:    r.PackageName,
+			PackageVersion: r.PackageVersion,
+		}
+		err := c.db.PutAssignment(ctx, assignment)
+		if err != nil {
+			return err
+		}
+	}
+	r.State = domain.RolloutCompleted
+	r.StateReason = ""
+	slog.InfoContext(ctx, "Rollout completed", "rollout", r.ID)
+
+	return c.db.UpdateRolloutState(ctx, r)
+}
+
+// Progress evaluates state of rollout machines. Machine succeeded when its last activation installed the rollout
+// package and failed when the activation installing it failed or the version failed in its group.
+// Machines of staging rollout are staged when their agent reported the package downloaded. Pending machines
+// without heartbeat for the stale timeout are stale.
+func (c *Controller) Progress(ctx context.Context, r domain.Rollout) ([]MachineProgress, error) {
+	machines, err := c.db.ListRolloutMachines(ctx, r.ID)
+	if err != nil {
+		return nil, err
+	}
+	failed, err := c.db.ListFailedVersions(ctx, domain.PackageRef{
+		Name:    r.PackageName,
+		Version: r.PackageVersion,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	// Store paths of the rollout package differ by system of the machine
+	expectedBySystem := map[string][]string{}
+	progress := []MachineProgress{}
+	for _, m := range machines {
+		p := MachineProgress{
+			RolloutMachine: m,
+			State:          MachineWaiting,
+		}
+		if r.State == domain.RolloutAborted || (m.Wave > r.CurrentWave && r.State != domain.RolloutStaging) {
+			progress = append(progress, p)
+			continue
+		}
+
+		p.State = MachinePending
+		machine, err := c.db.GetMachine(ctx, m.MachineID)
+		if err != nil {
+			return nil, err
+		}
+		expected, ok := expectedBySystem[machine.System]
+		if !ok {
+			expected, err = c.expectedPaths(ctx, r, machine.System)
+			if err != nil {
+				return nil, err
+			}
+			expectedBySystem[machine.System] = expected
+		}
+
+		if len(expected) == 0 {
+			// Package was not built for system of the machine
+			p.State = MachineFailed
+			progress = append(progress, p)
+			continue
+		}
+		if f, ok := fleet.FailedOnMachine(failed, r.PackageName, r.PackageVersion, machine); ok {
+			// Failure of machine without groups counts towards failure rate, it does not halt the rollout
+			p.State = MachineFailed
+			p.FailedGroup = f.Group
+			progress = append(progress, p)
+			continue
+		}
+		if r.State == domain.RolloutStaging {
+			// All machines download the package before the rollout is activated
+			p.State = MachineStaging
+			if machine.Status != nil && fleet.ContainsAll(machine.Status.StagedStorePaths, expected) {
+				p.State = MachineStaged
+			}
+			progress = append(progress, p)
+			continue
+		}
+
+		activation, err := c.db.GetLatestActivation(ctx, m.MachineID)
+		if err != nil && !errors.Is(err, db.ErrNotFound) {
+			return nil, err
+		}
+		if err == nil && fleet.ContainsAll(activation.StorePaths, expected) {
+			p.State = MachineFailed
+			if activation.Success {
+				p.State = MachineSucceeded
+			}
+		}
+		if p.State == MachinePending && machine.Stale(now, c.staleAfter) {
+			p.State = MachineStale
+		}
+		progress = append(progress, p)
+	}
+
+	return progress, nil
+}
+
+// expectedPaths are store paths of the rollout package for the system, empty when package was not built for it.
+func (c *Controller) expectedPaths(ctx context.Context, r domain.Rollout, system string) ([]string, error) {
+	pkg, err := c.db.GetPackage(ctx, domain.PackageRef{
+		Name:    r.PackageName,
+		Version: r.PackageVersion,
+		System:  system,
+	})
+	if errors.Is(err, db.ErrNotFound) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return fleet.InstallPaths(pkg)
+}
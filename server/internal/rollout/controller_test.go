@@ -0,0 +1,121 @@
+package rollout
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"server/internal/db"
+	"server/internal/domain"
+	"testing"
+	"time"
+)
+
+// openFleet opens temporary database with package app in versions 1 and 2 and machines of group web.
+func openFleet(t *testing.T, machines int) db.Database {
+	t.Helper()
+	ctx := context.Background()
+	database, err := db.Open(ctx, filepath.Join(t.TempDir(), "meshix.db"), os.DirFS("../../migrations"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, version := range []string{"1", "2"} {
+		err = database.PutPackage(ctx, domain.NewPackage{
+			Name:        "app",
+			Version:     version,
+			System:      "x86_64-linux",
+			NixMetadata: domain.NixMetadata{StorePath: "/nix/store/aaaa-app-" + version},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := range machines {
+		token := fmt.Sprintf("token-%d", i)
+		err = database.PutBootstrapToken(ctx, token, domain.BootstrapToken{Groups: []string{"web"}, ExpiresAt: time.Now().Add(time.Hour)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = database.RegisterMachine(ctx, token, domain.NewMachine{
+			Name:      fmt.Sprintf("web-%d", i),
+			System:    "x86_64-linux",
+			PublicKey: make([]byte, 32),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return database
+}
+
+func reportHeartbeats(t *testing.T, database db.Database, reportedAt time.Time) {
+	t.Helper()
+	ctx := context.Background()
+	machines, err := database.ListMachines(ctx, domain.MachineFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range machines {
+		err = database.PutMachineStatus(ctx, m.ID, domain.MachineStatus{ReportedAt: reportedAt})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestWaveSkipsStaleMachines(t *testing.T) {
+	ctx := context.Background()
+	database := openFleet(t, 3)
+	controller := NewController(database, 5*time.Minute)
+	id, err := controller.Create(ctx, domain.NewRollout{
+		PackageName:     "app",
+		PackageVersion:  "2",
+		Selector:        domain.MachineFilter{Group: "web"},
+		CanaryCount:     1,
+		WavePercentages: []int{100},
+		MaxFailureRate:  0.5,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Machines sending heartbeats keep the canary wave pending
+	reportHeartbeats(t, database, time.Now())
+	err = controller.Tick(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := database.GetRollout(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.CurrentWave != 0 || r.State != domain.RolloutRunning {
+		t.Fatalf("Rollout advanced past pending canary, wave %d, state %s", r.CurrentWave, r.State)
+	}
+
+	reportHeartbeats(t, database, time.Now().Add(-time.Hour))
+	progress, err := controller.Progress(ctx, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range progress {
+		if p.Wave == 0 && p.State != MachineStale {
+			t.Errorf("Canary %s without heartbeat is %s", p.MachineName, p.State)
+		}
+	}
+
+	for wave := 1; wave <= 2; wave++ {
+		err = controller.Tick(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	r, err = database.GetRollout(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.State != domain.RolloutCompleted {
+		t.Errorf("Rollout of stale machines is %s in wave %d: %s", r.State, r.CurrentWave, r.StateReason)
+	}
+}
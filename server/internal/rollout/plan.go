@@ -0,0 +1,88 @@
+package rollout
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"server/internal/domain"
+	"slices"
+	"strings"
+)
+
+var ErrInvalidRollout = errors.New("Invalid rollout")
+
+// Validate checks wave configuration of the rollout, the last wave is completed to 100 percent.
+func Validate(r *domain.NewRollout) error {
+	if r.PackageName == "" || r.PackageVersion == "" {
+		return fmt.Errorf("%w: package name and version are required", ErrInvalidRollout)
+	}
+	if r.CanaryCount < 0 {
+		return fmt.Errorf("%w: canary count can't be negative", ErrInvalidRollout)
+	}
+	if r.MaxFailureRate < 0 || r.MaxFailureRate > 1 {
+		return fmt.Errorf("%w: max failure rate has to be between 0 and 1", ErrInvalidRollout)
+	}
+	previous := 0
+	for _, p := range r.WavePercentages {
+		if p <= previous || p > 100 {
+			return fmt.Errorf("%w: wave percentages have to be increasing and at most 100", ErrInvalidRollout)
+		}
+		previous = p
+	}
+	if previous != 100 {
+		r.WavePercentages = append(r.WavePercentages, 100)
+	}
+
+	return nil
+}
+
+// WaveCount is number of waves including canary.
+func WaveCount(canaryCount int, wavePercentages []int) int {
+	if canaryCount > 0 {
+		return len(wavePercentages) + 1
+	}
+
+	return len(wavePercentages)
+}
+
+// Plan splits machines into waves. Machines are ordered by hash of the rollout package and machine name,
+// so the canary differs between packages, but is stable for the same rollout.
+func Plan(r domain.NewRollout, machines []domain.Machine) []domain.RolloutMachine {
+	ordered := slices.Clone(machines)
+	key := func(m domain.Machine) string {
+		hash := sha256.Sum256([]byte(r.PackageName + "@" + r.PackageVersion + "/" + m.Name))
+		return hex.EncodeToString(hash[:])
+	}
+	slices.SortFunc(ordered, func(a, b domain.Machine) int {
+		return strings.Compare(key(a), key(b))
+	})
+
+	planned := []domain.RolloutMachine{}
+	add := func(m domain.Machine, wave int) {
+		planned = append(planned, domain.RolloutMachine{
+			MachineID:   m.ID,
+			MachineName: m.Name,
+			Wave:        wave,
+		})
+	}
+
+	next := 0
+	wave := 0
+	if r.CanaryCount > 0 {
+		for ; next < len(ordered) && next < r.CanaryCount; next++ {
+			add(ordered[next], wave)
+		}
+		wave++
+	}
+	for _, p := range r.WavePercentages {
+		upTo := int(math.Ceil(float64(len(ordered)) * float64(p) / 100))
+		for ; next < upTo; next++ {
+			add(ordered[next], wave)
+		}
+		wave++
+	}
+
+	return planned
+}
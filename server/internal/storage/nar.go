@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/nix-community/go-nix/pkg/nar"
+	"github.com/nix-community/go-nix/pkg/narinfo"
+	"github.com/nix-community/go-nix/pkg/nixbase32"
+	"github.com/nix-community/go-nix/pkg/storepath"
+)
+
+// GetNar opens uncompressed NAR of the narinfo.
+func (s *NarInfoStore) GetNar(ctx context.Context, info *narinfo.NarInfo) (io.ReadCloser, error) {
+	return s.GetObject(ctx, narKey(info))
+}
+
+// PutNar stores uncompressed NAR of the narinfo.
+func (s *NarInfoStore) PutNar(ctx context.Context, info *narinfo.NarInfo, nar io.ReadSeeker) error {
+	return s.PutObject(ctx, narKey(info), nar, int64(info.NarSize))
+}
+
+// GetDelta opens cached delta of NAR of the narinfo against NAR of the base narinfo, see handlers.HandleDeltaNar.
+func (s *NarInfoStore) GetDelta(ctx context.Context, baseInfo, info *narinfo.NarInfo) (io.ReadCloser, error) {
+	return s.GetObject(ctx, deltaKey(baseInfo, info))
+}
+
+// PutDelta caches encoded delta of NAR of the narinfo against NAR of the base narinfo.
+func (s *NarInfoStore) PutDelta(ctx context.Context, baseInfo, info *narinfo.NarInfo, delta io.ReadSeeker, size int64) error {
+	return s.PutObject(ctx, deltaKey(baseInfo, info), delta, size)
+}
+
+// deltaKey is made of file hashes of both NARs, so deltas are not reused when either store path
+// is uploaded again with other content.
+func deltaKey(baseInfo, info *narinfo.NarInfo) string {
+	return narFileHash(info) + "-" + narFileHash(baseInfo) + ".delta"
+}
+
+// narKey is key of NAR stored decompressed under its file hash, see handlers.HandlenNar.
+func narKey(info *narinfo.NarInfo) string {
+	return narFileHash(info) + ".nar"
+}
+
+func narFileHash(info *narinfo.NarInfo) string {
+	fileHash, _, _ := strings.Cut(path.Base(info.URL), ".")
+
+	return fileHash
+}
+
+// PathExists checks if absolute path, e.g. /nix/store/<hash>-hello/bin/hello, exists in NAR of its store path.
+// Store path which is not in the cache does not exist.
+func (s *NarInfoStore) PathExists(ctx context.Context, absolutePath string) (bool, error) {
+	relative, ok := strings.CutPrefix(path.Clean(absolutePath), storepath.StoreDir+"/")
+	if !ok {
+		return false, fmt.Errorf("Path %s is not in nix store", absolutePath)
+	}
+	name, inner, _ := strings.Cut(relative, "/")
+	sp, err := storepath.FromString(name)
+	if err != nil {
+		return false, err
+	}
+
+	info, err := s.GetNarInfo(ctx, nixbase32.EncodeToString(sp.Digest))
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	obj, err := s.GetNar(ctx, info)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	defer obj.Close()
+
+	nr, err := nar.NewReader(obj)
+	if err != nil {
+		return false, fmt.Errorf("Failed to read nar of %s: %w", info.StorePath, err)
+	}
+	defer nr.Close()
+
+	wanted := "/" + inner
+	for {
+		header, err := nr.Next()
+		if errors.Is(err, io.EOF) {
+			return false, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("Failed to read nar of %s: %w", info.StorePath, err)
+		}
+		if header.Path == wanted {
+			return true, nil
+		}
+	}
+}
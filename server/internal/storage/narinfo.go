@@ -0,0 +1,52 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"server/internal/config"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/nix-community/go-nix/pkg/narinfo"
+)
+
+var ErrNotFound = errors.New("Not found")
+
+// NarInfoStore reads narinfo files uploaded to the binary cache.
+type NarInfoStore struct {
+	client *minio.Client
+	bucket string
+	// remote holds objects placed on other nodes of the cluster, nil when not in cluster mode
+	remote Remote
+}
+
+func NewNarInfoStore(client *minio.Client, cfg config.MinioCfg) *NarInfoStore {
+	return &NarInfoStore{
+		client: client,
+		bucket: cfg.Bucket,
+	}
+}
+
+// GetNarInfo gets narinfo by hash part of the store path.
+func (s *NarInfoStore) GetNarInfo(ctx context.Context, hash string) (*narinfo.NarInfo, error) {
+	obj, err := s.GetObject(ctx, hash+".narinfo")
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+
+	info, err := narinfo.Parse(obj)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse narinfo %s: %w", hash, err)
+	}
+
+	return info, nil
+}
+
+// PutNarInfo stores narinfo under hash part of the store path.
+func (s *NarInfoStore) PutNarInfo(ctx context.Context, hash string, info *narinfo.NarInfo) error {
+	body := []byte(info.String())
+
+	return s.PutObject(ctx, hash+".narinfo", bytes.NewReader(body), int64(len(body)))
+}
@@ -0,0 +1,127 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"iter"
+	"log/slog"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// streamPartSize limits memory of uploads of unknown size, objects up to 10000 parts can be uploaded.
+const streamPartSize = 16 << 20
+
+// Remote holds objects of the binary cache which are placed on other nodes of the cluster, see cluster.Cluster.
+type Remote interface {
+	// Owns reports if the object with the key is placed on this node.
+	Owns(key string) bool
+	// Get reads the object from other nodes, ErrNotFound when none of them has it.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Put writes the object to other nodes owning the key.
+	Put(ctx context.Context, key string, body io.ReadSeeker, size int64) error
+}
+
+// WithRemote returns store which places objects owned by other nodes on the remote, objects missing in the
+// bucket of this node are read from the remote as well.
+func (s *NarInfoStore) WithRemote(remote Remote) *NarInfoStore {
+	return &NarInfoStore{
+		client: s.client,
+		bucket: s.bucket,
+		remote: remote,
+	}
+}
+
+// GetObject opens object of the binary cache, e.g. <hash>.narinfo or <file hash>.nar.
+func (s *NarInfoStore) GetObject(ctx context.Context, key string) (io.ReadCloser, error) {
+	if s.remote != nil && !s.remote.Owns(key) {
+		return s.remote.Get(ctx, key)
+	}
+
+	obj, err := s.client.GetObject(ctx, s.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get %s: %w", key, err)
+	}
+	_, err = obj.Stat()
+	if err != nil {
+		obj.Close()
+		if minio.ToErrorResponse(err).Code != "NoSuchKey" {
+			return nil, fmt.Errorf("Failed to stat %s: %w", key, err)
+		}
+		if s.remote == nil {
+			return nil, ErrNotFound
+		}
+		// Object is not rebalanced to this node yet
+		return s.remote.Get(ctx, key)
+	}
+
+	return obj, nil
+}
+
+// PutObject stores object of the binary cache on nodes owning it.
+func (s *NarInfoStore) PutObject(ctx context.Context, key string, body io.ReadSeeker, size int64) error {
+	if s.remote != nil && !s.remote.Owns(key) {
+		return s.remote.Put(ctx, key, body, size)
+	}
+
+	err := s.PutBucketObject(ctx, key, body, size)
+	if err != nil {
+		return err
+	}
+	if s.remote == nil {
+		return nil
+	}
+	_, err = body.Seek(0, io.SeekStart)
+	if err == nil {
+		err = s.remote.Put(ctx, key, body, size)
+	}
+	if err != nil {
+		// Object is stored, missing copies are restored by rebalancing
+		slog.WarnContext(ctx, "Failed to replicate object to other nodes", "key", key, "err", err)
+	}
+
+	return nil
+}
+
+// PutBucketObject streams object to the bucket of this node, size is -1 when unknown.
+func (s *NarInfoStore) PutBucketObject(ctx context.Context, key string, body io.Reader, size int64) error {
+	opts := minio.PutObjectOptions{}
+	if size < 0 {
+		// Object of unknown size is uploaded in parts buffered in memory, default parts fit objects of 5 TiB
+		opts.PartSize = streamPartSize
+	}
+	_, err := s.client.PutObject(ctx, s.bucket, key, body, size, opts)
+	if err != nil {
+		return fmt.Errorf("Failed to put %s: %w", key, err)
+	}
+
+	return nil
+}
+
+// DeleteObject removes object from the bucket of this node.
+func (s *NarInfoStore) DeleteObject(ctx context.Context, key string) error {
+	err := s.client.RemoveObject(ctx, s.bucket, key, minio.RemoveObjectOptions{})
+	if err != nil {
+		return fmt.Errorf("Failed to delete %s: %w", key, err)
+	}
+
+	return nil
+}
+
+// ListObjects lists keys of objects in the bucket of this node.
+func (s *NarInfoStore) ListObjects(ctx context.Context) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		for obj := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{}) {
+			if obj.Err != nil {
+				yield("", fmt.Errorf("Failed to list objects: %w", obj.Err))
+				return
+			}
+			if !yield(obj.Key, nil) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package vulns
+
+import (
+	"encoding/json"
+	"fmt"
+	"server/internal/domain"
+	"slices"
+	"time"
+)
+
+// Subset of the OSV schema used for matching, https://ossf.github.io/osv-schema/
+type osvAdvisory struct {
+	ID               string        `json:"id"`
+	Modified         time.Time     `json:"modified"`
+	Summary          string        `json:"summary"`
+	Details          string        `json:"details"`
+	Aliases          []string      `json:"aliases"`
+	Withdrawn        *time.Time    `json:"withdrawn"`
+	Severity         []osvSeverity `json:"severity"`
+	Affected         []osvAffected `json:"affected"`
+	DatabaseSpecific struct {
+		Severity string `json:"severity"`
+	} `json:"database_specific"`
+}
+
+type osvSeverity struct {
+	Type  string `json:"type"`
+	Score string `json:"score"`
+}
+
+type osvAffected struct {
+	Package struct {
+		Ecosystem string `json:"ecosystem"`
+		Name      string `json:"name"`
+	} `json:"package"`
+	Ranges   []osvRange `json:"ranges"`
+	Versions []string   `json:"versions"`
+}
+
+type osvRange struct {
+	Type   string     `json:"type"`
+	Events []osvEvent `json:"events"`
+}
+
+type osvEvent struct {
+	Introduced   string `json:"introduced"`
+	Fixed        string `json:"fixed"`
+	LastAffected string `json:"last_affected"`
+	Limit        string `json:"limit"`
+}
+
+func parseAdvisory(document []byte) (osvAdvisory, error) {
+	var advisory osvAdvisory
+	err := json.Unmarshal(document, &advisory)
+	if err != nil {
+		return osvAdvisory{}, fmt.Errorf("Failed to decode OSV advisory: %w", err)
+	}
+	if advisory.ID == "" {
+		return osvAdvisory{}, fmt.Errorf("OSV advisory has no id")
+	}
+
+	return advisory, nil
+}
+
+func (a osvAdvisory) toDomain(document []byte) domain.Advisory {
+	packages := []string{}
+	if a.Withdrawn == nil {
+		for _, affected := range a.Affected {
+			if affected.Package.Name != "" && !slices.Contains(packages, affected.Package.Name) {
+				packages = append(packages, affected.Package.Name)
+			}
+		}
+	}
+
+	summary := a.Summary
+	if summary == "" {
+		summary = a.Details
+	}
+	severity := a.DatabaseSpecific.Severity
+	if severity == "" && len(a.Severity) > 0 {
+		severity = a.Severity[0].Score
+	}
+
+	return domain.Advisory{
+		ID:       a.ID,
+		Modified: a.Modified,
+		Summary:  summary,
+		Severity: severity,
+		Aliases:  a.Aliases,
+		Packages: packages,
+		Document: document,
+	}
+}
+
+// affects checks if package name and version is affected by the advisory.
+//
+// Package names are matched regardless of ecosystem, versions are compared by nix version ordering.
+func (a osvAdvisory) affects(name string, version string) bool {
+	if a.Withdrawn != nil {
+		return false
+	}
+
+	for _, affected := range a.Affected {
+		if affected.Package.Name != name {
+			continue
+		}
+		if slices.Contains(affected.Versions, version) {
+			return true
+		}
+		for _, r := range affected.Ranges {
+			if r.Type == "GIT" {
+				continue
+			}
+			if r.affects(version) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// affects evaluates range events, https://ossf.github.io/osv-schema/#evaluation
+func (r osvRange) affects(version string) bool {
+	if version == "" {
+		return false
+	}
+
+	affected := false
+	events := slices.Clone(r.Events)
+	slices.SortStableFunc(events, func(a, b osvEvent) int {
+		return CompareVersions(a.version(), b.version())
+	})
+	for _, event := range events {
+		switch {
+		case event.Introduced != "":
+			if event.Introduced == "0" || CompareVersions(version, event.Introduced) >= 0 {
+				affected = true
+			}
+		case event.Fixed != "":
+			if CompareVersions(version, event.Fixed) >= 0 {
+				affected = false
+			}
+		case event.LastAffected != "":
+			if CompareVersions(version, event.LastAffected) > 0 {
+				affected = false
+			}
+		case event.Limit != "":
+			if CompareVersions(version, event.Limit) >= 0 {
+				affected = false
+			}
+		}
+	}
+
+	return affected
+}
+
+func (e osvEvent) version() string {
+	switch {
+	case e.Introduced != "":
+		if e.Introduced == "0" {
+			return ""
+		}
+		return e.Introduced
+	case e.Fixed != "":
+		return e.Fixed
+	case e.LastAffected != "":
+		return e.LastAffected
+	}
+
+	return e.Limit
+}
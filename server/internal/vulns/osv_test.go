@@ -0,0 +1,79 @@
+package vulns
+
+import "testing"
+
+func TestAdvisoryAffects(t *testing.T) {
+	document := []byte(`{
+		"id": "TEST-1",
+		"affected": [
+			{
+				"package": {"name": "openssl"},
+				"ranges": [
+					{"type": "ECOSYSTEM", "events": [{"introduced": "3.0"}, {"fixed": "3.0.7"}]},
+					{"type": "ECOSYSTEM", "events": [{"introduced": "3.1"}, {"last_affected": "3.1.2"}]},
+					{"type": "GIT", "events": [{"introduced": "0"}]}
+				],
+				"versions": ["1.1.1k"]
+			},
+			{
+				"package": {"name": "zlib"},
+				"ranges": [{"type": "ECOSYSTEM", "events": [{"fixed": "1.2.12"}, {"introduced": "0"}]}]
+			},
+			{
+				"package": {"name": "curl"},
+				"ranges": [{"type": "ECOSYSTEM", "events": [{"introduced": "7.0"}, {"limit": "8.0"}]}]
+			}
+		]
+	}`)
+	advisory, err := parseAdvisory(document)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name, version string
+		want          bool
+	}{
+		{"openssl", "2.9", false},
+		{"openssl", "3.0", true},
+		{"openssl", "3.0.6", true},
+		{"openssl", "3.0.7", false},
+		{"openssl", "3.0.10", false},
+		{"openssl", "3.1", true},
+		{"openssl", "3.1.2", true},
+		{"openssl", "3.1.3", false},
+		{"openssl", "3.2", false},
+		{"openssl", "1.1.1k", true},
+		{"openssl", "1.1.1l", false},
+		{"openssl", "", false},
+		{"zlib", "1.2.11", true},
+		{"zlib", "1.2.12", false},
+		{"curl", "7.88.1", true},
+		{"curl", "8.0", false},
+		{"libressl", "3.0.6", false},
+	}
+	for _, test := range tests {
+		got := advisory.affects(test.name, test.version)
+		if got != test.want {
+			t.Errorf("affects(%q, %q) = %v, want %v", test.name, test.version, got, test.want)
+		}
+	}
+}
+
+func TestWithdrawnAdvisoryAffectsNothing(t *testing.T) {
+	advisory, err := parseAdvisory([]byte(`{
+		"id": "TEST-2",
+		"withdrawn": "2025-01-01T00:00:00Z",
+		"affected": [{"package": {"name": "openssl"}, "ranges": [{"type": "ECOSYSTEM", "events": [{"introduced": "0"}]}]}]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if advisory.affects("openssl", "3.0") {
+		t.Error("Withdrawn advisory affects openssl 3.0")
+	}
+	if len(advisory.toDomain(nil).Packages) != 0 {
+		t.Error("Withdrawn advisory lists packages")
+	}
+}
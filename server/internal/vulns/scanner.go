@@ -0,0 +1,241 @@
+package vulns
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"server/internal/closure"
+	"server/internal/db"
+	"server/internal/domain"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Scanner matches advisories against closures of registered packages.
+//
+// Packages are re-evaluated in background whenever new advisories or packages arrive.
+type Scanner struct {
+	db       db.Database
+	narInfos closure.NarInfoGetter
+
+	mu      sync.Mutex
+	pending []domain.PackageRef
+	all     bool
+	notify  chan struct{}
+}
+
+func NewScanner(database db.Database, narInfos closure.NarInfoGetter) *Scanner {
+	return &Scanner{
+		db:       database,
+		narInfos: narInfos,
+		notify:   make(chan struct{}, 1),
+	}
+}
+
+// ScanPackage schedules scan of the package.
+func (s *Scanner) ScanPackage(ref domain.PackageRef) {
+	s.mu.Lock()
+	s.pending = append(s.pending, ref)
+	s.mu.Unlock()
+	s.wake()
+}
+
+// ScanAll schedules scan of all registered packages.
+func (s *Scanner) ScanAll() {
+	s.mu.Lock()
+	s.all = true
+	s.mu.Unlock()
+	s.wake()
+}
+
+func (s *Scanner) wake() {
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Run scans scheduled packages until context is done.
+func (s *Scanner) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.notify:
+		}
+
+		s.mu.Lock()
+		all, pending := s.all, s.pending
+		s.all, s.pending = false, nil
+		s.mu.Unlock()
+
+		if all {
+			err := s.scanAll(ctx)
+			if err != nil {
+				slog.ErrorContext(ctx, "Failed to scan packages for vulnerabilities", "err", err)
+			}
+			continue
+		}
+		for _, ref := range pending {
+			pkg, err := s.db.GetPackage(ctx, ref)
+			if err != nil {
+				slog.ErrorContext(ctx, "Failed to get package for vulnerability scan", "package", ref.Name, "version", ref.Version, "err", err)
+				continue
+			}
+			err = s.scan(ctx, pkg)
+			if err != nil {
+				slog.ErrorContext(ctx, "Failed to scan package for vulnerabilities", "package", ref.Name, "version", ref.Version, "err", err)
+			}
+		}
+	}
+}
+
+// ImportAdvisories stores OSV advisories and schedules rescan of all packages.
+func (s *Scanner) ImportAdvisories(ctx context.Context, documents [][]byte) (int, error) {
+	advisories := []domain.Advisory{}
+	for _, document := range documents {
+		advisory, err := parseAdvisory(document)
+		if err != nil {
+			return 0, err
+		}
+		advisories = append(advisories, advisory.toDomain(document))
+	}
+	if len(advisories) == 0 {
+		return 0, nil
+	}
+
+	err := s.db.PutAdvisories(ctx, advisories)
+	if err != nil {
+		return 0, fmt.Errorf("Failed to store advisories: %w", err)
+	}
+	s.ScanAll()
+
+	return len(advisories), nil
+}
+
+// WatchDir imports OSV JSON files from the directory, checking for new or modified files every interval.
+func (s *Scanner) WatchDir(ctx context.Context, dir string, interval time.Duration) {
+	imported := map[string]time.Time{}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		err := s.importDir(ctx, dir, imported)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to import advisories", "dir", dir, "err", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Scanner) importDir(ctx context.Context, dir string, imported map[string]time.Time) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	documents := [][]byte{}
+	modified := map[string]time.Time{}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if last, ok := imported[entry.Name()]; ok && !info.ModTime().After(last) {
+			continue
+		}
+
+		document, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return err
+		}
+		documents = append(documents, document)
+		modified[entry.Name()] = info.ModTime()
+	}
+
+	count, err := s.ImportAdvisories(ctx, documents)
+	if err != nil {
+		return err
+	}
+	for name, modTime := range modified {
+		imported[name] = modTime
+	}
+	if count > 0 {
+		slog.InfoContext(ctx, "Imported advisories", "dir", dir, "count", count)
+	}
+
+	return nil
+}
+
+func (s *Scanner) scanAll(ctx context.Context) error {
+	packages, err := s.db.ListPackages(ctx, domain.PackageFilter{IncludeYanked: true})
+	if err != nil {
+		return err
+	}
+
+	for _, pkg := range packages {
+		err = s.scan(ctx, pkg)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to scan package for vulnerabilities", "package", pkg.Name, "version", pkg.Version, "err", err)
+		}
+	}
+
+	return nil
+}
+
+func (s *Scanner) scan(ctx context.Context, pkg domain.Package) error {
+	components, err := closure.Walk(ctx, s.narInfos, closure.Roots(pkg))
+	if err != nil {
+		return err
+	}
+
+	names := []string{}
+	for _, c := range components {
+		if !slices.Contains(names, c.Name) {
+			names = append(names, c.Name)
+		}
+	}
+	advisories, err := s.db.ListAdvisories(ctx, names)
+	if err != nil {
+		return err
+	}
+
+	vulnerabilities := []domain.Vulnerability{}
+	for _, advisory := range advisories {
+		osv, err := parseAdvisory(advisory.Document)
+		if err != nil {
+			return err
+		}
+		for _, c := range components {
+			if !osv.affects(c.Name, c.Version) {
+				continue
+			}
+			vulnerabilities = append(vulnerabilities, domain.Vulnerability{
+				AdvisoryID:       advisory.ID,
+				Aliases:          advisory.Aliases,
+				Summary:          advisory.Summary,
+				Severity:         advisory.Severity,
+				StorePath:        c.StorePath,
+				ComponentName:    c.Name,
+				ComponentVersion: c.Version,
+			})
+		}
+	}
+	if len(vulnerabilities) > 0 {
+		slog.WarnContext(ctx, "Package has vulnerabilities", "package", pkg.Name, "version", pkg.Version, "count", len(vulnerabilities))
+	}
+
+	return s.db.SetVulnerabilities(ctx, pkg.ID, vulnerabilities)
+}
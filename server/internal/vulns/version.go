@@ -0,0 +1,69 @@
+package vulns
+
+import (
+	"strconv"
+)
+
+// CompareVersions compares versions the same way as builtins.compareVersions of nix,
+// returns -1 when a is older than b, 0 when equal and 1 when newer.
+func CompareVersions(a, b string) int {
+	for a != "" || b != "" {
+		var c1, c2 string
+		c1, a = nextComponent(a)
+		c2, b = nextComponent(b)
+		if componentLess(c1, c2) {
+			return -1
+		}
+		if componentLess(c2, c1) {
+			return 1
+		}
+	}
+
+	return 0
+}
+
+// nextComponent splits version on '.' and '-' separators and between digits and other characters.
+func nextComponent(s string) (string, string) {
+	for s != "" && (s[0] == '.' || s[0] == '-') {
+		s = s[1:]
+	}
+	if s == "" {
+		return "", ""
+	}
+
+	digits := isDigit(s[0])
+	i := 0
+	for i < len(s) && s[i] != '.' && s[i] != '-' && isDigit(s[i]) == digits {
+		i++
+	}
+
+	return s[:i], s[i:]
+}
+
+func componentLess(c1, c2 string) bool {
+	n1, err1 := strconv.ParseUint(c1, 10, 64)
+	n2, err2 := strconv.ParseUint(c2, 10, 64)
+	c1Num, c2Num := err1 == nil, err2 == nil
+
+	switch {
+	case c1Num && c2Num:
+		return n1 < n2
+	case c1 == "" && c2Num:
+		return true
+	case c1 == "pre" && c2 != "pre":
+		return true
+	case c2 == "pre":
+		return false
+	// Assume that `2.3a' < `2.3.1'.
+	case c2Num:
+		return true
+	case c1Num:
+		return false
+	}
+
+	return c1 < c2
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
@@ -0,0 +1,37 @@
+package vulns
+
+import "testing"
+
+// Cases of builtins.compareVersions from nix tests/functional/lang/eval-okay-versions.nix
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.0", "2.3", -1},
+		{"2.1", "2.3", -1},
+		{"2.3", "2.3", 0},
+		{"2.5", "2.3", 1},
+		{"3.1", "2.3", 1},
+		{"2.3.1", "2.3", 1},
+		{"2.3.1", "2.3a", 1},
+		{"2.3pre1", "2.3", -1},
+		{"2.3pre3", "2.3pre12", -1},
+		{"2.3a", "2.3c", -1},
+		{"2.3pre1", "2.3c", -1},
+		{"2.3pre1", "2.3q", -1},
+		{"2.11", "2.9", 1},
+		{"1.0-rc1", "1.0", 1},
+		{"", "1.0", -1},
+	}
+	for _, test := range tests {
+		got := CompareVersions(test.a, test.b)
+		if got != test.want {
+			t.Errorf("CompareVersions(%q, %q) = %d, want %d", test.a, test.b, got, test.want)
+		}
+		got = CompareVersions(test.b, test.a)
+		if got != -test.want {
+			t.Errorf("CompareVersions(%q, %q) = %d, want %d", test.b, test.a, got, -test.want)
+		}
+	}
+}
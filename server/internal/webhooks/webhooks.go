@@ -0,0 +1,319 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"path"
+	"server/internal/config"
+	"server/internal/db"
+	"server/internal/domain"
+	"server/internal/outbox"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// TestKind is kind of the change posted by Test.
+const TestKind domain.ChangeKind = "webhook.test"
+
+// MaxAttempts to deliver a change, the change is skipped when all of them failed.
+const MaxAttempts = 10
+
+// initialBackoff is how long the second attempt waits, each next attempt waits twice as long up to maxBackoff.
+const initialBackoff = time.Second
+
+const maxBackoff = 5 * time.Minute
+
+const requestTimeout = 10 * time.Second
+
+// readRetryInterval is how long reading of the outbox waits after it failed.
+const readRetryInterval = time.Minute
+
+// Headers of the posted request.
+const (
+	// EventHeader is kind of the change
+	EventHeader = "X-Meshix-Event"
+	// DeliveryHeader is id of the change in the outbox, receivers use it to drop duplicates
+	DeliveryHeader = "X-Meshix-Delivery"
+	// SignatureHeader is sha256= followed by hex of HMAC-SHA256 of the body keyed by secret of the webhook
+	SignatureHeader = "X-Meshix-Signature"
+)
+
+var ErrUnknownWebhook = errors.New("Webhook is not configured")
+
+// Payload is JSON body posted to webhooks.
+type Payload struct {
+	ID      int64             `json:"id"`
+	Kind    domain.ChangeKind `json:"kind"`
+	Subject string            `json:"subject"`
+	// Data is payload of the change, e.g. package for package.pushed
+	Data      json.RawMessage `json:"data"`
+	CreatedAt time.Time       `json:"createdAt"`
+}
+
+// Dispatcher posts changes of the outbox to webhooks.
+//
+// Each webhook reads the outbox from its own cursor which is stored after each change, so changes are delivered
+// in order and at least once across restarts. Webhook without cursor starts with the latest change. Failed
+// deliveries are retried with exponential backoff and the change is skipped after MaxAttempts. Every attempt
+// is recorded in the delivery log.
+type Dispatcher struct {
+	db      db.Database
+	hooks   []config.WebhookCfg
+	client  *http.Client
+	backoff time.Duration
+}
+
+func NewDispatcher(database db.Database, hooks []config.WebhookCfg) *Dispatcher {
+	return &Dispatcher{
+		db:    database,
+		hooks: hooks,
+		client: &http.Client{
+			Timeout: requestTimeout,
+		},
+		backoff: initialBackoff,
+	}
+}
+
+// Run delivers changes to all webhooks until the context is cancelled.
+func (d *Dispatcher) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, hook := range d.hooks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.run(ctx, hook)
+		}()
+	}
+	wg.Wait()
+}
+
+// Test posts test change to the webhook once and records the delivery.
+func (d *Dispatcher) Test(ctx context.Context, name string) (domain.WebhookDelivery, error) {
+	for _, hook := range d.hooks {
+		if hook.Name != name {
+			continue
+		}
+		delivery := d.post(ctx, hook, domain.Change{
+			Kind:      TestKind,
+			Subject:   hook.Name,
+			Data:      json.RawMessage("{}"),
+			CreatedAt: time.Now(),
+		}, 1)
+		err := d.db.PutWebhookDelivery(ctx, delivery)
+		if err != nil {
+			return delivery, fmt.Errorf("Failed to record delivery: %w", err)
+		}
+
+		return delivery, nil
+	}
+
+	return domain.WebhookDelivery{}, fmt.Errorf("%w: %s", ErrUnknownWebhook, name)
+}
+
+func (d *Dispatcher) run(ctx context.Context, hook config.WebhookCfg) {
+	for {
+		err := d.deliverChanges(ctx, hook)
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			continue
+		}
+		slog.ErrorContext(ctx, "Failed to deliver changes to webhook", "webhook", hook.Name, "err", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(readRetryInterval):
+		}
+	}
+}
+
+// deliverChanges delivers changes following cursor of the webhook until reading the outbox fails.
+func (d *Dispatcher) deliverChanges(ctx context.Context, hook config.WebhookCfg) error {
+	cursor, err := d.cursor(ctx, hook)
+	if err != nil {
+		return err
+	}
+
+	err = outbox.Read(ctx, d.db, cursor, nil, func(c domain.Change) error {
+		if matches(hook.Events, c.Kind) {
+			err := d.deliver(ctx, hook, c)
+			if err != nil {
+				return err
+			}
+		}
+
+		return d.db.PutWebhookCursor(ctx, hook.Name, c.ID)
+	})
+	if errors.Is(err, outbox.ErrPruned) {
+		oldest, err := d.db.GetOldestChangeID(ctx)
+		if err != nil {
+			return err
+		}
+		slog.WarnContext(ctx, "Changes were pruned before they were delivered to webhook, skipping them",
+			"webhook", hook.Name, "cursor", cursor, "oldestChangeId", oldest)
+
+		return d.db.PutWebhookCursor(ctx, hook.Name, oldest-1)
+	}
+
+	return err
+}
+
+func (d *Dispatcher) cursor(ctx context.Context, hook config.WebhookCfg) (int64, error) {
+	cursor, err := d.db.GetWebhookCursor(ctx, hook.Name)
+	if !errors.Is(err, db.ErrNotFound) {
+		return cursor, err
+	}
+
+	// New webhook isn't flooded with history of the outbox
+	cursor, err = d.db.GetLatestChangeID(ctx)
+	if err != nil {
+		return 0, err
+	}
+	err = d.db.PutWebhookCursor(ctx, hook.Name, cursor)
+	if err != nil {
+		return 0, err
+	}
+	slog.InfoContext(ctx, "Webhook starts with the latest change", "webhook", hook.Name, "cursor", cursor)
+
+	return cursor, nil
+}
+
+// deliver posts the change until the webhook accepts it or MaxAttempts failed, it fails only when the context
+// is cancelled.
+func (d *Dispatcher) deliver(ctx context.Context, hook config.WebhookCfg, change domain.Change) error {
+	backoff := d.backoff
+	for attempt := 1; ; attempt++ {
+		delivery := d.post(ctx, hook, change, attempt)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		err := d.db.PutWebhookDelivery(ctx, delivery)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to record webhook delivery", "webhook", hook.Name, "changeId", change.ID, "err", err)
+		}
+		if delivery.Succeeded() {
+			return nil
+		}
+		if attempt == MaxAttempts {
+			slog.ErrorContext(ctx, "Giving up delivery of change to webhook", "webhook", hook.Name, "changeId", change.ID,
+				"kind", change.Kind, "attempts", attempt, "err", delivery.Error)
+			return nil
+		}
+		slog.WarnContext(ctx, "Failed to deliver change to webhook, retrying", "webhook", hook.Name, "changeId", change.ID,
+			"attempt", attempt, "backoff", backoff, "err", delivery.Error)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// post makes one attempt to deliver the change.
+func (d *Dispatcher) post(ctx context.Context, hook config.WebhookCfg, change domain.Change, attempt int) domain.WebhookDelivery {
+	delivery := domain.WebhookDelivery{
+		Webhook:   hook.Name,
+		ChangeID:  change.ID,
+		Kind:      change.Kind,
+		Subject:   change.Subject,
+		Attempt:   attempt,
+		CreatedAt: time.Now(),
+	}
+	body, err := json.Marshal(Payload{
+		ID:        change.ID,
+		Kind:      change.Kind,
+		Subject:   change.Subject,
+		Data:      change.Data,
+		CreatedAt: change.CreatedAt,
+	})
+	if err != nil {
+		delivery.Error = fmt.Sprintf("Failed to encode payload: %s", err)
+		return delivery
+	}
+
+	delivery.StatusCode, err = d.send(ctx, hook, change, body)
+	delivery.Duration = time.Since(delivery.CreatedAt)
+	if err != nil {
+		delivery.Error = err.Error()
+	}
+
+	return delivery
+}
+
+func (d *Dispatcher) send(ctx context.Context, hook config.WebhookCfg, change domain.Change, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.Url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "meshix")
+	req.Header.Set(EventHeader, string(change.Kind))
+	req.Header.Set(DeliveryHeader, strconv.FormatInt(change.ID, 10))
+	req.Header.Set(SignatureHeader, Sign(hook.Secret, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	// Drained so the connection is reused
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("Webhook responded with %s", resp.Status)
+	}
+
+	return resp.StatusCode, nil
+}
+
+// Sign returns value of SignatureHeader for the body.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// matches reports if the change kind matches any of the events, events of config are never empty.
+func matches(events []string, kind domain.ChangeKind) bool {
+	for _, e := range events {
+		ok, _ := path.Match(e, string(kind))
+		if ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RunRetention removes deliveries older than retention from the delivery log every interval.
+func RunRetention(ctx context.Context, database db.Database, retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		deleted, err := database.DeleteWebhookDeliveriesBefore(ctx, time.Now().Add(-retention))
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to prune webhook deliveries", "err", err)
+		} else if deleted > 0 {
+			slog.InfoContext(ctx, "Webhook deliveries pruned", "deleted", deleted, "retention", retention)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package webhooks
+
+import (
+	"server/internal/config"
+	"server/internal/domain"
+	"testing"
+)
+
+func TestMatches(t *testing.T) {
+	tests := []struct {
+		events   []string
+		kind     domain.ChangeKind
+		expected bool
+	}{
+		{config.DefaultWebhookEvents, domain.ChangePackagePushed, true},
+		{config.DefaultWebhookEvents, domain.ChangeAssignmentPut, true},
+		{config.DefaultWebhookEvents, domain.ChangeRolloutUpdated, true},
+		{config.DefaultWebhookEvents, domain.ChangeGcRun, true},
+		{config.DefaultWebhookEvents, domain.ChangeMachineStatus, false},
+		{config.DefaultWebhookEvents, domain.ChangeMachineDrift, false},
+		{config.DefaultWebhookEvents, domain.ChangeBootstrapTokenCreated, false},
+		{[]string{"machine.*"}, domain.ChangeMachineStatus, true},
+		{[]string{"package.*"}, domain.ChangeAssignmentPut, false},
+		{nil, domain.ChangePackagePushed, false},
+	}
+	for _, tt := range tests {
+		if got := matches(tt.events, tt.kind); got != tt.expected {
+			t.Errorf("matches(%v, %s) = %v, expected %v", tt.events, tt.kind, got, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE packages ADD COLUMN yanked_at DATETIME;
+ALTER TABLE packages ADD COLUMN yank_reason TEXT;
+
+CREATE TABLE gc_roots (
+    store_path TEXT PRIMARY KEY
+);
+
+INSERT INTO gc_roots (store_path)
+SELECT DISTINCT nix_store_hash FROM packages;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE gc_roots;
+
+ALTER TABLE packages DROP COLUMN yank_reason;
+ALTER TABLE packages DROP COLUMN yanked_at;
+-- +goose StatementEnd
@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE package_outputs (
+    package_id integer NOT NULL REFERENCES packages(id),
+
+    name TEXT NOT NULL,
+    store_path TEXT NOT NULL,
+    install BOOLEAN NOT NULL,
+
+    PRIMARY KEY (package_id, name)
+);
+
+INSERT INTO package_outputs (package_id, name, store_path, install)
+SELECT id, 'out', nix_store_hash, TRUE FROM packages;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE package_outputs;
+-- +goose StatementEnd
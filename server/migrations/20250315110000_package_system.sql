@@ -0,0 +1,14 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Packages pushed before systems were tracked have unknown, empty system.
+ALTER TABLE packages ADD COLUMN system TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX packages_name_system_idx ON packages (name, system);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX packages_name_system_idx;
+
+ALTER TABLE packages DROP COLUMN system;
+-- +goose StatementEnd
@@ -0,0 +1,23 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE packages ADD COLUMN description TEXT NOT NULL DEFAULT '';
+-- JSON array of license identifiers
+ALTER TABLE packages ADD COLUMN licenses TEXT NOT NULL DEFAULT '[]';
+ALTER TABLE packages ADD COLUMN homepage TEXT NOT NULL DEFAULT '';
+-- JSON array of nix systems
+ALTER TABLE packages ADD COLUMN platforms TEXT NOT NULL DEFAULT '[]';
+ALTER TABLE packages ADD COLUMN unfree BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE packages ADD COLUMN insecure BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE packages ADD COLUMN position TEXT NOT NULL DEFAULT '';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE packages DROP COLUMN position;
+ALTER TABLE packages DROP COLUMN insecure;
+ALTER TABLE packages DROP COLUMN unfree;
+ALTER TABLE packages DROP COLUMN platforms;
+ALTER TABLE packages DROP COLUMN homepage;
+ALTER TABLE packages DROP COLUMN licenses;
+ALTER TABLE packages DROP COLUMN description;
+-- +goose StatementEnd
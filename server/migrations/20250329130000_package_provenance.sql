@@ -0,0 +1,21 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE package_provenances (
+    package_id integer PRIMARY KEY REFERENCES packages(id),
+
+    flake_url TEXT NOT NULL,
+    flake_revision TEXT NOT NULL,
+    flake_nar_hash TEXT NOT NULL,
+    drv_path TEXT NOT NULL,
+    builder_host TEXT NOT NULL,
+    client_version TEXT NOT NULL,
+    -- JSON object of ci run identifiers
+    ci TEXT NOT NULL,
+    signature TEXT NOT NULL
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE package_provenances;
+-- +goose StatementEnd
@@ -0,0 +1,42 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE advisories (
+    id TEXT PRIMARY KEY,
+
+    modified DATETIME NOT NULL,
+    summary TEXT NOT NULL,
+    severity TEXT NOT NULL,
+    -- JSON array of advisory aliases, e.g. CVE ids
+    aliases TEXT NOT NULL,
+    -- Original OSV JSON document
+    document TEXT NOT NULL
+);
+
+CREATE TABLE advisory_packages (
+    advisory_id TEXT NOT NULL REFERENCES advisories(id),
+    package_name TEXT NOT NULL,
+
+    PRIMARY KEY (advisory_id, package_name)
+);
+
+CREATE INDEX advisory_packages_package_name_idx ON advisory_packages (package_name);
+
+CREATE TABLE vulnerabilities (
+    package_id integer NOT NULL REFERENCES packages(id),
+    advisory_id TEXT NOT NULL REFERENCES advisories(id),
+    store_path TEXT NOT NULL,
+
+    component_name TEXT NOT NULL,
+    component_version TEXT NOT NULL,
+
+    PRIMARY KEY (package_id, advisory_id, store_path)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE vulnerabilities;
+DROP INDEX advisory_packages_package_name_idx;
+DROP TABLE advisory_packages;
+DROP TABLE advisories;
+-- +goose StatementEnd
@@ -0,0 +1,35 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE machines (
+    id integer PRIMARY KEY,
+
+    name TEXT NOT NULL UNIQUE,
+    system TEXT NOT NULL,
+    -- Base64 encoded ed25519 public key machine signs its requests with
+    public_key TEXT NOT NULL,
+    -- JSON object of labels
+    labels TEXT NOT NULL,
+    -- JSON array of group names
+    group_names TEXT NOT NULL,
+    enrolled_at DATETIME NOT NULL
+);
+
+CREATE TABLE bootstrap_tokens (
+    -- Hex encoded sha256 of the token, token itself is never stored
+    token_hash TEXT PRIMARY KEY,
+
+    created_by TEXT NOT NULL,
+    -- JSON array of group names and JSON object of labels given to the enrolled machine
+    group_names TEXT NOT NULL,
+    labels TEXT NOT NULL,
+    expires_at DATETIME NOT NULL,
+    used_at DATETIME,
+    machine_id integer REFERENCES machines(id)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE bootstrap_tokens;
+DROP TABLE machines;
+-- +goose StatementEnd
@@ -0,0 +1,36 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE assignments (
+    id integer PRIMARY KEY,
+
+    -- Exactly one of machine_name and machine_group is set
+    machine_name TEXT NOT NULL,
+    machine_group TEXT NOT NULL,
+    package_name TEXT NOT NULL,
+    -- Empty version follows latest non yanked version of the package
+    package_version TEXT NOT NULL,
+    created_at DATETIME NOT NULL,
+
+    UNIQUE (machine_name, machine_group, package_name)
+);
+
+CREATE TABLE activations (
+    id integer PRIMARY KEY,
+
+    machine_id integer NOT NULL REFERENCES machines(id),
+    -- JSON array of store paths installed into the machine profile
+    store_paths TEXT NOT NULL,
+    success BOOLEAN NOT NULL,
+    error TEXT NOT NULL,
+    reported_at DATETIME NOT NULL
+);
+
+CREATE INDEX activations_machine_id_idx ON activations (machine_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX activations_machine_id_idx;
+DROP TABLE activations;
+DROP TABLE assignments;
+-- +goose StatementEnd
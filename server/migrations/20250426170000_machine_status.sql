@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE machine_statuses (
+    machine_id integer PRIMARY KEY REFERENCES machines(id),
+
+    profile_generation integer NOT NULL,
+    -- JSON array of store paths installed in the machine profile
+    store_paths TEXT NOT NULL,
+    nix_version TEXT NOT NULL,
+    free_disk_bytes integer NOT NULL,
+    last_error TEXT NOT NULL,
+    reported_at DATETIME NOT NULL
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE machine_statuses;
+-- +goose StatementEnd
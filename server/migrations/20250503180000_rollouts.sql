@@ -0,0 +1,41 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE rollouts (
+    id integer PRIMARY KEY,
+
+    package_name TEXT NOT NULL,
+    package_version TEXT NOT NULL,
+    -- Machines of the rollout are selected by group and JSON object of labels
+    selector_group TEXT NOT NULL,
+    selector_labels TEXT NOT NULL,
+    canary_count integer NOT NULL,
+    -- JSON array of cumulative percentages of machines updated by each wave after canary
+    wave_percentages TEXT NOT NULL,
+    max_failure_rate REAL NOT NULL,
+    soak_seconds integer NOT NULL,
+
+    state TEXT NOT NULL,
+    state_reason TEXT NOT NULL,
+    current_wave integer NOT NULL,
+    wave_started_at DATETIME NOT NULL,
+    created_by TEXT NOT NULL,
+    created_at DATETIME NOT NULL
+);
+
+CREATE TABLE rollout_machines (
+    rollout_id integer NOT NULL REFERENCES rollouts(id),
+    machine_id integer NOT NULL REFERENCES machines(id),
+    wave integer NOT NULL,
+
+    PRIMARY KEY (rollout_id, machine_id)
+);
+
+CREATE INDEX rollout_machines_machine_id_idx ON rollout_machines (machine_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX rollout_machines_machine_id_idx;
+DROP TABLE rollout_machines;
+DROP TABLE rollouts;
+-- +goose StatementEnd
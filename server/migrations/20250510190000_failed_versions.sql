@@ -0,0 +1,25 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE activations ADD COLUMN rolled_back BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE activations ADD COLUMN logs TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE failed_versions (
+    package_name TEXT NOT NULL,
+    package_version TEXT NOT NULL,
+    group_name TEXT NOT NULL,
+    -- Machine which failed the health check first
+    machine_id integer NOT NULL REFERENCES machines(id),
+    reason TEXT NOT NULL,
+    failed_at DATETIME NOT NULL,
+
+    PRIMARY KEY (package_name, package_version, group_name)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE failed_versions;
+
+ALTER TABLE activations DROP COLUMN logs;
+ALTER TABLE activations DROP COLUMN rolled_back;
+-- +goose StatementEnd
@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE packages ADD COLUMN kind TEXT NOT NULL DEFAULT 'app';
+
+-- Mode of switch-to-configuration used to activate system packages
+ALTER TABLE assignments ADD COLUMN activation_mode TEXT NOT NULL DEFAULT 'switch';
+
+-- Store paths of /run/current-system and /run/booted-system on NixOS machines
+ALTER TABLE machine_statuses ADD COLUMN running_system TEXT NOT NULL DEFAULT '';
+ALTER TABLE machine_statuses ADD COLUMN booted_system TEXT NOT NULL DEFAULT '';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE machine_statuses DROP COLUMN booted_system;
+ALTER TABLE machine_statuses DROP COLUMN running_system;
+ALTER TABLE assignments DROP COLUMN activation_mode;
+ALTER TABLE packages DROP COLUMN kind;
+-- +goose StatementEnd
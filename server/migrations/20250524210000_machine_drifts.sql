@@ -0,0 +1,22 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE machine_drifts (
+    machine_id integer PRIMARY KEY REFERENCES machines(id),
+
+    -- JSON arrays of store paths desired but not installed and installed but not desired
+    missing TEXT NOT NULL,
+    unexpected TEXT NOT NULL,
+    desired_system TEXT NOT NULL,
+    actual_system TEXT NOT NULL,
+    -- Desired state of the machine can't be resolved
+    error TEXT NOT NULL,
+    -- When the machine drifted, NULL when it is in sync
+    since DATETIME,
+    checked_at DATETIME NOT NULL
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE machine_drifts;
+-- +goose StatementEnd
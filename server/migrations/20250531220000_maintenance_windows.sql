@@ -0,0 +1,30 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE maintenance_windows (
+    id integer PRIMARY KEY,
+
+    group_name TEXT NOT NULL,
+    -- JSON array of weekdays the window starts on, 0 is Sunday, empty is every day
+    days TEXT NOT NULL,
+    -- Minutes from midnight in the time zone
+    start_minute integer NOT NULL,
+    end_minute integer NOT NULL,
+    timezone TEXT NOT NULL,
+    created_at DATETIME NOT NULL
+);
+
+CREATE INDEX maintenance_windows_group_name_idx ON maintenance_windows (group_name);
+
+-- Emergency deployments ignore maintenance windows
+ALTER TABLE assignments ADD COLUMN emergency BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE rollouts ADD COLUMN emergency BOOLEAN NOT NULL DEFAULT FALSE;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE rollouts DROP COLUMN emergency;
+ALTER TABLE assignments DROP COLUMN emergency;
+
+DROP INDEX maintenance_windows_group_name_idx;
+DROP TABLE maintenance_windows;
+-- +goose StatementEnd
@@ -0,0 +1,25 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE secrets (
+    machine_id integer NOT NULL REFERENCES machines(id),
+    name TEXT NOT NULL,
+
+    -- age file encrypted to the identity key of the machine, plaintext never reaches the hub
+    ciphertext BLOB NOT NULL,
+    -- SHA-256 of the ciphertext, agents rewrite the secret when it changes
+    hash TEXT NOT NULL,
+    -- Ownership and permissions of the file materialised by agent
+    file_owner TEXT NOT NULL,
+    file_group TEXT NOT NULL,
+    file_mode integer NOT NULL,
+    updated_by TEXT NOT NULL,
+    updated_at DATETIME NOT NULL,
+
+    PRIMARY KEY (machine_id, name)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE secrets;
+-- +goose StatementEnd
@@ -0,0 +1,14 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Prestaged rollouts start in staging state, machines download the closure before the rollout is activated
+ALTER TABLE rollouts ADD COLUMN prestage BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- JSON array of store paths realised ahead of activation
+ALTER TABLE machine_statuses ADD COLUMN staged_store_paths TEXT NOT NULL DEFAULT '[]';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE machine_statuses DROP COLUMN staged_store_paths;
+ALTER TABLE rollouts DROP COLUMN prestage;
+-- +goose StatementEnd
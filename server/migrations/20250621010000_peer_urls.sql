@@ -0,0 +1,10 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Url of the agent serving its store to peers, empty when sharing is disabled
+ALTER TABLE machine_statuses ADD COLUMN peer_url TEXT NOT NULL DEFAULT '';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE machine_statuses DROP COLUMN peer_url;
+-- +goose StatementEnd
@@ -0,0 +1,31 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Change feed followed by replicas, id is the cursor of replicas
+CREATE TABLE replication_changes (
+    id integer PRIMARY KEY AUTOINCREMENT,
+
+    -- narinfo, package.pushed, package.yanked or package.deleted
+    kind TEXT NOT NULL,
+    -- Hash of the narinfo or name@version of the package
+    subject TEXT NOT NULL,
+    -- JSON payload of the change
+    data TEXT NOT NULL,
+    created_at DATETIME NOT NULL
+);
+
+-- Position of the replica in change feed of the primary
+CREATE TABLE replication_cursors (
+    primary_url TEXT PRIMARY KEY,
+
+    last_change_id integer NOT NULL,
+    -- Promoted replica stops following the primary and accepts writes
+    promoted_at DATETIME,
+    updated_at DATETIME NOT NULL
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE replication_cursors;
+DROP TABLE replication_changes;
+-- +goose StatementEnd
@@ -0,0 +1,18 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Membership of the cluster the objects of the node were last rebalanced to
+CREATE TABLE cluster_memberships (
+    -- Url of the node
+    node TEXT PRIMARY KEY,
+
+    -- JSON array of node urls
+    nodes TEXT NOT NULL,
+    replication_factor integer NOT NULL,
+    updated_at DATETIME NOT NULL
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE cluster_memberships;
+-- +goose StatementEnd
@@ -0,0 +1,41 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Ordered record of changes appended in the transaction of each change, id is the cursor of consumers
+CREATE TABLE outbox (
+    id integer PRIMARY KEY AUTOINCREMENT,
+
+    -- Kind of the change, e.g. package.pushed
+    kind TEXT NOT NULL,
+    -- What the change is about, e.g. name of the package
+    subject TEXT NOT NULL,
+    -- JSON payload of the change
+    data TEXT NOT NULL,
+    created_at DATETIME NOT NULL
+);
+
+CREATE INDEX outbox_created_at ON outbox (created_at);
+
+-- Ids of the replication feed are kept, so cursors of replicas stay valid
+INSERT INTO outbox (id, kind, subject, data, created_at)
+SELECT id, kind, subject, data, created_at FROM replication_changes;
+
+DROP TABLE replication_changes;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+CREATE TABLE replication_changes (
+    id integer PRIMARY KEY AUTOINCREMENT,
+
+    kind TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    data TEXT NOT NULL,
+    created_at DATETIME NOT NULL
+);
+
+INSERT INTO replication_changes (id, kind, subject, data, created_at)
+SELECT id, kind, subject, data, created_at FROM outbox
+ WHERE kind IN ('narinfo', 'package.pushed', 'package.yanked', 'package.deleted', 'assignment.put', 'assignment.deleted');
+
+DROP TABLE outbox;
+-- +goose StatementEnd
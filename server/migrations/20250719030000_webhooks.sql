@@ -0,0 +1,38 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Position of the webhook in the outbox
+CREATE TABLE webhook_cursors (
+    -- Name of the webhook in config
+    webhook TEXT PRIMARY KEY,
+
+    last_change_id integer NOT NULL,
+    updated_at DATETIME NOT NULL
+);
+
+-- Attempts to deliver changes to webhooks
+CREATE TABLE webhook_deliveries (
+    id integer PRIMARY KEY AUTOINCREMENT,
+
+    webhook TEXT NOT NULL,
+    -- Id of the delivered change in the outbox, 0 for test deliveries
+    change_id integer NOT NULL,
+    kind TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    attempt integer NOT NULL,
+    -- Status code of the response, 0 when no response was received
+    status_code integer NOT NULL,
+    -- Empty when the delivery succeeded
+    error TEXT NOT NULL,
+    duration_ms integer NOT NULL,
+    created_at DATETIME NOT NULL
+);
+
+CREATE INDEX webhook_deliveries_webhook ON webhook_deliveries (webhook, id);
+CREATE INDEX webhook_deliveries_created_at ON webhook_deliveries (created_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE webhook_deliveries;
+DROP TABLE webhook_cursors;
+-- +goose StatementEnd
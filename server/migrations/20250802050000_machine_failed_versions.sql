@@ -0,0 +1,37 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Failures on machines without groups are kept per machine with empty group_name
+CREATE TABLE failed_versions_new (
+    package_name TEXT NOT NULL,
+    package_version TEXT NOT NULL,
+    group_name TEXT NOT NULL,
+    -- Machine which failed the health check first
+    machine_id integer NOT NULL REFERENCES machines(id),
+    reason TEXT NOT NULL,
+    failed_at DATETIME NOT NULL
+);
+INSERT INTO failed_versions_new SELECT package_name, package_version, group_name, machine_id, reason, failed_at FROM failed_versions;
+DROP TABLE failed_versions;
+ALTER TABLE failed_versions_new RENAME TO failed_versions;
+
+CREATE UNIQUE INDEX failed_versions_group_idx ON failed_versions (package_name, package_version, group_name) WHERE group_name <> '';
+CREATE UNIQUE INDEX failed_versions_machine_idx ON failed_versions (package_name, package_version, machine_id) WHERE group_name = '';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+CREATE TABLE failed_versions_old (
+    package_name TEXT NOT NULL,
+    package_version TEXT NOT NULL,
+    group_name TEXT NOT NULL,
+    -- Machine which failed the health check first
+    machine_id integer NOT NULL REFERENCES machines(id),
+    reason TEXT NOT NULL,
+    failed_at DATETIME NOT NULL,
+
+    PRIMARY KEY (package_name, package_version, group_name)
+);
+INSERT INTO failed_versions_old SELECT package_name, package_version, group_name, machine_id, reason, failed_at FROM failed_versions WHERE group_name <> '';
+DROP TABLE failed_versions;
+ALTER TABLE failed_versions_old RENAME TO failed_versions;
+-- +goose StatementEnd
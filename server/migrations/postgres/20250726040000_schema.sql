@@ -0,0 +1,358 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Schema of the PostgreSQL backend, it matches the sqlite schema after all its migrations.
+-- JSON columns are kept as TEXT like in sqlite, so documents are stored verbatim.
+CREATE TABLE packages (
+    id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+
+    name TEXT NOT NULL,
+    version TEXT NOT NULL,
+    nix_store_hash TEXT NOT NULL,
+    nix_main_bin TEXT NOT NULL,
+    yanked_at TIMESTAMPTZ,
+    yank_reason TEXT,
+    -- Packages pushed before systems were tracked have unknown, empty system.
+    system TEXT NOT NULL DEFAULT '',
+    description TEXT NOT NULL DEFAULT '',
+    -- JSON array of license identifiers
+    licenses TEXT NOT NULL DEFAULT '[]',
+    homepage TEXT NOT NULL DEFAULT '',
+    -- JSON array of nix systems
+    platforms TEXT NOT NULL DEFAULT '[]',
+    unfree BOOLEAN NOT NULL DEFAULT FALSE,
+    insecure BOOLEAN NOT NULL DEFAULT FALSE,
+    position TEXT NOT NULL DEFAULT '',
+    kind TEXT NOT NULL DEFAULT 'app'
+);
+
+CREATE INDEX packages_name_system_idx ON packages (name, system);
+
+CREATE TABLE gc_roots (
+    store_path TEXT PRIMARY KEY
+);
+
+CREATE TABLE package_outputs (
+    package_id BIGINT NOT NULL REFERENCES packages(id),
+
+    name TEXT NOT NULL,
+    store_path TEXT NOT NULL,
+    install BOOLEAN NOT NULL,
+
+    PRIMARY KEY (package_id, name)
+);
+
+CREATE TABLE package_provenances (
+    package_id BIGINT PRIMARY KEY REFERENCES packages(id),
+
+    flake_url TEXT NOT NULL,
+    flake_revision TEXT NOT NULL,
+    flake_nar_hash TEXT NOT NULL,
+    drv_path TEXT NOT NULL,
+    builder_host TEXT NOT NULL,
+    client_version TEXT NOT NULL,
+    -- JSON object of ci run identifiers
+    ci TEXT NOT NULL,
+    signature TEXT NOT NULL
+);
+
+CREATE TABLE advisories (
+    id TEXT PRIMARY KEY,
+
+    modified TIMESTAMPTZ NOT NULL,
+    summary TEXT NOT NULL,
+    severity TEXT NOT NULL,
+    -- JSON array of advisory aliases, e.g. CVE ids
+    aliases TEXT NOT NULL,
+    -- Original OSV JSON document
+    document TEXT NOT NULL
+);
+
+CREATE TABLE advisory_packages (
+    advisory_id TEXT NOT NULL REFERENCES advisories(id),
+    package_name TEXT NOT NULL,
+
+    PRIMARY KEY (advisory_id, package_name)
+);
+
+CREATE INDEX advisory_packages_package_name_idx ON advisory_packages (package_name);
+
+CREATE TABLE vulnerabilities (
+    package_id BIGINT NOT NULL REFERENCES packages(id),
+    advisory_id TEXT NOT NULL REFERENCES advisories(id),
+    store_path TEXT NOT NULL,
+
+    component_name TEXT NOT NULL,
+    component_version TEXT NOT NULL,
+
+    PRIMARY KEY (package_id, advisory_id, store_path)
+);
+
+CREATE TABLE machines (
+    id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+
+    name TEXT NOT NULL UNIQUE,
+    system TEXT NOT NULL,
+    -- Base64 encoded ed25519 public key machine signs its requests with
+    public_key TEXT NOT NULL,
+    -- JSON object of labels
+    labels TEXT NOT NULL,
+    -- JSON array of group names
+    group_names TEXT NOT NULL,
+    enrolled_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE bootstrap_tokens (
+    -- Hex encoded sha256 of the token, token itself is never stored
+    token_hash TEXT PRIMARY KEY,
+
+    created_by TEXT NOT NULL,
+    -- JSON array of group names and JSON object of labels given to the enrolled machine
+    group_names TEXT NOT NULL,
+    labels TEXT NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at TIMESTAMPTZ,
+    machine_id BIGINT REFERENCES machines(id)
+);
+
+CREATE TABLE assignments (
+    id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+
+    -- Exactly one of machine_name and machine_group is set
+    machine_name TEXT NOT NULL,
+    machine_group TEXT NOT NULL,
+    package_name TEXT NOT NULL,
+    -- Empty version follows latest non yanked version of the package
+    package_version TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    -- Mode of switch-to-configuration used to activate system packages
+    activation_mode TEXT NOT NULL DEFAULT 'switch',
+    -- Emergency deployments ignore maintenance windows
+    emergency BOOLEAN NOT NULL DEFAULT FALSE,
+
+    UNIQUE (machine_name, machine_group, package_name)
+);
+
+CREATE TABLE activations (
+    id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+
+    machine_id BIGINT NOT NULL REFERENCES machines(id),
+    -- JSON array of store paths installed into the machine profile
+    store_paths TEXT NOT NULL,
+    success BOOLEAN NOT NULL,
+    error TEXT NOT NULL,
+    reported_at TIMESTAMPTZ NOT NULL,
+    rolled_back BOOLEAN NOT NULL DEFAULT FALSE,
+    logs TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX activations_machine_id_idx ON activations (machine_id);
+
+CREATE TABLE machine_statuses (
+    machine_id BIGINT PRIMARY KEY REFERENCES machines(id),
+
+    profile_generation BIGINT NOT NULL,
+    -- JSON array of store paths installed in the machine profile
+    store_paths TEXT NOT NULL,
+    nix_version TEXT NOT NULL,
+    free_disk_bytes BIGINT NOT NULL,
+    last_error TEXT NOT NULL,
+    reported_at TIMESTAMPTZ NOT NULL,
+    -- Store paths of /run/current-system and /run/booted-system on NixOS machines
+    running_system TEXT NOT NULL DEFAULT '',
+    booted_system TEXT NOT NULL DEFAULT '',
+    -- JSON array of store paths realised ahead of activation
+    staged_store_paths TEXT NOT NULL DEFAULT '[]',
+    -- Url of the agent serving its store to peers, empty when sharing is disabled
+    peer_url TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE machine_drifts (
+    machine_id BIGINT PRIMARY KEY REFERENCES machines(id),
+
+    -- JSON arrays of store paths desired but not installed and installed but not desired
+    missing TEXT NOT NULL,
+    unexpected TEXT NOT NULL,
+    desired_system TEXT NOT NULL,
+    actual_system TEXT NOT NULL,
+    -- Desired state of the machine can't be resolved
+    error TEXT NOT NULL,
+    -- When the machine drifted, NULL when it is in sync
+    since TIMESTAMPTZ,
+    checked_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE rollouts (
+    id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+
+    package_name TEXT NOT NULL,
+    package_version TEXT NOT NULL,
+    -- Machines of the rollout are selected by group and JSON object of labels
+    selector_group TEXT NOT NULL,
+    selector_labels TEXT NOT NULL,
+    canary_count BIGINT NOT NULL,
+    -- JSON array of cumulative percentages of machines updated by each wave after canary
+    wave_percentages TEXT NOT NULL,
+    max_failure_rate DOUBLE PRECISION NOT NULL,
+    soak_seconds BIGINT NOT NULL,
+
+    state TEXT NOT NULL,
+    state_reason TEXT NOT NULL,
+    current_wave BIGINT NOT NULL,
+    wave_started_at TIMESTAMPTZ NOT NULL,
+    created_by TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    emergency BOOLEAN NOT NULL DEFAULT FALSE,
+    -- Prestaged rollouts start in staging state, machines download the closure before the rollout is activated
+    prestage BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE TABLE rollout_machines (
+    rollout_id BIGINT NOT NULL REFERENCES rollouts(id),
+    machine_id BIGINT NOT NULL REFERENCES machines(id),
+    wave BIGINT NOT NULL,
+
+    PRIMARY KEY (rollout_id, machine_id)
+);
+
+CREATE INDEX rollout_machines_machine_id_idx ON rollout_machines (machine_id);
+
+CREATE TABLE failed_versions (
+    package_name TEXT NOT NULL,
+    package_version TEXT NOT NULL,
+    group_name TEXT NOT NULL,
+    -- Machine which failed the health check first
+    machine_id BIGINT NOT NULL REFERENCES machines(id),
+    reason TEXT NOT NULL,
+    failed_at TIMESTAMPTZ NOT NULL,
+
+    PRIMARY KEY (package_name, package_version, group_name)
+);
+
+CREATE TABLE maintenance_windows (
+    id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+
+    group_name TEXT NOT NULL,
+    -- JSON array of weekdays the window starts on, 0 is Sunday, empty is every day
+    days TEXT NOT NULL,
+    -- Minutes from midnight in the time zone
+    start_minute BIGINT NOT NULL,
+    end_minute BIGINT NOT NULL,
+    timezone TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX maintenance_windows_group_name_idx ON maintenance_windows (group_name);
+
+CREATE TABLE secrets (
+    machine_id BIGINT NOT NULL REFERENCES machines(id),
+    name TEXT NOT NULL,
+
+    -- age file encrypted to the identity key of the machine, plaintext never reaches the hub
+    ciphertext BYTEA NOT NULL,
+    -- SHA-256 of the ciphertext, agents rewrite the secret when it changes
+    hash TEXT NOT NULL,
+    -- Ownership and permissions of the file materialised by agent
+    file_owner TEXT NOT NULL,
+    file_group TEXT NOT NULL,
+    file_mode BIGINT NOT NULL,
+    updated_by TEXT NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL,
+
+    PRIMARY KEY (machine_id, name)
+);
+
+-- Ordered record of changes appended in the transaction of each change, id is the cursor of consumers.
+-- Ids are not taken from a sequence, they are assigned under a transaction lock, so they have no gaps
+-- and are committed in order, see LockOutbox.
+CREATE TABLE outbox (
+    id BIGINT PRIMARY KEY,
+
+    -- Kind of the change, e.g. package.pushed
+    kind TEXT NOT NULL,
+    -- What the change is about, e.g. name of the package
+    subject TEXT NOT NULL,
+    -- JSON payload of the change
+    data TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX outbox_created_at ON outbox (created_at);
+
+-- Position of the replica in change feed of the primary
+CREATE TABLE replication_cursors (
+    primary_url TEXT PRIMARY KEY,
+
+    last_change_id BIGINT NOT NULL,
+    -- Promoted replica stops following the primary and accepts writes
+    promoted_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ NOT NULL
+);
+
+-- Membership of the cluster the objects of the node were last rebalanced to
+CREATE TABLE cluster_memberships (
+    -- Url of the node
+    node TEXT PRIMARY KEY,
+
+    -- JSON array of node urls
+    nodes TEXT NOT NULL,
+    replication_factor BIGINT NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL
+);
+
+-- Position of the webhook in the outbox
+CREATE TABLE webhook_cursors (
+    -- Name of the webhook in config
+    webhook TEXT PRIMARY KEY,
+
+    last_change_id BIGINT NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL
+);
+
+-- Attempts to deliver changes to webhooks
+CREATE TABLE webhook_deliveries (
+    id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+
+    webhook TEXT NOT NULL,
+    -- Id of the delivered change in the outbox, 0 for test deliveries
+    change_id BIGINT NOT NULL,
+    kind TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    attempt BIGINT NOT NULL,
+    -- Status code of the response, 0 when no response was received
+    status_code BIGINT NOT NULL,
+    -- Empty when the delivery succeeded
+    error TEXT NOT NULL,
+    duration_ms BIGINT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX webhook_deliveries_webhook ON webhook_deliveries (webhook, id);
+CREATE INDEX webhook_deliveries_created_at ON webhook_deliveries (created_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE webhook_deliveries;
+DROP TABLE webhook_cursors;
+DROP TABLE cluster_memberships;
+DROP TABLE replication_cursors;
+DROP TABLE outbox;
+DROP TABLE secrets;
+DROP TABLE maintenance_windows;
+DROP TABLE failed_versions;
+DROP TABLE rollout_machines;
+DROP TABLE rollouts;
+DROP TABLE machine_drifts;
+DROP TABLE machine_statuses;
+DROP TABLE activations;
+DROP TABLE assignments;
+DROP TABLE bootstrap_tokens;
+DROP TABLE machines;
+DROP TABLE vulnerabilities;
+DROP TABLE advisory_packages;
+DROP TABLE advisories;
+DROP TABLE package_provenances;
+DROP TABLE package_outputs;
+DROP TABLE gc_roots;
+DROP TABLE packages;
+-- +goose StatementEnd
@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Failures on machines without groups are kept per machine with empty group_name
+ALTER TABLE failed_versions DROP CONSTRAINT failed_versions_pkey;
+
+CREATE UNIQUE INDEX failed_versions_group_idx ON failed_versions (package_name, package_version, group_name) WHERE group_name <> '';
+CREATE UNIQUE INDEX failed_versions_machine_idx ON failed_versions (package_name, package_version, machine_id) WHERE group_name = '';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX failed_versions_machine_idx;
+DROP INDEX failed_versions_group_idx;
+
+DELETE FROM failed_versions WHERE group_name = '';
+ALTER TABLE failed_versions ADD PRIMARY KEY (package_name, package_version, group_name);
+-- +goose StatementEnd